@@ -3,9 +3,12 @@ package main
 import (
 	"clustta/internal/chunk_service"
 	"clustta/internal/utils"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/cors"
@@ -65,29 +68,20 @@ func RequestLoggerMiddleware(next http.Handler) http.HandlerFunc {
 	})
 }
 
-// ProjectStorageMiddleware makes a Deflated project unavailable when its
-// Studio-wide storage root is not configured, instead of exposing partial data.
+// ProjectStorageMiddleware makes a project unavailable when the backend of
+// its storage mode is not configured on this Studio, instead of exposing
+// partial data. Nothing is looked up while every backend is available.
 func ProjectStorageMiddleware(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !chunk_service.StorageDirectoryAvailable() {
+		if len(chunk_service.AvailableStorageModes()) < len(chunk_service.SupportedStorageModes()) {
 			parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 			if len(parts) > 0 && parts[0] != "" {
 				projectPath, err := safeProjectPath(CONFIG.ProjectsDir, parts[0])
 				if err == nil && utils.FileExists(projectPath) {
-					db, openErr := utils.OpenDb(projectPath)
-					if openErr == nil {
-						tx, beginErr := db.Beginx()
-						if beginErr == nil {
-							mode, modeErr := chunk_service.GetProjectStorageMode(tx)
-							tx.Rollback()
-							db.Close()
-							if modeErr == nil && mode == chunk_service.StorageModeDeflated {
-								http.Error(w, "Project storage is unavailable", http.StatusServiceUnavailable)
-								return
-							}
-						} else {
-							db.Close()
-						}
+					mode, modeErr := projectStorageMode(projectPath)
+					if modeErr == nil && chunk_service.ValidateStorageMode(mode) != nil {
+						http.Error(w, "Project storage is unavailable", http.StatusServiceUnavailable)
+						return
 					}
 				}
 			}
@@ -96,6 +90,72 @@ func ProjectStorageMiddleware(next http.Handler) http.HandlerFunc {
 	})
 }
 
+// projectStorageModes caches the storage mode of each project by path, so
+// ProjectStorageMiddleware does not open the project on every request. An
+// entry is read again once the project's database or WAL file changed, so
+// modes changed by another process, such as the migrate-storage command,
+// are seen. Handlers that create, migrate or delete a project also forget
+// its entry once the change is committed.
+var projectStorageModes = struct {
+	sync.Mutex
+	modes map[string]cachedStorageMode
+}{modes: map[string]cachedStorageMode{}}
+
+type cachedStorageMode struct {
+	state string
+	mode  string
+}
+
+func projectStorageMode(projectPath string) (string, error) {
+	state := projectFileState(projectPath)
+	projectStorageModes.Lock()
+	cached, ok := projectStorageModes.modes[projectPath]
+	projectStorageModes.Unlock()
+	if ok && cached.state == state {
+		return cached.mode, nil
+	}
+
+	db, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	mode, err := chunk_service.GetProjectStorageMode(tx)
+	if err != nil {
+		return "", err
+	}
+	projectStorageModes.Lock()
+	projectStorageModes.modes[projectPath] = cachedStorageMode{state: state, mode: mode}
+	projectStorageModes.Unlock()
+	return mode, nil
+}
+
+// projectFileState describes the size and modification time of the
+// project's database and WAL files, which any committed write changes.
+func projectFileState(projectPath string) string {
+	var state strings.Builder
+	for _, suffix := range []string{"", "-wal"} {
+		if info, err := os.Stat(projectPath + suffix); err == nil {
+			fmt.Fprintf(&state, "%d:%d ", info.Size(), info.ModTime().UnixNano())
+		} else {
+			state.WriteString("- ")
+		}
+	}
+	return state.String()
+}
+
+// forgetProjectStorageMode drops the cached storage mode of the project.
+func forgetProjectStorageMode(projectPath string) {
+	projectStorageModes.Lock()
+	delete(projectStorageModes.modes, projectPath)
+	projectStorageModes.Unlock()
+}
+
 // func ClientValidationMiddleware(next http.Handler) http.HandlerFunc {
 // 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 // 		lrw := NewLoggingResponseWriter(w)
@@ -163,21 +223,49 @@ func (s *APIServer) Run() error {
 	router.HandleFunc("DELETE /{project}", DeleteProjectHandler)
 	router.HandleFunc("GET /{project}", GetProjectHandler)
 	router.HandleFunc("GET /{project}/sync-token", GetProjectSyncTokenHandler)
+	router.HandleFunc("GET /{project}/events", GetProjectEventsHandler)
+	router.HandleFunc("GET /{project}/audit", GetProjectAuditHandler)
+	router.HandleFunc("GET /{project}/replica", GetProjectReplicaHandler)
+	router.HandleFunc("PUT /{project}/replica", PutProjectReplicaHandler)
+	router.HandleFunc("POST /{project}/replica/sync", SyncProjectReplicaHandler)
+	router.HandleFunc("POST /{project}/replica/promote", PromoteProjectReplicaHandler)
 	router.HandleFunc("PUT /{project}/icon", SetProjectIconHandler)
 	router.HandleFunc("PUT /{project}/ignore-list", SetProjectIgnoreListHandler)
+	router.HandleFunc("PUT /{project}/chunking-profiles", SetProjectChunkingProfilesHandler)
 	router.HandleFunc("PUT /{project}/toggle-close", ToggleProjectCloseHandler)
 	router.HandleFunc("GET /{project}/data", GetDataHandler)
 	router.HandleFunc("POST /{project}/data", PostDataHandler)
 	router.HandleFunc("GET /{project}/chunks", GetChunksHandler)
 	router.HandleFunc("GET /{project}/stream-chunks", StreamChunksHandler)
 	router.HandleFunc("POST /{project}/chunks", PostChunksHandler)
+	router.HandleFunc("POST /{project}/upload-sessions", CreateUploadSessionHandler)
+	router.HandleFunc("GET /{project}/upload-sessions/{session}", GetUploadSessionHandler)
+	router.HandleFunc("PUT /{project}/upload-sessions/{session}", PutUploadRangeHandler)
+	router.HandleFunc("DELETE /{project}/upload-sessions/{session}", DeleteUploadSessionHandler)
 	router.HandleFunc("GET /{project}/chunks-missing", ChunksMissingHandler)
 	router.HandleFunc("GET /{project}/chunks-info", GetChunksInfoHandler)
+	router.HandleFunc("POST /{project}/storage-migration", StartStorageMigrationHandler)
+	router.HandleFunc("GET /{project}/storage-migration", GetStorageMigrationHandler)
+	router.HandleFunc("POST /{project}/gc", CollectGarbageHandler)
+	router.HandleFunc("GET /{project}/chunk-health", GetChunkHealthHandler)
+	router.HandleFunc("POST /{project}/scrub", ScrubChunksHandler)
+	router.HandleFunc("POST /{project}/repack", RepackChunksHandler)
+	router.HandleFunc("POST /{project}/archive", ArchiveChunksHandler)
+	router.HandleFunc("POST /{project}/warm-up", WarmChunksHandler)
+	router.HandleFunc("GET /{project}/tiering", GetTieringStatusHandler)
+	router.HandleFunc("GET /{project}/quota", GetProjectQuotaHandler)
+	router.HandleFunc("PUT /{project}/quota", SetProjectQuotaHandler)
+	router.HandleFunc("DELETE /{project}/quota", DeleteProjectQuotaHandler)
+	router.HandleFunc("POST /{project}/encryption", EnableProjectEncryptionHandler)
+	router.HandleFunc("GET /{project}/encryption", GetProjectEncryptionHandler)
+	router.HandleFunc("GET /{project}/dictionaries", GetChunkDictionariesHandler)
+	router.HandleFunc("POST /{project}/dictionaries", TrainChunkDictionariesHandler)
 	router.HandleFunc("GET /{project}/previews", GetPreviewsHandler)
 	router.HandleFunc("GET /{project}/preview", GetProjectPreview)
 	router.HandleFunc("POST /{project}/previews", PostPreviewsHandler)
 	router.HandleFunc("GET /{project}/previews-exist", PreviewsExistHandler)
 	router.HandleFunc("GET /projects", GetProjectsHandler)
+	router.HandleFunc("GET /replicas", GetReplicasHandler)
 
 	// ============================================
 	// Project Status
@@ -218,7 +306,7 @@ func (s *APIServer) Run() error {
 	})
 
 	// Wrap with session manager for auth endpoints
-	handlerWithStorage := ProjectStorageMiddleware(c.Handler(router))
+	handlerWithStorage := ProjectStorageMiddleware(ReplicaMiddleware(c.Handler(router)))
 	handlerWithSession := sessionManager.LoadAndSave(handlerWithStorage)
 	handlerWithApiToken := ApiTokenMiddleware(handlerWithSession)
 	handlerWithRecovery := RecoveryMiddleware(handlerWithApiToken)
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"clustta/internal/chunk_service"
+	"sync"
+)
+
+var (
+	studioBandwidthOnce sync.Once
+	studioBandwidth     *chunk_service.BandwidthLimiter
+
+	userBandwidthMutex sync.Mutex
+	userBandwidth      = map[string]*chunk_service.BandwidthLimiter{}
+)
+
+// bandwidthLimiters returns the limiters chunks sent to user are held to:
+// the studio-wide one and the user's own, shared by all their downloads.
+func bandwidthLimiters(user UserInfo) []*chunk_service.BandwidthLimiter {
+	studioBandwidthOnce.Do(func() {
+		studioBandwidth = chunk_service.NewBandwidthLimiter(CONFIG.StreamBandwidthLimit)
+	})
+	return []*chunk_service.BandwidthLimiter{studioBandwidth, userBandwidthLimiter(user)}
+}
+
+// userBandwidthLimit is the bytes per second chunks are sent to user at
+// most, 0 meaning unlimited.
+func userBandwidthLimit(user UserInfo) int64 {
+	if limit, ok := CONFIG.UserBandwidthLimits[user.Email]; ok {
+		return limit
+	}
+	return CONFIG.UserBandwidthLimit
+}
+
+func userBandwidthLimiter(user UserInfo) *chunk_service.BandwidthLimiter {
+	limit := userBandwidthLimit(user)
+	userBandwidthMutex.Lock()
+	defer userBandwidthMutex.Unlock()
+	limiter, ok := userBandwidth[user.Id]
+	if !ok || limiter.BytesPerSecond() != limit {
+		limiter = chunk_service.NewBandwidthLimiter(limit)
+		userBandwidth[user.Id] = limiter
+	}
+	return limiter
+}
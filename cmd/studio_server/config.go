@@ -31,6 +31,81 @@ type Config struct {
 	SMTPUser          string `json:"smtp_user" envconfig:"SMTP_USER"`
 	SMTPPassword      string `json:"smtp_password" envconfig:"SMTP_PASSWORD"`
 	SMTPFrom          string `json:"smtp_from" envconfig:"SMTP_FROM"`
+
+	// ObjectStorage* configure the S3-compatible bucket for Object Storage
+	// projects. An empty endpoint leaves the mode unavailable.
+	ObjectStorageEndpoint         string `json:"object_storage_endpoint" envconfig:"OBJECT_STORAGE_ENDPOINT"`
+	ObjectStorageBucket           string `json:"object_storage_bucket" envconfig:"OBJECT_STORAGE_BUCKET"`
+	ObjectStoragePrefix           string `json:"object_storage_prefix" envconfig:"OBJECT_STORAGE_PREFIX"`
+	ObjectStorageRegion           string `json:"object_storage_region" envconfig:"OBJECT_STORAGE_REGION"`
+	ObjectStorageAccessKeyId      string `json:"object_storage_access_key_id" envconfig:"OBJECT_STORAGE_ACCESS_KEY_ID"`
+	ObjectStorageSecretAccessKey  string `json:"object_storage_secret_access_key" envconfig:"OBJECT_STORAGE_SECRET_ACCESS_KEY"`
+	ObjectStorageVirtualHostStyle bool   `json:"object_storage_virtual_host_style" envconfig:"OBJECT_STORAGE_VIRTUAL_HOST_STYLE"`
+
+	// ChunkGCGracePeriod (Go duration) spares unreferenced chunks younger than
+	// this from garbage collection. Defaults to 24h.
+	ChunkGCGracePeriod string `json:"chunk_gc_grace_period" envconfig:"CHUNK_GC_GRACE_PERIOD"`
+	// ChunkScrubInterval (Go duration) is how often every chunk is re-verified.
+	// Defaults to 168h; "0" disables the background scrubber.
+	ChunkScrubInterval string `json:"chunk_scrub_interval" envconfig:"CHUNK_SCRUB_INTERVAL"`
+	// ChunkRepackInterval (Go duration) is how often deflated projects have
+	// their packs compacted. Defaults to 24h; "0" disables the repacker.
+	ChunkRepackInterval string `json:"chunk_repack_interval" envconfig:"CHUNK_REPACK_INTERVAL"`
+	// ChunkArchiveDir is where chunks only cold checkpoints use are moved.
+	// Empty disables tiered storage.
+	ChunkArchiveDir string `json:"chunk_archive_dir" envconfig:"CHUNK_ARCHIVE_DIR"`
+	// ChunkTieringAge (Go duration) is the age after which superseded
+	// checkpoints are archived. Empty or "0" archives only closed projects
+	// and collections marked cold.
+	ChunkTieringAge string `json:"chunk_tiering_age" envconfig:"CHUNK_TIERING_AGE"`
+	// ChunkTieringInterval (Go duration) is how often cold chunks are
+	// archived. Defaults to 24h; "0" disables the background pass.
+	ChunkTieringInterval string `json:"chunk_tiering_interval" envconfig:"CHUNK_TIERING_INTERVAL"`
+	// ChangeLogRetention (Go duration) is how long row deletions are kept for
+	// delta data sync. Clients last synced before that pull a full snapshot.
+	// Defaults to 720h; "0" keeps them forever.
+	ChangeLogRetention string `json:"change_log_retention" envconfig:"CHANGE_LOG_RETENTION"`
+	// IdempotencyRetention (Go duration) is how long the response to a
+	// request with an Idempotency-Key is kept to answer its retries.
+	// Defaults to 24h.
+	IdempotencyRetention string `json:"idempotency_retention" envconfig:"IDEMPOTENCY_RETENTION"`
+
+	// StudioQuotaBytes caps the primary storage all projects may use
+	// together. 0 means unlimited.
+	StudioQuotaBytes int64 `json:"studio_quota_bytes" envconfig:"STUDIO_QUOTA_BYTES"`
+	// ProjectQuotaBytes is the quota of projects without their own. 0 means
+	// unlimited.
+	ProjectQuotaBytes int64 `json:"project_quota_bytes" envconfig:"PROJECT_QUOTA_BYTES"`
+	// QuotaSoftLimitPercent is the share of a quota at which admins are
+	// warned, unless a project sets its own soft limit. Defaults to 90.
+	QuotaSoftLimitPercent int `json:"quota_soft_limit_percent" envconfig:"QUOTA_SOFT_LIMIT_PERCENT"`
+	// MinFreeDiskBytes refuses uploads that would leave less free space on
+	// the projects or storage volume. 0 disables the check.
+	MinFreeDiskBytes int64 `json:"min_free_disk_bytes" envconfig:"MIN_FREE_DISK_BYTES"`
+
+	// StreamBandwidthLimit caps, in bytes per second, how fast chunks are
+	// sent to all clients together. 0 means unlimited.
+	StreamBandwidthLimit int64 `json:"stream_bandwidth_limit" envconfig:"STREAM_BANDWIDTH_LIMIT"`
+	// UserBandwidthLimit caps, in bytes per second, how fast chunks are sent
+	// to each user. 0 means unlimited.
+	UserBandwidthLimit int64 `json:"user_bandwidth_limit" envconfig:"USER_BANDWIDTH_LIMIT"`
+	// UserBandwidthLimits overrides UserBandwidthLimit by user email, as
+	// "render@studio.com:0,artist@studio.com:2097152" in the environment.
+	UserBandwidthLimits map[string]int64 `json:"user_bandwidth_limits" envconfig:"USER_BANDWIDTH_LIMITS"`
+
+	// ReplicaTokens are the API tokens mirrors pull from their primary
+	// studio with, by project name. They stay here rather than in the
+	// project, which is copied around with its data.
+	ReplicaTokens map[string]string `json:"replica_tokens,omitempty" ignored:"true"`
+
+	// StorageMasterKey is the base64-encoded 32-byte AES-GCM key that wraps
+	// per-project data keys for encryption at rest. Empty disables it.
+	StorageMasterKey string `json:"storage_master_key" envconfig:"STORAGE_MASTER_KEY"`
+	// StoragePreviousMasterKeys lists retired master keys, comma-separated.
+	// Projects still wrapped by one are re-wrapped with StorageMasterKey on
+	// startup.
+	StoragePreviousMasterKeys string `json:"storage_previous_master_keys" envconfig:"STORAGE_PREVIOUS_MASTER_KEYS"`
+
 	// IntegrationSecretKey is the base64-encoded 32-byte AES-GCM master key
 	// for encrypting integration credentials. Empty disables integrations.
 	IntegrationSecretKey string `json:"integration_secret_key" envconfig:"INTEGRATION_SECRET_KEY"`
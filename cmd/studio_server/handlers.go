@@ -3,6 +3,7 @@ package main
 import (
 	"clustta/internal/auth_service"
 	"clustta/internal/chunk_service"
+	"clustta/internal/event_service"
 	"clustta/internal/metadata_service"
 	"clustta/internal/repository"
 	"clustta/internal/repository/repositorypb"
@@ -18,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -52,6 +54,7 @@ func removeProjectDatabaseFiles(projectPath string) {
 			log.Printf("Failed to clean project file %q: %v", projectPath+suffix, err)
 		}
 	}
+	forgetProjectStorageMode(projectPath)
 }
 
 type dataStruct struct {
@@ -86,6 +89,17 @@ type UsageResponse struct {
 	StorageBytes          int64 `json:"storage_bytes"`
 	StorageAvailableBytes int64 `json:"storage_available_bytes"`
 	StorageTotalBytes     int64 `json:"storage_total_bytes"`
+	// LogicalBytes is what the projects would occupy without the shared chunk
+	// pool; PhysicalBytes is what they actually occupy on disk.
+	LogicalBytes  int64 `json:"logical_bytes"`
+	PhysicalBytes int64 `json:"physical_bytes"`
+	// ArchiveBytes is what archived chunks occupy on the archive volume, not
+	// counted in StorageBytes.
+	ArchiveBytes int64 `json:"archive_bytes"`
+	// QuotaBytes is the Studio storage quota, 0 if unlimited. QuotaWarnings
+	// lists the projects and Studio past their soft limit.
+	QuotaBytes    int64    `json:"quota_bytes"`
+	QuotaWarnings []string `json:"quota_warnings"`
 }
 
 func VersionHandler(w http.ResponseWriter, r *http.Request) {
@@ -171,6 +185,30 @@ func GetUsageHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	poolLogicalBytes, poolPhysicalBytes, err := chunk_service.PoolUsage()
+	if err != nil {
+		log.Printf("[GetUsage] failed to inspect chunk pool: %v", err)
+		SendErrorResponse(w, "Failed to inspect chunk pool", http.StatusInternalServerError)
+		return
+	}
+
+	var archiveBytes int64
+	if chunk_service.ChunkArchiveAvailable() {
+		archiveBytes, err = directoryBytes(CONFIG.ChunkArchiveDir)
+		if err != nil {
+			log.Printf("[GetUsage] failed to inspect archive directory %q: %v", CONFIG.ChunkArchiveDir, err)
+			SendErrorResponse(w, "Failed to inspect archive directory", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	quotaWarnings, err := studioQuotaWarnings()
+	if err != nil {
+		log.Printf("[GetUsage] failed to measure project quotas: %v", err)
+		SendErrorResponse(w, "Failed to measure project quotas", http.StatusInternalServerError)
+		return
+	}
+
 	diskStats, err := getDiskStats(projectsDir)
 	if err != nil {
 		log.Printf("[GetUsage] failed to inspect disk for %q: %v", projectsDir, err)
@@ -183,6 +221,11 @@ func GetUsageHandler(w http.ResponseWriter, r *http.Request) {
 		StorageBytes:          storageBytes,
 		StorageAvailableBytes: diskStats.AvailableBytes,
 		StorageTotalBytes:     diskStats.TotalBytes,
+		LogicalBytes:          storageBytes + poolLogicalBytes - poolPhysicalBytes,
+		PhysicalBytes:         storageBytes,
+		ArchiveBytes:          archiveBytes,
+		QuotaBytes:            CONFIG.StudioQuotaBytes,
+		QuotaWarnings:         quotaWarnings,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -654,6 +697,13 @@ func PostProjectHandler(
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	if err = sync_service.EnableChangeTracking(tx); err != nil {
+		tx.Rollback()
+		dbConn.Close()
+		removeProjectDatabaseFiles(projectPath)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 	if err = tx.Commit(); err != nil {
 		dbConn.Close()
 		removeProjectDatabaseFiles(projectPath)
@@ -661,6 +711,7 @@ func PostProjectHandler(
 		return
 	}
 	dbConn.Close()
+	forgetProjectStorageMode(projectPath)
 	projectInfo.StorageMode = payload.StorageMode
 
 	objJson, _ := json.Marshal(projectInfo)
@@ -711,6 +762,8 @@ func RenameProjectHandler(
 		return
 	}
 
+	forgetMirror(projectName)
+	forgetProjectStorageMode(projectPath)
 	newProjectPath := filepath.Join(filepath.Dir(projectPath), newProjectName+".clst")
 
 	projectInfo, err := repository.GetProjectInfo(newProjectPath, user)
@@ -754,6 +807,7 @@ func DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "You have no permission to delete this project", 403)
 		return
 	}
+	stopReplica(projectName)
 
 	dbConn, err := utils.OpenDb(projectPath)
 	if err != nil {
@@ -788,6 +842,11 @@ func DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 	if utils.FileExists(journal) {
 		os.Remove(journal)
 	}
+	forgetMirror(projectName)
+	forgetProjectStorageMode(projectPath)
+	if err := setReplicaToken(projectName, ""); err != nil {
+		log.Printf("Failed to remove replica token: %v", err)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -836,6 +895,9 @@ func ToggleProjectCloseHandler(
 		http.Error(w, "Internal server error", 400)
 		return
 	}
+	if projectInfo.IsClosed && chunk_service.ChunkArchiveAvailable() {
+		go tierProject(projectPath)
+	}
 
 	objJson, _ := json.Marshal(projectInfo)
 	w.Write(objJson)
@@ -946,6 +1008,59 @@ func SetProjectIgnoreListHandler(
 	w.Write(objJson)
 }
 
+// SetProjectChunkingProfilesHandler replaces the project's chunking
+// profiles. Clients pick them up with the project info on their next sync.
+func SetProjectChunkingProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 400)
+		return
+	}
+
+	user := auth_service.User{}
+	err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can change chunking profiles", http.StatusForbidden)
+		return
+	}
+
+	var profiles repository.ChunkingProfiles
+	if err := json.NewDecoder(r.Body).Decode(&profiles); err != nil {
+		SendErrorResponse(w, "Invalid chunking profiles", http.StatusBadRequest)
+		return
+	}
+	if err := profiles.Validate(); err != nil {
+		SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = repository.SetChunkingProfiles(projectPath, "", profiles, user)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	projectInfo, err := repository.GetProjectInfo(projectPath, user)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	objJson, _ := json.Marshal(projectInfo)
+	w.Write(objJson)
+}
+
 func GetProjectHandler(
 	w http.ResponseWriter, r *http.Request) {
 	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
@@ -1126,6 +1241,88 @@ func GetProjectsHandler(
 	w.Write(objJson)
 }
 
+// changeLogRetention is how long deletions stay in a project's change log.
+// Defaults to 30 days; 0 keeps them forever.
+func changeLogRetention() time.Duration {
+	if CONFIG.ChangeLogRetention == "" {
+		return 720 * time.Hour
+	}
+	d, err := time.ParseDuration(CONFIG.ChangeLogRetention)
+	if err != nil || d < 0 {
+		log.Printf("Warning: invalid CHANGE_LOG_RETENTION %q, using 720h", CONFIG.ChangeLogRetention)
+		return 720 * time.Hour
+	}
+	return d
+}
+
+// enableChangeTracking starts recording the project's row changes, so
+// clients can pull deltas from it.
+func enableChangeTracking(projectPath string) error {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := sync_service.EnableChangeTracking(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// pruneChangeLog forgets the project's deletions older than the retention.
+func pruneChangeLog(projectPath string, retention time.Duration) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("[ChangeLog] %s: %v", filepath.Base(projectPath), err)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("[ChangeLog] %s: %v", filepath.Base(projectPath), err)
+		return
+	}
+	defer tx.Rollback()
+	pruned, err := sync_service.PruneChangeLog(tx, time.Now().Add(-retention).Unix())
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("[ChangeLog] %s: %v", filepath.Base(projectPath), err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("[ChangeLog] %s: pruned %d deletions", filepath.Base(projectPath), pruned)
+	}
+}
+
+// runChangeLogPruner periodically prunes the change log of every project,
+// at least daily and as often as the retention.
+func runChangeLogPruner(projectsDir string) {
+	retention := changeLogRetention()
+	if retention <= 0 {
+		return
+	}
+	for {
+		entries, err := os.ReadDir(projectsDir)
+		if err != nil {
+			log.Printf("[ChangeLog] failed to list projects: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".clst") {
+				continue
+			}
+			pruneChangeLog(filepath.Join(projectsDir, entry.Name()), retention)
+		}
+		time.Sleep(min(retention, 24*time.Hour))
+	}
+}
+
 func GetDataHandler(
 	w http.ResponseWriter, r *http.Request) {
 	if _, ok := getAuthUser(r); !ok {
@@ -1172,7 +1369,43 @@ func GetDataHandler(
 		http.Error(w, "Internal server error", 400)
 		return
 	}
-	userData, err := sync_service.LoadUserDataPb(tx, data.UserId)
+	// Clients that send the sequence of their last pull get only what
+	// changed since, when the change log still reaches back that far.
+	since, hasSince := int64(0), false
+	if value := r.URL.Query().Get("since"); value != "" {
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		hasSince = true
+	}
+
+	sequence, err := sync_service.CurrentChangeSequence(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	var userData []byte
+	delta := false
+	// Sparse clones name the collection subtrees they hold and always get
+	// them in full.
+	if selection := sync_service.CollectionSelectionFromQuery(r.URL.Query()); !selection.IsEmpty() {
+		userData, err = sync_service.LoadSelectedUserDataPb(tx, data.UserId, selection)
+	} else if hasSince {
+		userData, delta, err = sync_service.LoadUserDataSincePb(tx, data.UserId, since)
+	} else {
+		userData, err = sync_service.LoadUserDataPb(tx, data.UserId)
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		log.Printf("Request error: %v", err)
 		http.Error(w, "Internal server error", 500)
@@ -1186,6 +1419,12 @@ func GetDataHandler(
 		return
 	}
 
+	w.Header().Set(sync_service.SyncSequenceHeader, strconv.FormatInt(sequence, 10))
+	if delta {
+		w.Header().Set(sync_service.SyncModeHeader, sync_service.SyncModeDelta)
+	} else {
+		w.Header().Set(sync_service.SyncModeHeader, sync_service.SyncModeFull)
+	}
 	_, err = w.Write(compressedData)
 	if err != nil {
 		log.Printf("Request error: %v", err)
@@ -1292,16 +1531,71 @@ func PostDataHandler(
 		IntegrationProjects:           repository.FromPbIntegrationProjects(userDataPb.IntegrationProjects),
 		IntegrationCollectionMappings: repository.FromPbIntegrationCollectionMappings(userDataPb.IntegrationCollectionMappings),
 		IntegrationAssetMappings:      repository.FromPbIntegrationAssetMappings(userDataPb.IntegrationAssetMappings),
+
+		FieldBases: repository.FromPbFieldBases(userDataPb.FieldBases),
+
+		ConflictPolicies: sync_service.ConflictPoliciesFromPb(userDataPb.ConflictPolicy, userDataPb.ConflictResolutions),
 	}
 
-	conflictResult, err := sync_service.CheckForConflicts(tx, requestData)
+	// A dry run reports what the push would do; its transaction is never
+	// committed.
+	if r.URL.Query().Get("dry_run") == "1" {
+		report, err := sync_service.DryRunProjectData(tx, authUser.Id, false, requestData)
+		if errors.Is(err, sync_service.ErrUnknownConflictPolicy) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Request error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	// A retry of a push that was already applied gets the original answer.
+	idempotent := newIdempotentRequest(r, authUser.Id, body)
+	if idempotent.replay(w, tx) {
+		return
+	}
+
+	conflictResult, err := sync_service.ResolveNameConflicts(tx, &requestData)
+	if errors.Is(err, sync_service.ErrUnknownConflictPolicy) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	// Fields both sides changed keep the server's value and are reported
+	// with the result; the rest of the push is applied.
+	fieldConflicts, err := sync_service.MergeFieldEdits(tx, &requestData)
 	if err != nil {
 		log.Printf("Request error: %v", err)
 		http.Error(w, "Internal server error", 500)
 		return
 	}
+	if len(fieldConflicts) > 0 {
+		conflictResult.FieldConflicts = fieldConflicts
+	}
+
+	audit := repository.SyncAudit{
+		UserId:      authUser.Id,
+		Action:      repository.SyncAuditData,
+		ClientAgent: clientAgent(r),
+		Tables:      requestData.RowCounts(),
+		Bytes:       int64(len(body)),
+	}
 
 	if !conflictResult.Success {
+		tx.Rollback()
+		audit.Outcome = repository.SyncAuditConflict
+		audit.Detail = fmt.Sprintf("%d name conflicts", len(conflictResult.Conflicts))
+		recordSyncAudit(projectPath, audit)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusConflict)
 		json.NewEncoder(w).Encode(conflictResult)
@@ -1310,13 +1604,29 @@ func PostDataHandler(
 
 	if err := sync_service.AuthorizeProjectDataWrite(tx, authUser.Id, false, requestData); err != nil {
 		log.Printf("AUDIT: user=%s action=sync_denied project=%s reason=%v", authUser.Id, project, err)
+		tx.Rollback()
+		audit.Outcome = repository.SyncAuditDenied
+		audit.Detail = err.Error()
+		recordSyncAudit(projectPath, audit)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
+	// Names are taken before the write deletes the tombed rows.
+	audit.Tombs, err = repository.AuditTombs(tx, requestData.Tombs)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
 	err = sync_service.WriteProjectData(tx, requestData, true)
 	if err != nil {
 		log.Printf("Request error: %v", err)
+		tx.Rollback()
+		audit.Outcome = repository.SyncAuditFailed
+		audit.Detail = err.Error()
+		recordSyncAudit(projectPath, audit)
 		http.Error(w, "Internal server error", 400)
 		return
 	}
@@ -1339,6 +1649,29 @@ func PostDataHandler(
 		http.Error(w, "Internal server error", 400)
 		return
 	}
+	audit.Outcome = repository.SyncAuditApplied
+	audit.SyncToken = newSyncToken
+	if len(conflictResult.FieldConflicts) > 0 {
+		audit.Detail = fmt.Sprintf("%d field conflicts kept the server value", len(conflictResult.FieldConflicts))
+	}
+	err = repository.AddSyncAudit(tx, audit)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	response, err := json.Marshal(conflictResult)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	err = idempotent.save(tx, http.StatusOK, response, newSyncToken)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -1347,6 +1680,9 @@ func PostDataHandler(
 		return
 	}
 	utils.RunPassiveCheckpoint(db)
+	event_service.PublishSyncToken(project, newSyncToken, requestData.Tables())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
 
 	// Notify integration listeners when sync touched integration_project rows
 	// so they reconcile within seconds instead of waiting for the next tick.
@@ -1427,7 +1763,8 @@ func UpdateStatusHandler(w http.ResponseWriter, r *http.Request) {
 		request.Assets = append(request.Assets, metadata_service.AssetPatch{Id: item.AssetId, StatusId: &statusId})
 		results = append(results, statusResult{AssetId: item.AssetId, StatusId: item.StatusId, Status: "updated"})
 	}
-	if _, err = metadata_service.ApplyAssets(tx, authUser.Id, request); err != nil {
+	applied, err := metadata_service.ApplyAssets(tx, authUser.Id, request)
+	if err != nil {
 		writeMutationError(w, err)
 		return
 	}
@@ -1436,6 +1773,7 @@ func UpdateStatusHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error committing status changes", http.StatusInternalServerError)
 		return
 	}
+	event_service.PublishSyncToken(project, applied.SyncToken, []string{"asset"})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -1443,7 +1781,8 @@ func UpdateStatusHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetChunksHandler(w http.ResponseWriter, r *http.Request) {
-	if _, ok := getAuthUser(r); !ok {
+	user, ok := getAuthUser(r)
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -1484,9 +1823,15 @@ func GetChunksHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 400)
 		return
 	}
+	store, err := chunk_service.OpenChunkStore(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
 	chunks := []chunk_service.Chunk{}
 	for _, chunkHash := range data.Chunks {
-		chunkData, readErr := chunk_service.ReadChunk(tx, chunkHash)
+		chunkData, readErr := store.Get(tx, chunkHash)
 		err = readErr
 		if err != nil {
 			log.Printf("Request error: %v", err)
@@ -1500,17 +1845,25 @@ func GetChunksHandler(w http.ResponseWriter, r *http.Request) {
 		chunks = append(chunks, chunk)
 	}
 
-	encodedChunks, err := chunk_service.EncodeChunks(chunks)
+	framing := chunk_service.ChunkFramingForAgent(r.Header)
+	encodedChunks, err := chunk_service.EncodeChunkStream(framing, chunks)
 	if err != nil {
 		log.Printf("Request error: %v", err)
 		http.Error(w, "Internal server error", 400)
 		return
 	}
-	w.Write(encodedChunks)
+	w.Header().Set(chunk_service.ChunkFramingHeader, strconv.Itoa(framing))
+	// A bandwidth limit can keep the response going past the server's
+	// write timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Get chunks: failed to clear write deadline: %v", err)
+	}
+	chunk_service.LimitWriter(r.Context(), w, bandwidthLimiters(user)...).Write(encodedChunks)
 }
 
 func StreamChunksHandler(w http.ResponseWriter, r *http.Request) {
-	if _, ok := getAuthUser(r); !ok {
+	user, ok := getAuthUser(r)
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -1546,8 +1899,11 @@ func StreamChunksHandler(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	decoder := json.NewDecoder(r.Body)
+	// Cursor is the last chunk a resuming client fully wrote; streaming
+	// continues with the chunk after it.
 	type chunksStruct struct {
 		Chunks []string `json:"chunks"`
+		Cursor string   `json:"cursor"`
 	}
 	var data chunksStruct
 	err = decoder.Decode(&data)
@@ -1556,10 +1912,37 @@ func StreamChunksHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 400)
 		return
 	}
+	if data.Cursor != "" {
+		resumeAt := slices.Index(data.Chunks, data.Cursor)
+		if resumeAt < 0 {
+			http.Error(w, "Unknown stream cursor", http.StatusBadRequest)
+			return
+		}
+		data.Chunks = data.Chunks[resumeAt+1:]
+	}
+
+	store, err := chunk_service.OpenChunkStore(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	framing := chunk_service.ChunkFramingForAgent(r.Header)
+	// Chunks go out no faster than the studio's and the user's bandwidth
+	// caps allow.
+	out := chunk_service.LimitWriter(r.Context(), w, bandwidthLimiters(user)...)
+	frames, err := chunk_service.NewChunkFrameWriter(out, framing)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
 
 	// Enable streaming response
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Transfer-Encoding", "chunked")
+	w.Header().Set(chunk_service.ChunkFramingHeader, strconv.Itoa(framing))
 
 	if _, ok := w.(http.Flusher); !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
@@ -1572,7 +1955,7 @@ func StreamChunksHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		chunkData, readErr := chunk_service.ReadChunk(tx, chunkHash)
+		chunkData, readErr := store.Get(tx, chunkHash)
 		err = readErr
 		if err != nil {
 			log.Printf("Request error: %v", err)
@@ -1583,14 +1966,8 @@ func StreamChunksHandler(w http.ResponseWriter, r *http.Request) {
 			Hash: chunkHash,
 			Data: chunkData,
 		}
-		encodedChunk, err := chunk_service.EncodeChunk(chunk)
-		if err != nil {
-			log.Printf("Request error: %v", err)
-			http.Error(w, "Internal server error", 400)
-			return
-		}
 		// Send chunk data to client
-		if _, err := w.Write(encodedChunk); err != nil {
+		if err := frames.WriteChunk(chunk); err != nil {
 			log.Printf("Stream chunks: write failed for project %s chunk %s: %v", project, chunkHash, err)
 			return
 		}
@@ -1599,10 +1976,19 @@ func StreamChunksHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	// The v2 trailer tells the client the stream is whole.
+	if err := frames.Close(); err != nil {
+		log.Printf("Stream chunks: write failed for project %s trailer: %v", project, err)
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		log.Printf("Stream chunks: flush failed for project %s trailer: %v", project, err)
+	}
 }
 
 func PostChunksHandler(w http.ResponseWriter, r *http.Request) {
-	if _, ok := getAuthUser(r); !ok {
+	authUser, ok := getAuthUser(r)
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -1623,13 +2009,35 @@ func PostChunksHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 400)
 		return
 	}
-	failedChunks, err := chunk_service.WriteChunks(projectPath, chunks)
+	if !enforceUploadQuota(w, r, projectPath, int64(len(chunks))) {
+		return
+	}
+	audit := repository.SyncAudit{
+		UserId:      authUser.Id,
+		Action:      repository.SyncAuditChunks,
+		ClientAgent: clientAgent(r),
+		Bytes:       int64(len(chunks)),
+	}
+	framing, err := chunk_service.BodyChunkFraming(r.Header, chunks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	failedChunks, err := chunk_service.WriteChunks(projectPath, chunks, framing)
 	if err != nil {
 		log.Printf("Request error: %v", err)
+		audit.Outcome = repository.SyncAuditFailed
+		audit.Detail = err.Error()
+		recordSyncAudit(projectPath, audit)
 		http.Error(w, "Internal server error", 400)
 		return
 	}
 	chunk_service.RunPassiveCheckpointForProject(projectPath)
+	audit.Outcome = repository.SyncAuditApplied
+	if len(failedChunks) > 0 {
+		audit.Detail = fmt.Sprintf("%d chunks failed", len(failedChunks))
+	}
+	recordSyncAudit(projectPath, audit)
 
 	data := map[string]interface{}{
 		"failed_chunks": failedChunks,
@@ -1683,10 +2091,16 @@ func ChunksMissingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	store, err := chunk_service.OpenChunkStore(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
 	missingChunks := []string{}
 	seenChunks := make(map[string]bool)
 	for _, chunkHash := range data {
-		if chunk_service.ChunkExists(chunkHash, tx, seenChunks) {
+		if chunk_service.StoreHasChunk(store, tx, chunkHash, seenChunks) {
 			continue
 		}
 		missingChunks = append(missingChunks, chunkHash)
@@ -1737,10 +2151,16 @@ func GetChunksInfoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	store, err := chunk_service.OpenChunkStore(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
 	chunksInfo := []chunk_service.ChunkInfo{}
 	// seenChunks := make(map[string]bool)
 	for _, chunkHash := range data {
-		chunkInfo, err := chunk_service.GetChunkInfo(tx, chunkHash)
+		chunkInfo, err := chunk_service.StoredChunkInfo(store, tx, chunkHash)
 		if err != nil {
 			log.Printf("Request error: %v", err)
 			http.Error(w, "Internal server error", 400)
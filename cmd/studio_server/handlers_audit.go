@@ -0,0 +1,154 @@
+package main
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// clientAgent names the client a request came from.
+func clientAgent(r *http.Request) string {
+	if agent := r.Header.Get("Clustta-Agent"); agent != "" {
+		return agent
+	}
+	return r.UserAgent()
+}
+
+// recordSyncAudit writes audit in a transaction of its own, for pushes whose
+// transaction is not committed. Failures are logged rather than failing the
+// request.
+func recordSyncAudit(projectPath string, audit repository.SyncAudit) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("[Audit] failed to record push: %v", err)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("[Audit] failed to record push: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	if err = repository.AddSyncAudit(tx, audit); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("[Audit] failed to record push: %v", err)
+	}
+}
+
+// parseAuditTime accepts epoch seconds or an RFC 3339 time.
+func parseAuditTime(value string) (int64, error) {
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return epoch, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Unix(), nil
+}
+
+// GetProjectAuditHandler lists the pushes recorded for a project, newest
+// first. Studio admins and the project's admins may read it.
+func GetProjectAuditHandler(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := getAuthUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+	if err := repository.UpdateProject(projectPath); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := repository.SyncAuditFilter{
+		UserId:   query.Get("user_id"),
+		Action:   query.Get("action"),
+		Outcome:  query.Get("outcome"),
+		Table:    query.Get("table"),
+		EntityId: query.Get("id"),
+		Name:     query.Get("name"),
+		Limit:    defaultAuditLimit,
+	}
+	for param, target := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := parseAuditTime(value)
+			if err != nil {
+				http.Error(w, "Invalid "+param, http.StatusBadRequest)
+				return
+			}
+			*target = parsed
+		}
+	}
+	for param, target := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if value := query.Get(param); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				http.Error(w, "Invalid "+param, http.StatusBadRequest)
+				return
+			}
+			*target = parsed
+		}
+	}
+	if filter.Limit == 0 || filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer tx.Rollback()
+
+	if Users[authUser.Id].RoleName != "admin" {
+		isProjectAdmin := false
+		if user, err := repository.GetUser(tx, authUser.Id); err == nil {
+			role, err := repository.GetRole(tx, user.RoleId)
+			isProjectAdmin = err == nil && role.Name == "admin"
+		}
+		if !isProjectAdmin {
+			SendErrorResponse(w, "Only admins can read the audit log", http.StatusForbidden)
+			return
+		}
+	}
+
+	audits, err := repository.GetSyncAudits(tx, filter)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audits)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"clustta/internal/constants"
+	"clustta/internal/repository"
+	"clustta/internal/repository/migrations"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/repositorypb"
+	"clustta/internal/repository/sync_service"
+	"clustta/internal/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/proto"
+)
+
+func withProjectTx(t *testing.T, projectPath string, fn func(tx *sqlx.Tx)) {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	fn(tx)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustExec(t *testing.T, tx *sqlx.Tx, query string, args ...any) {
+	t.Helper()
+	if _, err := tx.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// newDataProject creates a project holding an admin, user-1, and one synced
+// asset, asset-1, named hero with status-1.
+func newDataProject(t *testing.T, dir, name string) string {
+	t.Helper()
+	projectPath := filepath.Join(dir, name+".clst")
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, repository.ProjectSchema)
+		mustExec(t, tx, "INSERT INTO config(name,value,mtime) VALUES('working_dir',?,1)", t.TempDir())
+		if err := utils.SetProjectVersion(tx, migrations.LatestVersion); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repository.CreateRole(tx, "role-1", "admin", models.RoleAttributes{UpdateAsset: true, ChangeStatus: true}); err != nil {
+			t.Fatal(err)
+		}
+		mustExec(t, tx, `INSERT INTO user (id, mtime, added_at, first_name, last_name, username, email, role_id)
+			VALUES ('user-1', 1, '2026-01-01', 'Ada', 'Admin', 'ada', 'ada@example.com', 'role-1')`)
+		for _, id := range []string{"status-1", "status-2"} {
+			if _, err := repository.CreateStatus(tx, id, id, id, "#ffffff"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := repository.CreateAssetType(tx, "type-1", "model", "model"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repository.CreateCollectionType(tx, "ctype-1", "shot", "shot"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repository.AddCollection(tx, "collection-1", "shots", "", "ctype-1", "", "", false); err != nil {
+			t.Fatal(err)
+		}
+		mustExec(t, tx, `INSERT INTO asset (id, mtime, created_at, name, extension, asset_type_id, collection_id, status_id)
+			VALUES ('asset-1', 1, 1, 'hero', '.blend', 'type-1', 'collection-1', 'status-1')`)
+		if err := utils.SetTablesToSynced(tx, sync_service.ProjectTables); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return projectPath
+}
+
+func TestPostDataMergesFieldsAroundConflicts(t *testing.T) {
+	// Pushes refresh user photos from the global server.
+	photos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("photo"))
+	}))
+	defer photos.Close()
+	host := constants.HOST
+	constants.HOST = photos.URL
+	defer func() { constants.HOST = host }()
+
+	CONFIG.ProjectsDir = t.TempDir()
+	serverPath := newDataProject(t, CONFIG.ProjectsDir, "project")
+	clientPath := newDataProject(t, t.TempDir(), "client")
+
+	// Both sides rename the asset; only the client changes its status.
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET name = 'hero_local', status_id = 'status-2', mtime = 2 WHERE id = 'asset-1'")
+	})
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET name = 'hero_remote', mtime = 200 WHERE id = 'asset-1'")
+	})
+
+	var data sync_service.ProjectData
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		var err error
+		if data, err = sync_service.LoadChangedData(tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	payload, err := proto.Marshal(&repositorypb.ProjectData{
+		Assets:     repository.ToPbAssets(data.Assets),
+		FieldBases: repository.ToPbFieldBases(data.FieldBases),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := zstd.Compress(nil, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := json.Marshal(UserInfo{Id: "user-1"})
+	r := httptest.NewRequest(http.MethodPost, "/project/data", bytes.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), apiUserContextKey, user))
+	r.SetPathValue("project", "project")
+	w := httptest.NewRecorder()
+	PostDataHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("push answered %d: %s", w.Code, w.Body.String())
+	}
+
+	result := sync_service.WriteResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || len(result.FieldConflicts) != 1 {
+		t.Fatalf("expected a successful push with one field conflict, got %+v", result)
+	}
+	if conflict := result.FieldConflicts[0]; conflict.Field != "name" || conflict.Local != "hero_local" || conflict.Remote != "hero_remote" {
+		t.Fatalf("unexpected conflict %+v", conflict)
+	}
+
+	asset := models.Asset{}
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		if err := tx.Get(&asset, "SELECT name, status_id FROM asset WHERE id = 'asset-1'"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if asset.Name != "hero_remote" || asset.StatusId != "status-2" {
+		t.Fatalf("server asset is %q with status %q, want the server's name and the client's status", asset.Name, asset.StatusId)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/chunk_service"
+	"clustta/internal/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// GetChunkDictionariesHandler returns the project's compression dictionaries
+// so clients can decode chunks compressed with them.
+func GetChunkDictionariesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := getAuthUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 400)
+		return
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	dictionaries, err := chunk_service.ListChunkDictionaries(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dictionaries)
+}
+
+// TrainChunkDictionariesHandler trains new compression dictionaries from the
+// project's small files. Chunks stored afterwards are compressed with them.
+func TrainChunkDictionariesHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can train compression dictionaries", http.StatusForbidden)
+		return
+	}
+
+	dictionaries, err := chunk_service.TrainChunkDictionaries(projectPath)
+	if errors.Is(err, chunk_service.ErrDictionaryUnsupported) {
+		SendErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dictionaries)
+}
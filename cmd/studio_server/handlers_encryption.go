@@ -0,0 +1,213 @@
+package main
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/chunk_service"
+	"clustta/internal/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+type projectEncryptionResponse struct {
+	chunk_service.ProjectEncryption
+	Encrypting bool `json:"encrypting"`
+}
+
+// EnableProjectEncryptionHandler turns on encryption at rest for the project.
+// New chunks and previews are sealed immediately and the ones already stored
+// are encrypted in the background.
+func EnableProjectEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can enable project encryption", http.StatusForbidden)
+		return
+	}
+	if !chunk_service.StorageEncryptionAvailable() {
+		SendErrorResponse(w, chunk_service.ErrStorageEncryptionUnavailable.Error(), http.StatusConflict)
+		return
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	mode, err := chunk_service.GetProjectStorageMode(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if mode == chunk_service.StorageModePooled {
+		SendErrorResponse(w, "Pooled storage cannot hold encrypted projects", http.StatusConflict)
+		return
+	}
+	encryption, err := chunk_service.EnableProjectEncryption(tx)
+	if errors.Is(err, chunk_service.ErrStorageMigrationInProgress) {
+		SendErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	if encryption.BackfillPending && !chunk_service.ProjectEncryptionActive(projectPath) {
+		go runEncryptionBackfill(projectPath)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(projectEncryptionResponse{ProjectEncryption: encryption, Encrypting: encryption.BackfillPending})
+}
+
+// GetProjectEncryptionHandler reports whether the project is encrypted at
+// rest and whether its existing data is still being sealed.
+func GetProjectEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can view project encryption", http.StatusForbidden)
+		return
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	encryption, err := chunk_service.GetProjectEncryption(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projectEncryptionResponse{
+		ProjectEncryption: encryption,
+		Encrypting:        chunk_service.ProjectEncryptionActive(projectPath),
+	})
+}
+
+func runEncryptionBackfill(projectPath string) {
+	name := filepath.Base(projectPath)
+	backfill, err := chunk_service.EncryptProjectData(
+		context.Background(), projectPath, chunk_service.DefaultEncryptionBatchSize,
+	)
+	if errors.Is(err, chunk_service.ErrEncryptionBusy) {
+		return
+	}
+	if err != nil {
+		log.Printf("[Encryption] %s: stopped after %d chunks and %d previews: %v", name, backfill.Chunks, backfill.Previews, err)
+		return
+	}
+	log.Printf("[Encryption] %s: encrypted %d existing chunks and %d previews", name, backfill.Chunks, backfill.Previews)
+}
+
+// resumeProjectEncryption re-wraps project data keys still sealed by a
+// previous master key and restarts encryption of existing data that was
+// interrupted when the server last stopped.
+func resumeProjectEncryption(projectPaths []string) {
+	if !chunk_service.StorageEncryptionAvailable() {
+		return
+	}
+	for _, projectPath := range projectPaths {
+		encryption, err := rewrapProjectKey(projectPath)
+		if err != nil {
+			log.Printf("[Encryption] %s: %v", filepath.Base(projectPath), err)
+			continue
+		}
+		if encryption.BackfillPending {
+			go runEncryptionBackfill(projectPath)
+		}
+	}
+}
+
+func rewrapProjectKey(projectPath string) (chunk_service.ProjectEncryption, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return chunk_service.ProjectEncryption{}, err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return chunk_service.ProjectEncryption{}, err
+	}
+	defer tx.Rollback()
+
+	encryption, err := chunk_service.GetProjectEncryption(tx)
+	if err != nil || !encryption.Enabled {
+		return encryption, err
+	}
+	rewrapped, err := chunk_service.RewrapProjectKey(tx)
+	if err != nil || !rewrapped {
+		return encryption, err
+	}
+	if err := tx.Commit(); err != nil {
+		return encryption, err
+	}
+	log.Printf("[Encryption] %s: data key re-wrapped with the current master key", filepath.Base(projectPath))
+	return encryption, nil
+}
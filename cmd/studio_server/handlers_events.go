@@ -0,0 +1,118 @@
+package main
+
+import (
+	"clustta/internal/event_service"
+	"clustta/internal/repository"
+	"clustta/internal/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// eventsKeepAlive is how often an idle event stream sends a comment so
+// proxies do not close it.
+const eventsKeepAlive = 25 * time.Second
+
+// GetProjectEventsHandler streams the project's sync token changes as
+// Server-Sent Events, starting with the current token, so clients no longer
+// need to poll /sync-token.
+func GetProjectEventsHandler(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := getAuthUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	project := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, project)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", http.StatusNotFound)
+		return
+	}
+	userInProject, err := repository.UserInProject(projectPath, authUser.Id)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if !userInProject {
+		http.Error(w, "user not in project", 400)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the token so no write falls in between.
+	events, unsubscribe := event_service.Subscribe(project)
+	defer unsubscribe()
+	syncToken, err := readProjectSyncToken(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	err = writeSyncTokenEvent(w, event_service.SyncTokenEvent{Project: project, SyncToken: syncToken, ChangedTables: []string{}})
+	if err == nil {
+		err = rc.Flush()
+	}
+	if err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			err = writeSyncTokenEvent(w, event)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeSyncTokenEvent(w http.ResponseWriter, event event_service.SyncTokenEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: sync-token\ndata: %s\n\n", data)
+	return err
+}
+
+func readProjectSyncToken(projectPath string) (string, error) {
+	db, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	return utils.GetProjectSyncToken(tx)
+}
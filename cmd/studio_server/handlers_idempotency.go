@@ -0,0 +1,101 @@
+package main
+
+import (
+	"clustta/internal/repository"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// idempotencyKeyHeader carries the client-chosen key a retried request
+// repeats so the server applies it at most once.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyRetention is how long responses are kept for replays.
+func idempotencyRetention() time.Duration {
+	if CONFIG.IdempotencyRetention == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(CONFIG.IdempotencyRetention)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: invalid IDEMPOTENCY_RETENTION %q, using 24h", CONFIG.IdempotencyRetention)
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// idempotentRequest is a request carrying an Idempotency-Key. The zero value
+// stands for a request without one, which is never replayed.
+type idempotentRequest struct {
+	userId      string
+	key         string
+	endpoint    string
+	requestHash string
+}
+
+func newIdempotentRequest(r *http.Request, userId string, body []byte) idempotentRequest {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return idempotentRequest{}
+	}
+	hash := sha256.Sum256(body)
+	return idempotentRequest{
+		userId:      userId,
+		key:         key,
+		endpoint:    r.Method + " " + r.URL.Path,
+		requestHash: hex.EncodeToString(hash[:]),
+	}
+}
+
+// replay answers the request with the response stored for its key, reporting
+// whether it did. A key reused for a different request is refused.
+func (req idempotentRequest) replay(w http.ResponseWriter, tx *sqlx.Tx) bool {
+	if req.key == "" {
+		return false
+	}
+	stored, ok, err := repository.GetIdempotentResponse(tx, req.userId, req.key)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return true
+	}
+	if !ok || stored.CreatedAt < time.Now().Add(-idempotencyRetention()).Unix() {
+		return false
+	}
+	if stored.Endpoint != req.endpoint || stored.RequestHash != req.requestHash {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Response)
+	return true
+}
+
+// save stores response in tx, to be committed with the changes it reports,
+// and forgets responses past the retention window.
+func (req idempotentRequest) save(tx *sqlx.Tx, status int, response []byte, syncToken string) error {
+	if req.key == "" {
+		return nil
+	}
+	now := time.Now()
+	err := repository.PruneIdempotentResponses(tx, now.Add(-idempotencyRetention()).Unix())
+	if err != nil {
+		return err
+	}
+	return repository.SaveIdempotentResponse(tx, repository.IdempotentResponse{
+		UserId:      req.userId,
+		Key:         req.key,
+		Endpoint:    req.endpoint,
+		RequestHash: req.requestHash,
+		Status:      status,
+		Response:    response,
+		SyncToken:   syncToken,
+		CreatedAt:   now.Unix(),
+	})
+}
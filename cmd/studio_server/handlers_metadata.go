@@ -1,11 +1,14 @@
 package main
 
 import (
+	"clustta/internal/event_service"
 	"clustta/internal/metadata_service"
+	"clustta/internal/repository"
 	"clustta/internal/utils"
 	"encoding/json"
 	"errors"
 	"github.com/jmoiron/sqlx"
+	"io"
 	"net/http"
 )
 
@@ -31,6 +34,10 @@ func openMutationProject(w http.ResponseWriter, r *http.Request) (string, *sqlx.
 		http.Error(w, "Project Not Found", 404)
 		return "", nil, false
 	}
+	if e = repository.UpdateProject(path); e != nil {
+		http.Error(w, "Error opening project file", 500)
+		return "", nil, false
+	}
 	db, e := utils.OpenDb(path)
 	if e != nil {
 		http.Error(w, "Error opening project file", 500)
@@ -44,8 +51,13 @@ func PatchAssetsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer db.Close()
+	body, e := io.ReadAll(r.Body)
+	if e != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
 	var req metadata_service.AssetRequest
-	if json.NewDecoder(r.Body).Decode(&req) != nil {
+	if json.Unmarshal(body, &req) != nil {
 		http.Error(w, "invalid request", 400)
 		return
 	}
@@ -55,17 +67,31 @@ func PatchAssetsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer tx.Rollback()
+	idempotent := newIdempotentRequest(r, id, body)
+	if idempotent.replay(w, tx) {
+		return
+	}
 	out, e := metadata_service.ApplyAssets(tx, id, req)
 	if e != nil {
 		writeMutationError(w, e)
 		return
 	}
+	response, e := json.Marshal(out)
+	if e != nil {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if e = idempotent.save(tx, http.StatusOK, response, out.SyncToken); e != nil {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
 	if e = tx.Commit(); e != nil {
 		http.Error(w, "Internal server error", 500)
 		return
 	}
+	event_service.PublishSyncToken(r.PathValue("project"), out.SyncToken, []string{"asset"})
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(out)
+	w.Write(response)
 }
 func PatchCollectionsHandler(w http.ResponseWriter, r *http.Request) {
 	id, db, ok := openMutationProject(w, r)
@@ -73,8 +99,13 @@ func PatchCollectionsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer db.Close()
+	body, e := io.ReadAll(r.Body)
+	if e != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
 	var req metadata_service.CollectionRequest
-	if json.NewDecoder(r.Body).Decode(&req) != nil {
+	if json.Unmarshal(body, &req) != nil {
 		http.Error(w, "invalid request", 400)
 		return
 	}
@@ -84,17 +115,31 @@ func PatchCollectionsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer tx.Rollback()
+	idempotent := newIdempotentRequest(r, id, body)
+	if idempotent.replay(w, tx) {
+		return
+	}
 	out, e := metadata_service.ApplyCollections(tx, id, req)
 	if e != nil {
 		writeMutationError(w, e)
 		return
 	}
+	response, e := json.Marshal(out)
+	if e != nil {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if e = idempotent.save(tx, http.StatusOK, response, out.SyncToken); e != nil {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
 	if e = tx.Commit(); e != nil {
 		http.Error(w, "Internal server error", 500)
 		return
 	}
+	event_service.PublishSyncToken(r.PathValue("project"), out.SyncToken, []string{"collection", "collection_assignee"})
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(out)
+	w.Write(response)
 }
 
 func PutAssetTypeHandler(w http.ResponseWriter, r *http.Request) {
@@ -124,6 +169,7 @@ func PutAssetTypeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 500)
 		return
 	}
+	event_service.PublishSyncToken(r.PathValue("project"), out.SyncToken, []string{"asset_type"})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(out)
 }
@@ -155,6 +201,7 @@ func PutCollectionTypeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 500)
 		return
 	}
+	event_service.PublishSyncToken(r.PathValue("project"), out.SyncToken, []string{"collection_type"})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(out)
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/chunk_service"
+	"clustta/internal/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// studioUsageTTL is how long measured project usage is reused when checking
+// quotas. Uploads accepted in the meantime are added to the cached figure.
+const studioUsageTTL = time.Minute
+
+type projectUsage struct {
+	Quota      chunk_service.ProjectQuota
+	UsedBytes  int64
+	measuredAt time.Time
+}
+
+var studioUsage struct {
+	sync.Mutex
+	measuredAt time.Time
+	projects   map[string]projectUsage
+	// warned holds the projects admins were already warned about, so the
+	// soft limit is logged once per crossing.
+	warned map[string]bool
+}
+
+type projectQuotaResponse struct {
+	chunk_service.ProjectQuota
+	Default           bool  `json:"default"`
+	UsedBytes         int64 `json:"used_bytes"`
+	SoftLimitExceeded bool  `json:"soft_limit_exceeded"`
+}
+
+// effectiveProjectQuota returns the project's own quota or, without one, the
+// Studio default.
+func effectiveProjectQuota(tx *sqlx.Tx) (chunk_service.ProjectQuota, bool, error) {
+	quota, ok, err := chunk_service.GetProjectQuota(tx)
+	if err != nil || ok {
+		return quota, false, err
+	}
+	return chunk_service.ProjectQuota{LimitBytes: CONFIG.ProjectQuotaBytes}, true, nil
+}
+
+// softLimit returns the usage at which admins are warned, or 0 if limit is
+// unlimited.
+func softLimit(limit, soft int64) int64 {
+	if limit <= 0 {
+		return 0
+	}
+	if soft > 0 {
+		return soft
+	}
+	percent := CONFIG.QuotaSoftLimitPercent
+	if percent <= 0 || percent > 100 {
+		percent = 90
+	}
+	return limit * int64(percent) / 100
+}
+
+func measureProjectUsage(projectPath string) (projectUsage, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return projectUsage{}, err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return projectUsage{}, err
+	}
+	defer tx.Rollback()
+
+	quota, _, err := effectiveProjectQuota(tx)
+	if err != nil {
+		return projectUsage{}, err
+	}
+	used, err := chunk_service.ProjectStorageUsage(tx)
+	if err != nil {
+		return projectUsage{}, err
+	}
+	return projectUsage{Quota: quota, UsedBytes: used, measuredAt: time.Now()}, nil
+}
+
+// cachedProjectUsage returns the project's usage, measuring it again once
+// the cached figure is older than studioUsageTTL. The caller must hold
+// studioUsage.
+func cachedProjectUsage(projectPath string) (projectUsage, error) {
+	if usage, ok := studioUsage.projects[projectPath]; ok && time.Since(usage.measuredAt) < studioUsageTTL {
+		return usage, nil
+	}
+	usage, err := measureProjectUsage(projectPath)
+	if err != nil {
+		return projectUsage{}, err
+	}
+	if studioUsage.projects == nil {
+		studioUsage.projects = map[string]projectUsage{}
+	}
+	studioUsage.projects[projectPath] = usage
+	return usage, nil
+}
+
+// refreshStudioUsage measures every project unless the cached figures are
+// recent. The caller must hold studioUsage.
+func refreshStudioUsage() error {
+	if studioUsage.projects != nil && time.Since(studioUsage.measuredAt) < studioUsageTTL {
+		return nil
+	}
+	entries, err := os.ReadDir(CONFIG.ProjectsDir)
+	if err != nil {
+		return err
+	}
+	projects := map[string]projectUsage{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".clst") {
+			continue
+		}
+		projectPath := filepath.Join(CONFIG.ProjectsDir, entry.Name())
+		usage, err := measureProjectUsage(projectPath)
+		if err != nil {
+			log.Printf("[Quota] failed to measure %s: %v", entry.Name(), err)
+			continue
+		}
+		projects[projectPath] = usage
+	}
+	studioUsage.projects = projects
+	studioUsage.measuredAt = time.Now()
+	return nil
+}
+
+// checkUploadQuota decides whether projectPath may store requested more
+// bytes. It returns the rejection to send, if any, and a soft-limit warning
+// for admins.
+func checkUploadQuota(projectPath string, requested int64) (*chunk_service.QuotaExceededError, string, error) {
+	if CONFIG.MinFreeDiskBytes > 0 {
+		volumes := []string{CONFIG.ProjectsDir}
+		if chunk_service.StorageDirectoryAvailable() {
+			volumes = append(volumes, CONFIG.StorageDir)
+		}
+		for _, volume := range volumes {
+			stats, err := getDiskStats(volume)
+			if err != nil {
+				return nil, "", err
+			}
+			if stats.AvailableBytes-requested < CONFIG.MinFreeDiskBytes {
+				return &chunk_service.QuotaExceededError{
+					Message:        "The Studio server is running out of disk space",
+					Code:           chunk_service.QuotaCodeDiskSpace,
+					LimitBytes:     CONFIG.MinFreeDiskBytes,
+					RequestedBytes: requested,
+					AvailableBytes: stats.AvailableBytes,
+				}, "", nil
+			}
+		}
+	}
+
+	studioUsage.Lock()
+	defer studioUsage.Unlock()
+	project, err := cachedProjectUsage(projectPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit := project.Quota.LimitBytes; limit > 0 && project.UsedBytes+requested > limit {
+		return &chunk_service.QuotaExceededError{
+			Message:        "Project storage quota exceeded",
+			Code:           chunk_service.QuotaCodeProject,
+			LimitBytes:     limit,
+			UsedBytes:      project.UsedBytes,
+			RequestedBytes: requested,
+		}, "", nil
+	}
+
+	var studioUsed int64
+	if CONFIG.StudioQuotaBytes > 0 {
+		if err := refreshStudioUsage(); err != nil {
+			return nil, "", err
+		}
+		if _, ok := studioUsage.projects[projectPath]; !ok {
+			studioUsage.projects[projectPath] = project
+		}
+		for _, usage := range studioUsage.projects {
+			studioUsed += usage.UsedBytes
+		}
+		if studioUsed+requested > CONFIG.StudioQuotaBytes {
+			return &chunk_service.QuotaExceededError{
+				Message:        "Studio storage quota exceeded",
+				Code:           chunk_service.QuotaCodeStudio,
+				LimitBytes:     CONFIG.StudioQuotaBytes,
+				UsedBytes:      studioUsed,
+				RequestedBytes: requested,
+			}, "", nil
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(projectPath), ".clst")
+	var warning string
+	if soft := softLimit(CONFIG.StudioQuotaBytes, 0); soft > 0 && studioUsed+requested >= soft {
+		warning = fmt.Sprintf("Studio storage is at %s of its %s quota",
+			utils.BytesToHumanReadable(int(studioUsed+requested)), utils.BytesToHumanReadable(int(CONFIG.StudioQuotaBytes)))
+	}
+	if soft := softLimit(project.Quota.LimitBytes, project.Quota.SoftLimitBytes); soft > 0 && project.UsedBytes+requested >= soft {
+		warning = fmt.Sprintf("Project %s is at %s of its %s quota", name,
+			utils.BytesToHumanReadable(int(project.UsedBytes+requested)), utils.BytesToHumanReadable(int(project.Quota.LimitBytes)))
+	}
+	if studioUsage.warned == nil {
+		studioUsage.warned = map[string]bool{}
+	}
+	if warning != "" && !studioUsage.warned[projectPath] {
+		log.Printf("[Quota] %s", warning)
+	}
+	studioUsage.warned[projectPath] = warning != ""
+
+	// Count the accepted upload until the project is measured again.
+	if usage, ok := studioUsage.projects[projectPath]; ok {
+		usage.UsedBytes += requested
+		studioUsage.projects[projectPath] = usage
+	}
+	return nil, warning, nil
+}
+
+// enforceUploadQuota checks an upload of requested bytes against the quotas
+// and the free disk floor. It writes a 507 and returns false if the upload
+// must be refused; otherwise admins get any soft-limit warning in the
+// Clustta-Quota-Warning header.
+func enforceUploadQuota(w http.ResponseWriter, r *http.Request, projectPath string, requested int64) bool {
+	quotaErr, warning, err := checkUploadQuota(projectPath, requested)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return false
+	}
+	if quotaErr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInsufficientStorage)
+		json.NewEncoder(w).Encode(quotaErr)
+		return false
+	}
+	if user, ok := getAuthUser(r); ok && warning != "" && Users[user.Id].RoleName == "admin" {
+		w.Header().Set("Clustta-Quota-Warning", warning)
+	}
+	return true
+}
+
+// studioQuotaWarnings lists the projects, and the Studio itself, that are
+// past their soft limit.
+func studioQuotaWarnings() ([]string, error) {
+	studioUsage.Lock()
+	defer studioUsage.Unlock()
+	studioUsage.measuredAt = time.Time{}
+	if err := refreshStudioUsage(); err != nil {
+		return nil, err
+	}
+	warnings := []string{}
+	var studioUsed int64
+	for projectPath, usage := range studioUsage.projects {
+		studioUsed += usage.UsedBytes
+		if soft := softLimit(usage.Quota.LimitBytes, usage.Quota.SoftLimitBytes); soft > 0 && usage.UsedBytes >= soft {
+			warnings = append(warnings, fmt.Sprintf("Project %s is at %s of its %s quota",
+				strings.TrimSuffix(filepath.Base(projectPath), ".clst"),
+				utils.BytesToHumanReadable(int(usage.UsedBytes)), utils.BytesToHumanReadable(int(usage.Quota.LimitBytes))))
+		}
+	}
+	if soft := softLimit(CONFIG.StudioQuotaBytes, 0); soft > 0 && studioUsed >= soft {
+		warnings = append(warnings, fmt.Sprintf("Studio storage is at %s of its %s quota",
+			utils.BytesToHumanReadable(int(studioUsed)), utils.BytesToHumanReadable(int(CONFIG.StudioQuotaBytes))))
+	}
+	return warnings, nil
+}
+
+// quotaProjectPath resolves the project of an admin-only quota request,
+// writing the error response and returning "" if the request is refused.
+func quotaProjectPath(w http.ResponseWriter, r *http.Request) string {
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return ""
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return ""
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return ""
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can manage storage quotas", http.StatusForbidden)
+		return ""
+	}
+	return projectPath
+}
+
+// withQuotaTx runs fn in a transaction on the project and responds with the
+// project's resulting quota.
+func withQuotaTx(w http.ResponseWriter, projectPath string, fn func(tx *sqlx.Tx) error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quota, isDefault, err := effectiveProjectQuota(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	used, err := chunk_service.ProjectStorageUsage(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	studioUsage.Lock()
+	studioUsage.measuredAt = time.Time{}
+	delete(studioUsage.projects, projectPath)
+	studioUsage.Unlock()
+
+	soft := softLimit(quota.LimitBytes, quota.SoftLimitBytes)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projectQuotaResponse{
+		ProjectQuota:      quota,
+		Default:           isDefault,
+		UsedBytes:         used,
+		SoftLimitExceeded: soft > 0 && used >= soft,
+	})
+}
+
+// GetProjectQuotaHandler reports the project's quota and how much of it is
+// used.
+func GetProjectQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath := quotaProjectPath(w, r)
+	if projectPath == "" {
+		return
+	}
+	withQuotaTx(w, projectPath, func(tx *sqlx.Tx) error { return nil })
+}
+
+// SetProjectQuotaHandler gives the project its own quota instead of the
+// Studio default.
+func SetProjectQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath := quotaProjectPath(w, r)
+	if projectPath == "" {
+		return
+	}
+	quota := chunk_service.ProjectQuota{}
+	if err := json.NewDecoder(r.Body).Decode(&quota); err != nil {
+		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	withQuotaTx(w, projectPath, func(tx *sqlx.Tx) error {
+		return chunk_service.SetProjectQuota(tx, quota)
+	})
+}
+
+// DeleteProjectQuotaHandler returns the project to the Studio default quota.
+func DeleteProjectQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath := quotaProjectPath(w, r)
+	if projectPath == "" {
+		return
+	}
+	withQuotaTx(w, projectPath, chunk_service.ClearProjectQuota)
+}
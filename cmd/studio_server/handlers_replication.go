@@ -0,0 +1,574 @@
+package main
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/chunk_service"
+	"clustta/internal/event_service"
+	"clustta/internal/replication_service"
+	"clustta/internal/repository"
+	"clustta/internal/repository/sync_service"
+	"clustta/internal/utils"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// replicaPollInterval is how often a continuous mirror pulls when the
+// primary's event stream is down or missed an event.
+const replicaPollInterval = 5 * time.Minute
+
+// replicaMaintenancePaths are the project endpoints that keep working on a
+// read-only mirror, as they manage its local storage rather than its data.
+var replicaMaintenancePaths = map[string]bool{
+	"replica":           true,
+	"storage-migration": true,
+	"gc":                true,
+	"scrub":             true,
+	"repack":            true,
+	"archive":           true,
+	"warm-up":           true,
+	"encryption":        true,
+	"dictionaries":      true,
+}
+
+// replicaRunner pulls one mirror from its primary until cancelled.
+type replicaRunner struct {
+	projectPath string
+	token       string
+	cancel      context.CancelFunc
+	done        chan struct{}
+	trigger     chan struct{}
+
+	mu               sync.Mutex
+	primarySyncToken string
+}
+
+var replicas = struct {
+	sync.Mutex
+	runners map[string]*replicaRunner
+}{runners: map[string]*replicaRunner{}}
+
+// mirrors remembers which projects are mirrors, as read from their replica
+// row, so the read-only check does not open every project on each write.
+var mirrors = struct {
+	sync.Mutex
+	known map[string]bool
+}{known: map[string]bool{}}
+
+// isMirror reports whether the project is a read-only mirror. A project
+// that does not exist is not one.
+func isMirror(projectName string) (bool, error) {
+	mirrors.Lock()
+	mirror, ok := mirrors.known[projectName]
+	mirrors.Unlock()
+	if ok {
+		return mirror, nil
+	}
+	projectPath, err := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if err != nil || !utils.FileExists(projectPath) {
+		return false, nil
+	}
+	mirror, err = replication_service.IsMirror(projectPath)
+	if err != nil {
+		return false, err
+	}
+	setMirror(projectName, mirror)
+	return mirror, nil
+}
+
+func setMirror(projectName string, mirror bool) {
+	mirrors.Lock()
+	mirrors.known[projectName] = mirror
+	mirrors.Unlock()
+}
+
+// forgetMirror drops what is known of the project, e.g. once it is deleted.
+func forgetMirror(projectName string) {
+	mirrors.Lock()
+	delete(mirrors.known, projectName)
+	mirrors.Unlock()
+}
+
+// replicaToken is the API token the mirror pulls from its primary with.
+func replicaToken(projectName string) string {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return CONFIG.ReplicaTokens[projectName]
+}
+
+// setReplicaToken saves the API token the mirror pulls with in the studio
+// config, removing it when token is empty.
+func setReplicaToken(projectName, token string) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if CONFIG.ReplicaTokens[projectName] == token {
+		return nil
+	}
+
+	updated := CONFIG
+	updated.ReplicaTokens = map[string]string{}
+	for name, saved := range CONFIG.ReplicaTokens {
+		updated.ReplicaTokens[name] = saved
+	}
+	if token == "" {
+		delete(updated.ReplicaTokens, projectName)
+	} else {
+		updated.ReplicaTokens[projectName] = token
+	}
+	if err := saveConfig(&updated); err != nil {
+		return err
+	}
+	CONFIG = updated
+	return nil
+}
+
+// pullSoon asks the runner to pull without waiting for its next turn.
+func (runner *replicaRunner) pullSoon() {
+	select {
+	case runner.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (runner *replicaRunner) setPrimarySyncToken(token string) {
+	runner.mu.Lock()
+	runner.primarySyncToken = token
+	runner.mu.Unlock()
+}
+
+func (runner *replicaRunner) getPrimarySyncToken() string {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	return runner.primarySyncToken
+}
+
+// startReplicas resumes pulling every mirror in projectsDir.
+func startReplicas(projectsDir string) {
+	entries, err := os.ReadDir(projectsDir)
+	if err != nil {
+		log.Printf("[Replica] failed to list projects: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".clst") {
+			continue
+		}
+		projectPath := filepath.Join(projectsDir, entry.Name())
+		replica, ok, err := replication_service.ReadReplica(projectPath)
+		if err != nil {
+			log.Printf("[Replica] %s: %v", entry.Name(), err)
+			continue
+		}
+		if ok {
+			projectName := strings.TrimSuffix(entry.Name(), ".clst")
+			setMirror(projectName, true)
+			startReplica(projectName, projectPath, replica, replicaToken(projectName))
+		}
+	}
+}
+
+// startReplica starts pulling the mirror with the primary's API token,
+// replacing any runner it had.
+func startReplica(projectName, projectPath string, replica replication_service.Replica, token string) {
+	stopReplica(projectName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &replicaRunner{
+		projectPath: projectPath,
+		token:       token,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		trigger:     make(chan struct{}, 1),
+	}
+	replicas.Lock()
+	replicas.runners[projectName] = runner
+	replicas.Unlock()
+
+	go func() {
+		defer close(runner.done)
+		runReplica(ctx, runner, projectName, replica)
+	}()
+}
+
+// stopReplica stops the mirror's runner, waiting for an ongoing pull to
+// give up.
+func stopReplica(projectName string) {
+	replicas.Lock()
+	runner := replicas.runners[projectName]
+	delete(replicas.runners, projectName)
+	replicas.Unlock()
+	if runner == nil {
+		return
+	}
+	runner.cancel()
+	<-runner.done
+}
+
+func getReplicaRunner(projectName string) *replicaRunner {
+	replicas.Lock()
+	defer replicas.Unlock()
+	return replicas.runners[projectName]
+}
+
+func runReplica(ctx context.Context, runner *replicaRunner, projectName string, replica replication_service.Replica) {
+	interval := replica.Interval()
+	if interval == 0 {
+		interval = replicaPollInterval
+		go watchPrimary(ctx, runner, replica)
+	}
+	applied := replica.SyncToken
+	for {
+		replica, err := replication_service.Replicate(ctx, runner.projectPath, runner.token, func(int, int, string, string) {})
+		if ctx.Err() != nil {
+			return
+		}
+		wait := interval
+		if err != nil {
+			log.Printf("[Replica] %s: pull from %s failed: %v", projectName, replica.PrimaryUrl, err)
+			wait = min(wait, time.Duration(replica.ConsecutiveFailures)*30*time.Second, 10*time.Minute)
+		} else if replica.SyncToken != applied {
+			applied = replica.SyncToken
+			event_service.PublishSyncToken(projectName, applied, sync_service.ProjectTables)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-runner.trigger:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// watchPrimary triggers a pull whenever the primary reports a sync token,
+// reconnecting to its event stream until ctx is cancelled.
+func watchPrimary(ctx context.Context, runner *replicaRunner, replica replication_service.Replica) {
+	user := auth_service.User{Id: replica.UserId}
+	ctx = utils.WithHTTPClient(ctx, replication_service.PrimaryClient(replica.PrimaryUrl, runner.token))
+	for {
+		err := repository.WatchSyncToken(ctx, replica.PrimaryUrl, user, func(event event_service.SyncTokenEvent) {
+			runner.setPrimarySyncToken(event.SyncToken)
+			runner.pullSoon()
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("[Replica] events of %s: %v", replica.PrimaryUrl, err)
+		}
+		timer := time.NewTimer(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// ReplicaMiddleware refuses writes to mirrored projects, which only change
+// by pulling from their primary, apart from deleting them and managing
+// their storage.
+func ReplicaMiddleware(next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		mirror, err := isMirror(parts[0])
+		if err != nil {
+			log.Printf("Request error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		if mirror {
+			deleting := len(parts) == 1 && r.Method == http.MethodDelete
+			if !deleting && (len(parts) == 1 || !replicaMaintenancePaths[parts[1]]) {
+				http.Error(w, "Project is a read-only mirror", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type replicaStatus struct {
+	Project string `json:"project"`
+	replication_service.Replica
+	PrimarySyncToken string `json:"primary_sync_token"`
+	LagSeconds       int64  `json:"lag_seconds"`
+	// Behind is set when the primary reported a sync token the mirror has
+	// not caught up with yet.
+	Behind bool `json:"behind"`
+}
+
+func newReplicaStatus(projectName string, replica replication_service.Replica) replicaStatus {
+	status := replicaStatus{
+		Project:    projectName,
+		Replica:    replica,
+		LagSeconds: int64(replica.Lag(time.Now()).Seconds()),
+	}
+	if runner := getReplicaRunner(projectName); runner != nil {
+		status.PrimarySyncToken = runner.getPrimarySyncToken()
+	}
+	status.Behind = status.PrimarySyncToken != "" && status.PrimarySyncToken != replica.SyncToken
+	return status
+}
+
+// replicaAdmin checks the caller is a studio admin, responding otherwise.
+func replicaAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return false
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can manage project replication", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// mirrorProjectPath resolves the project of a replica request, responding
+// when it is not a mirror.
+func mirrorProjectPath(w http.ResponseWriter, r *http.Request) (string, replication_service.Replica, bool) {
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return "", replication_service.Replica{}, false
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return "", replication_service.Replica{}, false
+	}
+	if !replicaAdmin(w, r) {
+		return "", replication_service.Replica{}, false
+	}
+	if err := repository.UpdateProject(projectPath); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return "", replication_service.Replica{}, false
+	}
+	replica, ok, err := replication_service.ReadReplica(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return "", replication_service.Replica{}, false
+	}
+	if !ok {
+		SendErrorResponse(w, "Project is not a mirror", http.StatusNotFound)
+		return "", replication_service.Replica{}, false
+	}
+	return projectPath, replica, true
+}
+
+// PutProjectReplicaHandler creates the project as a read-only mirror of a
+// project on another studio, or changes how an existing mirror pulls. The
+// mirror starts pulling right away.
+func PutProjectReplicaHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !replicaAdmin(w, r) {
+		return
+	}
+
+	var payload struct {
+		replication_service.Replica
+		Token       string `json:"token"`
+		StorageMode string `json:"storage_mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	replica := payload.Replica
+	replica.PrimaryUrl = strings.TrimRight(replica.PrimaryUrl, "/")
+	if err := replica.Validate(); err != nil {
+		SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !utils.FileExists(projectPath) {
+		if payload.StorageMode == "" {
+			payload.StorageMode = chunk_service.StorageModeCompact
+		}
+		if err := replication_service.CreateMirror(projectPath, replica, payload.Token, payload.StorageMode); err != nil {
+			removeProjectDatabaseFiles(projectPath)
+			forgetMirror(projectName)
+			SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		forgetProjectStorageMode(projectPath)
+		if err := enableChangeTracking(projectPath); err != nil {
+			removeProjectDatabaseFiles(projectPath)
+			forgetMirror(projectName)
+			log.Printf("Request error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	} else {
+		if err := repository.UpdateProject(projectPath); err != nil {
+			log.Printf("Request error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		mirror, err := replication_service.IsMirror(projectPath)
+		if err != nil {
+			log.Printf("Request error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		if !mirror {
+			SendErrorResponse(w, "Project Already Exist", http.StatusConflict)
+			return
+		}
+		if payload.Token == "" {
+			payload.Token = replicaToken(projectName)
+		}
+		ok := withReplicaTx(w, projectPath, func(tx *sqlx.Tx) error {
+			return replication_service.SetReplica(tx, replica)
+		})
+		if !ok {
+			return
+		}
+	}
+
+	setMirror(projectName, true)
+	if err := setReplicaToken(projectName, payload.Token); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	replica, _, err := replication_service.ReadReplica(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	startReplica(projectName, projectPath, replica, payload.Token)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newReplicaStatus(projectName, replica))
+}
+
+// GetProjectReplicaHandler reports how far the mirror lags its primary and
+// how its last pulls went.
+func GetProjectReplicaHandler(w http.ResponseWriter, r *http.Request) {
+	_, replica, ok := mirrorProjectPath(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newReplicaStatus(r.PathValue("project"), replica))
+}
+
+// SyncProjectReplicaHandler makes the mirror pull from its primary now.
+func SyncProjectReplicaHandler(w http.ResponseWriter, r *http.Request) {
+	_, replica, ok := mirrorProjectPath(w, r)
+	if !ok {
+		return
+	}
+	if runner := getReplicaRunner(r.PathValue("project")); runner != nil {
+		runner.pullSoon()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newReplicaStatus(r.PathValue("project"), replica))
+}
+
+// PromoteProjectReplicaHandler turns the mirror into a writable project that
+// no longer follows its primary, e.g. when the primary studio is lost.
+func PromoteProjectReplicaHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, replica, ok := mirrorProjectPath(w, r)
+	if !ok {
+		return
+	}
+	stopReplica(projectName)
+	if !withReplicaTx(w, projectPath, replication_service.ClearReplica) {
+		return
+	}
+	setMirror(projectName, false)
+	if err := setReplicaToken(projectName, ""); err != nil {
+		log.Printf("[Replica] %s: failed to remove its token: %v", projectName, err)
+	}
+	log.Printf("[Replica] %s promoted, no longer following %s", projectName, replica.PrimaryUrl)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newReplicaStatus(projectName, replica))
+}
+
+// GetReplicasHandler lists the studio's mirrors with their lag.
+func GetReplicasHandler(w http.ResponseWriter, r *http.Request) {
+	if !replicaAdmin(w, r) {
+		return
+	}
+	replicas.Lock()
+	runners := map[string]string{}
+	for projectName, runner := range replicas.runners {
+		runners[projectName] = runner.projectPath
+	}
+	replicas.Unlock()
+
+	statuses := []replicaStatus{}
+	for projectName, projectPath := range runners {
+		replica, ok, err := replication_service.ReadReplica(projectPath)
+		if err != nil {
+			log.Printf("[Replica] %s: %v", projectName, err)
+			continue
+		}
+		if ok {
+			statuses = append(statuses, newReplicaStatus(projectName, replica))
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Project < statuses[j].Project })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// withReplicaTx runs fn in a transaction on the project, responding when it
+// fails, and reports whether it was committed.
+func withReplicaTx(w http.ResponseWriter, projectPath string, fn func(tx *sqlx.Tx) error) bool {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return false
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return false
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return false
+	}
+	return true
+}
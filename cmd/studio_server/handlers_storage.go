@@ -0,0 +1,538 @@
+package main
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/chunk_service"
+	"clustta/internal/repository"
+	"clustta/internal/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type storageMigrationResponse struct {
+	chunk_service.StorageMigration
+	Active bool `json:"active"`
+}
+
+// StartStorageMigrationHandler moves an existing project to another storage
+// mode in the background. The project keeps serving from its current mode
+// until every chunk has been copied and verified.
+func StartStorageMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can migrate project storage", http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		StorageMode string `json:"storage_mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.StorageMode == "" {
+		SendErrorResponse(w, "storage_mode is required", http.StatusBadRequest)
+		return
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	migration, err := chunk_service.StartStorageMigration(tx, payload.StorageMode)
+	if err != nil {
+		SendErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	if !chunk_service.StorageMigrationActive(projectPath) {
+		go runStorageMigration(projectPath, migration.TargetMode)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(storageMigrationResponse{StorageMigration: migration, Active: true})
+}
+
+// GetStorageMigrationHandler reports the progress of the project's most
+// recent storage migration.
+func GetStorageMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can view storage migrations", http.StatusForbidden)
+		return
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	migration, err := chunk_service.GetStorageMigration(tx)
+	if errors.Is(err, chunk_service.ErrNoStorageMigration) {
+		SendErrorResponse(w, "Project has no storage migration", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(storageMigrationResponse{
+		StorageMigration: migration,
+		Active:           chunk_service.StorageMigrationActive(projectPath),
+	})
+}
+
+func runStorageMigration(projectPath, targetMode string) {
+	name := filepath.Base(projectPath)
+	log.Printf("[StorageMigration] %s: migrating to %s storage", name, targetMode)
+	migration, err := chunk_service.MigrateProjectStorage(
+		context.Background(), projectPath, targetMode, chunk_service.DefaultStorageMigrationBatchSize, nil,
+	)
+	forgetProjectStorageMode(projectPath)
+	if errors.Is(err, chunk_service.ErrStorageMigrationBusy) {
+		return
+	}
+	if err != nil {
+		log.Printf("[StorageMigration] %s: stopped after %d/%d chunks: %v", name, migration.MigratedChunks, migration.TotalChunks, err)
+		return
+	}
+	log.Printf("[StorageMigration] %s: completed, %d chunks (%d bytes) now in %s storage", name, migration.MigratedChunks, migration.MigratedBytes, targetMode)
+}
+
+// resumeStorageMigrations restarts migrations that were still running when the
+// server last stopped.
+func resumeStorageMigrations(projectPaths []string) {
+	for _, projectPath := range projectPaths {
+		dbConn, err := utils.OpenDb(projectPath)
+		if err != nil {
+			continue
+		}
+		tx, err := dbConn.Beginx()
+		if err != nil {
+			dbConn.Close()
+			continue
+		}
+		migration, err := chunk_service.GetStorageMigration(tx)
+		tx.Rollback()
+		dbConn.Close()
+		if err == nil && migration.Status == chunk_service.StorageMigrationRunning {
+			go runStorageMigration(projectPath, migration.TargetMode)
+		}
+	}
+}
+
+// runMigrateStorageCommand implements `migrate-storage <project> <mode>`,
+// running a migration in the foreground with the studio's storage config.
+func runMigrateStorageCommand(args []string) {
+	if len(args) != 2 {
+		println("usage: migrate-storage <project> <compact|deflated|object_storage>")
+		os.Exit(2)
+	}
+	readFile(&CONFIG)
+	readEnv(&CONFIG)
+	loadDefaults(&CONFIG)
+	configureChunkStorage()
+
+	projectName := strings.TrimSuffix(args[0], ".clst")
+	projectPath, err := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if err != nil || !utils.FileExists(projectPath) {
+		fmt.Printf("Project %q not found in %s\n", args[0], CONFIG.ProjectsDir)
+		os.Exit(1)
+	}
+	if err := repository.UpdateProject(projectPath); err != nil {
+		fmt.Printf("Failed to update project: %v\n", err)
+		os.Exit(1)
+	}
+
+	migration, err := chunk_service.MigrateProjectStorage(
+		context.Background(), projectPath, args[1], chunk_service.DefaultStorageMigrationBatchSize,
+		func(m chunk_service.StorageMigration) {
+			fmt.Printf("\rMigrated %d/%d chunks (%d bytes, %d failed)", m.MigratedChunks, m.TotalChunks, m.MigratedBytes, len(m.FailedChunks))
+		},
+	)
+	fmt.Println()
+	if err != nil {
+		for _, hash := range migration.FailedChunks {
+			fmt.Printf("  failed chunk %s\n", hash)
+		}
+		fmt.Printf("Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Project %s now uses %s storage\n", projectName, migration.TargetMode)
+}
+
+// CollectGarbageHandler sweeps chunks no checkpoint or template references.
+// ?dry_run=1 only reports what would be reclaimed; ?grace_period=<duration>
+// overrides the configured grace period for this pass.
+func CollectGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can collect garbage", http.StatusForbidden)
+		return
+	}
+
+	gracePeriod := chunkGCGracePeriod()
+	if value := r.URL.Query().Get("grace_period"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			SendErrorResponse(w, "grace_period must be a non-negative duration", http.StatusBadRequest)
+			return
+		}
+		gracePeriod = parsed
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "1" || r.URL.Query().Get("dry_run") == "true"
+
+	report, err := chunk_service.CollectGarbage(projectPath, gracePeriod, dryRun)
+	if errors.Is(err, chunk_service.ErrStorageMigrationInProgress) {
+		SendErrorResponse(w, "Project storage is being migrated", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if !dryRun {
+		log.Printf("[GC] %s: reclaimed %d bytes (%d chunks, %d orphaned objects)", projectName, report.ReclaimableBytes, report.ReclaimableChunks, report.OrphanedObjects)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func chunkGCGracePeriod() time.Duration {
+	if d, err := time.ParseDuration(CONFIG.ChunkGCGracePeriod); err == nil && d >= 0 {
+		return d
+	}
+	return chunk_service.DefaultChunkGCGracePeriod
+}
+
+// GetChunkHealthHandler reports the project's scrub status and quarantined
+// chunks, with the checkpoints and templates each one breaks.
+func GetChunkHealthHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can view chunk health", http.StatusForbidden)
+		return
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	report, err := chunk_service.GetChunkHealth(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// ScrubChunksHandler starts an immediate background scrub of the project.
+func ScrubChunksHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can scrub project storage", http.StatusForbidden)
+		return
+	}
+
+	go scrubProject(projectPath)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Scrub started"})
+}
+
+func scrubProject(projectPath string) {
+	name := filepath.Base(projectPath)
+	status, err := chunk_service.ScrubChunks(context.Background(), projectPath, chunk_service.DefaultScrubBatchSize)
+	if errors.Is(err, chunk_service.ErrScrubBusy) || errors.Is(err, chunk_service.ErrStorageMigrationInProgress) {
+		return
+	}
+	if err != nil {
+		log.Printf("[Scrub] %s: stopped after %d chunks: %v", name, status.ScannedChunks, err)
+		return
+	}
+	if status.CorruptChunks > 0 {
+		log.Printf("[Scrub] %s: quarantined %d of %d chunks", name, status.CorruptChunks, status.ScannedChunks)
+	}
+}
+
+func chunkScrubInterval() time.Duration {
+	if CONFIG.ChunkScrubInterval == "" {
+		return 7 * 24 * time.Hour
+	}
+	d, err := time.ParseDuration(CONFIG.ChunkScrubInterval)
+	if err != nil {
+		log.Printf("Warning: invalid CHUNK_SCRUB_INTERVAL %q, using 168h", CONFIG.ChunkScrubInterval)
+		return 7 * 24 * time.Hour
+	}
+	return d
+}
+
+// runChunkScrubber periodically scrubs every project whose last scrub is
+// older than the configured interval.
+func runChunkScrubber(projectsDir string) {
+	interval := chunkScrubInterval()
+	if interval <= 0 {
+		return
+	}
+	for {
+		entries, err := os.ReadDir(projectsDir)
+		if err != nil {
+			log.Printf("[Scrub] failed to list projects: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".clst") {
+				continue
+			}
+			projectPath := filepath.Join(projectsDir, entry.Name())
+			due, err := chunk_service.ScrubDue(projectPath, interval)
+			if err != nil {
+				log.Printf("[Scrub] %s: %v", entry.Name(), err)
+				continue
+			}
+			if due {
+				scrubProject(projectPath)
+			}
+		}
+		time.Sleep(min(interval, time.Hour))
+	}
+}
+
+// RepackChunksHandler starts an immediate background repack of a deflated
+// project.
+func RepackChunksHandler(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, projectName)
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can repack project storage", http.StatusForbidden)
+		return
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+	mode, err := chunk_service.GetProjectStorageMode(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	if mode != chunk_service.StorageModeDeflated {
+		SendErrorResponse(w, chunk_service.ErrRepackUnsupported.Error(), http.StatusConflict)
+		return
+	}
+
+	go repackProject(projectPath)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Repack started"})
+}
+
+func repackProject(projectPath string) {
+	name := filepath.Base(projectPath)
+	report, err := chunk_service.RepackChunks(context.Background(), projectPath)
+	if errors.Is(err, chunk_service.ErrRepackBusy) || errors.Is(err, chunk_service.ErrRepackUnsupported) ||
+		errors.Is(err, chunk_service.ErrStorageMigrationInProgress) {
+		return
+	}
+	if err != nil {
+		log.Printf("[Repack] %s: stopped: %v", name, err)
+		return
+	}
+	if report != (chunk_service.RepackReport{}) {
+		log.Printf("[Repack] %s: packed %d loose chunks, rewrote %d and removed %d packs, reclaimed %d bytes",
+			name, report.LooseChunksPacked, report.PacksRewritten, report.PacksRemoved, report.ReclaimedBytes)
+	}
+}
+
+func chunkRepackInterval() time.Duration {
+	if CONFIG.ChunkRepackInterval == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(CONFIG.ChunkRepackInterval)
+	if err != nil {
+		log.Printf("Warning: invalid CHUNK_REPACK_INTERVAL %q, using 24h", CONFIG.ChunkRepackInterval)
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// runChunkRepacker periodically repacks every deflated project.
+func runChunkRepacker(projectsDir string) {
+	interval := chunkRepackInterval()
+	if interval <= 0 {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		entries, err := os.ReadDir(projectsDir)
+		if err != nil {
+			log.Printf("[Repack] failed to list projects: %v", err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".clst") {
+				continue
+			}
+			repackProject(filepath.Join(projectsDir, entry.Name()))
+		}
+	}
+}
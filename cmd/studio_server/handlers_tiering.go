@@ -0,0 +1,234 @@
+package main
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/chunk_service"
+	"clustta/internal/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type tieringRequest struct {
+	CollectionId string `json:"collection_id"`
+}
+
+// tieringProjectPath resolves the project of an admin-only tiering request,
+// writing the error response and returning "" if the request is refused.
+func tieringProjectPath(w http.ResponseWriter, r *http.Request) string {
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return ""
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 404)
+		return ""
+	}
+
+	user := auth_service.User{}
+	if err := json.Unmarshal([]byte(r.Header.Get("UserData")), &user); err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return ""
+	}
+	if Users[user.Id].RoleName != "admin" {
+		SendErrorResponse(w, "Only admins can manage storage tiers", http.StatusForbidden)
+		return ""
+	}
+	return projectPath
+}
+
+// setCollectionTier records a collection mark before a background tiering
+// pass starts, so an unknown collection is reported to the caller.
+func setCollectionTier(projectPath, collectionId, tier string) error {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := chunk_service.SetCollectionTier(tx, collectionId, tier); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ArchiveChunksHandler starts a background pass moving cold chunks to the
+// archive. With a collection_id the collection is marked cold first.
+func ArchiveChunksHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath := tieringProjectPath(w, r)
+	if projectPath == "" {
+		return
+	}
+	if !chunk_service.ChunkArchiveAvailable() {
+		SendErrorResponse(w, chunk_service.ErrArchiveUnavailable.Error(), http.StatusConflict)
+		return
+	}
+	request := tieringRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if request.CollectionId != "" {
+		if err := setCollectionTier(projectPath, request.CollectionId, chunk_service.CollectionTierCold); err != nil {
+			SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	go tierProject(projectPath)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Archiving started"})
+}
+
+// WarmChunksHandler starts moving archived chunks back to the project's
+// storage, for a reopened shot given by collection_id or, without one, for
+// the whole project.
+func WarmChunksHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath := tieringProjectPath(w, r)
+	if projectPath == "" {
+		return
+	}
+	if !chunk_service.ChunkArchiveAvailable() {
+		SendErrorResponse(w, chunk_service.ErrArchiveUnavailable.Error(), http.StatusConflict)
+		return
+	}
+	request := tieringRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if request.CollectionId != "" {
+		if err := setCollectionTier(projectPath, request.CollectionId, chunk_service.CollectionTierHot); err != nil {
+			SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	go warmProject(projectPath, request.CollectionId)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Warm-up started"})
+}
+
+func GetTieringStatusHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath := tieringProjectPath(w, r)
+	if projectPath == "" {
+		return
+	}
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	defer tx.Rollback()
+
+	status, err := chunk_service.GetTieringStatus(tx)
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func tierProject(projectPath string) {
+	name := filepath.Base(projectPath)
+	report, err := chunk_service.ArchiveColdChunks(context.Background(), projectPath, chunkTieringPolicy())
+	if errors.Is(err, chunk_service.ErrTieringBusy) || errors.Is(err, chunk_service.ErrTieringUnsupported) ||
+		errors.Is(err, chunk_service.ErrStorageMigrationInProgress) {
+		return
+	}
+	if err != nil {
+		log.Printf("[Tiering] %s: stopped: %v", name, err)
+		return
+	}
+	if report.ArchivedChunks > 0 {
+		log.Printf("[Tiering] %s: archived %d cold chunks (%d bytes)", name, report.ArchivedChunks, report.ArchivedBytes)
+	}
+}
+
+func warmProject(projectPath, collectionId string) {
+	name := filepath.Base(projectPath)
+	report, err := chunk_service.WarmChunks(context.Background(), projectPath, collectionId)
+	if err != nil {
+		log.Printf("[Tiering] %s: warm-up stopped: %v", name, err)
+		return
+	}
+	log.Printf("[Tiering] %s: warmed %d chunks (%d bytes)", name, report.WarmedChunks, report.WarmedBytes)
+}
+
+// chunkTieringPolicy builds the archive policy from the config. The age
+// rule is off unless CHUNK_TIERING_AGE is set.
+func chunkTieringPolicy() chunk_service.TieringPolicy {
+	if CONFIG.ChunkTieringAge == "" {
+		return chunk_service.TieringPolicy{}
+	}
+	d, err := time.ParseDuration(CONFIG.ChunkTieringAge)
+	if err != nil {
+		log.Printf("Warning: invalid CHUNK_TIERING_AGE %q, archiving by age disabled", CONFIG.ChunkTieringAge)
+		return chunk_service.TieringPolicy{}
+	}
+	return chunk_service.TieringPolicy{ColdAfter: d}
+}
+
+func chunkTieringInterval() time.Duration {
+	if CONFIG.ChunkTieringInterval == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(CONFIG.ChunkTieringInterval)
+	if err != nil {
+		log.Printf("Warning: invalid CHUNK_TIERING_INTERVAL %q, using 24h", CONFIG.ChunkTieringInterval)
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// runChunkTiering periodically archives the cold chunks of every project.
+func runChunkTiering(projectsDir string) {
+	interval := chunkTieringInterval()
+	if interval <= 0 || !chunk_service.ChunkArchiveAvailable() {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		entries, err := os.ReadDir(projectsDir)
+		if err != nil {
+			log.Printf("[Tiering] failed to list projects: %v", err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".clst") {
+				continue
+			}
+			tierProject(filepath.Join(projectsDir, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"clustta/internal/chunk_service"
+	"clustta/internal/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// uploadSessionProject resolves the project of an upload session request and
+// the user making it, writing the error response if either is invalid.
+func uploadSessionProject(w http.ResponseWriter, r *http.Request) (string, UserInfo, bool) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", user, false
+	}
+	projectPath, pathErr := safeProjectPath(CONFIG.ProjectsDir, r.PathValue("project"))
+	if pathErr != nil {
+		http.Error(w, "Invalid project name", http.StatusBadRequest)
+		return "", user, false
+	}
+	if !utils.FileExists(projectPath) {
+		http.Error(w, "Project Not Found", 400)
+		return "", user, false
+	}
+	return projectPath, user, true
+}
+
+// withUploadSessionTx runs fn in a transaction on the project, committing if
+// fn succeeds.
+func withUploadSessionTx(projectPath string, fn func(tx *sqlx.Tx) error) error {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func sendUploadSession(w http.ResponseWriter, status int, session chunk_service.UploadSession) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(session)
+}
+
+// CreateUploadSessionHandler opens a resumable upload for the listed chunks.
+// The response lists the chunks the server still needs, in upload order.
+func CreateUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath, user, ok := uploadSessionProject(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Chunks []string `json:"chunks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		SendErrorResponse(w, "Invalid upload session request", http.StatusBadRequest)
+		return
+	}
+
+	var session chunk_service.UploadSession
+	err := withUploadSessionTx(projectPath, func(tx *sqlx.Tx) error {
+		var err error
+		framing := chunk_service.ChunkFramingForAgent(r.Header)
+		session, err = chunk_service.CreateUploadSession(tx, user.Id, request.Chunks, framing)
+		return err
+	})
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	sendUploadSession(w, http.StatusCreated, session)
+}
+
+// GetUploadSessionHandler reports how much of the upload the server has
+// committed, which is where an interrupted client resumes.
+func GetUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath, user, ok := uploadSessionProject(w, r)
+	if !ok {
+		return
+	}
+	var session chunk_service.UploadSession
+	err := withUploadSessionTx(projectPath, func(tx *sqlx.Tx) error {
+		var err error
+		session, err = chunk_service.GetUploadSession(tx, r.PathValue("session"))
+		return err
+	})
+	if errors.Is(err, chunk_service.ErrUploadSessionNotFound) || (err == nil && session.UserId != user.Id) {
+		SendErrorResponse(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	sendUploadSession(w, http.StatusOK, session)
+}
+
+// PutUploadRangeHandler appends the request body to the upload stream at the
+// offset query parameter. A range that does not start at the committed
+// offset gets 409 with the session, so the client can resync.
+func PutUploadRangeHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath, user, ok := uploadSessionProject(w, r)
+	if !ok {
+		return
+	}
+	sessionId := r.PathValue("session")
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		SendErrorResponse(w, "offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	var session chunk_service.UploadSession
+	err = withUploadSessionTx(projectPath, func(tx *sqlx.Tx) error {
+		var err error
+		session, err = chunk_service.GetUploadSession(tx, sessionId)
+		return err
+	})
+	if errors.Is(err, chunk_service.ErrUploadSessionNotFound) || (err == nil && session.UserId != user.Id) {
+		SendErrorResponse(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+
+	if !enforceUploadQuota(w, r, projectPath, max(r.ContentLength, 0)) {
+		return
+	}
+	session, err = chunk_service.WriteUploadRange(projectPath, sessionId, offset, r.Body)
+	var offsetErr chunk_service.UploadOffsetError
+	if errors.As(err, &offsetErr) {
+		sendUploadSession(w, http.StatusConflict, session)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	chunk_service.RunPassiveCheckpointForProject(projectPath)
+	sendUploadSession(w, http.StatusOK, session)
+}
+
+// DeleteUploadSessionHandler discards an upload session. Chunks it already
+// stored are kept.
+func DeleteUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	projectPath, user, ok := uploadSessionProject(w, r)
+	if !ok {
+		return
+	}
+	err := withUploadSessionTx(projectPath, func(tx *sqlx.Tx) error {
+		session, err := chunk_service.GetUploadSession(tx, r.PathValue("session"))
+		if err != nil {
+			return err
+		}
+		if session.UserId != user.Id {
+			return chunk_service.ErrUploadSessionNotFound
+		}
+		return chunk_service.DeleteUploadSession(tx, session.Id)
+	})
+	if errors.Is(err, chunk_service.ErrUploadSessionNotFound) {
+		SendErrorResponse(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Request error: %v", err)
+		http.Error(w, "Internal server error", 400)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
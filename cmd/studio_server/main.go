@@ -118,6 +118,11 @@ func main() {
 		return
 	}
 
+	if os.Args[1] == "migrate-storage" {
+		runMigrateStorageCommand(os.Args[2:])
+		return
+	}
+
 	serverType := os.Args[1]
 	if serverType != "studio" && serverType != "personal" {
 		println("must provide studio or personal argument")
@@ -130,6 +135,52 @@ func main() {
 	})
 }
 
+// configureChunkStorage points the chunk service at the Studio-wide storage
+// backends. Unavailable backends only disable the modes that need them.
+func configureChunkStorage() {
+	if err := chunk_service.ConfigureProjectStorage(CONFIG.StorageDir); err != nil {
+		log.Printf("Warning: Deflated storage unavailable: %v", err)
+	}
+	if err := chunk_service.ConfigureChunkArchive(CONFIG.ChunkArchiveDir); err != nil {
+		log.Printf("Warning: Chunk archive unavailable: %v", err)
+	}
+	if err := chunk_service.ConfigureObjectStorage(chunk_service.ObjectStorageConfig{
+		Endpoint:         CONFIG.ObjectStorageEndpoint,
+		Bucket:           CONFIG.ObjectStorageBucket,
+		Prefix:           CONFIG.ObjectStoragePrefix,
+		Region:           CONFIG.ObjectStorageRegion,
+		AccessKeyId:      CONFIG.ObjectStorageAccessKeyId,
+		SecretAccessKey:  CONFIG.ObjectStorageSecretAccessKey,
+		VirtualHostStyle: CONFIG.ObjectStorageVirtualHostStyle,
+	}); err != nil {
+		log.Printf("Warning: Object storage unavailable: %v", err)
+	}
+	if CONFIG.StorageMasterKey != "" {
+		if err := configureStorageEncryption(); err != nil {
+			log.Printf("Warning: STORAGE_MASTER_KEY is invalid: %v (encryption at rest disabled)", err)
+		}
+	}
+}
+
+func configureStorageEncryption() error {
+	current, err := cryptoutil.DecodeKey(CONFIG.StorageMasterKey)
+	if err != nil {
+		return err
+	}
+	var previous [][]byte
+	for _, encoded := range strings.Split(CONFIG.StoragePreviousMasterKeys, ",") {
+		if strings.TrimSpace(encoded) == "" {
+			continue
+		}
+		key, err := cryptoutil.DecodeKey(strings.TrimSpace(encoded))
+		if err != nil {
+			return fmt.Errorf("previous key: %w", err)
+		}
+		previous = append(previous, key)
+	}
+	return chunk_service.ConfigureStorageEncryption(current, previous...)
+}
+
 // startServer contains all server initialization and run logic.
 func startServer(serverType string) {
 	if err := settings.InitializeServer(); err != nil {
@@ -179,9 +230,7 @@ func startServer(serverType string) {
 	}
 
 	loadDefaults(&CONFIG)
-	if err := chunk_service.ConfigureProjectStorage(CONFIG.StorageDir); err != nil {
-		log.Printf("Warning: Deflated storage unavailable: %v", err)
-	}
+	configureChunkStorage()
 
 	// Set private mode globals for internal packages
 	constants.PrivateMode = CONFIG.Private
@@ -253,6 +302,7 @@ func startServer(serverType string) {
 	}
 
 	// Iterate over the directory entries
+	var projectPaths []string
 	for _, entry := range entries {
 		// Check if the entry is a file and has the specified extension
 		if !entry.IsDir() && strings.HasSuffix(entry.Name(), extension) {
@@ -263,8 +313,21 @@ func startServer(serverType string) {
 				println(err.Error())
 				return
 			}
+			if err := enableChangeTracking(projectPath); err != nil {
+				println(err.Error())
+				return
+			}
+			projectPaths = append(projectPaths, projectPath)
 		}
 	}
+	resumeStorageMigrations(projectPaths)
+	resumeProjectEncryption(projectPaths)
+	go runChunkScrubber(projectFolder)
+	go runChunkRepacker(projectFolder)
+	go runChunkTiering(projectFolder)
+	go runChangeLogPruner(projectFolder)
+
+	startReplicas(projectFolder)
 
 	go func() {
 		for {
@@ -144,6 +144,9 @@ func PostPreviewsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Decompressed data exceeds size limit", 413)
 		return
 	}
+	if !enforceUploadQuota(w, r, projectPath, int64(len(decompressedData))) {
+		return
+	}
 
 	previewList := repositorypb.Previews{}
 	err = proto.Unmarshal(decompressedData, &previewList)
@@ -0,0 +1,203 @@
+package chunk_service
+
+import (
+	"bytes"
+	"clustta/internal/utils"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bandwidthSlice is the most a limited writer sends at once, so a large
+// chunk frame goes out evenly rather than in one burst after a long wait.
+const bandwidthSlice = 32 * 1024
+
+// BandwidthLimiter is a token bucket capping transfers at a number of bytes
+// per second. Up to a second's worth may pass at full speed after a quiet
+// spell. A nil limiter does not limit.
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter returns a limiter of bytesPerSecond, or nil when
+// bytesPerSecond is 0 or less.
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &BandwidthLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// BytesPerSecond is the limiter's rate, 0 for a nil limiter.
+func (l *BandwidthLimiter) BytesPerSecond() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// WaitN blocks until n more bytes may be transferred or ctx is done.
+// Concurrent callers share the rate in the order they asked.
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		take := min(float64(n), l.rate)
+		if delay := l.reserve(take); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				l.reserve(-take)
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		n -= int(take)
+	}
+	return nil
+}
+
+// reserve takes n tokens, which may leave the bucket in debt, and returns
+// how long until the debt is paid. A negative n returns tokens.
+func (l *BandwidthLimiter) reserve(n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate) - n
+	l.last = now
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*BandwidthLimiter
+	meter    *transferMeter
+}
+
+// LimitReader returns a reader of r that reads no faster than every one of
+// limiters allows.
+func LimitReader(ctx context.Context, r io.Reader, limiters ...*BandwidthLimiter) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		for _, limiter := range r.limiters {
+			if waitErr := limiter.WaitN(r.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+		r.meter.add(n)
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*BandwidthLimiter
+}
+
+// LimitWriter returns a writer to w that writes no faster than every one of
+// limiters allows.
+func LimitWriter(ctx context.Context, w io.Writer, limiters ...*BandwidthLimiter) io.Writer {
+	return &limitedWriter{ctx: ctx, w: w, limiters: limiters}
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		slice := p[written:min(written+bandwidthSlice, len(p))]
+		for _, limiter := range w.limiters {
+			if err := limiter.WaitN(w.ctx, len(slice)); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.w.Write(slice)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+type bandwidthLimitKey struct{}
+
+// WithBandwidthLimit returns a context under which chunk pulls and pushes
+// transfer at most bytesPerSecond, shared by all of them. For 0 or less it
+// returns ctx, keeping any limit ctx has.
+func WithBandwidthLimit(ctx context.Context, bytesPerSecond int64) context.Context {
+	if bytesPerSecond <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, bandwidthLimitKey{}, NewBandwidthLimiter(bytesPerSecond))
+}
+
+func bandwidthLimiter(ctx context.Context) *BandwidthLimiter {
+	limiter, _ := ctx.Value(bandwidthLimitKey{}).(*BandwidthLimiter)
+	return limiter
+}
+
+// transferMeter measures the effective throughput of a transfer, counting
+// the bytes that went over the network since it started.
+type transferMeter struct {
+	mu    sync.Mutex
+	start time.Time
+	bytes int
+}
+
+func newTransferMeter() *transferMeter {
+	return &transferMeter{start: time.Now()}
+}
+
+func (m *transferMeter) add(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.bytes += n
+	m.mu.Unlock()
+}
+
+// reader returns a reader of r limited by ctx's bandwidth limit and counted
+// by the meter.
+func (m *transferMeter) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiters: []*BandwidthLimiter{bandwidthLimiter(ctx)}, meter: m}
+}
+
+// request returns a request sending body, limited by ctx's bandwidth limit
+// and counted by the meter.
+func (m *transferMeter) request(ctx context.Context, method, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, m.reader(ctx, bytes.NewReader(body)))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	return req, nil
+}
+
+// String is the throughput so far, such as "1.50 MB/s".
+func (m *transferMeter) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elapsed := time.Since(m.start).Seconds()
+	if elapsed <= 0 {
+		return "0 B/s"
+	}
+	return fmt.Sprintf("%s/s", utils.BytesToHumanReadable(int(float64(m.bytes)/elapsed)))
+}
@@ -0,0 +1,71 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiterPacesTransfers(t *testing.T) {
+	limiter := chunk_service.NewBandwidthLimiter(200 * 1024)
+
+	// The first second's worth passes at once, the rest at the limit.
+	start := time.Now()
+	n, err := io.Copy(io.Discard, chunk_service.LimitReader(context.Background(), bytes.NewReader(make([]byte, 300*1024)), limiter))
+	if err != nil || n != 300*1024 {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("reading 300 KB at 200 KB/s took %s", elapsed)
+	}
+
+	// A writer shares the limiter, so it starts in debt.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	_, err = chunk_service.LimitWriter(ctx, &out, limiter).Write(make([]byte, 100*1024))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the write to wait past its deadline, got %v", err)
+	}
+
+	if chunk_service.NewBandwidthLimiter(0) != nil {
+		t.Fatal("a zero limit should not limit")
+	}
+}
+
+func TestPullStreamChunksReportsThroughput(t *testing.T) {
+	projectPath := newCompactProject(t)
+	hash, data := compressedChunk(t, "streamed under a bandwidth limit")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream-chunks" {
+			http.NotFound(w, r)
+			return
+		}
+		record, err := chunk_service.EncodeChunk(chunk_service.Chunk{Hash: hash, Data: data})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Write(record)
+	}))
+	defer server.Close()
+
+	messages := []string{}
+	ctx := chunk_service.WithBandwidthLimit(context.Background(), 1024*1024)
+	err := chunk_service.PullStreamChunks(ctx, projectPath, server.URL, []string{hash}, []string{hash}, len(data), func(_, _ int, message, _ string) {
+		messages = append(messages, message)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) == 0 || !strings.HasSuffix(messages[len(messages)-1], "/s)") {
+		t.Fatalf("progress does not report throughput: %q", messages)
+	}
+}
@@ -5,19 +5,19 @@ import (
 	"clustta/internal/constants"
 	"clustta/internal/utils"
 	"context"
-	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	kzstd "github.com/klauspost/compress/zstd"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -33,9 +33,9 @@ func (c *idleTimeoutConn) Read(b []byte) (int, error) {
 	return c.Conn.Read(b)
 }
 
-// streamTransport returns an HTTP transport suited for long-running downloads.
+// StreamTransport returns an HTTP transport suited for long-running downloads.
 // It enforces a 30s header wait and kills reads that stall for more than 60s.
-func streamTransport() *http.Transport {
+func StreamTransport() *http.Transport {
 	return &http.Transport{
 		ResponseHeaderTimeout: 30 * time.Second,
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -60,13 +60,30 @@ type ChunkInfo struct {
 }
 
 func GetChunkInfo(tx *sqlx.Tx, chunkHash string) (ChunkInfo, error) {
-	return chunkInfoFromStore(tx, chunkHash)
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return ChunkInfo{}, err
+	}
+	return StoredChunkInfo(store, tx, chunkHash)
+}
+
+// StoredChunkInfo is GetChunkInfo against an already resolved store.
+func StoredChunkInfo(store ChunkStore, tx *sqlx.Tx, chunkHash string) (ChunkInfo, error) {
+	stat, err := store.Stat(tx, chunkHash)
+	if err != nil {
+		return ChunkInfo{}, err
+	}
+	return ChunkInfo{Hash: stat.Hash, Size: stat.Size}, nil
 }
 
 func GetChunksInfo(tx *sqlx.Tx, chunkHashes []string) ([]ChunkInfo, error) {
 	var chunkInfos []ChunkInfo
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return chunkInfos, err
+	}
 	for _, chunkHash := range chunkHashes {
-		chunkInfo, err := GetChunkInfo(tx, chunkHash)
+		chunkInfo, err := StoredChunkInfo(store, tx, chunkHash)
 		if err != nil {
 			return chunkInfos, err
 		}
@@ -77,9 +94,13 @@ func GetChunksInfo(tx *sqlx.Tx, chunkHashes []string) ([]ChunkInfo, error) {
 
 func GetNonExistingChunks(tx *sqlx.Tx, chunks []string) ([]string, error) {
 	var nonExistentChunks []string
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return nonExistentChunks, err
+	}
 	seenChunks := make(map[string]bool)
 	for _, chunk := range chunks {
-		if ChunkExists(chunk, tx, seenChunks) {
+		if StoreHasChunk(store, tx, chunk, seenChunks) {
 			continue
 		}
 		nonExistentChunks = append(nonExistentChunks, chunk)
@@ -112,7 +133,9 @@ func WriteChunkData(projectPath string, chunkData Chunk) error {
 	return nil
 }
 
-func WriteChunks(projectPath string, chunks []byte) ([]string, error) {
+// WriteChunks stores the chunks of a complete stream in the given framing,
+// returning the hashes of chunks whose data did not match their hash.
+func WriteChunks(projectPath string, chunks []byte, framing int) ([]string, error) {
 	var failedChunks []string
 	dbConn, err := utils.OpenDb(projectPath)
 	if err != nil {
@@ -126,45 +149,51 @@ func WriteChunks(projectPath string, chunks []byte) ([]string, error) {
 	}
 	defer tx.Rollback()
 
-	decoder, err := kzstd.NewReader(nil)
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return failedChunks, err
+	}
+	decoder, err := newChunkDecoder(tx)
 	if err != nil {
 		return nil, err
 	}
 	defer decoder.Close()
+	reader, err := NewChunkFrameReader(bytes.NewReader(chunks), framing)
+	if err != nil {
+		return nil, err
+	}
 	seenChunks := make(map[string]bool)
-	for len(chunks) > 0 {
-		if len(chunks) < 36 { // Adjusted to 36 to account for 4 bytes of length
-			break // Not enough data for a complete chunk
+	verified := []Chunk{}
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			break
 		}
-
-		tag := chunks[:32]
-		length := binary.BigEndian.Uint32(chunks[32:36]) // Use the full 4 bytes for length
-
-		if len(chunks) < 36+int(length) {
-			break // Not enough data for the value
+		if errors.Is(err, io.ErrUnexpectedEOF) && framing == ChunkFramingV1 {
+			break // v1 bodies cut short keep the chunks before the cut
 		}
-
-		compressedValue := chunks[36 : 36+length]
-		chunks = chunks[36+length:]
-
-		if ChunkExists(hex.EncodeToString(tag), tx, seenChunks) {
-			continue
+		if err != nil {
+			return failedChunks, err
 		}
 
-		decompressedValue, err := decoder.DecodeAll(compressedValue, nil)
-		if err != nil {
-			failedChunks = append(failedChunks, hex.EncodeToString(tag))
+		if StoreHasChunk(store, tx, frame.Hash, seenChunks) {
 			continue
 		}
-
-		hash := sha256.Sum256(decompressedValue)
-		if !bytes.Equal(hash[:], tag) {
-			failedChunks = append(failedChunks, hex.EncodeToString(tag))
+		compressedValue, _, err := frame.verify(decoder)
+		if err != nil {
+			failedChunks = append(failedChunks, frame.Hash)
 			continue
 		}
+		seenChunks[frame.Hash] = true
+		verified = append(verified, Chunk{Hash: frame.Hash, Data: compressedValue, Size: len(compressedValue)})
+	}
 
-		err = StoreChunk(tx, hex.EncodeToString(tag), compressedValue, len(compressedValue))
-		if err != nil {
+	// Deflated stores append the whole stream to a pack with one sync.
+	if err := putChunks(store, tx, verified); err != nil {
+		return failedChunks, err
+	}
+	for _, chunk := range verified {
+		if err := RecordChunkDictionary(tx, chunk.Hash, chunk.Data); err != nil {
 			return failedChunks, err
 		}
 	}
@@ -187,8 +216,10 @@ func RunPassiveCheckpointForProject(projectPath string) {
 	utils.RunPassiveCheckpoint(dbConn)
 }
 
+// DecodeChunk reads the v1 record at the start of data: a 32-byte hash, a
+// 4-byte big-endian length and the compressed data.
 func DecodeChunk(data []byte) (hash string, compressedData []byte, bytesRead int, err error) {
-	if len(data) < 36 { // Adjusted to 36 to account for 4 bytes of length
+	if len(data) < v1RecordHeaderSize {
 		return "", nil, 0, fmt.Errorf("not enough data for a complete chunk")
 	}
 
@@ -197,78 +228,27 @@ func DecodeChunk(data []byte) (hash string, compressedData []byte, bytesRead int
 	hash = hex.EncodeToString(hashBytes)
 
 	// Extract the length
-	length := binary.BigEndian.Uint32(data[32:36]) // Use the full 4 bytes for length
+	length := binary.BigEndian.Uint32(data[32:36])
 
-	if len(data) < 36+int(length) {
+	if len(data) < v1RecordHeaderSize+int(length) {
 		return "", nil, 0, fmt.Errorf("not enough data for the complete chunk value")
 	}
 
 	// Extract the compressed data
-	compressedData = data[36 : 36+length]
+	compressedData = data[v1RecordHeaderSize : v1RecordHeaderSize+length]
 
-	bytesRead = 36 + int(length)
+	bytesRead = v1RecordHeaderSize + int(length)
 	return hash, compressedData, bytesRead, nil
 }
 
+// EncodeChunks encodes chunks as a v1 stream, which every server accepts.
 func EncodeChunks(chunks []Chunk) ([]byte, error) {
-	var buffer bytes.Buffer
-
-	for _, chunk := range chunks {
-		hashBytes, err := hex.DecodeString(chunk.Hash)
-		if err != nil {
-			return nil, fmt.Errorf("invalid hash string: %v", err)
-		}
-		if len(hashBytes) != 32 {
-			return nil, fmt.Errorf("invalid hash length: expected 32 bytes, got %d", len(chunk.Hash))
-		}
-
-		// Write the hash (tag)
-		buffer.Write(hashBytes)
-
-		// Write the length (3 bytes for length up to 16MB)
-		length := len(chunk.Data)
-		if length > 16777215 { // 2^24 - 1, max value for 3 bytes
-			return nil, fmt.Errorf("chunk size exceeds 16MB limit")
-		}
-		lengthBytes := make([]byte, 4)
-		binary.BigEndian.PutUint32(lengthBytes, uint32(length))
-		buffer.Write(lengthBytes)
-
-		// Write the compressed chunk data
-		buffer.Write(chunk.Data)
-	}
-
-	return buffer.Bytes(), nil
+	return EncodeChunkStream(ChunkFramingV1, chunks)
 }
 
+// EncodeChunk encodes a chunk as a v1 record.
 func EncodeChunk(chunk Chunk) ([]byte, error) {
-	var buffer bytes.Buffer
-
-	// Decode the hash string into bytes
-	hashBytes, err := hex.DecodeString(chunk.Hash)
-	if err != nil {
-		return nil, fmt.Errorf("invalid hash string: %v", err)
-	}
-	if len(hashBytes) != 32 {
-		return nil, fmt.Errorf("invalid hash length: expected 32 bytes, got %d", len(hashBytes))
-	}
-
-	// Write the hash (tag)
-	buffer.Write(hashBytes)
-
-	// Write the length (3 bytes for length up to 16MB)
-	length := len(chunk.Data)
-	if length > 16777215 { // 2^24 - 1, max value for 3 bytes
-		return nil, fmt.Errorf("chunk size exceeds 16MB limit")
-	}
-	lengthBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(lengthBytes, uint32(length))
-	buffer.Write(lengthBytes) // Write only the last 3 bytes
-
-	// Write the compressed chunk data
-	buffer.Write(chunk.Data)
-
-	return buffer.Bytes(), nil
+	return encodeChunkFrame(ChunkFramingV1, chunk)
 }
 
 func PullChunks(ctx context.Context, projectPath, remoteUrl string, chunkInfos []ChunkInfo, callback func(int, int, string, string)) error {
@@ -277,15 +257,19 @@ func PullChunks(ctx context.Context, projectPath, remoteUrl string, chunkInfos [
 	}
 
 	dataUrl := remoteUrl + "/chunks"
-	client := &http.Client{Transport: streamTransport()}
+	client := utils.HTTPClient(ctx, &http.Client{Transport: StreamTransport()})
 
 	totalChunksSize := 0
 	for _, chunkInfo := range chunkInfos {
 		totalChunksSize += chunkInfo.Size
 	}
 	processedChunks := 0
+	meter := newTransferMeter()
 
 	if utils.IsValidURL(remoteUrl) {
+		if err := pullChunkDictionaries(ctx, projectPath, remoteUrl); err != nil {
+			return err
+		}
 		for _, chunkInfo := range chunkInfos {
 			if ctx.Err() != nil {
 				return ctx.Err()
@@ -303,6 +287,7 @@ func PullChunks(ctx context.Context, projectPath, remoteUrl string, chunkInfos [
 				return err
 			}
 			req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+			AcceptChunkFraming(req)
 			response, err := client.Do(req)
 			if err != nil {
 				return err
@@ -311,16 +296,20 @@ func PullChunks(ctx context.Context, projectPath, remoteUrl string, chunkInfos [
 
 			responseCode := response.StatusCode
 			if responseCode == 200 {
-				body, err := io.ReadAll(response.Body)
+				body, err := io.ReadAll(meter.reader(ctx, response.Body))
 				if err != nil {
 					return fmt.Errorf("error reading response body: %s", err.Error())
 				}
-				_, err = WriteChunks(projectPath, body)
+				framing, err := BodyChunkFraming(response.Header, body)
+				if err != nil {
+					return err
+				}
+				_, err = WriteChunks(projectPath, body, framing)
 				if err != nil {
 					return fmt.Errorf("error writing chunks: %s", err.Error())
 				}
 				processedChunks += chunkInfo.Size
-				message := fmt.Sprintf("Pulling data %s/%s", utils.BytesToHumanReadable(processedChunks), utils.BytesToHumanReadable(totalChunksSize))
+				message := fmt.Sprintf("Pulling data %s/%s (%s)", utils.BytesToHumanReadable(processedChunks), utils.BytesToHumanReadable(totalChunksSize), meter)
 				callback(processedChunks, totalChunksSize, message, "")
 			} else if responseCode == 400 {
 				body, err := io.ReadAll(response.Body)
@@ -344,11 +333,22 @@ func PullChunks(ctx context.Context, projectPath, remoteUrl string, chunkInfos [
 			return err
 		}
 		defer remoteTx.Rollback()
+		remoteStore, err := OpenChunkStore(remoteTx)
+		if err != nil {
+			return err
+		}
+		dictionaries, err := ListChunkDictionaries(remoteTx)
+		if err != nil {
+			return err
+		}
+		if err := importChunkDictionaries(projectPath, dictionaries); err != nil {
+			return err
+		}
 		for _, chunkInfo := range chunkInfos {
 			if ctx.Err() != nil {
 				return ctx.Err()
 			}
-			chunkBytes, readErr := ReadChunk(remoteTx, chunkInfo.Hash)
+			chunkBytes, readErr := remoteStore.Get(remoteTx, chunkInfo.Hash)
 			err = readErr
 			if err != nil {
 				return err
@@ -366,7 +366,29 @@ func PullChunks(ctx context.Context, projectPath, remoteUrl string, chunkInfos [
 	return nil
 }
 
-func processTLVStream(ctx context.Context, projectPath string, r io.Reader, downloadedSize, totalSize int, chunksCountMap map[string]int, callback func(int, int, string, string)) error {
+// streamProgress carries a pull's progress across stream attempts. Cursor is
+// the last chunk fully written, where a resumed stream continues. Meter
+// measures the throughput of all attempts together.
+type streamProgress struct {
+	downloadedSize int
+	savedSize      int
+	cursor         string
+	meter          *transferMeter
+}
+
+// streamInterruptedError marks a stream that broke off before it finished, as
+// opposed to one carrying bad data. Interrupted streams are resumed.
+type streamInterruptedError struct {
+	err error
+}
+
+func (e streamInterruptedError) Error() string { return e.err.Error() }
+func (e streamInterruptedError) Unwrap() error { return e.err }
+
+// processTLVStream writes the chunks of a stream in the given framing as they
+// arrive. Read failures are returned as a streamInterruptedError; bad frames
+// and chunks are not.
+func processTLVStream(ctx context.Context, projectPath string, r io.Reader, framing int, progress *streamProgress, totalSize int, chunksCountMap map[string]int, callback func(int, int, string, string)) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
@@ -377,47 +399,34 @@ func processTLVStream(ctx context.Context, projectPath string, r io.Reader, down
 	}
 	defer dbConn.Close()
 
-	decoder, err := kzstd.NewReader(nil)
+	store, err := openProjectChunkStore(dbConn)
+	if err != nil {
+		return err
+	}
+	decoder, err := openChunkDecoder(dbConn)
 	if err != nil {
 		return err
 	}
 	defer decoder.Close()
+	reader, err := NewChunkFrameReader(r, framing)
+	if err != nil {
+		return err
+	}
 	seenChunks := make(map[string]bool)
 
-	savedSize := downloadedSize
-
 	for {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		// Read the TLV tag (hash, 32 bytes)
-		tag := make([]byte, 32)
-		_, err = io.ReadFull(r, tag)
+		frame, err := reader.Next()
 		if err == io.EOF {
 			break // End of stream
+		} else if errors.Is(err, ErrChunkFraming) {
+			return err
 		} else if err != nil {
-			return fmt.Errorf("error reading tag: %w", err)
-		}
-
-		// Read the TLV length (4 bytes, uint32)
-		lengthBuf := make([]byte, 4)
-		_, err = io.ReadFull(r, lengthBuf)
-		if err != nil {
-			return fmt.Errorf("error reading length: %w", err)
-		}
-		length := binary.BigEndian.Uint32(lengthBuf) // Use the full 4 bytes for length
-
-		// Validate the length
-		if length == 0 || length > 16777215 { // 3-byte max value
-			return fmt.Errorf("invalid length: %d", length)
-		}
-
-		// Read the TLV value (chunk data)
-		compressedValue := make([]byte, length)
-		_, err = io.ReadFull(r, compressedValue)
-		if err != nil {
-			return fmt.Errorf("error reading value: %w", err)
+			return streamInterruptedError{fmt.Errorf("error reading chunk: %w", err)}
 		}
+		chunkHash := frame.Hash
 
 		tx, err := dbConn.Beginx()
 		if err != nil {
@@ -425,28 +434,22 @@ func processTLVStream(ctx context.Context, projectPath string, r io.Reader, down
 		}
 		defer tx.Rollback()
 
-		if ChunkExists(hex.EncodeToString(tag), tx, seenChunks) {
+		if StoreHasChunk(store, tx, chunkHash, seenChunks) {
 			tx.Rollback()
+			progress.cursor = chunkHash
 			continue
 		}
 
-		// Validate Zstandard magic number
-		// if len(compressedValue) < 4 || !bytes.Equal(compressedValue[:4], []byte{0x28, 0xB5, 0x2F, 0xFD}) {
-		// 	return fmt.Errorf("invalid input: magic number mismatch")
-		// }
-
-		decompressedValue, err := decoder.DecodeAll(compressedValue, nil)
+		compressedValue, size, err := frame.verify(decoder)
 		if err != nil {
-			return fmt.Errorf("error decoding chunk: %w", err)
-		}
-
-		hash := sha256.Sum256(decompressedValue)
-		if !bytes.Equal(hash[:], tag) {
-			return errors.New("invalid chunk data")
+			return err
 		}
 		compressedSize := len(compressedValue)
-		size := len(decompressedValue)
-		err = StoreChunk(tx, hex.EncodeToString(tag), compressedValue, size)
+		err = store.Put(tx, chunkHash, compressedValue, size)
+		if err != nil {
+			return fmt.Errorf("error inserting into DB: %w", err)
+		}
+		err = RecordChunkDictionary(tx, chunkHash, compressedValue)
 		if err != nil {
 			return fmt.Errorf("error inserting into DB: %w", err)
 		}
@@ -454,24 +457,25 @@ func processTLVStream(ctx context.Context, projectPath string, r io.Reader, down
 		if err != nil {
 			return fmt.Errorf("error writing data: %w", err)
 		}
+		progress.cursor = chunkHash
 
-		downloadedSize += size * chunksCountMap[hex.EncodeToString(tag)]
-		savedSize += size - compressedSize
-		if chunksCountMap[hex.EncodeToString(tag)] > 1 {
-			savedSize += size * (chunksCountMap[hex.EncodeToString(tag)] - 1)
+		progress.downloadedSize += size * chunksCountMap[chunkHash]
+		progress.savedSize += size - compressedSize
+		if chunksCountMap[chunkHash] > 1 {
+			progress.savedSize += size * (chunksCountMap[chunkHash] - 1)
 		}
-		message := fmt.Sprintf("Pulling data %s/%s", utils.BytesToHumanReadable(downloadedSize), utils.BytesToHumanReadable(totalSize))
+		message := fmt.Sprintf("Pulling data %s/%s (%s)", utils.BytesToHumanReadable(progress.downloadedSize), utils.BytesToHumanReadable(totalSize), progress.meter)
 		extraMessage := ""
 
 		dataSavedPercentage := 0.0
 		if totalSize > 0 {
-			dataSavedPercentage = (float64(savedSize) / float64(downloadedSize)) * 100
+			dataSavedPercentage = (float64(progress.savedSize) / float64(progress.downloadedSize)) * 100
 		}
-		if savedSize > 0 {
-			extraMessage = fmt.Sprintf("Data saved: %s (%.2f%%)", utils.BytesToHumanReadable(savedSize), dataSavedPercentage)
+		if progress.savedSize > 0 {
+			extraMessage = fmt.Sprintf("Data saved: %s (%.2f%%)", utils.BytesToHumanReadable(progress.savedSize), dataSavedPercentage)
 		}
 
-		callback(downloadedSize, totalSize, message, extraMessage)
+		callback(progress.downloadedSize, totalSize, message, extraMessage)
 	}
 
 	return nil
@@ -493,6 +497,10 @@ func ProcessDownloadedChunksProgress(ctx context.Context, projectPath, remoteUrl
 	}
 	defer tx.Rollback()
 
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return 0, 0, map[string]int{}, err
+	}
 	downloadedSize := 0
 
 	missingChunksMap := map[string]bool{}
@@ -509,7 +517,7 @@ func ProcessDownloadedChunksProgress(ctx context.Context, projectPath, remoteUrl
 		if missingChunksMap[hash] {
 			continue
 		}
-		chunkInfo, err := GetChunkInfo(tx, hash)
+		chunkInfo, err := StoredChunkInfo(store, tx, hash)
 		if err != nil {
 			return downloadedSize, totalSize, chunksCountMap, err
 		}
@@ -531,6 +539,24 @@ func ProcessDownloadedChunksProgress(ctx context.Context, projectPath, remoteUrl
 	return downloadedSize, totalSize, chunksCountMap, nil
 }
 
+const (
+	// maxStreamAttempts is how many times in a row a pull stream may break
+	// off without delivering a chunk before the pull gives up.
+	maxStreamAttempts = 8
+	// maxStreamBackoff caps the wait between stream attempts.
+	maxStreamBackoff = time.Minute
+)
+
+// streamBackoff returns how long to wait before the given retry, doubling
+// from one second with up to 50% jitter.
+func streamBackoff(attempt int) time.Duration {
+	delay := min(time.Second<<(attempt-1), maxStreamBackoff)
+	return delay/2 + time.Duration(rand.Int64N(int64(delay/2)+1))
+}
+
+// PullStreamChunks downloads the missing chunks as one stream. A stream that
+// breaks off is resumed after the last chunk written, waiting longer after
+// each consecutive attempt that makes no progress.
 func PullStreamChunks(ctx context.Context, projectPath, remoteUrl string, missingChunkHashes []string, allChunkHashes []string, totalSize int, callback func(int, int, string, string)) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
@@ -540,57 +566,104 @@ func PullStreamChunks(ctx context.Context, projectPath, remoteUrl string, missin
 	if err != nil {
 		return err
 	}
+	if !utils.IsValidURL(remoteUrl) {
+		return errors.New("invalid url")
+	}
+	if len(missingChunkHashes) == 0 {
+		return nil
+	}
+	if err := pullChunkDictionaries(ctx, projectPath, remoteUrl); err != nil {
+		return err
+	}
 
-	dataUrl := remoteUrl + "/stream-chunks"
-	client := &http.Client{Transport: streamTransport()}
-
-	if utils.IsValidURL(remoteUrl) {
-		if ctx.Err() != nil {
-			return ctx.Err()
+	progress := &streamProgress{downloadedSize: downloadedSize, savedSize: downloadedSize, meter: newTransferMeter()}
+	lastChunk := missingChunkHashes[len(missingChunkHashes)-1]
+	failures := 0
+	for {
+		cursor := progress.cursor
+		err := pullStreamAttempt(ctx, projectPath, remoteUrl, missingChunkHashes, progress, totalSize, chunksCountMap, callback)
+		if err == nil && progress.cursor == lastChunk {
+			return nil
 		}
-		data := map[string]any{
-			"chunks": missingChunkHashes,
+		if err == nil {
+			// The server closed the stream early between two chunks.
+			err = streamInterruptedError{errors.New("stream ended before all chunks were received")}
 		}
-		jsonData, err := json.Marshal(data)
-		if err != nil {
+		var interrupted streamInterruptedError
+		if !errors.As(err, &interrupted) {
 			return err
 		}
-
-		req, err := http.NewRequest("GET", dataUrl, bytes.NewBuffer(jsonData))
-		if err != nil {
-			return err
+		if progress.cursor != cursor {
+			failures = 0
 		}
-		req.Header.Set("Clustta-Agent", constants.USER_AGENT)
-		response, err := client.Do(req)
-		if err != nil {
+		failures++
+		if failures >= maxStreamAttempts {
 			return err
 		}
-		defer response.Body.Close()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(streamBackoff(failures)):
+		}
+	}
+}
 
-		responseCode := response.StatusCode
-		if responseCode == 200 {
-			// Process the TLV stream
-			err = processTLVStream(ctx, projectPath, response.Body, downloadedSize, totalSize, chunksCountMap, callback)
-			if err != nil {
-				return fmt.Errorf("error processing stream: %s", err.Error())
-			}
-		} else if responseCode == 400 {
-			body, err := io.ReadAll(response.Body)
-			if err != nil {
-				return err
-			}
-			return errors.New(string(body))
-		} else {
-			return errors.New("unknown error while fetching data")
+// pullStreamAttempt requests the chunks after progress.cursor and writes
+// them as they arrive. Failures that a retry could fix are returned as a
+// streamInterruptedError.
+func pullStreamAttempt(ctx context.Context, projectPath, remoteUrl string, chunkHashes []string, progress *streamProgress, totalSize int, chunksCountMap map[string]int, callback func(int, int, string, string)) error {
+	dataUrl := remoteUrl + "/stream-chunks"
+	client := utils.HTTPClient(ctx, &http.Client{Transport: StreamTransport()})
+
+	data := map[string]any{
+		"chunks": chunkHashes,
+	}
+	if progress.cursor != "" {
+		data["cursor"] = progress.cursor
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", dataUrl, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+	AcceptChunkFraming(req)
+	response, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		return streamInterruptedError{err}
+	}
+	defer response.Body.Close()
 
-	} else {
-		return errors.New("invalid url")
+	responseCode := response.StatusCode
+	if responseCode == 200 {
+		// Process the TLV stream
+		err = processTLVStream(ctx, projectPath, progress.meter.reader(ctx, response.Body), HeaderChunkFraming(response.Header), progress, totalSize, chunksCountMap, callback)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			return fmt.Errorf("error processing stream: %w", err)
+		}
+		return nil
+	} else if responseCode == 400 {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(body))
+	} else if responseCode >= 500 {
+		return streamInterruptedError{fmt.Errorf("server responded with status %d", responseCode)}
 	}
-	return nil
+	return errors.New("unknown error while fetching data")
 }
 
-func PushChunks(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []ChunkInfo, callback func(int, int, string, string)) error {
+func PushChunks(ctx context.Context, tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []ChunkInfo, callback func(int, int, string, string)) error {
 	dataUrl := remoteUrl + "/chunks"
 	client := &http.Client{Timeout: 30 * time.Second}
 
@@ -599,10 +672,15 @@ func PushChunks(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []Chunk
 		totalChunksSize += chunkInfo.Size
 	}
 	processedChunks := 0
+	meter := newTransferMeter()
 
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return err
+	}
 	if utils.IsValidURL(remoteUrl) {
 		for _, chunkInfo := range chunkInfos {
-			chunkData, err := ReadChunk(tx, chunkInfo.Hash)
+			chunkData, err := store.Get(tx, chunkInfo.Hash)
 			if err != nil {
 				return err
 			}
@@ -615,11 +693,12 @@ func PushChunks(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []Chunk
 				return err
 			}
 
-			req, err := http.NewRequest("POST", dataUrl, bytes.NewBuffer(encodedChunk))
+			req, err := meter.request(ctx, "POST", dataUrl, encodedChunk)
 			if err != nil {
 				return err
 			}
 			req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+			req.Header.Set(ChunkFramingHeader, strconv.Itoa(ChunkFramingV1))
 
 			response, err := client.Do(req)
 			if err != nil {
@@ -629,7 +708,7 @@ func PushChunks(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []Chunk
 			responseCode := response.StatusCode
 			if responseCode == 200 {
 				processedChunks += chunkInfo.Size
-				message := fmt.Sprintf("Pushing Data %s/%s", utils.BytesToHumanReadable(processedChunks), utils.BytesToHumanReadable(totalChunksSize))
+				message := fmt.Sprintf("Pushing Data %s/%s (%s)", utils.BytesToHumanReadable(processedChunks), utils.BytesToHumanReadable(totalChunksSize), meter)
 				callback(processedChunks, totalChunksSize, message, "")
 			} else if responseCode == 400 {
 				body, err := io.ReadAll(response.Body)
@@ -651,14 +730,23 @@ func PushChunks(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []Chunk
 		if err != nil {
 			return err
 		}
+		remoteStore, err := OpenChunkStore(remoteTx)
+		if err != nil {
+			remoteTx.Rollback()
+			return err
+		}
+		if err := copyChunkDictionaries(tx, remoteTx); err != nil {
+			remoteTx.Rollback()
+			return err
+		}
 
 		for _, chunkInfo := range chunkInfos {
-			chunkBytes, readErr := ReadChunk(tx, chunkInfo.Hash)
+			chunkBytes, readErr := store.Get(tx, chunkInfo.Hash)
 			err = readErr
 			if err != nil {
 				return err
 			}
-			err = StoreChunk(remoteTx, chunkInfo.Hash, chunkBytes, chunkInfo.Size)
+			err = remoteStore.Put(remoteTx, chunkInfo.Hash, chunkBytes, chunkInfo.Size)
 			if err != nil {
 				return err
 			}
@@ -676,7 +764,7 @@ func PushChunks(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []Chunk
 	return nil
 }
 
-func PushChunksBatch(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []ChunkInfo, callback func(int, int, string, string)) error {
+func PushChunksBatch(ctx context.Context, tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []ChunkInfo, callback func(int, int, string, string)) error {
 	// const batchSizeLimit = 1 << 20 // 1 MB
 	const batchSizeLimit = 512 * 1024 // 512 KB
 
@@ -688,7 +776,12 @@ func PushChunksBatch(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []
 		totalChunksSize += chunkInfo.Size
 	}
 	processedChunks := 0
+	meter := newTransferMeter()
 
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return err
+	}
 	if utils.IsValidURL(remoteUrl) {
 		var currentBatch []Chunk
 		currentBatchSize := 0
@@ -701,11 +794,12 @@ func PushChunksBatch(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []
 			if err != nil {
 				return err
 			}
-			req, err := http.NewRequest("POST", dataUrl, bytes.NewBuffer(encodedChunk))
+			req, err := meter.request(ctx, "POST", dataUrl, encodedChunk)
 			if err != nil {
 				return err
 			}
 			req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+			req.Header.Set(ChunkFramingHeader, strconv.Itoa(ChunkFramingV1))
 			resp, err := client.Do(req)
 			if err != nil {
 				return err
@@ -716,11 +810,14 @@ func PushChunksBatch(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []
 				for _, chunk := range batch {
 					processedChunks += chunk.Size
 				}
-				message := fmt.Sprintf("Pushing Data %s/%s", utils.BytesToHumanReadable(processedChunks), utils.BytesToHumanReadable(totalChunksSize))
+				message := fmt.Sprintf("Pushing Data %s/%s (%s)", utils.BytesToHumanReadable(processedChunks), utils.BytesToHumanReadable(totalChunksSize), meter)
 				callback(processedChunks, totalChunksSize, message, "")
 			} else if resp.StatusCode == 400 {
 				body, _ := io.ReadAll(resp.Body)
 				return errors.New(string(body))
+			} else if resp.StatusCode == http.StatusInsufficientStorage {
+				body, _ := io.ReadAll(resp.Body)
+				return QuotaErrorFromResponse(resp.StatusCode, body)
 			} else {
 				return fmt.Errorf("unknown error while pushing chunks, status: %d", resp.StatusCode)
 			}
@@ -728,7 +825,7 @@ func PushChunksBatch(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []
 		}
 
 		for _, chunkInfo := range chunkInfos {
-			chunkData, err := ReadChunk(tx, chunkInfo.Hash)
+			chunkData, err := store.Get(tx, chunkInfo.Hash)
 			if err != nil {
 				return err
 			}
@@ -771,14 +868,22 @@ func PushChunksBatch(tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []
 				panic(p)
 			}
 		}()
+		remoteStore, err := OpenChunkStore(remoteTx)
+		if err != nil {
+			remoteTx.Rollback()
+			return err
+		}
 
 		for _, chunkInfo := range chunkInfos {
-			chunkBytes, readErr := ReadChunk(tx, chunkInfo.Hash)
+			chunkBytes, readErr := store.Get(tx, chunkInfo.Hash)
 			err = readErr
 			if err != nil {
 				return err
 			}
-			err = StoreChunk(remoteTx, chunkInfo.Hash, chunkBytes, chunkInfo.Size)
+			err = remoteStore.Put(remoteTx, chunkInfo.Hash, chunkBytes, chunkInfo.Size)
+			if err == nil {
+				err = RecordChunkDictionary(remoteTx, chunkInfo.Hash, chunkBytes)
+			}
 			if err != nil {
 				remoteTx.Rollback()
 				return err
@@ -800,7 +905,19 @@ func ChunkExists(chunkHash string, tx *sqlx.Tx, seenChunks map[string]bool) bool
 	if _, ok := seenChunks[chunkHash]; ok {
 		return true
 	}
-	exists, err := chunkExistsInStore(tx, chunkHash)
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return false
+	}
+	return StoreHasChunk(store, tx, chunkHash, seenChunks)
+}
+
+// StoreHasChunk is ChunkExists against an already resolved store.
+func StoreHasChunk(store ChunkStore, tx *sqlx.Tx, chunkHash string, seenChunks map[string]bool) bool {
+	if _, ok := seenChunks[chunkHash]; ok {
+		return true
+	}
+	exists, err := store.Has(tx, chunkHash)
 	if err != nil {
 		return false
 	}
@@ -809,3 +926,14 @@ func ChunkExists(chunkHash string, tx *sqlx.Tx, seenChunks map[string]bool) bool
 	}
 	return exists
 }
+
+// openProjectChunkStore resolves the project's chunk store for callers that
+// use a transaction per chunk.
+func openProjectChunkStore(dbConn *sqlx.DB) (ChunkStore, error) {
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return OpenChunkStore(tx)
+}
@@ -0,0 +1,300 @@
+package chunk_service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ChunkStore holds the compressed chunks of one project. It is resolved once
+// per opened project and can be used across that project's transactions.
+// Index rows follow the transaction passed in, while bytes kept outside the
+// project database are written and removed immediately.
+type ChunkStore interface {
+	Mode() string
+	// Put stores data under hash. Storing a chunk that already exists is a
+	// no-op.
+	Put(tx *sqlx.Tx, hash string, data []byte, size int) error
+	// Get returns the compressed bytes of a chunk, or sql.ErrNoRows when the
+	// chunk is not stored.
+	Get(tx *sqlx.Tx, hash string) ([]byte, error)
+	// Has reports whether the chunk is indexed and its bytes are present.
+	Has(tx *sqlx.Tx, hash string) (bool, error)
+	// Stat returns the chunk's index entry without reading its bytes.
+	Stat(tx *sqlx.Tx, hash string) (ChunkStat, error)
+	// Delete removes the chunk's index entry and its stored bytes.
+	Delete(tx *sqlx.Tx, hash string) error
+	// Iterate calls fn for up to limit indexed chunks whose hash sorts after
+	// after, in hash order. A limit of 0 or less visits every chunk.
+	Iterate(tx *sqlx.Tx, after string, limit int, fn func(ChunkStat) error) error
+}
+
+// ChunkStat is the index entry of a stored chunk. Size is the size recorded
+// when the chunk was stored, not necessarily its size on disk.
+type ChunkStat struct {
+	Hash      string `db:"hash" json:"hash"`
+	Size      int    `db:"size" json:"size"`
+	CreatedAt int64  `db:"created_at" json:"created_at"`
+}
+
+// ChunkSizer is implemented by stores that can tell how many bytes deleting a
+// chunk would free.
+type ChunkSizer interface {
+	ReclaimableSize(tx *sqlx.Tx, hash string) (int64, error)
+}
+
+// ChunkQuarantiner is implemented by stores that move a corrupt chunk's bytes
+// aside instead of deleting them. Quarantine drops the chunk from the index
+// and returns where the bytes went, or "" if none remained. Stores without it
+// have their chunk bytes kept in the chunk_quarantine table.
+type ChunkQuarantiner interface {
+	Quarantine(tx *sqlx.Tx, hash string) (string, error)
+}
+
+// ChunkOrphanSweeper is implemented by stores that can hold bytes no index row
+// points at, such as files left by an upload whose transaction rolled back.
+// SweepOrphans removes those older than cutoff and reports how many it found
+// and their size.
+type ChunkOrphanSweeper interface {
+	SweepOrphans(tx *sqlx.Tx, indexed map[string]bool, cutoff time.Time, dryRun bool) (int, int64, error)
+}
+
+// ChunkReplacer is implemented by stores that can overwrite a chunk's stored
+// bytes in place, such as when existing chunks are encrypted.
+type ChunkReplacer interface {
+	Replace(tx *sqlx.Tx, hash string, data []byte) error
+}
+
+// ChunkBatchPutter is implemented by stores that keep several chunks more
+// cheaply together than one at a time, such as by appending them to a pack
+// with a single sync. PutBatch stores each chunk as Put does.
+type ChunkBatchPutter interface {
+	PutBatch(tx *sqlx.Tx, chunks []Chunk) error
+}
+
+// putChunks stores chunks in store, together when the store supports it.
+func putChunks(store ChunkStore, tx *sqlx.Tx, chunks []Chunk) error {
+	if putter, ok := store.(ChunkBatchPutter); ok {
+		return putter.PutBatch(tx, chunks)
+	}
+	for _, chunk := range chunks {
+		if err := store.Put(tx, chunk.Hash, chunk.Data, chunk.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChunkStoreRemover is implemented by stores that keep chunks outside the
+// project database. RemoveAll deletes everything the project stored there.
+type ChunkStoreRemover interface {
+	RemoveAll(tx *sqlx.Tx) error
+}
+
+// StorageBackend describes a storage mode projects can select.
+type StorageBackend struct {
+	Mode string
+	// IndexTable is the project table listing the chunks the backend holds.
+	// Migrations need the source and target modes to use different tables.
+	IndexTable string
+	// Shared backends keep one copy of a chunk for several projects, so the
+	// chunks cannot be encrypted with per-project keys.
+	Shared bool
+	// Available returns why the backend cannot currently be used, or nil.
+	Available func() error
+	// Open resolves the backend's store for the project tx belongs to.
+	Open func(tx *sqlx.Tx) (ChunkStore, error)
+}
+
+var (
+	storageBackendsMu sync.RWMutex
+	storageBackends   = map[string]StorageBackend{}
+	storageModeOrder  []string
+)
+
+// RegisterStorageBackend makes a storage mode available to projects. It
+// panics if the mode is registered twice or the backend is incomplete.
+func RegisterStorageBackend(backend StorageBackend) {
+	storageBackendsMu.Lock()
+	defer storageBackendsMu.Unlock()
+	if backend.Mode == "" || backend.IndexTable == "" || backend.Open == nil {
+		panic("chunk_service: incomplete storage backend")
+	}
+	if _, dup := storageBackends[backend.Mode]; dup {
+		panic("chunk_service: storage backend registered twice for mode " + backend.Mode)
+	}
+	storageBackends[backend.Mode] = backend
+	storageModeOrder = append(storageModeOrder, backend.Mode)
+}
+
+func storageBackend(mode string) (StorageBackend, bool) {
+	storageBackendsMu.RLock()
+	defer storageBackendsMu.RUnlock()
+	backend, ok := storageBackends[mode]
+	return backend, ok
+}
+
+func init() {
+	RegisterStorageBackend(StorageBackend{
+		Mode:       StorageModeCompact,
+		IndexTable: "chunk",
+		Open: func(tx *sqlx.Tx) (ChunkStore, error) {
+			return compactStore{}, nil
+		},
+	})
+	RegisterStorageBackend(StorageBackend{
+		Mode:       StorageModeDeflated,
+		IndexTable: "chunk_ref",
+		Available:  deflatedStorageAvailable,
+		Open:       openDeflatedStore,
+	})
+	RegisterStorageBackend(StorageBackend{
+		Mode:       StorageModeObjectStorage,
+		IndexTable: "chunk_ref",
+		Available:  objectStorageAvailable,
+		Open:       openObjectChunkStore,
+	})
+	RegisterStorageBackend(StorageBackend{
+		Mode:       StorageModePooled,
+		IndexTable: "chunk_ref",
+		Shared:     true,
+		Available:  pooledStorageAvailable,
+		Open:       openPooledStore,
+	})
+}
+
+// chunkStores caches the opened chunk store of each project by database
+// file, so reading or writing a single chunk does not unwrap the project's
+// key and resolve its backend again. An entry is reopened once the project
+// id, storage mode, data key or archive use differ from when it was opened,
+// and all are dropped when the studio's storage is reconfigured.
+var chunkStores = struct {
+	sync.Mutex
+	entries map[string]cachedChunkStore
+}{entries: map[string]cachedChunkStore{}}
+
+type cachedChunkStore struct {
+	state string
+	store ChunkStore
+}
+
+// OpenChunkStore resolves the chunk store of the project tx belongs to.
+func OpenChunkStore(tx *sqlx.Tx) (ChunkStore, error) {
+	file, state, err := chunkStoreState(tx)
+	if err != nil || file == "" {
+		// Databases from before the storage tables and in-memory ones are
+		// resolved every time.
+		return openChunkStore(tx)
+	}
+	chunkStores.Lock()
+	cached, ok := chunkStores.entries[file]
+	chunkStores.Unlock()
+	if ok && cached.state == state {
+		return cached.store, nil
+	}
+	store, err := openChunkStore(tx)
+	if err != nil {
+		return nil, err
+	}
+	chunkStores.Lock()
+	chunkStores.entries[file] = cachedChunkStore{state: state, store: store}
+	chunkStores.Unlock()
+	return store, nil
+}
+
+func openChunkStore(tx *sqlx.Tx) (ChunkStore, error) {
+	mode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return nil, err
+	}
+	return openChunkStoreForMode(tx, mode)
+}
+
+// chunkStoreState returns the database file of the project tx belongs to
+// and what its chunk store was opened from, as seen by tx.
+func chunkStoreState(tx *sqlx.Tx) (string, string, error) {
+	var row struct {
+		File  string `db:"file"`
+		State string `db:"state"`
+	}
+	err := tx.Get(&row, `
+		SELECT file,
+			IFNULL((SELECT value FROM config WHERE name = 'project_id'), '') || ' ' ||
+			IFNULL((SELECT mode FROM project_storage WHERE id = 1), '') || ' ' ||
+			IFNULL((SELECT key_id || ' ' || wrapped_key FROM project_encryption WHERE id = 1), '') || ' ' ||
+			EXISTS(SELECT 1 FROM chunk_archive) AS state
+		FROM pragma_database_list WHERE name = 'main'
+	`)
+	return row.File, row.State, err
+}
+
+// forgetChunkStores drops every cached chunk store, as after the storage
+// backends or master keys are reconfigured.
+func forgetChunkStores() {
+	chunkStores.Lock()
+	clear(chunkStores.entries)
+	chunkStores.Unlock()
+}
+
+// openChunkStoreForMode opens mode's store for the project, falling back to
+// the archive for archived chunks and encrypting and decrypting chunk
+// payloads if the project has a data key.
+func openChunkStoreForMode(tx *sqlx.Tx, mode string) (ChunkStore, error) {
+	store, err := openBackendStore(tx, mode)
+	if err != nil {
+		return nil, err
+	}
+	if store, err = openTieredStore(tx, store); err != nil {
+		return nil, err
+	}
+	key, err := projectDataKey(tx)
+	if err != nil || key == nil {
+		return store, err
+	}
+	if backend, _ := storageBackend(mode); backend.Shared {
+		return nil, fmt.Errorf("%s storage cannot hold encrypted projects", mode)
+	}
+	return encryptedStore{inner: store, key: key}, nil
+}
+
+// openBackendStore opens mode's store without encryption.
+func openBackendStore(tx *sqlx.Tx, mode string) (ChunkStore, error) {
+	if err := ValidateStorageMode(mode); err != nil {
+		return nil, err
+	}
+	backend, _ := storageBackend(mode)
+	return backend.Open(tx)
+}
+
+// chunkIndexTable returns the table that lists the chunks held by mode.
+func chunkIndexTable(mode string) string {
+	backend, ok := storageBackend(mode)
+	if !ok {
+		panic(fmt.Sprintf("chunk_service: unknown storage mode %q", mode))
+	}
+	return backend.IndexTable
+}
+
+// iterateChunkIndex implements ChunkStore.Iterate over an index table with
+// hash, size and created_at columns.
+func iterateChunkIndex(tx *sqlx.Tx, table, after string, limit int, fn func(ChunkStat) error) error {
+	query := "SELECT hash, size, created_at FROM " + table + " WHERE hash > ? ORDER BY hash"
+	args := []any{after}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	// Collect first so fn may write to the table being iterated.
+	var chunks []ChunkStat
+	if err := tx.Select(&chunks, query, args...); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
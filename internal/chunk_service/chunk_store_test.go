@@ -0,0 +1,131 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestChunkStoresBehaveAlike(t *testing.T) {
+	if err := chunk_service.ConfigureProjectStorage(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	firstHash, firstData := compressedChunk(t, "first chunk")
+	secondHash, secondData := compressedChunk(t, "second chunk")
+	for _, mode := range []string{chunk_service.StorageModeCompact, chunk_service.StorageModeDeflated, chunk_service.StorageModePooled} {
+		t.Run(mode, func(t *testing.T) {
+			projectPath := newCompactProjectWithID(t, "project-"+mode)
+			withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+				if err := chunk_service.SetProjectStorageMode(tx, mode); err != nil {
+					t.Fatal(err)
+				}
+				store, err := chunk_service.OpenChunkStore(tx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if store.Mode() != mode {
+					t.Fatalf("opened %q store for %q project", store.Mode(), mode)
+				}
+				if err := store.Put(tx, secondHash, secondData, 12); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Put(tx, firstHash, firstData, 11); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Put(tx, firstHash, firstData, 11); err != nil {
+					t.Fatalf("storing an existing chunk again: %v", err)
+				}
+
+				if got, err := store.Get(tx, firstHash); err != nil || string(got) != string(firstData) {
+					t.Fatalf("get: %v", err)
+				}
+				if stat, err := store.Stat(tx, secondHash); err != nil || stat.Size != 12 {
+					t.Fatalf("stat: %+v, %v", stat, err)
+				}
+				var visited []string
+				err = store.Iterate(tx, "", 0, func(chunk chunk_service.ChunkStat) error {
+					visited = append(visited, chunk.Hash)
+					return nil
+				})
+				if err != nil || len(visited) != 2 || visited[0] > visited[1] {
+					t.Fatalf("iterate visited %v: %v", visited, err)
+				}
+				visited = nil
+				err = store.Iterate(tx, min(firstHash, secondHash), 1, func(chunk chunk_service.ChunkStat) error {
+					visited = append(visited, chunk.Hash)
+					return nil
+				})
+				if err != nil || len(visited) != 1 || visited[0] != max(firstHash, secondHash) {
+					t.Fatalf("iterate after cursor visited %v: %v", visited, err)
+				}
+
+				if err := store.Delete(tx, firstHash); err != nil {
+					t.Fatal(err)
+				}
+				if ok, err := store.Has(tx, firstHash); err != nil || ok {
+					t.Fatalf("deleted chunk still present: %v", err)
+				}
+				if _, err := store.Get(tx, firstHash); !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("expected sql.ErrNoRows for deleted chunk, got %v", err)
+				}
+				if ok, err := store.Has(tx, secondHash); err != nil || !ok {
+					t.Fatalf("remaining chunk missing: %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestRegisteredStorageBackendNeedsAvailability(t *testing.T) {
+	chunk_service.RegisterStorageBackend(chunk_service.StorageBackend{
+		Mode:       "test_unavailable",
+		IndexTable: "chunk_ref",
+		Available:  func() error { return errors.New("test backend is offline") },
+		Open: func(tx *sqlx.Tx) (chunk_service.ChunkStore, error) {
+			return nil, errors.New("unexpected open")
+		},
+	})
+
+	supported := chunk_service.SupportedStorageModes()
+	if supported[len(supported)-1] != "test_unavailable" {
+		t.Fatalf("registered mode missing from %v", supported)
+	}
+	for _, mode := range chunk_service.AvailableStorageModes() {
+		if mode == "test_unavailable" {
+			t.Fatal("unavailable backend listed as available")
+		}
+	}
+	if err := chunk_service.ValidateStorageMode("test_unavailable"); err == nil || err.Error() != "test backend is offline" {
+		t.Fatalf("expected availability error, got %v", err)
+	}
+}
+
+func TestOpenChunkStoreFollowsModeChanges(t *testing.T) {
+	if err := chunk_service.ConfigureProjectStorage(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	projectPath := newCompactProject(t)
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for _, mode := range []string{chunk_service.StorageModeCompact, chunk_service.StorageModeDeflated, chunk_service.StorageModeCompact} {
+			if err := chunk_service.SetProjectStorageMode(tx, mode); err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				store, err := chunk_service.OpenChunkStore(tx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if store.Mode() != mode {
+					t.Fatalf("opened %q store after switching to %q", store.Mode(), mode)
+				}
+			}
+		}
+	})
+}
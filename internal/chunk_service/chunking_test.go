@@ -0,0 +1,87 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/repository"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestStoreFileChunksUsesChunkingProfiles(t *testing.T) {
+	projectPath := newCompactProject(t)
+
+	var text bytes.Buffer
+	for i := range 2000 {
+		fmt.Fprintf(&text, "line %04d of a text asset that changes a little between checkpoints\n", i)
+	}
+	original := text.Bytes()
+	edited := bytes.Replace(original, []byte("line 1000 of"), []byte("line 1000 (edited) of"), 1)
+
+	dir := t.TempDir()
+	store := func(tx *sqlx.Tx, name string, data []byte, assetTypeId string) []string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		sequence, err := repository.StoreFileChunks(tx, path, assetTypeId, func(int, int, string, string) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(sequence, ",")
+	}
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if chunks := store(tx, "default.txt", original, ""); len(chunks) != 1 {
+			t.Fatalf("default profile split a %d byte file into %d chunks", len(original), len(chunks))
+		}
+
+		profiles, err := repository.GetChunkingProfiles(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		profiles.Extensions["TXT"] = repository.ChunkingProfileSmallFile
+		profiles.AssetTypes["huge"] = repository.ChunkingProfileHugeMedia
+		if err := repository.SaveChunkingProfiles(tx, profiles); err != nil {
+			t.Fatal(err)
+		}
+
+		before := store(tx, "v1.txt", original, "")
+		after := store(tx, "v2.txt", edited, "")
+		if len(before) < 4 {
+			t.Fatalf("small-file profile produced only %d chunks", len(before))
+		}
+		shared := 0
+		for _, hash := range after {
+			for _, old := range before {
+				if hash == old {
+					shared++
+					break
+				}
+			}
+		}
+		if shared < len(after)-2 {
+			t.Fatalf("edited file shares %d of %d chunks with the original", shared, len(after))
+		}
+
+		if chunks := store(tx, "huge.txt", original, "huge"); len(chunks) != 1 {
+			t.Fatalf("asset type profile did not take precedence, got %d chunks", len(chunks))
+		}
+	})
+
+	invalid := repository.DefaultChunkingProfiles()
+	invalid.Profiles["too-big"] = repository.ChunkingProfile{MinSize: 1 << 20, AverageSize: 8 << 20, MaxSize: 64 << 20}
+	if err := invalid.Validate(); err == nil {
+		t.Fatal("profile larger than the chunk record limit was accepted")
+	}
+	invalid = repository.DefaultChunkingProfiles()
+	invalid.Extensions[".usd"] = "missing"
+	if err := invalid.Validate(); err == nil {
+		t.Fatal("extension mapped to an unknown profile was accepted")
+	}
+}
@@ -0,0 +1,472 @@
+package chunk_service
+
+import (
+	"clustta/internal/constants"
+	"clustta/internal/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/jmoiron/sqlx"
+	"github.com/klauspost/compress/dict"
+	kzstd "github.com/klauspost/compress/zstd"
+)
+
+// Small, similar files such as rigs, JSON sidecars and USD layers compress
+// poorly one chunk at a time. TrainChunkDictionaries samples a project's small
+// files and trains a zstd dictionary for each extension with enough of them,
+// plus a project-wide dictionary (extension "") from the rest. Dictionaries
+// are never replaced: retraining adds new ones, new chunks use the newest one
+// for their extension, and old chunks keep decoding with the dictionary their
+// zstd frame header names. chunk_dictionary_ref records that ID per chunk.
+
+var ErrDictionaryUnsupported = errors.New("shared storage cannot hold dictionary compressed chunks")
+
+var (
+	// maxDictionarySize caps each trained dictionary.
+	maxDictionarySize = 112 << 10
+	// maxDictionaryFileSize is the largest file sampled for training, and the
+	// largest chunk compressed with a dictionary. Bigger data gains little.
+	maxDictionaryFileSize = 1 << 20
+	// minDictionarySamples is how many files an extension needs for its own
+	// dictionary.
+	minDictionarySamples = 8
+	// maxDictionarySamples caps the files sampled per extension.
+	maxDictionarySamples = 512
+)
+
+// ChunkDictionary is a zstd dictionary trained on a project's chunks. Id is
+// the dictionary ID written in the frame header of chunks compressed with it.
+type ChunkDictionary struct {
+	Id           uint32 `db:"id" json:"id"`
+	Extension    string `db:"extension" json:"extension"`
+	Data         []byte `db:"data" json:"data,omitempty"`
+	SampleChunks int    `db:"sample_chunks" json:"sample_chunks"`
+	CreatedAt    int64  `db:"created_at" json:"created_at"`
+}
+
+// dictionaryExtension normalises a file extension for dictionary lookups.
+func dictionaryExtension(extension string) string {
+	return strings.ToLower(extension)
+}
+
+// ListChunkDictionaries returns the project's dictionaries, oldest first,
+// decrypted if the project is encrypted at rest.
+func ListChunkDictionaries(tx *sqlx.Tx) ([]ChunkDictionary, error) {
+	dictionaries := []ChunkDictionary{}
+	err := tx.Select(&dictionaries, "SELECT id, extension, data, sample_chunks, created_at FROM chunk_dictionary ORDER BY created_at, id")
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return []ChunkDictionary{}, nil
+		}
+		return nil, err
+	}
+	key, err := projectDataKey(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i, dictionary := range dictionaries {
+		dictionaries[i].Data, err = openPayload(key, dictionary.Data, dictionaryAdditionalData(dictionary.Id))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dictionaries, nil
+}
+
+func dictionaryAdditionalData(id uint32) string {
+	return "dictionary:" + strconv.FormatUint(uint64(id), 10)
+}
+
+// saveChunkDictionaries stores dictionaries the project does not have yet,
+// sealing them if the project is encrypted at rest.
+func saveChunkDictionaries(tx *sqlx.Tx, dictionaries []ChunkDictionary) error {
+	key, err := projectDataKey(tx)
+	if err != nil {
+		return err
+	}
+	for _, dictionary := range dictionaries {
+		data := dictionary.Data
+		if key != nil {
+			data, err = sealPayload(key, data, dictionaryAdditionalData(dictionary.Id))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO chunk_dictionary (id, extension, data, sample_chunks, created_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, dictionary.Id, dictionary.Extension, data, dictionary.SampleChunks, dictionary.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealChunkDictionaries encrypts the project's stored dictionaries with its
+// new data key.
+func sealChunkDictionaries(tx *sqlx.Tx, key []byte) error {
+	var dictionaries []ChunkDictionary
+	if err := tx.Select(&dictionaries, "SELECT id, data FROM chunk_dictionary"); err != nil {
+		return err
+	}
+	for _, dictionary := range dictionaries {
+		if isEncryptedPayload(dictionary.Data) {
+			continue
+		}
+		sealed, err := sealPayload(key, dictionary.Data, dictionaryAdditionalData(dictionary.Id))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE chunk_dictionary SET data = ? WHERE id = ?", sealed, dictionary.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importChunkDictionaries stores dictionaries received from a remote in the
+// project at projectPath.
+func importChunkDictionaries(projectPath string, dictionaries []ChunkDictionary) error {
+	if len(dictionaries) == 0 {
+		return nil
+	}
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveChunkDictionaries(tx, dictionaries); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// newChunkDecoder returns a decoder that knows all of the project's
+// dictionaries, so it reads chunks compressed with or without one.
+func newChunkDecoder(tx *sqlx.Tx) (*kzstd.Decoder, error) {
+	options, err := ChunkDecoderOptions(tx)
+	if err != nil {
+		return nil, err
+	}
+	return kzstd.NewReader(nil, options...)
+}
+
+// openChunkDecoder is newChunkDecoder for callers that use a transaction per
+// chunk.
+func openChunkDecoder(dbConn *sqlx.DB) (*kzstd.Decoder, error) {
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return newChunkDecoder(tx)
+}
+
+// ChunkDecoderOptions returns the decoder options that register the project's
+// dictionaries, for callers that build their own zstd readers.
+func ChunkDecoderOptions(tx *sqlx.Tx) ([]kzstd.DOption, error) {
+	dictionaries, err := ListChunkDictionaries(tx)
+	if err != nil || len(dictionaries) == 0 {
+		return nil, err
+	}
+	raw := make([][]byte, len(dictionaries))
+	for i, dictionary := range dictionaries {
+		raw[i] = dictionary.Data
+	}
+	return []kzstd.DOption{kzstd.WithDecoderDicts(raw...)}, nil
+}
+
+// RecordChunkDictionary records which dictionary, if any, the compressed
+// chunk data was encoded with.
+func RecordChunkDictionary(tx *sqlx.Tx, hash string, data []byte) error {
+	var header kzstd.Header
+	if err := header.Decode(data); err != nil || header.DictionaryID == 0 {
+		return nil
+	}
+	_, err := tx.Exec(
+		"INSERT OR REPLACE INTO chunk_dictionary_ref (hash, dictionary_id) VALUES (?, ?)",
+		hash, header.DictionaryID,
+	)
+	return err
+}
+
+func forgetChunkDictionary(tx *sqlx.Tx, hash string) error {
+	_, err := tx.Exec("DELETE FROM chunk_dictionary_ref WHERE hash = ?", hash)
+	return err
+}
+
+// ChunkCompressor compresses the chunks of one file, using the newest
+// dictionary for the file's extension or, failing that, the project-wide one.
+type ChunkCompressor struct {
+	encoder *kzstd.Encoder
+}
+
+// NewChunkCompressor prepares a compressor for a file with the given
+// extension. Close must be called when done.
+func NewChunkCompressor(tx *sqlx.Tx, extension string) (*ChunkCompressor, error) {
+	var data []byte
+	var id uint32
+	err := tx.QueryRowx(`
+		SELECT id, data FROM chunk_dictionary WHERE extension IN (?, '')
+		ORDER BY extension = '', created_at DESC, id DESC LIMIT 1
+	`, dictionaryExtension(extension)).Scan(&id, &data)
+	if errors.Is(err, sql.ErrNoRows) || (err != nil && strings.Contains(err.Error(), "no such table")) {
+		return &ChunkCompressor{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := projectDataKey(tx)
+	if err != nil {
+		return nil, err
+	}
+	data, err = openPayload(key, data, dictionaryAdditionalData(id))
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := kzstd.NewWriter(nil, kzstd.WithEncoderLevel(kzstd.SpeedDefault), kzstd.WithEncoderDict(data))
+	if err != nil {
+		return nil, fmt.Errorf("dictionary %d: %w", id, err)
+	}
+	return &ChunkCompressor{encoder: encoder}, nil
+}
+
+// Compress returns data as a zstd frame. Chunks too large to gain from a
+// dictionary are compressed without one.
+func (c *ChunkCompressor) Compress(data []byte) ([]byte, error) {
+	if c.encoder == nil || len(data) > maxDictionaryFileSize {
+		return zstd.CompressLevel(nil, data, 3)
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *ChunkCompressor) Close() {
+	if c.encoder != nil {
+		c.encoder.Close()
+	}
+}
+
+// TrainChunkDictionaries samples the project's small files and trains new
+// dictionaries from them. Extensions with too few files share the
+// project-wide dictionary. It returns the dictionaries added, without their
+// data.
+func TrainChunkDictionaries(projectPath string) ([]ChunkDictionary, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	mode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return nil, err
+	}
+	if backend, _ := storageBackend(mode); backend.Shared {
+		return nil, ErrDictionaryUnsupported
+	}
+	samples, err := sampleDictionaryFiles(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared [][]byte
+	trained := []ChunkDictionary{}
+	for extension, files := range samples {
+		if len(files) < minDictionarySamples {
+			shared = append(shared, files...)
+			continue
+		}
+		dictionary, err := trainChunkDictionary(tx, extension, files)
+		if err != nil {
+			return nil, err
+		}
+		trained = append(trained, dictionary)
+	}
+	if len(shared) >= minDictionarySamples {
+		dictionary, err := trainChunkDictionary(tx, "", shared)
+		if err != nil {
+			return nil, err
+		}
+		trained = append(trained, dictionary)
+	}
+	if err := saveChunkDictionaries(tx, trained); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for i := range trained {
+		trained[i].Data = nil
+	}
+	return trained, nil
+}
+
+// sampleDictionaryFiles rebuilds the newest small files of each extension
+// from their chunks. Files with chunks the project does not hold are skipped.
+func sampleDictionaryFiles(tx *sqlx.Tx) (map[string][][]byte, error) {
+	var checkpoints []struct {
+		Extension string `db:"extension"`
+		Chunks    string `db:"chunks"`
+	}
+	err := tx.Select(&checkpoints, `
+		SELECT asset.extension, asset_checkpoint.chunks FROM asset_checkpoint
+		JOIN asset ON asset.id = asset_checkpoint.asset_id
+		WHERE asset_checkpoint.trashed = 0 AND asset_checkpoint.file_size > 0 AND asset_checkpoint.file_size <= ?
+		ORDER BY asset_checkpoint.mtime DESC
+	`, maxDictionaryFileSize)
+	if err != nil {
+		return nil, err
+	}
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := newChunkDecoder(tx)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+
+	samples := map[string][][]byte{}
+	seen := map[string]bool{}
+	for _, checkpoint := range checkpoints {
+		extension := dictionaryExtension(checkpoint.Extension)
+		if len(samples[extension]) >= maxDictionarySamples || seen[checkpoint.Chunks] {
+			continue
+		}
+		seen[checkpoint.Chunks] = true
+		var file []byte
+		for _, hash := range strings.Split(checkpoint.Chunks, ",") {
+			data, err := store.Get(tx, hash)
+			if errors.Is(err, sql.ErrNoRows) {
+				file = nil
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			file, err = decoder.DecodeAll(data, file)
+			if err != nil {
+				return nil, fmt.Errorf("chunk %s: %w", hash, err)
+			}
+		}
+		if len(file) > 0 {
+			samples[extension] = append(samples[extension], file)
+		}
+	}
+	return samples, nil
+}
+
+func trainChunkDictionary(tx *sqlx.Tx, extension string, files [][]byte) (ChunkDictionary, error) {
+	id, err := newDictionaryID(tx)
+	if err != nil {
+		return ChunkDictionary{}, err
+	}
+	data, err := dict.BuildZstdDict(files, dict.Options{
+		MaxDictSize: maxDictionarySize,
+		HashBytes:   6,
+		ZstdDictID:  id,
+		ZstdLevel:   kzstd.SpeedDefault,
+	})
+	if err != nil {
+		return ChunkDictionary{}, fmt.Errorf("training dictionary for %q: %w", extension, err)
+	}
+	return ChunkDictionary{
+		Id:           id,
+		Extension:    extension,
+		Data:         data,
+		SampleChunks: len(files),
+		CreatedAt:    time.Now().Unix(),
+	}, nil
+}
+
+// newDictionaryID picks an unused ID outside the range zstd reserves for
+// registered dictionaries.
+func newDictionaryID(tx *sqlx.Tx) (uint32, error) {
+	for {
+		id := 32768 + rand.Uint32N((1<<31)-32768)
+		var exists bool
+		if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM chunk_dictionary WHERE id = ?)", id); err != nil {
+			return 0, err
+		}
+		if !exists {
+			return id, nil
+		}
+	}
+}
+
+// pullChunkDictionaries fetches the remote's dictionaries so chunks
+// compressed with them can be decoded. Servers without dictionary support
+// answer 404 and are skipped.
+func pullChunkDictionaries(ctx context.Context, projectPath, remoteUrl string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", remoteUrl+"/dictionaries", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+	client := utils.HTTPClient(ctx, &http.Client{Timeout: 30 * time.Second})
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil
+	case http.StatusBadRequest:
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(body))
+	default:
+		return fmt.Errorf("unknown error while fetching dictionaries, status: %d", response.StatusCode)
+	}
+	var dictionaries []ChunkDictionary
+	if err := json.NewDecoder(response.Body).Decode(&dictionaries); err != nil {
+		return fmt.Errorf("error decoding dictionaries: %w", err)
+	}
+	return importChunkDictionaries(projectPath, dictionaries)
+}
+
+// copyChunkDictionaries copies the dictionaries of the project in from into
+// the project in to, for remotes that are local project files.
+func copyChunkDictionaries(from, to *sqlx.Tx) error {
+	dictionaries, err := ListChunkDictionaries(from)
+	if err != nil {
+		return err
+	}
+	return saveChunkDictionaries(to, dictionaries)
+}
+
+// hasChunkDictionaries reports whether the project holds any dictionaries.
+func hasChunkDictionaries(tx *sqlx.Tx) (bool, error) {
+	var exists bool
+	err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM chunk_dictionary)")
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return false, nil
+	}
+	return exists, err
+}
@@ -0,0 +1,109 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	kzstd "github.com/klauspost/compress/zstd"
+)
+
+func sidecarFile(i int) []byte {
+	return fmt.Appendf(nil, `{"asset": "prop_%03d", "pipeline": {"department": "modeling", "status": "review",
+	"frame_range": [1001, %d], "colorspace": "ACEScg", "renderer": "karma"}, "attributes": {"lod": %d,
+	"variants": ["default", "damaged_%d", "hero"], "materials": ["metal_worn", "rubber_black", "glass_clear"]}}`,
+		i, 1001+i*10, i%4, i)
+}
+
+func TestChunkDictionariesCompressAndDecode(t *testing.T) {
+	projectPath := newCompactProject(t)
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for i := range 32 {
+			file := sidecarFile(i)
+			hash, data := compressedChunk(t, string(file))
+			if err := chunk_service.StoreChunk(tx, hash, data, len(file)); err != nil {
+				t.Fatal(err)
+			}
+			assetId := fmt.Sprintf("asset-%d", i)
+			_, err := tx.Exec(`
+				INSERT INTO asset (id, created_at, mtime, name, extension, status_id, asset_type_id)
+				VALUES (?, '', 1, ?, '.json', 'status', 'type')
+			`, assetId, assetId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tx.Exec(`
+				INSERT INTO asset_checkpoint (id, created_at, mtime, asset_id, xxhash_checksum, time_modified, file_size, chunks, author_id)
+				VALUES (?, '', ?, ?, '', 0, ?, ?, 'user')
+			`, "checkpoint-"+assetId, i, assetId, len(file), hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	dictionaries, err := chunk_service.TrainChunkDictionaries(projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dictionaries) != 1 || dictionaries[0].Extension != ".json" || dictionaries[0].SampleChunks != 32 {
+		t.Fatalf("unexpected dictionaries %+v", dictionaries)
+	}
+	dictionaryId := dictionaries[0].Id
+
+	file := sidecarFile(99)
+	sum := sha256.Sum256(file)
+	hash := hex.EncodeToString(sum[:])
+	var compressed []byte
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		compressor, err := chunk_service.NewChunkCompressor(tx, ".JSON")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer compressor.Close()
+		if compressed, err = compressor.Compress(file); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var header kzstd.Header
+	if err := header.Decode(compressed); err != nil || header.DictionaryID != dictionaryId {
+		t.Fatalf("chunk compressed with dictionary %d, want %d (%v)", header.DictionaryID, dictionaryId, err)
+	}
+	if _, plain := compressedChunk(t, string(file)); len(compressed) >= len(plain) {
+		t.Fatalf("dictionary did not help: %d bytes, %d without", len(compressed), len(plain))
+	}
+
+	encoded, err := chunk_service.EncodeChunks([]chunk_service.Chunk{{Hash: hash, Data: compressed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed, err := chunk_service.WriteChunks(projectPath, encoded, chunk_service.ChunkFramingV1); err != nil || len(failed) != 0 {
+		t.Fatalf("writing chunk: %v, failed %v", err, failed)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		var recorded uint32
+		if err := tx.Get(&recorded, "SELECT dictionary_id FROM chunk_dictionary_ref WHERE hash = ?", hash); err != nil || recorded != dictionaryId {
+			t.Fatalf("recorded dictionary %d, want %d (%v)", recorded, dictionaryId, err)
+		}
+		data, err := chunk_service.ReadChunk(tx, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		options, err := chunk_service.ChunkDecoderOptions(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder, err := kzstd.NewReader(nil, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer decoder.Close()
+		if got, err := decoder.DecodeAll(data, nil); err != nil || !bytes.Equal(got, file) {
+			t.Fatalf("decoding dictionary compressed chunk: %v", err)
+		}
+	})
+}
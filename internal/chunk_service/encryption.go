@@ -0,0 +1,501 @@
+package chunk_service
+
+import (
+	"bytes"
+	"clustta/internal/cryptoutil"
+	"clustta/internal/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Projects can opt in to encryption at rest. An encrypted project has its own
+// random data key, kept in project_encryption wrapped by the Studio master
+// key, and its chunk payloads and previews are sealed with that data key.
+// Rotating the master key only re-wraps the data keys.
+
+const DefaultEncryptionBatchSize = 128
+
+// encryptedPayloadMagic prefixes sealed chunks and previews. Plain chunks are
+// zstd frames and previews are images, so neither starts with it; payloads
+// written before a project was encrypted stay readable as they are.
+var encryptedPayloadMagic = []byte("CLE1")
+
+var (
+	ErrStorageEncryptionUnavailable = errors.New("storage encryption is not configured")
+	ErrStorageKeyUnavailable        = errors.New("project data key is wrapped by a storage master key that is not configured")
+	ErrEncryptionBusy               = errors.New("project data is already being encrypted")
+)
+
+type storageMasterKey struct {
+	id  string
+	key []byte
+}
+
+// storageMasterKeys holds the current master key first, followed by retired
+// keys that can still unwrap data keys. Guarded by storageConfigMu.
+var storageMasterKeys []storageMasterKey
+
+// ProjectEncryption describes a project's data key. BackfillPending is set
+// until chunks and previews stored before encryption was enabled have been
+// sealed.
+type ProjectEncryption struct {
+	Enabled         bool   `db:"-" json:"enabled"`
+	KeyId           string `db:"key_id" json:"key_id"`
+	BackfillPending bool   `db:"backfill_pending" json:"backfill_pending"`
+	CreatedAt       int64  `db:"created_at" json:"created_at"`
+	RotatedAt       int64  `db:"rotated_at" json:"rotated_at"`
+}
+
+// EncryptionBackfill counts the payloads sealed by EncryptProjectData.
+type EncryptionBackfill struct {
+	Chunks   int `json:"chunks"`
+	Previews int `json:"previews"`
+}
+
+var (
+	activeEncryptionsMu sync.Mutex
+	activeEncryptions   = map[string]bool{}
+)
+
+func storageKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ConfigureStorageEncryption sets the Studio master key that wraps project
+// data keys. previous lists retired master keys that are still accepted for
+// unwrapping until RewrapProjectKey moves projects off them. A nil current
+// key disables encryption for new projects.
+func ConfigureStorageEncryption(current []byte, previous ...[]byte) error {
+	if current == nil && len(previous) > 0 {
+		return errors.New("previous storage master keys require a current key")
+	}
+	keys := []storageMasterKey{}
+	if current != nil {
+		for _, key := range append([][]byte{current}, previous...) {
+			if len(key) != cryptoutil.KeySize {
+				return cryptoutil.ErrInvalidKey
+			}
+			keys = append(keys, storageMasterKey{id: storageKeyID(key), key: key})
+		}
+	}
+
+	storageConfigMu.Lock()
+	defer storageConfigMu.Unlock()
+	forgetChunkStores()
+	storageMasterKeys = keys
+	return nil
+}
+
+func StorageEncryptionAvailable() bool {
+	storageConfigMu.RLock()
+	defer storageConfigMu.RUnlock()
+	return len(storageMasterKeys) > 0
+}
+
+func currentMasterKey() (storageMasterKey, error) {
+	storageConfigMu.RLock()
+	defer storageConfigMu.RUnlock()
+	if len(storageMasterKeys) == 0 {
+		return storageMasterKey{}, ErrStorageEncryptionUnavailable
+	}
+	return storageMasterKeys[0], nil
+}
+
+func masterKeyByID(id string) (storageMasterKey, error) {
+	storageConfigMu.RLock()
+	defer storageConfigMu.RUnlock()
+	for _, key := range storageMasterKeys {
+		if key.id == id {
+			return key, nil
+		}
+	}
+	return storageMasterKey{}, ErrStorageKeyUnavailable
+}
+
+func GetProjectEncryption(tx *sqlx.Tx) (ProjectEncryption, error) {
+	var encryption ProjectEncryption
+	err := tx.Get(&encryption, `
+		SELECT key_id, backfill_pending, created_at, rotated_at
+		FROM project_encryption WHERE id = 1
+	`)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no such table") {
+			return ProjectEncryption{}, nil
+		}
+		return encryption, err
+	}
+	encryption.Enabled = true
+	return encryption, nil
+}
+
+// EnableProjectEncryption gives the project a data key wrapped by the current
+// master key. New chunks and previews are sealed from then on; the ones
+// already stored are left for EncryptProjectData.
+func EnableProjectEncryption(tx *sqlx.Tx) (ProjectEncryption, error) {
+	existing, err := GetProjectEncryption(tx)
+	if err != nil || existing.Enabled {
+		return existing, err
+	}
+	master, err := currentMasterKey()
+	if err != nil {
+		return existing, err
+	}
+	mode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return existing, err
+	}
+	if backend, _ := storageBackend(mode); backend.Shared {
+		return existing, fmt.Errorf("%s storage cannot hold encrypted projects", mode)
+	}
+	migration, err := GetStorageMigration(tx)
+	if err == nil && migration.Status == StorageMigrationRunning {
+		return existing, ErrStorageMigrationInProgress
+	} else if err != nil && !errors.Is(err, ErrNoStorageMigration) {
+		return existing, err
+	}
+
+	dataKey := make([]byte, cryptoutil.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return existing, err
+	}
+	wrapped, err := cryptoutil.Encrypt(master.key, dataKey)
+	if err != nil {
+		return existing, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO project_encryption (id, key_id, wrapped_key, backfill_pending, created_at)
+		VALUES (1, ?, ?, 1, ?)
+	`, master.id, wrapped, time.Now().Unix())
+	if err != nil {
+		return existing, err
+	}
+	if err := sealChunkDictionaries(tx, dataKey); err != nil {
+		return existing, err
+	}
+	return GetProjectEncryption(tx)
+}
+
+// projectDataKey returns the project's unwrapped data key, or nil if the
+// project is not encrypted.
+func projectDataKey(tx *sqlx.Tx) ([]byte, error) {
+	var row struct {
+		KeyId      string `db:"key_id"`
+		WrappedKey string `db:"wrapped_key"`
+	}
+	err := tx.Get(&row, "SELECT key_id, wrapped_key FROM project_encryption WHERE id = 1")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	master, err := masterKeyByID(row.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	return cryptoutil.Decrypt(master.key, row.WrappedKey)
+}
+
+// RewrapProjectKey re-wraps the project's data key with the current master
+// key if it was wrapped by a retired one. Stored chunks are not touched. It
+// reports whether the key was re-wrapped.
+func RewrapProjectKey(tx *sqlx.Tx) (bool, error) {
+	encryption, err := GetProjectEncryption(tx)
+	if err != nil || !encryption.Enabled {
+		return false, err
+	}
+	master, err := currentMasterKey()
+	if err != nil || encryption.KeyId == master.id {
+		return false, err
+	}
+	dataKey, err := projectDataKey(tx)
+	if err != nil {
+		return false, err
+	}
+	wrapped, err := cryptoutil.Encrypt(master.key, dataKey)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`
+		UPDATE project_encryption SET key_id = ?, wrapped_key = ?, rotated_at = ? WHERE id = 1
+	`, master.id, wrapped, time.Now().Unix())
+	return err == nil, err
+}
+
+func isEncryptedPayload(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedPayloadMagic)
+}
+
+func sealPayload(key, data []byte, additionalData string) ([]byte, error) {
+	sealed, err := cryptoutil.Seal(key, data, []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, encryptedPayloadMagic...), sealed...), nil
+}
+
+// openPayload decrypts a sealed payload and passes plain ones through.
+func openPayload(key, data []byte, additionalData string) ([]byte, error) {
+	if !isEncryptedPayload(data) {
+		return data, nil
+	}
+	if key == nil {
+		return nil, errors.New("payload is encrypted but the project has no data key")
+	}
+	plain, err := cryptoutil.Open(key, data[len(encryptedPayloadMagic):], []byte(additionalData))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt payload: %w", err)
+	}
+	return plain, nil
+}
+
+// EncryptPreview seals preview bytes if the project is encrypted.
+func EncryptPreview(tx *sqlx.Tx, hash string, data []byte) ([]byte, error) {
+	key, err := projectDataKey(tx)
+	if err != nil || key == nil || len(data) == 0 || isEncryptedPayload(data) {
+		return data, err
+	}
+	return sealPayload(key, data, "preview:"+hash)
+}
+
+// DecryptPreview reverses EncryptPreview.
+func DecryptPreview(tx *sqlx.Tx, hash string, data []byte) ([]byte, error) {
+	if !isEncryptedPayload(data) {
+		return data, nil
+	}
+	key, err := projectDataKey(tx)
+	if err != nil {
+		return nil, err
+	}
+	return openPayload(key, data, "preview:"+hash)
+}
+
+// encryptedStore seals chunk payloads before handing them to the backend
+// store. The chunk hash is bound to each payload so sealed chunks cannot be
+// swapped for one another.
+type encryptedStore struct {
+	inner ChunkStore
+	key   []byte
+}
+
+func (s encryptedStore) Mode() string { return s.inner.Mode() }
+
+func (s encryptedStore) Put(tx *sqlx.Tx, hash string, data []byte, size int) error {
+	sealed, err := sealPayload(s.key, data, "chunk:"+hash)
+	if err != nil {
+		return err
+	}
+	return s.inner.Put(tx, hash, sealed, size)
+}
+
+func (s encryptedStore) PutBatch(tx *sqlx.Tx, chunks []Chunk) error {
+	sealed := make([]Chunk, len(chunks))
+	for i, chunk := range chunks {
+		data, err := sealPayload(s.key, chunk.Data, "chunk:"+chunk.Hash)
+		if err != nil {
+			return err
+		}
+		sealed[i] = Chunk{Hash: chunk.Hash, Data: data, Size: chunk.Size}
+	}
+	return putChunks(s.inner, tx, sealed)
+}
+
+func (s encryptedStore) Get(tx *sqlx.Tx, hash string) ([]byte, error) {
+	data, err := s.inner.Get(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return openPayload(s.key, data, "chunk:"+hash)
+}
+
+func (s encryptedStore) Has(tx *sqlx.Tx, hash string) (bool, error) {
+	return s.inner.Has(tx, hash)
+}
+
+func (s encryptedStore) Stat(tx *sqlx.Tx, hash string) (ChunkStat, error) {
+	return s.inner.Stat(tx, hash)
+}
+
+func (s encryptedStore) Delete(tx *sqlx.Tx, hash string) error {
+	return s.inner.Delete(tx, hash)
+}
+
+func (s encryptedStore) Iterate(tx *sqlx.Tx, after string, limit int, fn func(ChunkStat) error) error {
+	return s.inner.Iterate(tx, after, limit, fn)
+}
+
+func (s encryptedStore) ReclaimableSize(tx *sqlx.Tx, hash string) (int64, error) {
+	if sizer, ok := s.inner.(ChunkSizer); ok {
+		return sizer.ReclaimableSize(tx, hash)
+	}
+	return 0, nil
+}
+
+// Quarantine always goes through the backend so decrypted bytes are never
+// kept in chunk_quarantine; backends without their own quarantine just drop
+// the chunk.
+func (s encryptedStore) Quarantine(tx *sqlx.Tx, hash string) (string, error) {
+	if quarantiner, ok := s.inner.(ChunkQuarantiner); ok {
+		return quarantiner.Quarantine(tx, hash)
+	}
+	return "", s.inner.Delete(tx, hash)
+}
+
+func (s encryptedStore) SweepOrphans(tx *sqlx.Tx, indexed map[string]bool, cutoff time.Time, dryRun bool) (int, int64, error) {
+	if sweeper, ok := s.inner.(ChunkOrphanSweeper); ok {
+		return sweeper.SweepOrphans(tx, indexed, cutoff, dryRun)
+	}
+	return 0, 0, nil
+}
+
+func (s encryptedStore) RemoveAll(tx *sqlx.Tx) error {
+	return removeChunkStore(tx, s.inner)
+}
+
+// EncryptProjectData seals the chunks and previews an encrypted project
+// stored before encryption was enabled, batch by batch. It is safe to run
+// again after an interruption; sealed payloads are skipped.
+func EncryptProjectData(ctx context.Context, projectPath string, batchSize int) (EncryptionBackfill, error) {
+	activeEncryptionsMu.Lock()
+	if activeEncryptions[projectPath] {
+		activeEncryptionsMu.Unlock()
+		return EncryptionBackfill{}, ErrEncryptionBusy
+	}
+	activeEncryptions[projectPath] = true
+	activeEncryptionsMu.Unlock()
+	defer func() {
+		activeEncryptionsMu.Lock()
+		delete(activeEncryptions, projectPath)
+		activeEncryptionsMu.Unlock()
+	}()
+
+	if batchSize <= 0 {
+		batchSize = DefaultEncryptionBatchSize
+	}
+	backfill := EncryptionBackfill{}
+	chunkCursor, previewCursor := "", ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return backfill, err
+		}
+		done, err := encryptBatch(projectPath, batchSize, &chunkCursor, &previewCursor, &backfill)
+		if err != nil || done {
+			return backfill, err
+		}
+	}
+}
+
+// ProjectEncryptionActive reports whether this process is currently sealing
+// the project's existing data.
+func ProjectEncryptionActive(projectPath string) bool {
+	activeEncryptionsMu.Lock()
+	defer activeEncryptionsMu.Unlock()
+	return activeEncryptions[projectPath]
+}
+
+func encryptBatch(projectPath string, batchSize int, chunkCursor, previewCursor *string, backfill *EncryptionBackfill) (bool, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return false, err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	key, err := projectDataKey(tx)
+	if err != nil {
+		return false, err
+	}
+	if key == nil {
+		return true, nil
+	}
+	migration, err := GetStorageMigration(tx)
+	if err == nil && migration.Status == StorageMigrationRunning {
+		return false, ErrStorageMigrationInProgress
+	} else if err != nil && !errors.Is(err, ErrNoStorageMigration) {
+		return false, err
+	}
+	mode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return false, err
+	}
+	store, err := openBackendStore(tx, mode)
+	if err != nil {
+		return false, err
+	}
+	replacer, ok := store.(ChunkReplacer)
+	if !ok {
+		return false, fmt.Errorf("%s storage cannot encrypt chunks in place", mode)
+	}
+
+	visited := 0
+	err = store.Iterate(tx, *chunkCursor, batchSize, func(chunk ChunkStat) error {
+		visited++
+		*chunkCursor = chunk.Hash
+		data, err := store.Get(tx, chunk.Hash)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && isEncryptedPayload(data)) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sealed, err := sealPayload(key, data, "chunk:"+chunk.Hash)
+		if err != nil {
+			return err
+		}
+		backfill.Chunks++
+		return replacer.Replace(tx, chunk.Hash, sealed)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if visited < batchSize {
+		var previews []struct {
+			Hash    string `db:"hash"`
+			Preview []byte `db:"preview"`
+		}
+		err = tx.Select(&previews, `
+			SELECT hash, preview FROM preview WHERE hash > ? ORDER BY hash LIMIT ?
+		`, *previewCursor, batchSize)
+		if err != nil {
+			return false, err
+		}
+		for _, preview := range previews {
+			*previewCursor = preview.Hash
+			if len(preview.Preview) == 0 || isEncryptedPayload(preview.Preview) {
+				continue
+			}
+			sealed, err := sealPayload(key, preview.Preview, "preview:"+preview.Hash)
+			if err != nil {
+				return false, err
+			}
+			if _, err := tx.Exec("UPDATE preview SET preview = ? WHERE hash = ?", sealed, preview.Hash); err != nil {
+				return false, err
+			}
+			backfill.Previews++
+		}
+		visited += len(previews)
+	}
+
+	done := visited < batchSize
+	if done {
+		if _, err := tx.Exec("UPDATE project_encryption SET backfill_pending = 0 WHERE id = 1"); err != nil {
+			return false, err
+		}
+	}
+	return done, tx.Commit()
+}
@@ -0,0 +1,95 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestProjectEncryptionSealsChunks(t *testing.T) {
+	oldKey, newKey := randomKey(t), randomKey(t)
+	if err := chunk_service.ConfigureStorageEncryption(oldKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureStorageEncryption(nil) })
+
+	projectPath := newCompactProject(t)
+	plainHash, plainData := compressedChunk(t, "stored before encryption")
+	newHash, newData := compressedChunk(t, "stored after encryption")
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.StoreChunk(tx, plainHash, plainData, 24); err != nil {
+			t.Fatal(err)
+		}
+		encryption, err := chunk_service.EnableProjectEncryption(tx)
+		if err != nil || !encryption.Enabled || !encryption.BackfillPending {
+			t.Fatalf("enable: %+v, %v", encryption, err)
+		}
+		if err := chunk_service.StoreChunk(tx, newHash, newData, 23); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	rawChunk := func(tx *sqlx.Tx, hash string) []byte {
+		var raw []byte
+		if err := tx.Get(&raw, "SELECT data FROM chunk WHERE hash = ?", hash); err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if !bytes.HasPrefix(rawChunk(tx, newHash), []byte("CLE1")) {
+			t.Fatal("chunk stored after enabling encryption is not sealed")
+		}
+		if !bytes.Equal(rawChunk(tx, plainHash), plainData) {
+			t.Fatal("existing chunk changed before the backfill ran")
+		}
+		if got, err := chunk_service.ReadChunk(tx, plainHash); err != nil || !bytes.Equal(got, plainData) {
+			t.Fatalf("reading plaintext chunk: %v", err)
+		}
+	})
+
+	backfill, err := chunk_service.EncryptProjectData(context.Background(), projectPath, 1)
+	if err != nil || backfill.Chunks != 1 {
+		t.Fatalf("backfill: %+v, %v", backfill, err)
+	}
+
+	if err := chunk_service.ConfigureStorageEncryption(newKey, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if !bytes.HasPrefix(rawChunk(tx, plainHash), []byte("CLE1")) {
+			t.Fatal("backfill left an existing chunk in plaintext")
+		}
+		if rewrapped, err := chunk_service.RewrapProjectKey(tx); err != nil || !rewrapped {
+			t.Fatalf("rewrap: %v", err)
+		}
+	})
+
+	if err := chunk_service.ConfigureStorageEncryption(newKey); err != nil {
+		t.Fatal(err)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		encryption, err := chunk_service.GetProjectEncryption(tx)
+		if err != nil || encryption.BackfillPending || encryption.RotatedAt == 0 {
+			t.Fatalf("unexpected encryption state %+v, %v", encryption, err)
+		}
+		for hash, want := range map[string][]byte{plainHash: plainData, newHash: newData} {
+			if got, err := chunk_service.ReadChunk(tx, hash); err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("reading %s after key rotation: %v", hash, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,14 @@
+package chunk_service
+
+import (
+	"testing"
+	"time"
+)
+
+// SetPackLimits overrides the pack size limit and minimum repack age for the
+// duration of a test.
+func SetPackLimits(t testing.TB, size int64, minAge time.Duration) {
+	oldSize, oldAge := maxPackSize, packMinAge
+	maxPackSize, packMinAge = size, minAge
+	t.Cleanup(func() { maxPackSize, packMinAge = oldSize, oldAge })
+}
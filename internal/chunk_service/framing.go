@@ -0,0 +1,535 @@
+package chunk_service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	kzstd "github.com/klauspost/compress/zstd"
+)
+
+// Chunks travel between clients and servers as a sequence of frames.
+//
+// Framing v1 is a bare run of records: a 32-byte hash, a 4-byte big-endian
+// length and the compressed chunk data. It has no header, so a stream cut
+// between two records cannot be told from a complete one.
+//
+// Framing v2 starts with the magic "CLCF" and a version byte. Each frame is a
+// flags byte, the 32-byte hash, a 4-byte big-endian length, the 4-byte
+// dictionary ID when FrameDictionary is set, and the data. The stream ends
+// with a trailer: the FrameTrailer flags byte, the 4-byte frame count and the
+// SHA-256 of every frame byte between the header and the trailer.
+//
+// Servers send v2 to clients whose Clustta-Agent is at least
+// chunkFramingV2Agent, unless the client names the highest framing it reads
+// in the Clustta-Accept-Framing request header, and say so in the
+// Clustta-Framing response header. Request bodies are told apart by their
+// magic. Upload sessions record the framing negotiated when they were opened.
+
+const (
+	ChunkFramingV1 = 1
+	ChunkFramingV2 = 2
+
+	// ChunkFramingHeader names the framing of a chunk request or response
+	// body.
+	ChunkFramingHeader = "Clustta-Framing"
+	// ChunkFramingAcceptHeader is the highest framing a client reads. It
+	// overrides the framing chosen from the client's Clustta-Agent.
+	ChunkFramingAcceptHeader = "Clustta-Accept-Framing"
+)
+
+// Frame flags.
+const (
+	// FrameCompressed marks zstd compressed data; without it the data is the
+	// raw chunk.
+	FrameCompressed byte = 1 << 0
+	// FrameDictionary marks data compressed with the dictionary whose ID
+	// follows the length.
+	FrameDictionary byte = 1 << 1
+	// FrameTrailer marks the end-of-stream trailer.
+	FrameTrailer byte = 1 << 7
+)
+
+const (
+	// maxChunkFrameSize bounds the length field of a frame.
+	maxChunkFrameSize = 16777215
+	// chunkFramingV2Agent is the first client version that reads v2.
+	chunkFramingV2Agent = "0.3"
+	// v1RecordHeaderSize is a v1 record's hash and length.
+	v1RecordHeaderSize = 32 + 4
+	// v2FrameHeaderSize is a v2 frame's flags, hash and length.
+	v2FrameHeaderSize = 1 + 32 + 4
+	// v2TrailerSize is the flags, frame count and digest.
+	v2TrailerSize = 1 + 4 + sha256.Size
+)
+
+var chunkStreamMagic = []byte("CLCF")
+
+// ErrChunkFraming is returned for a chunk stream that is malformed or whose
+// trailer does not match its frames. Such a stream is not worth resuming.
+var ErrChunkFraming = errors.New("invalid chunk framing")
+
+// ChunkFrame is one chunk as sent over the wire.
+type ChunkFrame struct {
+	Hash         string
+	Data         []byte
+	Flags        byte
+	DictionaryID uint32
+}
+
+// newChunkFrame flags a stored chunk's data by reading its zstd frame header.
+func newChunkFrame(chunk Chunk) ChunkFrame {
+	frame := ChunkFrame{Hash: chunk.Hash, Data: chunk.Data}
+	var header kzstd.Header
+	if err := header.Decode(chunk.Data); err == nil {
+		frame.Flags |= FrameCompressed
+		if header.DictionaryID != 0 {
+			frame.Flags |= FrameDictionary
+			frame.DictionaryID = header.DictionaryID
+		}
+	}
+	return frame
+}
+
+var (
+	frameEncoderOnce sync.Once
+	frameEncoder     *kzstd.Encoder
+	frameEncoderErr  error
+)
+
+// verify checks the frame's data against its hash. It returns the compressed
+// bytes to store, compressing raw frames, and the chunk's uncompressed size.
+func (f ChunkFrame) verify(decoder *kzstd.Decoder) ([]byte, int, error) {
+	raw := f.Data
+	if f.Flags&FrameCompressed != 0 {
+		var err error
+		raw, err = decoder.DecodeAll(f.Data, nil)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error decoding chunk: %w", err)
+		}
+	}
+	sum := sha256.Sum256(raw)
+	if hex.EncodeToString(sum[:]) != f.Hash {
+		return nil, 0, errors.New("invalid chunk data")
+	}
+	if f.Flags&FrameCompressed != 0 {
+		return f.Data, len(raw), nil
+	}
+	frameEncoderOnce.Do(func() {
+		frameEncoder, frameEncoderErr = kzstd.NewWriter(nil)
+	})
+	if frameEncoderErr != nil {
+		return nil, 0, frameEncoderErr
+	}
+	return frameEncoder.EncodeAll(raw, nil), len(raw), nil
+}
+
+// ChunkFramingForAgent returns the framing to send the client whose request
+// carried header, from the version in its Clustta-Agent or, when set, its
+// Clustta-Accept-Framing.
+func ChunkFramingForAgent(header http.Header) int {
+	if value := header.Get(ChunkFramingAcceptHeader); value != "" {
+		accepted, err := strconv.Atoi(value)
+		if err != nil || accepted < ChunkFramingV1 {
+			return ChunkFramingV1
+		}
+		return min(accepted, ChunkFramingV2)
+	}
+	version, ok := strings.CutPrefix(header.Get("Clustta-Agent"), "Clustta/")
+	if ok && compareAgentVersions(version, chunkFramingV2Agent) >= 0 {
+		return ChunkFramingV2
+	}
+	return ChunkFramingV1
+}
+
+// compareAgentVersions compares dotted version numbers. A malformed version
+// sorts before any valid one.
+func compareAgentVersions(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(aParts), len(bParts)); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			n, err := strconv.Atoi(aParts[i])
+			if err != nil {
+				return -1
+			}
+			aNum = n
+		}
+		if i < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[i])
+		}
+		if aNum != bNum {
+			return aNum - bNum
+		}
+	}
+	return 0
+}
+
+// AcceptChunkFraming asks the server to answer req in the highest framing
+// this client reads.
+func AcceptChunkFraming(req *http.Request) {
+	req.Header.Set(ChunkFramingAcceptHeader, strconv.Itoa(ChunkFramingV2))
+}
+
+// HeaderChunkFraming returns the framing of a streamed chunk response from
+// its Clustta-Framing header. Servers that predate framing v2 do not set it.
+func HeaderChunkFraming(header http.Header) int {
+	if header.Get(ChunkFramingHeader) == strconv.Itoa(ChunkFramingV2) {
+		return ChunkFramingV2
+	}
+	return ChunkFramingV1
+}
+
+// BodyChunkFraming tells the framing of a complete chunk body by its magic.
+// A Clustta-Framing header naming another framing is rejected rather than
+// trusted; peers that predate framing v2 do not set it.
+func BodyChunkFraming(header http.Header, body []byte) (int, error) {
+	framing := ChunkFramingOf(body)
+	if value := header.Get(ChunkFramingHeader); value != "" && value != strconv.Itoa(framing) {
+		return 0, fmt.Errorf("%w: body is v%d but %s says %s", ErrChunkFraming, framing, ChunkFramingHeader, value)
+	}
+	return framing, nil
+}
+
+// ChunkFramingOf tells the framing of a chunk body by its magic. Frame
+// readers use it to check a stream opens the way its framing says.
+func ChunkFramingOf(data []byte) int {
+	if len(data) >= len(chunkStreamMagic)+1 && bytes.HasPrefix(data, chunkStreamMagic) &&
+		data[len(chunkStreamMagic)] == ChunkFramingV2 {
+		return ChunkFramingV2
+	}
+	return ChunkFramingV1
+}
+
+func validChunkFraming(framing int) error {
+	if framing != ChunkFramingV1 && framing != ChunkFramingV2 {
+		return fmt.Errorf("%w: unsupported version %d", ErrChunkFraming, framing)
+	}
+	return nil
+}
+
+// chunkStreamHeader returns the bytes that open a stream, none for v1.
+func chunkStreamHeader(framing int) []byte {
+	if framing == ChunkFramingV1 {
+		return nil
+	}
+	return append(bytes.Clone(chunkStreamMagic), byte(framing))
+}
+
+// encodeChunkFrame encodes one chunk without the stream header or trailer.
+func encodeChunkFrame(framing int, chunk Chunk) ([]byte, error) {
+	hashBytes, err := hex.DecodeString(chunk.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash string: %v", err)
+	}
+	if len(hashBytes) != 32 {
+		return nil, fmt.Errorf("invalid hash length: expected 32 bytes, got %d", len(hashBytes))
+	}
+	// The length is a full 4-byte uint32, capped at 16MB.
+	if len(chunk.Data) > maxChunkFrameSize {
+		return nil, fmt.Errorf("chunk size exceeds 16MB limit")
+	}
+	frame := newChunkFrame(chunk)
+
+	var buffer bytes.Buffer
+	if framing == ChunkFramingV2 {
+		buffer.WriteByte(frame.Flags)
+	}
+	buffer.Write(hashBytes)
+	buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(len(chunk.Data))))
+	if frame.Flags&FrameDictionary != 0 && framing == ChunkFramingV2 {
+		buffer.Write(binary.BigEndian.AppendUint32(nil, frame.DictionaryID))
+	}
+	buffer.Write(chunk.Data)
+	return buffer.Bytes(), nil
+}
+
+// chunkStreamTrailer closes a v2 stream of count frames hashed into digest.
+func chunkStreamTrailer(count uint32, digest hash.Hash) []byte {
+	trailer := []byte{FrameTrailer}
+	trailer = binary.BigEndian.AppendUint32(trailer, count)
+	return digest.Sum(trailer)
+}
+
+// ChunkFrameWriter writes chunks as a stream of the given framing. Close
+// must be called to finish a v2 stream.
+type ChunkFrameWriter struct {
+	w       io.Writer
+	framing int
+	started bool
+	count   uint32
+	digest  hash.Hash
+}
+
+func NewChunkFrameWriter(w io.Writer, framing int) (*ChunkFrameWriter, error) {
+	if err := validChunkFraming(framing); err != nil {
+		return nil, err
+	}
+	return &ChunkFrameWriter{w: w, framing: framing, digest: sha256.New()}, nil
+}
+
+func (fw *ChunkFrameWriter) start() error {
+	if fw.started {
+		return nil
+	}
+	fw.started = true
+	if header := chunkStreamHeader(fw.framing); header != nil {
+		_, err := fw.w.Write(header)
+		return err
+	}
+	return nil
+}
+
+func (fw *ChunkFrameWriter) WriteChunk(chunk Chunk) error {
+	frame, err := encodeChunkFrame(fw.framing, chunk)
+	if err != nil {
+		return err
+	}
+	if err := fw.start(); err != nil {
+		return err
+	}
+	if _, err := fw.w.Write(frame); err != nil {
+		return err
+	}
+	fw.count++
+	fw.digest.Write(frame)
+	return nil
+}
+
+// Close writes the v2 trailer. It does not close the underlying writer.
+func (fw *ChunkFrameWriter) Close() error {
+	if err := fw.start(); err != nil {
+		return err
+	}
+	if fw.framing == ChunkFramingV1 {
+		return nil
+	}
+	_, err := fw.w.Write(chunkStreamTrailer(fw.count, fw.digest))
+	return err
+}
+
+// EncodeChunkStream encodes chunks as a complete stream of the given framing.
+func EncodeChunkStream(framing int, chunks []Chunk) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := NewChunkFrameWriter(&buffer, framing)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if err := writer.WriteChunk(chunk); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// chunkFrameDecoder parses a stream a frame at a time. Its state can be saved
+// with MarshalBinary, so an upload session picks up the stream where the
+// previous request left it.
+type chunkFrameDecoder struct {
+	framing  int
+	started  bool
+	finished bool
+	count    uint32
+	digest   hash.Hash
+}
+
+func newChunkFrameDecoder(framing int) (*chunkFrameDecoder, error) {
+	if err := validChunkFraming(framing); err != nil {
+		return nil, err
+	}
+	return &chunkFrameDecoder{framing: framing, started: framing == ChunkFramingV1, digest: sha256.New()}, nil
+}
+
+// frameSize returns how many bytes the next header, frame or trailer takes,
+// as far as the start of it in data tells. Once len(data) reaches the size
+// returned, the size is final.
+func (d *chunkFrameDecoder) frameSize(data []byte) (int, error) {
+	if !d.started {
+		return len(chunkStreamMagic) + 1, nil
+	}
+	if d.framing == ChunkFramingV1 {
+		if len(data) < v1RecordHeaderSize {
+			return v1RecordHeaderSize, nil
+		}
+		return frameWithData(v1RecordHeaderSize, binary.BigEndian.Uint32(data[32:36]))
+	}
+	if len(data) < 1 {
+		return 1, nil
+	}
+	flags := data[0]
+	if flags == FrameTrailer {
+		return v2TrailerSize, nil
+	}
+	if flags&^(FrameCompressed|FrameDictionary) != 0 {
+		return 0, fmt.Errorf("%w: unknown frame flags %#x", ErrChunkFraming, flags)
+	}
+	headerSize := v2FrameHeaderSize
+	if flags&FrameDictionary != 0 {
+		headerSize += 4
+	}
+	if len(data) < headerSize {
+		return headerSize, nil
+	}
+	return frameWithData(headerSize, binary.BigEndian.Uint32(data[33:37]))
+}
+
+func frameWithData(headerSize int, length uint32) (int, error) {
+	if length == 0 || length > maxChunkFrameSize {
+		return 0, fmt.Errorf("%w: invalid length: %d", ErrChunkFraming, length)
+	}
+	return headerSize + int(length), nil
+}
+
+// decode consumes the complete header, frame or trailer in data, whose
+// length frameSize gave. It returns the frame, or nil for a header or
+// trailer.
+func (d *chunkFrameDecoder) decode(data []byte) (*ChunkFrame, error) {
+	if d.finished {
+		return nil, fmt.Errorf("%w: data after the trailer", ErrChunkFraming)
+	}
+	if !d.started {
+		if ChunkFramingOf(data) != d.framing {
+			return nil, fmt.Errorf("%w: missing v%d stream header", ErrChunkFraming, d.framing)
+		}
+		d.started = true
+		return nil, nil
+	}
+	if d.framing == ChunkFramingV1 {
+		return &ChunkFrame{
+			Hash:  hex.EncodeToString(data[:32]),
+			Data:  data[v1RecordHeaderSize:],
+			Flags: FrameCompressed,
+		}, nil
+	}
+
+	if data[0] == FrameTrailer {
+		count := binary.BigEndian.Uint32(data[1:5])
+		if count != d.count {
+			return nil, fmt.Errorf("%w: trailer counts %d frames, stream had %d", ErrChunkFraming, count, d.count)
+		}
+		if !bytes.Equal(data[5:], d.digest.Sum(nil)) {
+			return nil, fmt.Errorf("%w: stream digest mismatch", ErrChunkFraming)
+		}
+		d.finished = true
+		return nil, nil
+	}
+	frame := &ChunkFrame{Hash: hex.EncodeToString(data[1:33]), Flags: data[0]}
+	offset := v2FrameHeaderSize
+	if frame.Flags&FrameDictionary != 0 {
+		frame.DictionaryID = binary.BigEndian.Uint32(data[offset:])
+		offset += 4
+	}
+	frame.Data = data[offset:]
+	if frame.Flags&FrameCompressed != 0 {
+		var header kzstd.Header
+		if err := header.Decode(frame.Data); err != nil {
+			return nil, fmt.Errorf("%w: chunk %s is not zstd compressed", ErrChunkFraming, frame.Hash)
+		}
+		if header.DictionaryID != frame.DictionaryID {
+			return nil, fmt.Errorf("%w: chunk %s names dictionary %d, frame says %d", ErrChunkFraming, frame.Hash, header.DictionaryID, frame.DictionaryID)
+		}
+	} else if frame.Flags&FrameDictionary != 0 {
+		return nil, fmt.Errorf("%w: uncompressed chunk %s names a dictionary", ErrChunkFraming, frame.Hash)
+	}
+	d.count++
+	d.digest.Write(data)
+	return frame, nil
+}
+
+// complete reports whether the stream ended properly. A v1 stream has no
+// trailer, so any record boundary counts.
+func (d *chunkFrameDecoder) complete() bool {
+	return d.framing == ChunkFramingV1 || d.finished
+}
+
+// MarshalBinary saves the decoder's progress through the stream.
+func (d *chunkFrameDecoder) MarshalBinary() ([]byte, error) {
+	digest, err := d.digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	state := []byte{byte(d.framing), 0}
+	if d.started {
+		state[1] |= 1
+	}
+	if d.finished {
+		state[1] |= 2
+	}
+	state = binary.BigEndian.AppendUint32(state, d.count)
+	return append(state, digest...), nil
+}
+
+func (d *chunkFrameDecoder) UnmarshalBinary(state []byte) error {
+	if len(state) < 6 {
+		return errors.New("invalid chunk stream state")
+	}
+	d.framing = int(state[0])
+	d.started = state[1]&1 != 0
+	d.finished = state[1]&2 != 0
+	d.count = binary.BigEndian.Uint32(state[2:6])
+	d.digest = sha256.New()
+	return d.digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state[6:])
+}
+
+// ChunkFrameReader reads the frames of a chunk stream.
+type ChunkFrameReader struct {
+	r       io.Reader
+	decoder *chunkFrameDecoder
+}
+
+func NewChunkFrameReader(r io.Reader, framing int) (*ChunkFrameReader, error) {
+	decoder, err := newChunkFrameDecoder(framing)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkFrameReader{r: r, decoder: decoder}, nil
+}
+
+// Next returns the next frame, or io.EOF once the stream has ended properly.
+// A v2 stream that stops before its trailer returns io.ErrUnexpectedEOF.
+// Malformed streams return an error wrapping ErrChunkFraming.
+func (fr *ChunkFrameReader) Next() (ChunkFrame, error) {
+	for !fr.decoder.finished {
+		data := []byte{}
+		size, err := fr.decoder.frameSize(data)
+		for err == nil && len(data) < size {
+			start := len(data)
+			data = append(data, make([]byte, size-start)...)
+			_, err = io.ReadFull(fr.r, data[start:])
+			if err == io.EOF {
+				if start == 0 && fr.decoder.started && fr.decoder.framing == ChunkFramingV1 {
+					return ChunkFrame{}, io.EOF
+				}
+				return ChunkFrame{}, fmt.Errorf("chunk stream ended before its trailer: %w", io.ErrUnexpectedEOF)
+			}
+			if err == nil {
+				size, err = fr.decoder.frameSize(data)
+			}
+		}
+		if err != nil {
+			return ChunkFrame{}, err
+		}
+		frame, err := fr.decoder.decode(data)
+		if err != nil {
+			return ChunkFrame{}, err
+		}
+		if frame != nil {
+			return *frame, nil
+		}
+	}
+	return ChunkFrame{}, io.EOF
+}
@@ -0,0 +1,219 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestChunkFramingV2RoundTripAndTrailer(t *testing.T) {
+	for _, c := range []struct {
+		agent, accepted string
+		want            int
+	}{
+		{"", "", chunk_service.ChunkFramingV1},
+		{"Clustta/0.2", "", chunk_service.ChunkFramingV1},
+		{"Clustta/0.3", "", chunk_service.ChunkFramingV2},
+		{"Clustta/1.0", "", chunk_service.ChunkFramingV2},
+		{"Clustta/unknown", "", chunk_service.ChunkFramingV1},
+		{"Mozilla/5.0 (X11)", "", chunk_service.ChunkFramingV1},
+		{"Clustta/0.2", "2", chunk_service.ChunkFramingV2},
+		{"Clustta/0.2", "3", chunk_service.ChunkFramingV2},
+		{"Clustta/1.0", "1", chunk_service.ChunkFramingV1},
+		{"Clustta/1.0", "unknown", chunk_service.ChunkFramingV1},
+	} {
+		header := http.Header{}
+		header.Set("Clustta-Agent", c.agent)
+		if c.accepted != "" {
+			header.Set(chunk_service.ChunkFramingAcceptHeader, c.accepted)
+		}
+		if got := chunk_service.ChunkFramingForAgent(header); got != c.want {
+			t.Errorf("agent %q accepting %q gets framing v%d, want v%d", c.agent, c.accepted, got, c.want)
+		}
+	}
+
+	compressedHash, compressedData := compressedChunk(t, "sent compressed")
+	raw := []byte("sent as is")
+	sum := sha256.Sum256(raw)
+	rawHash := hex.EncodeToString(sum[:])
+	chunks := []chunk_service.Chunk{
+		{Hash: compressedHash, Data: compressedData},
+		{Hash: rawHash, Data: raw},
+	}
+	stream, err := chunk_service.EncodeChunkStream(chunk_service.ChunkFramingV2, chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk_service.ChunkFramingOf(stream) != chunk_service.ChunkFramingV2 {
+		t.Fatal("v2 stream was not recognised by its header")
+	}
+	v1, _ := chunk_service.EncodeChunks(chunks[:1])
+	if chunk_service.ChunkFramingOf(v1) != chunk_service.ChunkFramingV1 {
+		t.Fatal("v1 stream was taken for v2")
+	}
+	claimsV2 := http.Header{}
+	claimsV2.Set(chunk_service.ChunkFramingHeader, strconv.Itoa(chunk_service.ChunkFramingV2))
+	if _, err := chunk_service.BodyChunkFraming(claimsV2, v1); !errors.Is(err, chunk_service.ErrChunkFraming) {
+		t.Fatalf("expected a v1 body sent as v2 to be rejected, got %v", err)
+	}
+	if framing, err := chunk_service.BodyChunkFraming(http.Header{}, stream); err != nil || framing != chunk_service.ChunkFramingV2 {
+		t.Fatalf("expected an unlabelled v2 body to be detected, got v%d %v", framing, err)
+	}
+
+	readAll := func(data []byte) ([]chunk_service.ChunkFrame, error) {
+		reader, err := chunk_service.NewChunkFrameReader(bytes.NewReader(data), chunk_service.ChunkFramingV2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := []chunk_service.ChunkFrame{}
+		for {
+			frame, err := reader.Next()
+			if err == io.EOF {
+				return frames, nil
+			}
+			if err != nil {
+				return frames, err
+			}
+			frames = append(frames, frame)
+		}
+	}
+	frames, err := readAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0].Flags != chunk_service.FrameCompressed || frames[1].Flags != 0 ||
+		!bytes.Equal(frames[1].Data, raw) {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+
+	// A stream cut before its trailer can be resumed; one whose trailer does
+	// not match cannot.
+	if _, err := readAll(stream[:len(stream)-10]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated stream: %v", err)
+	}
+	tampered := bytes.Clone(stream)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := readAll(tampered); !errors.Is(err, chunk_service.ErrChunkFraming) {
+		t.Fatalf("tampered trailer: %v", err)
+	}
+	dropped, _ := chunk_service.EncodeChunkStream(chunk_service.ChunkFramingV2, chunks[:1])
+	trailer := stream[len(stream)-(1+4+sha256.Size):]
+	if _, err := readAll(append(bytes.Clone(dropped[:len(dropped)-len(trailer)]), trailer...)); !errors.Is(err, chunk_service.ErrChunkFraming) {
+		t.Fatalf("stream missing a frame: %v", err)
+	}
+
+	projectPath := newCompactProject(t)
+	if failed, err := chunk_service.WriteChunks(projectPath, stream, chunk_service.ChunkFramingV2); err != nil || len(failed) != 0 {
+		t.Fatalf("writing v2 stream: %v %v", failed, err)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for _, hash := range []string{compressedHash, rawHash} {
+			if _, err := chunk_service.ReadChunk(tx, hash); err != nil {
+				t.Fatalf("reading chunk %s: %v", hash, err)
+			}
+		}
+	})
+}
+
+func TestUploadSessionV2CompletesAtTrailer(t *testing.T) {
+	projectPath := newCompactProject(t)
+	firstHash, firstData := compressedChunk(t, "first framed upload")
+	secondHash, secondData := compressedChunk(t, "second framed upload")
+
+	var session chunk_service.UploadSession
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		var err error
+		session, err = chunk_service.CreateUploadSession(tx, "user-1", []string{firstHash, secondHash}, chunk_service.ChunkFramingV2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if session.Framing != chunk_service.ChunkFramingV2 {
+		t.Fatalf("session framing %d", session.Framing)
+	}
+	stream, err := chunk_service.EncodeChunkStream(chunk_service.ChunkFramingV2, []chunk_service.Chunk{
+		{Hash: firstHash, Data: firstData},
+		{Hash: secondHash, Data: secondData},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both chunks arrive in one range and the trailer in the next.
+	cut := len(stream) - 20
+	session, err = chunk_service.WriteUploadRange(projectPath, session.Id, 0, bytes.NewReader(stream[:cut]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ReceivedChunks != 2 || session.Complete {
+		t.Fatalf("session completed without its trailer: %#v", session)
+	}
+	session, err = chunk_service.WriteUploadRange(projectPath, session.Id, int64(cut), bytes.NewReader(stream[cut:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.Complete || len(session.FailedChunks) != 0 {
+		t.Fatalf("expected a complete upload, got %#v", session)
+	}
+
+	// The client frames its ranges the way the session says.
+	serverPath := newCompactProject(t)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var session chunk_service.UploadSession
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			var request struct {
+				Chunks []string `json:"chunks"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+				session, err = chunk_service.CreateUploadSession(tx, "user-1", request.Chunks, chunk_service.ChunkFramingForAgent(r.Header))
+			})
+		case http.MethodPut:
+			offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+			session, err = chunk_service.WriteUploadRange(serverPath, r.PathValue("session"), offset, r.Body)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(session)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload-sessions", handler)
+	mux.HandleFunc("/upload-sessions/{session}", handler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		err := chunk_service.PushChunksResumable(context.Background(), tx, server.URL, "user-1", []chunk_service.ChunkInfo{
+			{Hash: firstHash, Size: len(firstData)},
+			{Hash: secondHash, Size: len(secondData)},
+		}, func(int, int, string, string) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		for hash, want := range map[string][]byte{firstHash: firstData, secondHash: secondData} {
+			if got, err := chunk_service.ReadChunk(tx, hash); err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("reading pushed chunk %s: %v", hash, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package chunk_service
+
+import (
+	"clustta/internal/utils"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultChunkGCGracePeriod protects chunks that clients upload before
+// pushing the checkpoints that reference them.
+const DefaultChunkGCGracePeriod = 24 * time.Hour
+
+var ErrStorageMigrationInProgress = errors.New("storage migration is in progress")
+
+// referencedChunksQuery lists every chunk hash named by a template or a
+// checkpoint. Trashed checkpoints still count until the trash is cleared.
+const referencedChunksQuery = `
+	SELECT DISTINCT TRIM(value) AS hash
+	FROM template, json_each('["' || REPLACE(chunks, ',', '","') || '"]')
+	WHERE chunks != ''
+	UNION
+	SELECT DISTINCT TRIM(value) AS hash
+	FROM asset_checkpoint, json_each('["' || REPLACE(chunks, ',', '","') || '"]')
+	WHERE chunks != ''
+`
+
+// ChunkGCReport summarises one garbage collection pass. In a dry run nothing
+// is deleted and the reclaimable figures describe what a real pass would free.
+type ChunkGCReport struct {
+	StorageMode       string `json:"storage_mode"`
+	DryRun            bool   `json:"dry_run"`
+	GracePeriod       string `json:"grace_period"`
+	TotalChunks       int    `json:"total_chunks"`
+	ReferencedChunks  int    `json:"referenced_chunks"`
+	RecentChunks      int    `json:"recent_chunks"`
+	ReclaimableChunks int    `json:"reclaimable_chunks"`
+	OrphanedObjects   int    `json:"orphaned_objects"`
+	ReclaimableBytes  int64  `json:"reclaimable_bytes"`
+}
+
+// CollectGarbage runs a mark-and-sweep pass over the project's chunk storage.
+// Chunks no template or checkpoint references are deleted once they are older
+// than gracePeriod, along with stored files or objects that have no index row.
+func CollectGarbage(projectPath string, gracePeriod time.Duration, dryRun bool) (ChunkGCReport, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return ChunkGCReport{}, err
+	}
+	defer dbConn.Close()
+
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return ChunkGCReport{}, err
+	}
+	defer tx.Rollback()
+
+	report, err := SweepChunks(tx, gracePeriod, dryRun)
+	if err != nil || dryRun {
+		return report, err
+	}
+	return report, tx.Commit()
+}
+
+// SweepChunks is CollectGarbage within an existing transaction. Files and
+// objects are removed immediately; only the index rows follow tx.
+func SweepChunks(tx *sqlx.Tx, gracePeriod time.Duration, dryRun bool) (ChunkGCReport, error) {
+	report := ChunkGCReport{DryRun: dryRun, GracePeriod: gracePeriod.String()}
+	migration, err := GetStorageMigration(tx)
+	if err == nil && migration.Status == StorageMigrationRunning {
+		return report, ErrStorageMigrationInProgress
+	} else if err != nil && !errors.Is(err, ErrNoStorageMigration) {
+		return report, err
+	}
+
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return report, err
+	}
+	report.StorageMode = store.Mode()
+	cutoff := time.Now().Add(-gracePeriod).Unix()
+
+	var usedHashes []string
+	if err := tx.Select(&usedHashes, referencedChunksQuery); err != nil {
+		return report, err
+	}
+	used := make(map[string]bool, len(usedHashes))
+	for _, hash := range usedHashes {
+		used[hash] = true
+	}
+
+	indexed := map[string]bool{}
+	var garbage []string
+	err = store.Iterate(tx, "", 0, func(chunk ChunkStat) error {
+		indexed[chunk.Hash] = true
+		report.TotalChunks++
+		if used[chunk.Hash] {
+			report.ReferencedChunks++
+		} else if chunk.CreatedAt > cutoff {
+			report.RecentChunks++
+		} else {
+			garbage = append(garbage, chunk.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.ReclaimableChunks = len(garbage)
+
+	sizer, _ := store.(ChunkSizer)
+	for _, hash := range garbage {
+		if sizer != nil {
+			size, err := sizer.ReclaimableSize(tx, hash)
+			if err != nil {
+				return report, err
+			}
+			report.ReclaimableBytes += size
+		}
+		if dryRun {
+			continue
+		}
+		if err := store.Delete(tx, hash); err != nil {
+			return report, err
+		}
+		if err := forgetChunkDictionary(tx, hash); err != nil {
+			return report, err
+		}
+	}
+
+	if err := sweepArchivedChunks(tx, used, indexed, cutoff, dryRun, &report); err != nil {
+		return report, err
+	}
+
+	if sweeper, ok := store.(ChunkOrphanSweeper); ok {
+		count, size, err := sweeper.SweepOrphans(tx, indexed, time.Unix(cutoff, 0), dryRun)
+		report.OrphanedObjects += count
+		report.ReclaimableBytes += size
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,80 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestCollectGarbageDeflated(t *testing.T) {
+	storageDir := t.TempDir()
+	if err := chunk_service.ConfigureProjectStorage(storageDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	projectPath := newCompactProject(t)
+	usedHash, usedData := compressedChunk(t, "referenced")
+	oldHash, oldData := compressedChunk(t, "unreferenced and old")
+	newHash, newData := compressedChunk(t, "unreferenced but recent")
+	orphanPath := filepath.Join(storageDir, "project-1", "chunks", "ff", "ff", "ffff")
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.SetProjectStorageMode(tx, chunk_service.StorageModeDeflated); err != nil {
+			t.Fatal(err)
+		}
+		for hash, data := range map[string][]byte{usedHash: usedData, oldHash: oldData, newHash: newData} {
+			if err := chunk_service.StoreChunk(tx, hash, data, len(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tx.Exec("UPDATE chunk_ref SET created_at = 0 WHERE hash IN (?, ?)", usedHash, oldHash); err != nil {
+			t.Fatal(err)
+		}
+		_, err := tx.Exec(`INSERT INTO template (id, mtime, name, extension, xxhash_checksum, file_size, chunks)
+			VALUES ('t1', 1, 'scene', '.blend', 'x', 10, ?)`, usedHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := os.MkdirAll(filepath.Dir(orphanPath), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphanPath, []byte("orphan"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(orphanPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := chunk_service.CollectGarbage(projectPath, time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := int64(len(oldData) + len("orphan"))
+	if report.TotalChunks != 3 || report.ReferencedChunks != 1 || report.RecentChunks != 1 ||
+		report.ReclaimableChunks != 1 || report.OrphanedObjects != 1 || report.ReclaimableBytes != want {
+		t.Fatalf("unexpected dry run report %#v (want %d bytes)", report, want)
+	}
+	if _, err := os.Stat(orphanPath); err != nil {
+		t.Fatal("dry run must not delete files")
+	}
+
+	if _, err = chunk_service.CollectGarbage(projectPath, time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(orphanPath); !os.IsNotExist(err) {
+		t.Fatal("expected orphaned file to be removed")
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for hash, wantExists := range map[string]bool{usedHash: true, oldHash: false, newHash: true} {
+			if got := chunk_service.ChunkExists(hash, tx, map[string]bool{}); got != wantExists {
+				t.Fatalf("chunk %s exists=%v, want %v", hash, got, wantExists)
+			}
+		}
+	})
+}
@@ -0,0 +1,402 @@
+package chunk_service
+
+import (
+	"clustta/internal/utils"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	kzstd "github.com/klauspost/compress/zstd"
+)
+
+const (
+	StorageMigrationRunning   = "running"
+	StorageMigrationFailed    = "failed"
+	StorageMigrationCompleted = "completed"
+
+	DefaultStorageMigrationBatchSize = 64
+)
+
+var (
+	ErrNoStorageMigration   = errors.New("project has no storage migration")
+	ErrStorageMigrationBusy = errors.New("storage migration is already running for this project")
+)
+
+// StorageMigration is the persisted state of a project's move between storage
+// modes. The project keeps serving from SourceMode until the migration
+// completes, so progress survives restarts and can be resumed at Cursor.
+type StorageMigration struct {
+	SourceMode     string   `db:"source_mode" json:"source_mode"`
+	TargetMode     string   `db:"target_mode" json:"target_mode"`
+	Status         string   `db:"status" json:"status"`
+	Cursor         string   `db:"cursor" json:"-"`
+	TotalChunks    int      `db:"total_chunks" json:"total_chunks"`
+	MigratedChunks int      `db:"migrated_chunks" json:"migrated_chunks"`
+	MigratedBytes  int64    `db:"migrated_bytes" json:"migrated_bytes"`
+	Error          string   `db:"error" json:"error"`
+	StartedAt      int64    `db:"started_at" json:"started_at"`
+	UpdatedAt      int64    `db:"updated_at" json:"updated_at"`
+	FailedChunks   []string `db:"-" json:"failed_chunks"`
+}
+
+var (
+	activeMigrationsMu sync.Mutex
+	activeMigrations   = map[string]bool{}
+)
+
+func GetStorageMigration(tx *sqlx.Tx) (StorageMigration, error) {
+	var migration StorageMigration
+	err := tx.Get(&migration, `
+		SELECT source_mode, target_mode, status, cursor, total_chunks, migrated_chunks,
+			migrated_bytes, error, started_at, updated_at
+		FROM storage_migration WHERE id = 1
+	`)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no such table") {
+			return migration, ErrNoStorageMigration
+		}
+		return migration, err
+	}
+	migration.FailedChunks = []string{}
+	err = tx.Select(&migration.FailedChunks, "SELECT hash FROM storage_migration_failure ORDER BY hash")
+	return migration, err
+}
+
+// StartStorageMigration records a migration of the project to targetMode. A
+// running migration to the same mode is returned unchanged so callers can
+// resume it; a failed one is restarted from the beginning.
+func StartStorageMigration(tx *sqlx.Tx, targetMode string) (StorageMigration, error) {
+	if err := ValidateStorageMode(targetMode); err != nil {
+		return StorageMigration{}, err
+	}
+	existing, err := GetStorageMigration(tx)
+	if err != nil && !errors.Is(err, ErrNoStorageMigration) {
+		return existing, err
+	}
+	if err == nil && existing.Status == StorageMigrationRunning {
+		if existing.TargetMode != targetMode {
+			return existing, fmt.Errorf("a migration to %q storage is already in progress", existing.TargetMode)
+		}
+		return existing, nil
+	}
+
+	sourceMode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	if sourceMode == targetMode {
+		return StorageMigration{}, fmt.Errorf("project already uses %q storage", targetMode)
+	}
+	if chunkIndexTable(sourceMode) == chunkIndexTable(targetMode) {
+		return StorageMigration{}, fmt.Errorf("migrating from %q to %q storage is not supported", sourceMode, targetMode)
+	}
+	if backend, _ := storageBackend(targetMode); backend.Shared {
+		if key, err := projectDataKey(tx); err != nil || key != nil {
+			return StorageMigration{}, errors.Join(err, fmt.Errorf("%s storage cannot hold encrypted projects", targetMode))
+		}
+		if has, err := hasChunkDictionaries(tx); err != nil || has {
+			return StorageMigration{}, errors.Join(err, ErrDictionaryUnsupported)
+		}
+	}
+
+	var total int
+	if err := tx.Get(&total, "SELECT COUNT(*) FROM "+chunkIndexTable(sourceMode)); err != nil {
+		return StorageMigration{}, err
+	}
+	if _, err := tx.Exec("DELETE FROM storage_migration_failure"); err != nil {
+		return StorageMigration{}, err
+	}
+	now := time.Now().Unix()
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO storage_migration
+			(id, source_mode, target_mode, status, cursor, total_chunks, migrated_chunks, migrated_bytes, error, started_at, updated_at)
+		VALUES (1, ?, ?, ?, '', ?, 0, 0, '', ?, ?)
+	`, sourceMode, targetMode, StorageMigrationRunning, total, now, now)
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	return GetStorageMigration(tx)
+}
+
+// MigrateStorageBatch copies up to batchSize chunks from the source to the
+// target storage, verifying each one. When the source is exhausted it sweeps
+// chunks written since the migration started, flips the project's storage
+// mode and removes the old copies.
+func MigrateStorageBatch(projectPath string, batchSize int) (StorageMigration, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultStorageMigrationBatchSize
+	}
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	defer dbConn.Close()
+
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	defer tx.Rollback()
+
+	migration, err := GetStorageMigration(tx)
+	if err != nil || migration.Status != StorageMigrationRunning {
+		return migration, err
+	}
+	if err := ValidateStorageMode(migration.TargetMode); err != nil {
+		return migration, err
+	}
+	currentMode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return migration, err
+	}
+	if currentMode != migration.SourceMode {
+		migration.Status = StorageMigrationFailed
+		migration.Error = fmt.Sprintf("project storage mode changed to %q during migration", currentMode)
+		if err := saveStorageMigration(tx, &migration); err != nil {
+			return migration, err
+		}
+		return migration, tx.Commit()
+	}
+
+	source, err := openChunkStoreForMode(tx, migration.SourceMode)
+	if err != nil {
+		return migration, err
+	}
+	target, err := openChunkStoreForMode(tx, migration.TargetMode)
+	if err != nil {
+		return migration, err
+	}
+	decoder, err := newChunkDecoder(tx)
+	if err != nil {
+		return migration, err
+	}
+	defer decoder.Close()
+
+	copied := 0
+	err = source.Iterate(tx, migration.Cursor, batchSize, func(chunk ChunkStat) error {
+		copied++
+		if err := migrateChunk(tx, decoder, source, target, &migration, chunk); err != nil {
+			return err
+		}
+		migration.Cursor = chunk.Hash
+		return nil
+	})
+	if err != nil {
+		return migration, err
+	}
+
+	finished := copied < batchSize
+	if finished {
+		if err := finishStorageMigration(tx, decoder, source, target, &migration); err != nil {
+			return migration, err
+		}
+	} else if err := tx.Get(&migration.TotalChunks, "SELECT COUNT(*) FROM "+chunkIndexTable(source.Mode())); err != nil {
+		return migration, err
+	}
+	if err := saveStorageMigration(tx, &migration); err != nil {
+		return migration, err
+	}
+	if err := tx.Commit(); err != nil {
+		return migration, err
+	}
+	if migration.Status != StorageMigrationCompleted {
+		return migration, nil
+	}
+
+	cleanupTx, err := dbConn.Beginx()
+	if err != nil {
+		return migration, err
+	}
+	defer cleanupTx.Rollback()
+	if err := removeChunkStore(cleanupTx, source); err != nil {
+		return migration, fmt.Errorf("migration completed but %s storage could not be removed: %w", migration.SourceMode, err)
+	}
+	return migration, nil
+}
+
+// migrateChunk copies one chunk to the target mode. Chunks that cannot be
+// read or fail hash verification are recorded rather than aborting the batch,
+// while errors writing the target abort it so the batch can be retried.
+func migrateChunk(tx *sqlx.Tx, decoder *kzstd.Decoder, source, target ChunkStore, migration *StorageMigration, chunk ChunkStat) error {
+	data, err := source.Get(tx, chunk.Hash)
+	if err != nil {
+		return recordMigrationFailure(tx, chunk.Hash, fmt.Sprintf("read failed: %v", err))
+	}
+	if reason := verifyChunkData(decoder, chunk.Hash, data); reason != "" {
+		return recordMigrationFailure(tx, chunk.Hash, reason)
+	}
+	if err := target.Put(tx, chunk.Hash, data, chunk.Size); err != nil {
+		return err
+	}
+	migration.MigratedChunks++
+	migration.MigratedBytes += int64(len(data))
+	return nil
+}
+
+// verifyChunkData returns why data does not match hash, or "" when it does.
+func verifyChunkData(decoder *kzstd.Decoder, hash string, data []byte) string {
+	decompressed, err := decoder.DecodeAll(data, nil)
+	if err != nil {
+		return fmt.Sprintf("decompress failed: %v", err)
+	}
+	sum := sha256.Sum256(decompressed)
+	if hex.EncodeToString(sum[:]) != hash {
+		return "hash mismatch"
+	}
+	return ""
+}
+
+func recordMigrationFailure(tx *sqlx.Tx, hash, reason string) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO storage_migration_failure (hash, reason) VALUES (?, ?)", hash, reason)
+	return err
+}
+
+// finishStorageMigration copies chunks that were written behind the cursor
+// while the migration ran, then switches the project over. This runs in the
+// same transaction as the mode flip so no new chunk can slip between them.
+func finishStorageMigration(tx *sqlx.Tx, decoder *kzstd.Decoder, source, target ChunkStore, migration *StorageMigration) error {
+	var failedHashes []string
+	if err := tx.Select(&failedHashes, "SELECT hash FROM storage_migration_failure"); err != nil {
+		return err
+	}
+	failedBefore := make(map[string]bool, len(failedHashes))
+	for _, hash := range failedHashes {
+		failedBefore[hash] = true
+	}
+	var stragglers []ChunkStat
+	err := source.Iterate(tx, "", 0, func(chunk ChunkStat) error {
+		if failedBefore[chunk.Hash] {
+			return nil
+		}
+		_, err := target.Stat(tx, chunk.Hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			stragglers = append(stragglers, chunk)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, chunk := range stragglers {
+		if err := migrateChunk(tx, decoder, source, target, migration, chunk); err != nil {
+			return err
+		}
+	}
+	sourceTable := chunkIndexTable(source.Mode())
+	if err := tx.Get(&migration.TotalChunks, "SELECT COUNT(*) FROM "+sourceTable); err != nil {
+		return err
+	}
+
+	var failed int
+	if err := tx.Get(&failed, "SELECT COUNT(*) FROM storage_migration_failure"); err != nil {
+		return err
+	}
+	if failed > 0 {
+		migration.Status = StorageMigrationFailed
+		migration.Error = fmt.Sprintf("%d chunks failed verification; the project still uses %s storage", failed, migration.SourceMode)
+		return nil
+	}
+
+	if err := SetProjectStorageMode(tx, migration.TargetMode); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM " + sourceTable); err != nil {
+		return err
+	}
+	migration.Status = StorageMigrationCompleted
+	migration.Error = ""
+	return nil
+}
+
+func saveStorageMigration(tx *sqlx.Tx, migration *StorageMigration) error {
+	migration.UpdatedAt = time.Now().Unix()
+	_, err := tx.Exec(`
+		UPDATE storage_migration
+		SET status = ?, cursor = ?, total_chunks = ?, migrated_chunks = ?, migrated_bytes = ?, error = ?, updated_at = ?
+		WHERE id = 1
+	`, migration.Status, migration.Cursor, migration.TotalChunks, migration.MigratedChunks,
+		migration.MigratedBytes, migration.Error, migration.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	migration.FailedChunks = []string{}
+	return tx.Select(&migration.FailedChunks, "SELECT hash FROM storage_migration_failure ORDER BY hash")
+}
+
+// MigrateProjectStorage starts (or resumes) a migration of the project to
+// targetMode and runs it batch by batch until it completes, fails or ctx is
+// cancelled. callback, if set, receives the progress after every batch.
+func MigrateProjectStorage(
+	ctx context.Context, projectPath, targetMode string, batchSize int,
+	callback func(StorageMigration),
+) (StorageMigration, error) {
+	activeMigrationsMu.Lock()
+	if activeMigrations[projectPath] {
+		activeMigrationsMu.Unlock()
+		return StorageMigration{}, ErrStorageMigrationBusy
+	}
+	activeMigrations[projectPath] = true
+	activeMigrationsMu.Unlock()
+	defer func() {
+		activeMigrationsMu.Lock()
+		delete(activeMigrations, projectPath)
+		activeMigrationsMu.Unlock()
+	}()
+
+	migration, err := startStorageMigration(projectPath, targetMode)
+	if err != nil {
+		return migration, err
+	}
+	for migration.Status == StorageMigrationRunning {
+		if err := ctx.Err(); err != nil {
+			return migration, err
+		}
+		migration, err = MigrateStorageBatch(projectPath, batchSize)
+		if err != nil {
+			return migration, err
+		}
+		if callback != nil {
+			callback(migration)
+		}
+	}
+	if migration.Status == StorageMigrationFailed {
+		return migration, errors.New(migration.Error)
+	}
+	return migration, nil
+}
+
+// StorageMigrationActive reports whether this process is currently running a
+// migration for the project.
+func StorageMigrationActive(projectPath string) bool {
+	activeMigrationsMu.Lock()
+	defer activeMigrationsMu.Unlock()
+	return activeMigrations[projectPath]
+}
+
+func startStorageMigration(projectPath, targetMode string) (StorageMigration, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	defer dbConn.Close()
+
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	defer tx.Rollback()
+
+	migration, err := StartStorageMigration(tx, targetMode)
+	if err != nil {
+		return migration, err
+	}
+	return migration, tx.Commit()
+}
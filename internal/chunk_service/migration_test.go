@@ -0,0 +1,169 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"clustta/internal/repository"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	kzstd "github.com/klauspost/compress/zstd"
+)
+
+func newCompactProject(t *testing.T) string {
+	t.Helper()
+	return newCompactProjectWithID(t, "project-1")
+}
+
+func newCompactProjectWithID(t *testing.T, projectID string) string {
+	t.Helper()
+	projectPath := filepath.Join(t.TempDir(), "project.clst")
+	db, err := sqlx.Open("sqlite3", projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(repository.ProjectSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO config(name,value,mtime) VALUES('project_id',?,1)", projectID); err != nil {
+		t.Fatal(err)
+	}
+	return projectPath
+}
+
+func compressedChunk(t *testing.T, content string) (string, []byte) {
+	t.Helper()
+	encoder, err := kzstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:]), encoder.EncodeAll([]byte(content), nil)
+}
+
+func withProjectTx(t *testing.T, projectPath string, fn func(tx *sqlx.Tx)) {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	fn(tx)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageMigrationRoundTrip(t *testing.T) {
+	if err := chunk_service.ConfigureProjectStorage(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	projectPath := newCompactProject(t)
+	chunks := map[string][]byte{}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for _, content := range []string{"alpha", "beta", "gamma", "delta", "epsilon"} {
+			hash, data := compressedChunk(t, content)
+			chunks[hash] = data
+			if err := chunk_service.StoreChunk(tx, hash, data, len(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	var batches int
+	migration, err := chunk_service.MigrateProjectStorage(context.Background(), projectPath, chunk_service.StorageModeDeflated, 2,
+		func(chunk_service.StorageMigration) { batches++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.Status != chunk_service.StorageMigrationCompleted || migration.MigratedChunks != len(chunks) {
+		t.Fatalf("unexpected migration result %#v", migration)
+	}
+	if batches != 3 {
+		t.Fatalf("expected 3 batches of 2, ran %d", batches)
+	}
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		mode, err := chunk_service.GetProjectStorageMode(tx)
+		if err != nil || mode != chunk_service.StorageModeDeflated {
+			t.Fatalf("mode %q, err %v", mode, err)
+		}
+		var remaining int
+		if err := tx.Get(&remaining, "SELECT COUNT(*) FROM chunk"); err != nil || remaining != 0 {
+			t.Fatalf("expected compact chunks to be removed, have %d (err %v)", remaining, err)
+		}
+		for hash, data := range chunks {
+			got, err := chunk_service.ReadChunk(tx, hash)
+			if err != nil || string(got) != string(data) {
+				t.Fatalf("read %s after migration: %v", hash, err)
+			}
+		}
+	})
+
+	if _, err = chunk_service.MigrateProjectStorage(context.Background(), projectPath, chunk_service.StorageModeCompact, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		mode, _ := chunk_service.GetProjectStorageMode(tx)
+		if mode != chunk_service.StorageModeCompact {
+			t.Fatalf("expected compact mode, have %q", mode)
+		}
+		for hash, data := range chunks {
+			got, err := chunk_service.ReadChunk(tx, hash)
+			if err != nil || string(got) != string(data) {
+				t.Fatalf("read %s after migrating back: %v", hash, err)
+			}
+		}
+	})
+}
+
+func TestStorageMigrationKeepsModeOnCorruptChunk(t *testing.T) {
+	if err := chunk_service.ConfigureProjectStorage(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	projectPath := newCompactProject(t)
+	goodHash, goodData := compressedChunk(t, "intact")
+	_, otherData := compressedChunk(t, "something else")
+	badHash := strings.Repeat("0", 64)
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.StoreChunk(tx, goodHash, goodData, 6); err != nil {
+			t.Fatal(err)
+		}
+		if err := chunk_service.StoreChunk(tx, badHash, otherData, 14); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	migration, err := chunk_service.MigrateProjectStorage(context.Background(), projectPath, chunk_service.StorageModeDeflated, 0, nil)
+	if err == nil {
+		t.Fatal("expected migration to fail verification")
+	}
+	if migration.Status != chunk_service.StorageMigrationFailed ||
+		len(migration.FailedChunks) != 1 || migration.FailedChunks[0] != badHash {
+		t.Fatalf("unexpected migration result %#v", migration)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		mode, _ := chunk_service.GetProjectStorageMode(tx)
+		if mode != chunk_service.StorageModeCompact {
+			t.Fatalf("expected project to stay compact, have %q", mode)
+		}
+		if _, err := chunk_service.ReadChunk(tx, goodHash); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
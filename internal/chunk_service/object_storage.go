@@ -0,0 +1,518 @@
+package chunk_service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ObjectStorageConfig describes the S3-compatible bucket used by projects in
+// Object Storage mode. Any endpoint speaking the S3 REST API (AWS, MinIO,
+// Ceph RGW, ...) is supported.
+type ObjectStorageConfig struct {
+	Endpoint        string
+	Bucket          string
+	Prefix          string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	// VirtualHostStyle addresses the bucket as <bucket>.<host> instead of
+	// <host>/<bucket>. MinIO and most self-hosted servers expect path style.
+	VirtualHostStyle bool
+}
+
+var errObjectNotFound = errors.New("object not found")
+
+type objectStore struct {
+	endpoint        *url.URL
+	bucket          string
+	prefix          string
+	region          string
+	accessKeyId     string
+	secretAccessKey string
+	virtualHost     bool
+	client          *http.Client
+}
+
+var objectStorage *objectStore
+
+// ConfigureObjectStorage validates and stores the Studio-wide object storage
+// bucket. An empty endpoint intentionally leaves Object Storage unavailable.
+func ConfigureObjectStorage(cfg ObjectStorageConfig) error {
+	storageConfigMu.Lock()
+	defer storageConfigMu.Unlock()
+
+	forgetChunkStores()
+	objectStorage = nil
+	if strings.TrimSpace(cfg.Endpoint) == "" {
+		return nil
+	}
+	endpoint, err := url.Parse(strings.TrimRight(strings.TrimSpace(cfg.Endpoint), "/"))
+	if err != nil {
+		return fmt.Errorf("parse object storage endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return fmt.Errorf("object storage endpoint must be http or https, got %q", cfg.Endpoint)
+	}
+	if strings.TrimSpace(cfg.Bucket) == "" {
+		return errors.New("object storage bucket is required")
+	}
+	if cfg.AccessKeyId == "" || cfg.SecretAccessKey == "" {
+		return errors.New("object storage credentials are required")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	store := &objectStore{
+		endpoint:        endpoint,
+		bucket:          cfg.Bucket,
+		prefix:          strings.Trim(cfg.Prefix, "/"),
+		region:          region,
+		accessKeyId:     cfg.AccessKeyId,
+		secretAccessKey: cfg.SecretAccessKey,
+		virtualHost:     cfg.VirtualHostStyle,
+		client:          &http.Client{Timeout: 5 * time.Minute},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if _, err := store.list(ctx, store.key(".clustta-write-check"), ""); err != nil {
+		return fmt.Errorf("object storage bucket is not reachable: %w", err)
+	}
+	objectStorage = store
+	return nil
+}
+
+func ObjectStorageAvailable() bool {
+	storageConfigMu.RLock()
+	defer storageConfigMu.RUnlock()
+	return objectStorage != nil
+}
+
+func currentObjectStore() (*objectStore, error) {
+	storageConfigMu.RLock()
+	defer storageConfigMu.RUnlock()
+	if objectStorage == nil {
+		return nil, errors.New("object storage is not configured")
+	}
+	return objectStorage, nil
+}
+
+// key joins the configured prefix with a project-relative object key.
+func (s *objectStore) key(rel string) string {
+	if s.prefix == "" {
+		return rel
+	}
+	return s.prefix + "/" + rel
+}
+
+func (s *objectStore) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return objectStorageError("put", key, resp)
+	}
+	return nil
+}
+
+func (s *objectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errObjectNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, objectStorageError("get", key, resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Head returns the stored object's size.
+func (s *objectStore) Head(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, errObjectNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, objectStorageError("head", key, resp)
+	}
+	return resp.ContentLength, nil
+}
+
+func (s *objectStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return objectStorageError("delete", key, resp)
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns one ListObjectsV2 page of keys under prefix.
+func (s *objectStore) list(ctx context.Context, prefix, continuationToken string) (listBucketResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if continuationToken != "" {
+		query.Set("continuation-token", continuationToken)
+	}
+	var result listBucketResult
+	resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return result, objectStorageError("list", prefix, resp)
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("decode object listing: %w", err)
+	}
+	return result, nil
+}
+
+// DeletePrefix removes every object stored under prefix.
+func (s *objectStore) DeletePrefix(ctx context.Context, prefix string) error {
+	token := ""
+	for {
+		page, err := s.list(ctx, prefix, token)
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			if err := s.Delete(ctx, object.Key); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func objectStorageError(op, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("object storage %s %q failed with status %d: %s", op, key, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (s *objectStore) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	host := s.endpoint.Host
+	path := s.endpoint.Path
+	if s.virtualHost {
+		host = s.bucket + "." + host
+	} else {
+		path += "/" + s.bucket
+	}
+	if key != "" {
+		path += "/" + key
+	} else if !s.virtualHost {
+		path += "/"
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	target := url.URL{Scheme: s.endpoint.Scheme, Host: host, Path: path, RawPath: awsURIEscape(path, false), RawQuery: canonicalQuery(query)}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, host, path, query, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *objectStore) sign(req *http.Request, host, path string, query url.Values, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	payloadHex := hex.EncodeToString(payloadHash[:])
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+
+	req.Host = host
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHex)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + host + "\n" +
+		"x-amz-content-sha256:" + payloadHex + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEscape(path, false),
+		canonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHex,
+	}, "\n")
+
+	scope := dateStamp + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretAccessKey), dateStamp)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyId, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEscape(k, true)+"="+awsURIEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEscape percent-encodes everything except RFC 3986 unreserved
+// characters, as required by SigV4. Slashes are kept when encoding paths.
+func awsURIEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func objectStorageAvailable() error {
+	if !ObjectStorageAvailable() {
+		return errors.New("object storage is not configured")
+	}
+	return nil
+}
+
+// objectChunkStore keeps each chunk as an object in the configured bucket.
+type objectChunkStore struct {
+	chunkRefIndex
+	store *objectStore
+	id    string
+}
+
+func openObjectChunkStore(tx *sqlx.Tx) (ChunkStore, error) {
+	store, err := currentObjectStore()
+	if err != nil {
+		return nil, err
+	}
+	id, err := projectID(tx)
+	if err != nil {
+		return nil, err
+	}
+	return objectChunkStore{store: store, id: id}, nil
+}
+
+func (objectChunkStore) Mode() string { return StorageModeObjectStorage }
+
+// chunkKey mirrors the Deflated fan-out layout inside the bucket so a project
+// can be copied between the two modes without renaming objects.
+func (s objectChunkStore) chunkKey(hash string) (string, error) {
+	if err := validateChunkHash(hash); err != nil {
+		return "", err
+	}
+	return s.store.key(s.id + "/chunks/" + hash[:2] + "/" + hash[2:4] + "/" + hash), nil
+}
+
+func (s objectChunkStore) Put(tx *sqlx.Tx, hash string, data []byte, size int) error {
+	key, err := s.chunkKey(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := s.store.Head(context.Background(), key); errors.Is(err, errObjectNotFound) {
+		if err := s.store.Put(context.Background(), key, data); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return s.add(tx, hash, key, size)
+}
+
+func (s objectChunkStore) Get(tx *sqlx.Tx, hash string) ([]byte, error) {
+	if ok, err := s.indexed(tx, hash); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, sql.ErrNoRows
+	}
+	key, err := s.chunkKey(hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.store.Get(context.Background(), key)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, sql.ErrNoRows
+	}
+	return data, err
+}
+
+func (s objectChunkStore) Has(tx *sqlx.Tx, hash string) (bool, error) {
+	if ok, err := s.indexed(tx, hash); err != nil || !ok {
+		return false, err
+	}
+	key, err := s.chunkKey(hash)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.store.Head(context.Background(), key)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s objectChunkStore) Delete(tx *sqlx.Tx, hash string) error {
+	key, err := s.chunkKey(hash)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Delete(context.Background(), key); err != nil {
+		return err
+	}
+	return s.remove(tx, hash)
+}
+
+func (s objectChunkStore) Replace(tx *sqlx.Tx, hash string, data []byte) error {
+	key, err := s.chunkKey(hash)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(context.Background(), key, data)
+}
+
+func (s objectChunkStore) ReclaimableSize(tx *sqlx.Tx, hash string) (int64, error) {
+	key, err := s.chunkKey(hash)
+	if err != nil {
+		return 0, err
+	}
+	size, err := s.store.Head(context.Background(), key)
+	if errors.Is(err, errObjectNotFound) {
+		return 0, nil
+	}
+	return size, err
+}
+
+// Quarantine copies the object to <project_id>/quarantine in the bucket and
+// deletes the original; buckets have no rename.
+func (s objectChunkStore) Quarantine(tx *sqlx.Tx, hash string) (string, error) {
+	key, err := s.chunkKey(hash)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	target := ""
+	data, err := s.store.Get(ctx, key)
+	if err == nil {
+		target = s.store.key(s.id + "/quarantine/" + hash)
+		if err := s.store.Put(ctx, target, data); err != nil {
+			return "", err
+		}
+		if err := s.store.Delete(ctx, key); err != nil {
+			return "", err
+		}
+	} else if !errors.Is(err, errObjectNotFound) {
+		return "", err
+	}
+	return target, s.remove(tx, hash)
+}
+
+// SweepOrphans removes chunk objects without an index row. The bucket's
+// LastModified stands in for the chunk's age.
+func (s objectChunkStore) SweepOrphans(tx *sqlx.Tx, indexed map[string]bool, cutoff time.Time, dryRun bool) (int, int64, error) {
+	count, size := 0, int64(0)
+	ctx := context.Background()
+	token := ""
+	for {
+		page, err := s.store.list(ctx, s.store.key(s.id+"/chunks/"), token)
+		if err != nil {
+			return count, size, err
+		}
+		for _, object := range page.Contents {
+			if indexed[path.Base(object.Key)] || object.LastModified.After(cutoff) {
+				continue
+			}
+			count++
+			size += object.Size
+			if !dryRun {
+				if err := s.store.Delete(ctx, object.Key); err != nil {
+					return count, size, err
+				}
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return count, size, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s objectChunkStore) RemoveAll(tx *sqlx.Tx) error {
+	return s.store.DeletePrefix(context.Background(), s.store.key(s.id+"/"))
+}
@@ -0,0 +1,171 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"clustta/internal/repository"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeS3 is a minimal in-process S3 server covering the calls the object
+// storage backend makes: Put/Get/Head/Delete object and ListObjectsV2.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	fake := &fakeS3{bucket: bucket, objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if key == "" && r.Method == http.MethodGet {
+		type content struct {
+			Key  string `xml:"Key"`
+			Size int    `xml:"Size"`
+		}
+		result := struct {
+			XMLName  xml.Name  `xml:"ListBucketResult"`
+			Contents []content `xml:"Contents"`
+		}{}
+		prefix := r.URL.Query().Get("prefix")
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, content{Key: k, Size: len(v)})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		xml.NewEncoder(w).Encode(result)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newObjectStorageProject(t *testing.T) *sqlx.Tx {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "project.clst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err = db.Exec(repository.ProjectSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO config(name,value,mtime) VALUES('project_id','project-1',1)"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	if err = chunk_service.SetProjectStorageMode(tx, chunk_service.StorageModeObjectStorage); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestObjectStorageChunkLifecycle(t *testing.T) {
+	fake, server := newFakeS3(t, "clustta")
+	err := chunk_service.ConfigureObjectStorage(chunk_service.ObjectStorageConfig{
+		Endpoint:        server.URL,
+		Bucket:          "clustta",
+		Prefix:          "studio",
+		AccessKeyId:     "minio",
+		SecretAccessKey: "minio-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureObjectStorage(chunk_service.ObjectStorageConfig{}) })
+
+	tx := newObjectStorageProject(t)
+	hash := strings.Repeat("ab", 32)
+	payload := []byte("compressed chunk bytes")
+	if err = chunk_service.StoreChunk(tx, hash, payload, len(payload)); err != nil {
+		t.Fatal(err)
+	}
+	wantKey := "studio/project-1/chunks/ab/ab/" + hash
+	if _, ok := fake.objects[wantKey]; !ok {
+		t.Fatalf("expected object %q, have %v", wantKey, fake.objects)
+	}
+
+	got, err := chunk_service.ReadChunk(tx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(payload) {
+		t.Fatalf("read %q, want %q", got, payload)
+	}
+	if !chunk_service.ChunkExists(hash, tx, map[string]bool{}) {
+		t.Fatal("expected chunk to exist")
+	}
+	info, err := chunk_service.GetChunkInfo(tx, hash)
+	if err != nil || info.Size != len(payload) {
+		t.Fatalf("unexpected chunk info %#v, err %v", info, err)
+	}
+
+	if err = chunk_service.DeleteProjectStorage(tx); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects) != 0 {
+		t.Fatalf("expected project objects to be deleted, have %v", fake.objects)
+	}
+	if chunk_service.ChunkExists(hash, tx, map[string]bool{}) {
+		t.Fatal("expected chunk to be missing after project storage was deleted")
+	}
+}
+
+func TestObjectStorageModeRequiresConfiguration(t *testing.T) {
+	if err := chunk_service.ConfigureObjectStorage(chunk_service.ObjectStorageConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := chunk_service.ValidateStorageMode(chunk_service.StorageModeObjectStorage); err == nil {
+		t.Fatal("expected object storage to be unavailable without an endpoint")
+	}
+}
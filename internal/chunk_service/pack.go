@@ -0,0 +1,562 @@
+package chunk_service
+
+import (
+	"bufio"
+	"clustta/internal/utils"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Deflated projects append chunks to pack files under
+// <storage_root>/<project_id>/packs rather than writing a file per chunk. A
+// pack starts with packMagic, followed by records holding the binary chunk
+// hash, a big-endian uint32 length and the chunk bytes. The chunk_pack table
+// maps each packed hash to its pack and the offset and length of its bytes;
+// records without a row are dead space until RepackChunks rewrites the pack.
+
+const DefaultRepackBatchSize = 1024
+
+const packRecordHeaderSize = 32 + 4
+
+var packMagic = []byte("CLSTPACK")
+
+// packLockName is the file in a packs directory that appends lock, so that
+// processes sharing the storage root do not interleave their records.
+const packLockName = "pack.lock"
+
+var (
+	ErrRepackBusy        = errors.New("repack is already running for this project")
+	ErrRepackUnsupported = errors.New("only deflated storage is packed")
+)
+
+var (
+	// maxPackSize is the size after which new chunks start another pack.
+	maxPackSize int64 = 256 << 20
+	// repackDeadRatio is the share of dead bytes at which a pack is rewritten.
+	repackDeadRatio = 0.3
+	// packMinAge spares recently written packs, which may still gain records
+	// from transactions that have not committed.
+	packMinAge = time.Hour
+)
+
+var (
+	packLocksMu sync.Mutex
+	packLocks   = map[string]*sync.Mutex{}
+
+	activeRepacksMu sync.Mutex
+	activeRepacks   = map[string]bool{}
+)
+
+// RepackReport summarises one RepackChunks pass.
+type RepackReport struct {
+	LooseChunksPacked int   `json:"loose_chunks_packed"`
+	PacksRewritten    int   `json:"packs_rewritten"`
+	PacksRemoved      int   `json:"packs_removed"`
+	ReclaimedBytes    int64 `json:"reclaimed_bytes"`
+}
+
+type packRecord struct {
+	hash string
+	data []byte
+}
+
+type packLocation struct {
+	Pack   string `db:"pack"`
+	Offset int64  `db:"offset"`
+	Length int    `db:"length"`
+}
+
+// packLock serialises appends to the packs in dir within this process;
+// the lock on packLockName does so between processes.
+func packLock(dir string) *sync.Mutex {
+	packLocksMu.Lock()
+	defer packLocksMu.Unlock()
+	lock, ok := packLocks[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		packLocks[dir] = lock
+	}
+	return lock
+}
+
+func packName(seq int) string {
+	return fmt.Sprintf("pack-%08d.pack", seq)
+}
+
+func packSeq(name string) (int, bool) {
+	var seq int
+	if _, err := fmt.Sscanf(name, "pack-%08d.pack", &seq); err != nil || packName(seq) != name {
+		return 0, false
+	}
+	return seq, true
+}
+
+// listPacks returns the names of the packs in dir, oldest first.
+func listPacks(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	packs := []string{}
+	for _, entry := range entries {
+		if _, ok := packSeq(entry.Name()); ok && !entry.IsDir() {
+			packs = append(packs, entry.Name())
+		}
+	}
+	sort.Strings(packs)
+	return packs, nil
+}
+
+// appendToPack writes records to the newest pack in dir with a single sync,
+// starting a new pack once the newest is full.
+func appendToPack(dir string, records []packRecord) ([]packLocation, error) {
+	lock := packLock(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	guard, err := os.OpenFile(filepath.Join(dir, packLockName), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	defer guard.Close()
+	unlock, err := lockFile(guard)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	packs, err := listPacks(dir)
+	if err != nil {
+		return nil, err
+	}
+	name := packName(1)
+	if len(packs) > 0 {
+		name = packs[len(packs)-1]
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() >= maxPackSize {
+			seq, _ := packSeq(name)
+			name = packName(seq + 1)
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	start := info.Size()
+	locations, err := writePackRecords(file, start, records)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		// Drop the partial records so later offsets stay consistent.
+		file.Truncate(start)
+		file.Close()
+		return nil, err
+	}
+	for i := range locations {
+		locations[i].Pack = name
+	}
+	return locations, file.Close()
+}
+
+func writePackRecords(file *os.File, offset int64, records []packRecord) ([]packLocation, error) {
+	writer := bufio.NewWriter(file)
+	if offset == 0 {
+		if _, err := writer.Write(packMagic); err != nil {
+			return nil, err
+		}
+		offset = int64(len(packMagic))
+	}
+	locations := make([]packLocation, 0, len(records))
+	header := make([]byte, packRecordHeaderSize)
+	for _, record := range records {
+		if _, err := hex.Decode(header[:32], []byte(record.hash)); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(header[32:], uint32(len(record.data)))
+		if _, err := writer.Write(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(record.data); err != nil {
+			return nil, err
+		}
+		offset += packRecordHeaderSize
+		locations = append(locations, packLocation{Offset: offset, Length: len(record.data)})
+		offset += int64(len(record.data))
+	}
+	return locations, writer.Flush()
+}
+
+// readPackRecord reads a chunk's bytes from its pack, reporting a missing pack
+// as sql.ErrNoRows like a missing chunk file.
+func readPackRecord(dir string, location packLocation) ([]byte, error) {
+	file, err := os.Open(filepath.Join(dir, location.Pack))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, sql.ErrNoRows
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data := make([]byte, location.Length)
+	if _, err := file.ReadAt(data, location.Offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("pack %s is truncated", location.Pack)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s deflatedStore) packsDir() string {
+	return filepath.Join(s.root, s.id, "packs")
+}
+
+func (s deflatedStore) packKey(pack string) string {
+	return filepath.ToSlash(filepath.Join(s.id, "packs", pack))
+}
+
+// locate returns where a packed chunk's bytes are, and false for chunks still
+// kept as loose files.
+func (s deflatedStore) locate(tx *sqlx.Tx, hash string) (packLocation, bool, error) {
+	var location packLocation
+	err := tx.Get(&location, "SELECT pack, offset, length FROM chunk_pack WHERE hash = ?", hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return location, false, nil
+	}
+	return location, err == nil, err
+}
+
+func (s deflatedStore) setLocation(tx *sqlx.Tx, hash string, location packLocation) error {
+	_, err := tx.Exec(`
+		INSERT OR REPLACE INTO chunk_pack (hash, pack, offset, length) VALUES (?, ?, ?, ?)
+	`, hash, location.Pack, location.Offset, location.Length)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE chunk_ref SET storage_key = ? WHERE hash = ?", s.packKey(location.Pack), hash)
+	return err
+}
+
+// sweepOrphanPacks removes packs no chunk_pack row points at.
+func (s deflatedStore) sweepOrphanPacks(tx *sqlx.Tx, cutoff time.Time, dryRun bool) (int, int64, error) {
+	var live []string
+	if err := tx.Select(&live, "SELECT DISTINCT pack FROM chunk_pack"); err != nil {
+		return 0, 0, err
+	}
+	inUse := make(map[string]bool, len(live))
+	for _, pack := range live {
+		inUse[pack] = true
+	}
+	packs, err := listPacks(s.packsDir())
+	if err != nil {
+		return 0, 0, err
+	}
+	count, size := 0, int64(0)
+	for _, pack := range packs {
+		if inUse[pack] {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(s.packsDir(), pack))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return count, size, err
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		count++
+		size += info.Size()
+		if dryRun {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.packsDir(), pack)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return count, size, err
+		}
+	}
+	return count, size, nil
+}
+
+// RepackChunks moves a deflated project's loose chunk files into packs and
+// rewrites packs that are mostly dead space, dropping records no index row
+// points at. Packs written within the last hour are left alone.
+func RepackChunks(ctx context.Context, projectPath string) (RepackReport, error) {
+	activeRepacksMu.Lock()
+	if activeRepacks[projectPath] {
+		activeRepacksMu.Unlock()
+		return RepackReport{}, ErrRepackBusy
+	}
+	activeRepacks[projectPath] = true
+	activeRepacksMu.Unlock()
+	defer func() {
+		activeRepacksMu.Lock()
+		delete(activeRepacks, projectPath)
+		activeRepacksMu.Unlock()
+	}()
+
+	report := RepackReport{}
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		packed, err := packLooseBatch(projectPath, &cursor)
+		report.LooseChunksPacked += packed
+		if err != nil {
+			return report, err
+		}
+		if cursor == "" {
+			break
+		}
+	}
+
+	var candidates []string
+	err := withRepackTx(projectPath, func(tx *sqlx.Tx, store deflatedStore) ([]string, error) {
+		var err error
+		candidates, err = repackCandidates(tx, store, &report)
+		return nil, err
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, pack := range candidates {
+		for {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			done, err := rewritePackBatch(projectPath, pack, &report)
+			if err != nil {
+				return report, err
+			}
+			if done {
+				report.PacksRewritten++
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+// withRepackTx runs fn in a transaction on a deflated project that is not
+// being migrated, removing the files fn returns once the transaction commits.
+func withRepackTx(projectPath string, fn func(tx *sqlx.Tx, store deflatedStore) ([]string, error)) error {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	mode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return err
+	}
+	if mode != StorageModeDeflated {
+		return ErrRepackUnsupported
+	}
+	migration, err := GetStorageMigration(tx)
+	if err == nil && migration.Status == StorageMigrationRunning {
+		return ErrStorageMigrationInProgress
+	} else if err != nil && !errors.Is(err, ErrNoStorageMigration) {
+		return err
+	}
+	store, err := openBackendStore(tx, StorageModeDeflated)
+	if err != nil {
+		return err
+	}
+	obsolete, err := fn(tx, store.(deflatedStore))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, path := range obsolete {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// packLooseBatch moves the next batch of loose chunk files after cursor into
+// a pack, clearing cursor once none are left.
+func packLooseBatch(projectPath string, cursor *string) (int, error) {
+	packed := 0
+	err := withRepackTx(projectPath, func(tx *sqlx.Tx, store deflatedStore) ([]string, error) {
+		var hashes []string
+		err := tx.Select(&hashes, `
+			SELECT hash FROM chunk_ref
+			WHERE hash > ? AND hash NOT IN (SELECT hash FROM chunk_pack)
+			ORDER BY hash LIMIT ?
+		`, *cursor, DefaultRepackBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(hashes) < DefaultRepackBatchSize {
+			*cursor = ""
+		} else {
+			*cursor = hashes[len(hashes)-1]
+		}
+
+		var records []packRecord
+		var loose []string
+		for _, hash := range hashes {
+			path, _, err := store.chunkPath(hash)
+			if err != nil {
+				return nil, err
+			}
+			data, err := readChunkFile(path)
+			if errors.Is(err, sql.ErrNoRows) {
+				// Missing files are left for the scrubber to report.
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, packRecord{hash: hash, data: data})
+			loose = append(loose, path)
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+		locations, err := appendToPack(store.packsDir(), records)
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if err := store.setLocation(tx, record.hash, locations[i]); err != nil {
+				return nil, err
+			}
+		}
+		packed = len(records)
+		return loose, nil
+	})
+	return packed, err
+}
+
+// repackCandidates removes packs with no live records and returns the packs
+// dead enough to rewrite. The newest pack is still being appended to and is
+// never a candidate.
+func repackCandidates(tx *sqlx.Tx, store deflatedStore, report *RepackReport) ([]string, error) {
+	var usage []struct {
+		Pack   string `db:"pack"`
+		Chunks int64  `db:"chunks"`
+		Live   int64  `db:"live"`
+	}
+	err := tx.Select(&usage, "SELECT pack, COUNT(*) AS chunks, SUM(length) AS live FROM chunk_pack GROUP BY pack")
+	if err != nil {
+		return nil, err
+	}
+	liveBytes := make(map[string]int64, len(usage))
+	for _, pack := range usage {
+		liveBytes[pack.Pack] = pack.Live + pack.Chunks*packRecordHeaderSize
+	}
+
+	packs, err := listPacks(store.packsDir())
+	if err != nil || len(packs) == 0 {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-packMinAge)
+	var candidates []string
+	for _, pack := range packs[:len(packs)-1] {
+		path := filepath.Join(store.packsDir(), pack)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		live, inUse := liveBytes[pack]
+		if !inUse {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			report.PacksRemoved++
+			report.ReclaimedBytes += info.Size()
+			continue
+		}
+		dead := info.Size() - int64(len(packMagic)) - live
+		if float64(dead) >= repackDeadRatio*float64(info.Size()) {
+			candidates = append(candidates, pack)
+		}
+	}
+	return candidates, nil
+}
+
+// rewritePackBatch copies the next batch of live records out of pack,
+// removing the pack once it has none left.
+func rewritePackBatch(projectPath, pack string, report *RepackReport) (bool, error) {
+	done := false
+	err := withRepackTx(projectPath, func(tx *sqlx.Tx, store deflatedStore) ([]string, error) {
+		var hashes []string
+		err := tx.Select(&hashes, "SELECT hash FROM chunk_pack WHERE pack = ? ORDER BY offset LIMIT ?", pack, DefaultRepackBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(store.packsDir(), pack)
+		if len(hashes) == 0 {
+			done = true
+			info, err := os.Stat(path)
+			if err == nil {
+				report.ReclaimedBytes += info.Size()
+			}
+			return []string{path}, nil
+		}
+
+		records := make([]packRecord, 0, len(hashes))
+		for _, hash := range hashes {
+			location, _, err := store.locate(tx, hash)
+			if err != nil {
+				return nil, err
+			}
+			data, err := readPackRecord(store.packsDir(), location)
+			if err != nil {
+				return nil, fmt.Errorf("read chunk %s: %w", hash, err)
+			}
+			records = append(records, packRecord{hash: hash, data: data})
+		}
+		locations, err := appendToPack(store.packsDir(), records)
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if err := store.setLocation(tx, record.hash, locations[i]); err != nil {
+				return nil, err
+			}
+			report.ReclaimedBytes -= int64(packRecordHeaderSize + len(record.data))
+		}
+		return nil, nil
+	})
+	return done, err
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package chunk_service
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile blocks until it holds an exclusive lock on file, shared with
+// other processes. The lock goes when the returned function is called or
+// the file is closed.
+func lockFile(file *os.File) (func() error, error) {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}, nil
+}
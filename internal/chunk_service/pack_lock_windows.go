@@ -0,0 +1,37 @@
+//go:build windows
+
+package chunk_service
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const lockfileExclusiveLock = 0x2
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockFile blocks until it holds an exclusive lock on file, shared with
+// other processes. The lock goes when the returned function is called or
+// the file is closed.
+func lockFile(file *os.File) (func() error, error) {
+	overlapped := new(syscall.Overlapped)
+	ret, _, callErr := procLockFileEx.Call(
+		file.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)),
+	)
+	if ret == 0 {
+		return nil, callErr
+	}
+	return func() error {
+		ret, _, callErr := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+		if ret == 0 {
+			return callErr
+		}
+		return nil
+	}, nil
+}
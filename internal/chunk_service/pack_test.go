@@ -0,0 +1,137 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestRepackDeflatedChunks(t *testing.T) {
+	storageDir := t.TempDir()
+	if err := chunk_service.ConfigureProjectStorage(storageDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	projectPath := newCompactProject(t)
+	looseHash, looseData := compressedChunk(t, "written before packs")
+	deadHash, deadData := compressedChunk(t, "deleted later")
+	liveHash, liveData := compressedChunk(t, "kept in the first pack")
+	lastHash, lastData := compressedChunk(t, "written to a later pack")
+	loosePath := filepath.Join(storageDir, "project-1", "chunks", looseHash[:2], looseHash[2:4], looseHash)
+	packsDir := filepath.Join(storageDir, "project-1", "packs")
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.SetProjectStorageMode(tx, chunk_service.StorageModeDeflated); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(loosePath), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(loosePath, looseData, 0640); err != nil {
+			t.Fatal(err)
+		}
+		_, err := tx.Exec("INSERT INTO chunk_ref (hash, storage_key, size, created_at) VALUES (?, 'legacy', 20, 1)", looseHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for hash, data := range map[string][]byte{deadHash: deadData, liveHash: liveData} {
+			if err := chunk_service.StoreChunk(tx, hash, data, len(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	chunk_service.SetPackLimits(t, 1, 0)
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.StoreChunk(tx, lastHash, lastData, len(lastData)); err != nil {
+			t.Fatal(err)
+		}
+		store, err := chunk_service.OpenChunkStore(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(tx, deadHash); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := os.Stat(filepath.Join(packsDir, "pack-00000002.pack")); err != nil {
+		t.Fatalf("expected a second pack once the first was full: %v", err)
+	}
+
+	report, err := chunk_service.RepackChunks(context.Background(), projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.LooseChunksPacked != 1 || report.PacksRewritten != 1 || report.PacksRemoved != 0 || report.ReclaimedBytes <= 0 {
+		t.Fatalf("unexpected repack report %#v", report)
+	}
+	if _, err := os.Stat(loosePath); !os.IsNotExist(err) {
+		t.Fatal("expected loose chunk file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(packsDir, "pack-00000001.pack")); !os.IsNotExist(err) {
+		t.Fatal("expected the mostly dead pack to be removed")
+	}
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for hash, want := range map[string][]byte{looseHash: looseData, liveHash: liveData, lastHash: lastData} {
+			if got, err := chunk_service.ReadChunk(tx, hash); err != nil || string(got) != string(want) {
+				t.Fatalf("reading %s after repack: %v", hash, err)
+			}
+		}
+		if chunk_service.ChunkExists(deadHash, tx, map[string]bool{}) {
+			t.Fatal("deleted chunk came back after repack")
+		}
+	})
+
+	report, err = chunk_service.RepackChunks(context.Background(), projectPath)
+	if err != nil || report != (chunk_service.RepackReport{}) {
+		t.Fatalf("expected a second repack to find nothing to do, got %#v, %v", report, err)
+	}
+}
+
+func TestWriteChunksAppendsOnePack(t *testing.T) {
+	storageDir := t.TempDir()
+	if err := chunk_service.ConfigureProjectStorage(storageDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	projectPath := newCompactProject(t)
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.SetProjectStorageMode(tx, chunk_service.StorageModeDeflated); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// Every append starts a new pack, so the stream must be appended once.
+	chunk_service.SetPackLimits(t, 1, 0)
+
+	chunks := []chunk_service.Chunk{}
+	for _, content := range []string{"first in the stream", "second in the stream", "third in the stream"} {
+		hash, data := compressedChunk(t, content)
+		chunks = append(chunks, chunk_service.Chunk{Hash: hash, Data: data, Size: len(data)})
+	}
+	stream, err := chunk_service.EncodeChunkStream(chunk_service.ChunkFramingV2, append(chunks, chunks[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, err := chunk_service.WriteChunks(projectPath, stream, chunk_service.ChunkFramingV2)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("write chunks failed %v: %v", failed, err)
+	}
+
+	packs, err := filepath.Glob(filepath.Join(storageDir, "project-1", "packs", "*.pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("expected the stream in one pack, found %v: %v", packs, err)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for _, chunk := range chunks {
+			if got, err := chunk_service.ReadChunk(tx, chunk.Hash); err != nil || string(got) != string(chunk.Data) {
+				t.Fatalf("read %s: %v", chunk.Hash, err)
+			}
+		}
+	})
+}
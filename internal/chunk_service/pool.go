@@ -0,0 +1,411 @@
+package chunk_service
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The chunk pool keeps one copy of each chunk for every Pooled project in the
+// Studio. Its index lives beside the files in <storage_root>/pool and counts
+// how many projects reference each chunk; a chunk is deleted when the last
+// reference is released.
+const poolSchema = `
+CREATE TABLE IF NOT EXISTS pool_chunk (
+    hash TEXT PRIMARY KEY NOT NULL,
+    stored_size INTEGER NOT NULL,
+    refcount INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pool_ref (
+    project_id TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (project_id, hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_pool_ref_hash ON pool_ref (hash);
+`
+
+// chunkPool is opened lazily under storageRoot and guarded by storageConfigMu.
+var chunkPool *sqlx.DB
+
+func currentPool() (*sqlx.DB, error) {
+	storageConfigMu.RLock()
+	pool := chunkPool
+	storageConfigMu.RUnlock()
+	if pool != nil {
+		return pool, nil
+	}
+
+	storageConfigMu.Lock()
+	defer storageConfigMu.Unlock()
+	if chunkPool != nil {
+		return chunkPool, nil
+	}
+	if storageRoot == "" {
+		return nil, errors.New("chunk pool requires a storage directory")
+	}
+	poolDir := filepath.Join(storageRoot, "pool")
+	if err := os.MkdirAll(poolDir, 0750); err != nil {
+		return nil, err
+	}
+	db, err := sqlx.Open("sqlite3", filepath.Join(poolDir, "pool.db")+"?_busy_timeout=120000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	// One connection serialises pool transactions, which also guard the
+	// check-then-write and release-then-delete of pool files.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(poolSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	chunkPool = db
+	return chunkPool, nil
+}
+
+// closePool must be called with storageConfigMu held.
+func closePool() {
+	if chunkPool != nil {
+		chunkPool.Close()
+		chunkPool = nil
+	}
+}
+
+// poolFilePath resolves a storage-root-relative pool key to a file path.
+func poolFilePath(key string) (string, error) {
+	storageConfigMu.RLock()
+	root := storageRoot
+	storageConfigMu.RUnlock()
+	if root == "" {
+		return "", errors.New("chunk pool requires a storage directory")
+	}
+	return filepath.Join(root, filepath.FromSlash(key)), nil
+}
+
+func poolChunkPath(hash string) (string, string, error) {
+	if err := validateChunkHash(hash); err != nil {
+		return "", "", err
+	}
+	key := "pool/chunks/" + hash[:2] + "/" + hash[2:4] + "/" + hash
+	path, err := poolFilePath(key)
+	return path, key, err
+}
+
+// acquirePoolRef stores data in the pool if it is not there yet and records
+// that projectID references it.
+func acquirePoolRef(projectID, hash string, data []byte) (string, error) {
+	path, key, err := poolChunkPath(hash)
+	if err != nil {
+		return "", err
+	}
+	pool, err := currentPool()
+	if err != nil {
+		return "", err
+	}
+	tx, err := pool.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := writeChunkFile(path, data); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO pool_chunk (hash, stored_size, refcount, created_at)
+		VALUES (?, ?, 0, unixepoch())
+	`, hash, len(data))
+	if err != nil {
+		return "", err
+	}
+	result, err := tx.Exec("INSERT OR IGNORE INTO pool_ref (project_id, hash, created_at) VALUES (?, ?, unixepoch())", projectID, hash)
+	if err != nil {
+		return "", err
+	}
+	if added, _ := result.RowsAffected(); added == 1 {
+		if _, err := tx.Exec("UPDATE pool_chunk SET refcount = refcount + 1 WHERE hash = ?", hash); err != nil {
+			return "", err
+		}
+	}
+	return key, tx.Commit()
+}
+
+// releasePoolRef drops projectID's reference to hash, deleting the pooled
+// chunk once no project references it.
+func releasePoolRef(projectID, hash string) error {
+	path, _, err := poolChunkPath(hash)
+	if err != nil {
+		return err
+	}
+	pool, err := currentPool()
+	if err != nil {
+		return err
+	}
+	tx, err := pool.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM pool_ref WHERE project_id = ? AND hash = ?", projectID, hash)
+	if err != nil {
+		return err
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return nil
+	}
+	if _, err := tx.Exec("UPDATE pool_chunk SET refcount = refcount - 1 WHERE hash = ?", hash); err != nil {
+		return err
+	}
+	var refcount int
+	err = tx.Get(&refcount, "SELECT refcount FROM pool_chunk WHERE hash = ?", hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if refcount <= 0 {
+		if _, err := tx.Exec("DELETE FROM pool_chunk WHERE hash = ?", hash); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// releaseProjectPoolRefs drops every pool reference held by projectID.
+func releaseProjectPoolRefs(projectID string) error {
+	pool, err := currentPool()
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	if err := pool.Select(&hashes, "SELECT hash FROM pool_ref WHERE project_id = ?", projectID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if err := releasePoolRef(projectID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// poolReclaimableSize returns how many bytes releasing projectID's reference
+// to hash would free: the whole chunk if it is the last reference, else 0.
+func poolReclaimableSize(hash string) (int64, error) {
+	pool, err := currentPool()
+	if err != nil {
+		return 0, err
+	}
+	var row struct {
+		StoredSize int64 `db:"stored_size"`
+		Refcount   int   `db:"refcount"`
+	}
+	err = pool.Get(&row, "SELECT stored_size, refcount FROM pool_chunk WHERE hash = ?", hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if row.Refcount > 1 {
+		return 0, nil
+	}
+	return row.StoredSize, nil
+}
+
+// staleProjectPoolRefs returns pool references held by projectID for chunks
+// the project no longer indexes, such as references left by an upload whose
+// project transaction rolled back.
+func staleProjectPoolRefs(projectID string, indexed map[string]bool, cutoff time.Time) ([]string, error) {
+	pool, err := currentPool()
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	err = pool.Select(&hashes, "SELECT hash FROM pool_ref WHERE project_id = ? AND created_at <= ?", projectID, cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	stale := []string{}
+	for _, hash := range hashes {
+		if !indexed[hash] {
+			stale = append(stale, hash)
+		}
+	}
+	return stale, nil
+}
+
+// quarantinePoolChunk moves a corrupt pooled chunk aside and releases
+// projectID's reference. Other projects keep theirs so a re-upload from any
+// of them restores the chunk for all.
+func quarantinePoolChunk(projectID, hash string) (string, error) {
+	path, _, err := poolChunkPath(hash)
+	if err != nil {
+		return "", err
+	}
+	pool, err := currentPool()
+	if err != nil {
+		return "", err
+	}
+	key := "pool/quarantine/" + hash
+	target, err := poolFilePath(key)
+	if err != nil {
+		return "", err
+	}
+	// The transaction only holds the pool connection so the move cannot
+	// interleave with an upload of the same chunk.
+	tx, err := pool.Beginx()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := os.Rename(path, target); errors.Is(err, os.ErrNotExist) {
+		key = ""
+	} else if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return key, releasePoolRef(projectID, hash)
+}
+
+// PoolUsage reports the bytes Pooled projects would use if each kept its own
+// copy (logical) against the bytes the pool actually stores (physical).
+func PoolUsage() (int64, int64, error) {
+	if !StorageDirectoryAvailable() {
+		return 0, 0, nil
+	}
+	pool, err := currentPool()
+	if err != nil {
+		return 0, 0, err
+	}
+	var usage struct {
+		Logical  int64 `db:"logical"`
+		Physical int64 `db:"physical"`
+	}
+	err = pool.Get(&usage, `
+		SELECT COALESCE(SUM(stored_size * refcount), 0) AS logical, COALESCE(SUM(stored_size), 0) AS physical
+		FROM pool_chunk
+	`)
+	return usage.Logical, usage.Physical, err
+}
+
+func pooledStorageAvailable() error {
+	if !StorageDirectoryAvailable() {
+		return errors.New("chunk pool requires a storage directory")
+	}
+	return nil
+}
+
+// pooledStore indexes the project's chunks in chunk_ref and keeps their
+// bytes in the Studio-wide chunk pool.
+type pooledStore struct {
+	chunkRefIndex
+	id string
+}
+
+func openPooledStore(tx *sqlx.Tx) (ChunkStore, error) {
+	id, err := projectID(tx)
+	if err != nil {
+		return nil, err
+	}
+	return pooledStore{id: id}, nil
+}
+
+func (pooledStore) Mode() string { return StorageModePooled }
+
+func (s pooledStore) Put(tx *sqlx.Tx, hash string, data []byte, size int) error {
+	key, err := acquirePoolRef(s.id, hash, data)
+	if err != nil {
+		return err
+	}
+	return s.add(tx, hash, key, size)
+}
+
+func (s pooledStore) Get(tx *sqlx.Tx, hash string) ([]byte, error) {
+	if ok, err := s.indexed(tx, hash); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, sql.ErrNoRows
+	}
+	path, _, err := poolChunkPath(hash)
+	if err != nil {
+		return nil, err
+	}
+	return readChunkFile(path)
+}
+
+func (s pooledStore) Has(tx *sqlx.Tx, hash string) (bool, error) {
+	if ok, err := s.indexed(tx, hash); err != nil || !ok {
+		return false, err
+	}
+	path, _, err := poolChunkPath(hash)
+	if err != nil {
+		return false, err
+	}
+	return fileExists(path)
+}
+
+func (s pooledStore) Delete(tx *sqlx.Tx, hash string) error {
+	if err := releasePoolRef(s.id, hash); err != nil {
+		return err
+	}
+	return s.remove(tx, hash)
+}
+
+// ReclaimableSize counts a pooled chunk only when this project holds its last
+// reference.
+func (s pooledStore) ReclaimableSize(tx *sqlx.Tx, hash string) (int64, error) {
+	return poolReclaimableSize(hash)
+}
+
+func (s pooledStore) Quarantine(tx *sqlx.Tx, hash string) (string, error) {
+	key, err := quarantinePoolChunk(s.id, hash)
+	if err != nil {
+		return "", err
+	}
+	return key, s.remove(tx, hash)
+}
+
+// SweepOrphans releases pool references the project holds for chunks it no
+// longer indexes.
+func (s pooledStore) SweepOrphans(tx *sqlx.Tx, indexed map[string]bool, cutoff time.Time, dryRun bool) (int, int64, error) {
+	stale, err := staleProjectPoolRefs(s.id, indexed, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, size := 0, int64(0)
+	for _, hash := range stale {
+		reclaimable, err := poolReclaimableSize(hash)
+		if err != nil {
+			return count, size, err
+		}
+		count++
+		size += reclaimable
+		if !dryRun {
+			if err := releasePoolRef(s.id, hash); err != nil {
+				return count, size, err
+			}
+		}
+	}
+	return count, size, nil
+}
+
+// RemoveAll gives up the project's pool references; chunks other projects
+// still reference stay in the pool.
+func (s pooledStore) RemoveAll(tx *sqlx.Tx) error {
+	return releaseProjectPoolRefs(s.id)
+}
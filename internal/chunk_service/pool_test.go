@@ -0,0 +1,65 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestPooledProjectsShareChunks(t *testing.T) {
+	storageDir := t.TempDir()
+	if err := chunk_service.ConfigureProjectStorage(storageDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureProjectStorage("") })
+
+	hash, data := compressedChunk(t, "shared library texture")
+	poolFile := filepath.Join(storageDir, "pool", "chunks", hash[:2], hash[2:4], hash)
+	projects := []string{newCompactProjectWithID(t, "project-a"), newCompactProjectWithID(t, "project-b")}
+	for _, projectPath := range projects {
+		withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+			if err := chunk_service.SetProjectStorageMode(tx, chunk_service.StorageModePooled); err != nil {
+				t.Fatal(err)
+			}
+			if err := chunk_service.StoreChunk(tx, hash, data, 22); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	logical, physical, err := chunk_service.PoolUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if physical != int64(len(data)) || logical != 2*physical {
+		t.Fatalf("logical %d, physical %d, chunk %d bytes", logical, physical, len(data))
+	}
+
+	withProjectTx(t, projects[0], func(tx *sqlx.Tx) {
+		if err := chunk_service.DeleteProjectStorage(tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := os.Stat(poolFile); err != nil {
+		t.Fatalf("chunk still referenced by project-b was removed: %v", err)
+	}
+	withProjectTx(t, projects[1], func(tx *sqlx.Tx) {
+		got, err := chunk_service.ReadChunk(tx, hash)
+		if err != nil || string(got) != string(data) {
+			t.Fatalf("read shared chunk: %v", err)
+		}
+	})
+
+	if _, err := chunk_service.CollectGarbage(projects[1], 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(poolFile); !os.IsNotExist(err) {
+		t.Fatal("expected chunk to leave the pool once its last reference was collected")
+	}
+	if logical, physical, _ = chunk_service.PoolUsage(); logical != 0 || physical != 0 {
+		t.Fatalf("expected empty pool, have logical %d physical %d", logical, physical)
+	}
+}
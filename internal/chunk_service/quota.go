@@ -0,0 +1,122 @@
+package chunk_service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Studios limit how much primary storage a project may use. A project's own
+// quota lives in its storage_quota row; projects without one use the
+// Studio's default. Servers refuse uploads that would go over a quota with a
+// 507 Insufficient Storage response whose body is a QuotaExceededError.
+
+const (
+	QuotaCodeProject   = "project_quota_exceeded"
+	QuotaCodeStudio    = "studio_quota_exceeded"
+	QuotaCodeDiskSpace = "disk_space_low"
+)
+
+// ProjectQuota is a project's storage limit in bytes. A LimitBytes of 0 means
+// unlimited. SoftLimitBytes is where admins start being warned; 0 leaves it
+// to the Studio's soft limit percentage.
+type ProjectQuota struct {
+	LimitBytes     int64 `db:"limit_bytes" json:"limit_bytes"`
+	SoftLimitBytes int64 `db:"soft_limit_bytes" json:"soft_limit_bytes"`
+}
+
+func (q ProjectQuota) Validate() error {
+	if q.LimitBytes < 0 || q.SoftLimitBytes < 0 {
+		return errors.New("quota limits cannot be negative")
+	}
+	if q.LimitBytes > 0 && q.SoftLimitBytes > q.LimitBytes {
+		return errors.New("soft limit cannot exceed the quota")
+	}
+	return nil
+}
+
+// QuotaExceededError is the body of a 507 response to an upload that would
+// exceed a quota or bring free disk space below the Studio's floor. For
+// QuotaCodeDiskSpace, LimitBytes is the floor and UsedBytes is unset.
+type QuotaExceededError struct {
+	Message        string `json:"message"`
+	Code           string `json:"code"`
+	LimitBytes     int64  `json:"limit_bytes"`
+	UsedBytes      int64  `json:"used_bytes"`
+	RequestedBytes int64  `json:"requested_bytes"`
+	AvailableBytes int64  `json:"available_bytes,omitempty"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return e.Message
+}
+
+// QuotaErrorFromResponse returns the QuotaExceededError a server sent, or nil
+// if the response is not a quota rejection.
+func QuotaErrorFromResponse(statusCode int, body []byte) error {
+	if statusCode != http.StatusInsufficientStorage {
+		return nil
+	}
+	quotaErr := &QuotaExceededError{}
+	if err := json.Unmarshal(body, quotaErr); err != nil || quotaErr.Code == "" {
+		return &QuotaExceededError{Message: strings.TrimSpace(string(body)), Code: QuotaCodeDiskSpace}
+	}
+	return quotaErr
+}
+
+// GetProjectQuota returns the project's own quota, and false if it uses the
+// Studio default.
+func GetProjectQuota(tx *sqlx.Tx) (ProjectQuota, bool, error) {
+	quota := ProjectQuota{}
+	err := tx.Get(&quota, "SELECT limit_bytes, soft_limit_bytes FROM storage_quota WHERE id = 1")
+	if errors.Is(err, sql.ErrNoRows) {
+		return quota, false, nil
+	}
+	if err != nil {
+		return quota, false, err
+	}
+	return quota, true, nil
+}
+
+func SetProjectQuota(tx *sqlx.Tx, quota ProjectQuota) error {
+	if err := quota.Validate(); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO storage_quota (id, limit_bytes, soft_limit_bytes, updated_at)
+		VALUES (1, ?, ?, unixepoch())
+		ON CONFLICT(id) DO UPDATE SET
+			limit_bytes = excluded.limit_bytes,
+			soft_limit_bytes = excluded.soft_limit_bytes,
+			updated_at = excluded.updated_at
+	`, quota.LimitBytes, quota.SoftLimitBytes)
+	return err
+}
+
+// ClearProjectQuota returns the project to the Studio default quota.
+func ClearProjectQuota(tx *sqlx.Tx) error {
+	_, err := tx.Exec("DELETE FROM storage_quota WHERE id = 1")
+	return err
+}
+
+// ProjectStorageUsage returns the bytes the project's chunks and previews
+// take on primary storage, from the sizes recorded in chunk, chunk_ref and
+// preview. Archived chunks do not count against quotas.
+func ProjectStorageUsage(tx *sqlx.Tx) (int64, error) {
+	var usage int64
+	err := tx.Get(&usage, `
+		SELECT
+			(SELECT IFNULL(SUM(size), 0) FROM chunk) +
+			(SELECT IFNULL(SUM(size), 0) FROM chunk_ref) +
+			(SELECT IFNULL(SUM(LENGTH(preview)), 0) FROM preview)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("measure project storage: %w", err)
+	}
+	return usage, nil
+}
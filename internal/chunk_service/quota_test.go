@@ -0,0 +1,89 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestProjectQuotaUsageAndRejection(t *testing.T) {
+	projectPath := newCompactProject(t)
+	hash, data := compressedChunk(t, "counted against the quota")
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.StoreChunk(tx, hash, data, len(data)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec("INSERT INTO preview (hash, preview, extension) VALUES ('preview', ?, '.png')", make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+		used, err := chunk_service.ProjectStorageUsage(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != int64(len(data))+100 {
+			t.Fatalf("usage %d, want %d", used, len(data)+100)
+		}
+
+		if _, ok, err := chunk_service.GetProjectQuota(tx); err != nil || ok {
+			t.Fatalf("new project has its own quota (%v)", err)
+		}
+		if err := chunk_service.SetProjectQuota(tx, chunk_service.ProjectQuota{LimitBytes: 10, SoftLimitBytes: 20}); err == nil {
+			t.Fatal("soft limit above the quota was accepted")
+		}
+		if err := chunk_service.SetProjectQuota(tx, chunk_service.ProjectQuota{LimitBytes: 1000, SoftLimitBytes: 800}); err != nil {
+			t.Fatal(err)
+		}
+		quota, ok, err := chunk_service.GetProjectQuota(tx)
+		if err != nil || !ok || quota.LimitBytes != 1000 || quota.SoftLimitBytes != 800 {
+			t.Fatalf("stored quota %+v, %v (%v)", quota, ok, err)
+		}
+		if err := chunk_service.ClearProjectQuota(tx); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := chunk_service.GetProjectQuota(tx); ok {
+			t.Fatal("cleared quota is still set")
+		}
+	})
+
+	// The server takes the session but refuses the bytes; the client must
+	// give up at once rather than retry.
+	puts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(chunk_service.UploadSession{Id: "session", Chunks: []string{hash}})
+		case http.MethodPut:
+			puts++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInsufficientStorage)
+			json.NewEncoder(w).Encode(chunk_service.QuotaExceededError{
+				Message:    "Project storage quota exceeded",
+				Code:       chunk_service.QuotaCodeProject,
+				LimitBytes: 1000,
+				UsedBytes:  990,
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		err := chunk_service.PushChunksResumable(context.Background(), tx, server.URL, "user-1",
+			[]chunk_service.ChunkInfo{{Hash: hash, Size: len(data)}}, func(int, int, string, string) {})
+		var quotaErr *chunk_service.QuotaExceededError
+		if !errors.As(err, &quotaErr) || quotaErr.Code != chunk_service.QuotaCodeProject || quotaErr.UsedBytes != 990 {
+			t.Fatalf("expected a project quota error, got %v", err)
+		}
+	})
+	if puts != 1 {
+		t.Fatalf("client sent %d uploads after a quota rejection", puts)
+	}
+}
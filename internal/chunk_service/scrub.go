@@ -0,0 +1,301 @@
+package chunk_service
+
+import (
+	"clustta/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const DefaultScrubBatchSize = 128
+
+var ErrScrubBusy = errors.New("chunk scrub is already running for this project")
+
+// ChunkScrubStatus is the persisted progress of the project's current or most
+// recent scrub. A scrub is in progress while StartedAt is after FinishedAt.
+type ChunkScrubStatus struct {
+	Cursor        string `db:"cursor" json:"-"`
+	ScannedChunks int    `db:"scanned_chunks" json:"scanned_chunks"`
+	CorruptChunks int    `db:"corrupt_chunks" json:"corrupt_chunks"`
+	StartedAt     int64  `db:"started_at" json:"started_at"`
+	FinishedAt    int64  `db:"finished_at" json:"finished_at"`
+}
+
+func (s ChunkScrubStatus) InProgress() bool {
+	return s.StartedAt > s.FinishedAt
+}
+
+// QuarantinedChunk is a chunk the scrubber pulled out of the live store,
+// with everything that can no longer be rebuilt without it.
+type QuarantinedChunk struct {
+	Hash          string               `db:"hash" json:"hash"`
+	Reason        string               `db:"reason" json:"reason"`
+	StorageMode   string               `db:"storage_mode" json:"storage_mode"`
+	QuarantinedAt int64                `db:"quarantined_at" json:"quarantined_at"`
+	Restored      bool                 `db:"-" json:"restored"`
+	Checkpoints   []AffectedCheckpoint `db:"-" json:"checkpoints"`
+	Templates     []AffectedTemplate   `db:"-" json:"templates"`
+}
+
+type AffectedCheckpoint struct {
+	CheckpointId   string `db:"checkpoint_id" json:"checkpoint_id"`
+	CreatedAt      string `db:"created_at" json:"created_at"`
+	AssetId        string `db:"asset_id" json:"asset_id"`
+	AssetName      string `db:"asset_name" json:"asset_name"`
+	CollectionPath string `db:"collection_path" json:"collection_path"`
+}
+
+type AffectedTemplate struct {
+	Id   string `db:"id" json:"id"`
+	Name string `db:"name" json:"name"`
+}
+
+// ChunkHealthReport describes the integrity of a project's chunk store.
+type ChunkHealthReport struct {
+	StorageMode       string             `json:"storage_mode"`
+	TotalChunks       int                `json:"total_chunks"`
+	Healthy           bool               `json:"healthy"`
+	Scrub             ChunkScrubStatus   `json:"scrub"`
+	ScrubInProgress   bool               `json:"scrub_in_progress"`
+	QuarantinedChunks []QuarantinedChunk `json:"quarantined_chunks"`
+}
+
+var (
+	activeScrubsMu sync.Mutex
+	activeScrubs   = map[string]bool{}
+)
+
+func getScrubStatus(tx *sqlx.Tx) (ChunkScrubStatus, error) {
+	var status ChunkScrubStatus
+	err := tx.Get(&status, `
+		SELECT cursor, scanned_chunks, corrupt_chunks, started_at, finished_at
+		FROM chunk_scrub WHERE id = 1
+	`)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, nil
+	}
+	return status, err
+}
+
+// ScrubChunks decompresses and re-hashes every chunk of the project, moving
+// unreadable or mismatching ones to quarantine. An interrupted scrub resumes
+// where it stopped.
+func ScrubChunks(ctx context.Context, projectPath string, batchSize int) (ChunkScrubStatus, error) {
+	activeScrubsMu.Lock()
+	if activeScrubs[projectPath] {
+		activeScrubsMu.Unlock()
+		return ChunkScrubStatus{}, ErrScrubBusy
+	}
+	activeScrubs[projectPath] = true
+	activeScrubsMu.Unlock()
+	defer func() {
+		activeScrubsMu.Lock()
+		delete(activeScrubs, projectPath)
+		activeScrubsMu.Unlock()
+	}()
+
+	if batchSize <= 0 {
+		batchSize = DefaultScrubBatchSize
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return ChunkScrubStatus{}, err
+		}
+		status, err := scrubBatch(projectPath, batchSize)
+		if err != nil || !status.InProgress() {
+			return status, err
+		}
+	}
+}
+
+func scrubBatch(projectPath string, batchSize int) (ChunkScrubStatus, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return ChunkScrubStatus{}, err
+	}
+	defer dbConn.Close()
+
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return ChunkScrubStatus{}, err
+	}
+	defer tx.Rollback()
+
+	migration, err := GetStorageMigration(tx)
+	if err == nil && migration.Status == StorageMigrationRunning {
+		return ChunkScrubStatus{}, ErrStorageMigrationInProgress
+	} else if err != nil && !errors.Is(err, ErrNoStorageMigration) {
+		return ChunkScrubStatus{}, err
+	}
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return ChunkScrubStatus{}, err
+	}
+	decoder, err := newChunkDecoder(tx)
+	if err != nil {
+		return ChunkScrubStatus{}, err
+	}
+	defer decoder.Close()
+	status, err := getScrubStatus(tx)
+	if err != nil {
+		return status, err
+	}
+	if !status.InProgress() {
+		status = ChunkScrubStatus{StartedAt: max(time.Now().Unix(), status.FinishedAt+1), FinishedAt: status.FinishedAt}
+	}
+
+	scanned := 0
+	err = store.Iterate(tx, status.Cursor, batchSize, func(chunk ChunkStat) error {
+		scanned++
+		status.ScannedChunks++
+		status.Cursor = chunk.Hash
+		data, err := store.Get(tx, chunk.Hash)
+		reason := ""
+		if errors.Is(err, sql.ErrNoRows) {
+			reason = "missing from storage"
+		} else if err != nil {
+			reason = fmt.Sprintf("unreadable: %v", err)
+		} else {
+			reason = verifyChunkData(decoder, chunk.Hash, data)
+		}
+		if reason == "" {
+			return nil
+		}
+		status.CorruptChunks++
+		return quarantineChunk(tx, store, chunk.Hash, data, reason)
+	})
+	if err != nil {
+		return status, err
+	}
+	if scanned < batchSize {
+		status.Cursor = ""
+		status.FinishedAt = max(time.Now().Unix(), status.StartedAt)
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO chunk_scrub (id, cursor, scanned_chunks, corrupt_chunks, started_at, finished_at)
+		VALUES (1, ?, ?, ?, ?, ?)
+	`, status.Cursor, status.ScannedChunks, status.CorruptChunks, status.StartedAt, status.FinishedAt)
+	if err != nil {
+		return status, err
+	}
+	return status, tx.Commit()
+}
+
+// quarantineChunk takes a bad chunk out of the live store so it reads as
+// missing and clients re-upload it, keeping whatever bytes remain for
+// inspection.
+func quarantineChunk(tx *sqlx.Tx, store ChunkStore, hash string, data []byte, reason string) error {
+	storageKey := ""
+	if quarantiner, ok := store.(ChunkQuarantiner); ok {
+		key, err := quarantiner.Quarantine(tx, hash)
+		if err != nil {
+			return err
+		}
+		storageKey = key
+		data = nil
+	} else if err := store.Delete(tx, hash); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT OR REPLACE INTO chunk_quarantine (hash, reason, storage_mode, storage_key, data, quarantined_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, hash, reason, store.Mode(), storageKey, data, time.Now().Unix())
+	return err
+}
+
+// GetChunkHealth reports quarantined chunks along with the checkpoints,
+// assets and templates they break. A chunk counts as restored once a client
+// has uploaded a good copy again.
+func GetChunkHealth(tx *sqlx.Tx) (ChunkHealthReport, error) {
+	report := ChunkHealthReport{QuarantinedChunks: []QuarantinedChunk{}}
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return report, err
+	}
+	report.StorageMode = store.Mode()
+	if err := tx.Get(&report.TotalChunks, "SELECT COUNT(*) FROM "+chunkIndexTable(store.Mode())); err != nil {
+		return report, err
+	}
+	if report.Scrub, err = getScrubStatus(tx); err != nil {
+		return report, err
+	}
+	report.ScrubInProgress = report.Scrub.InProgress()
+
+	err = tx.Select(&report.QuarantinedChunks, `
+		SELECT hash, reason, storage_mode, quarantined_at
+		FROM chunk_quarantine ORDER BY quarantined_at DESC, hash
+	`)
+	if err != nil {
+		return report, err
+	}
+	report.Healthy = true
+	for i := range report.QuarantinedChunks {
+		chunk := &report.QuarantinedChunks[i]
+		if chunk.Restored, err = store.Has(tx, chunk.Hash); err != nil {
+			return report, err
+		}
+		if !chunk.Restored {
+			report.Healthy = false
+		}
+		chunk.Checkpoints = []AffectedCheckpoint{}
+		err = tx.Select(&chunk.Checkpoints, `
+			SELECT ac.id AS checkpoint_id, ac.created_at, ac.asset_id,
+				COALESCE(a.name || a.extension, '') AS asset_name,
+				COALESCE(c.collection_path, '') AS collection_path
+			FROM asset_checkpoint ac
+			LEFT JOIN asset a ON a.id = ac.asset_id
+			LEFT JOIN collection c ON c.id = a.collection_id
+			WHERE ac.chunks != '' AND EXISTS (
+				SELECT 1 FROM json_each('["' || REPLACE(ac.chunks, ',', '","') || '"]')
+				WHERE TRIM(value) = ?
+			)
+			ORDER BY ac.created_at DESC
+		`, chunk.Hash)
+		if err != nil {
+			return report, err
+		}
+		chunk.Templates = []AffectedTemplate{}
+		err = tx.Select(&chunk.Templates, `
+			SELECT id, name || extension AS name FROM template
+			WHERE chunks != '' AND EXISTS (
+				SELECT 1 FROM json_each('["' || REPLACE(template.chunks, ',', '","') || '"]')
+				WHERE TRIM(value) = ?
+			)
+		`, chunk.Hash)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// ScrubDue reports whether the project's last completed scrub is older than
+// interval, or an earlier scrub was interrupted.
+func ScrubDue(projectPath string, interval time.Duration) (bool, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return false, err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	status, err := getScrubStatus(tx)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return false, nil
+		}
+		return false, err
+	}
+	return status.InProgress() || time.Since(time.Unix(status.FinishedAt, 0)) >= interval, nil
+}
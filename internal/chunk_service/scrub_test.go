@@ -0,0 +1,56 @@
+package chunk_service_test
+
+import (
+	"clustta/internal/chunk_service"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestScrubQuarantinesCorruptChunks(t *testing.T) {
+	projectPath := newCompactProject(t)
+	goodHash, goodData := compressedChunk(t, "healthy")
+	_, rottenData := compressedChunk(t, "bit rot")
+	badHash := strings.Repeat("e", 64)
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.StoreChunk(tx, goodHash, goodData, 7); err != nil {
+			t.Fatal(err)
+		}
+		if err := chunk_service.StoreChunk(tx, badHash, rottenData, 7); err != nil {
+			t.Fatal(err)
+		}
+		_, err := tx.Exec(`INSERT INTO asset_checkpoint
+			(id, created_at, mtime, asset_id, xxhash_checksum, time_modified, file_size, chunks, author_id)
+			VALUES ('cp1', '2026-01-01T00:00:00Z', 1, 'asset-1', 'x', 1, 14, ?, 'user-1')`, goodHash+","+badHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	status, err := chunk_service.ScrubChunks(context.Background(), projectPath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.InProgress() || status.ScannedChunks != 2 || status.CorruptChunks != 1 {
+		t.Fatalf("unexpected scrub status %#v", status)
+	}
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if chunk_service.ChunkExists(badHash, tx, map[string]bool{}) {
+			t.Fatal("expected corrupt chunk to leave the live store")
+		}
+		report, err := chunk_service.GetChunkHealth(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Healthy || report.TotalChunks != 1 || len(report.QuarantinedChunks) != 1 {
+			t.Fatalf("unexpected health report %#v", report)
+		}
+		bad := report.QuarantinedChunks[0]
+		if bad.Hash != badHash || bad.Reason != "hash mismatch" || len(bad.Checkpoints) != 1 || bad.Checkpoints[0].CheckpointId != "cp1" {
+			t.Fatalf("unexpected quarantine entry %#v", bad)
+		}
+	})
+}
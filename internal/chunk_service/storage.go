@@ -5,10 +5,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -17,6 +19,7 @@ const (
 	StorageModeCompact       = "compact"
 	StorageModeDeflated      = "deflated"
 	StorageModeObjectStorage = "object_storage"
+	StorageModePooled        = "pooled"
 )
 
 var (
@@ -30,31 +33,43 @@ func ConfigureProjectStorage(root string) error {
 	storageConfigMu.Lock()
 	defer storageConfigMu.Unlock()
 
+	forgetChunkStores()
+	closePool()
 	storageRoot = ""
 	if strings.TrimSpace(root) == "" {
 		return nil
 	}
+	absRoot, err := prepareStorageDir(root, "storage")
+	if err != nil {
+		return err
+	}
+	storageRoot = absRoot
+	return nil
+}
+
+// prepareStorageDir creates root if needed, checks that it is writable and
+// returns its absolute path. what names the directory in errors.
+func prepareStorageDir(root, what string) (string, error) {
 	absRoot, err := filepath.Abs(root)
 	if err != nil {
-		return fmt.Errorf("resolve storage directory: %w", err)
+		return "", fmt.Errorf("resolve %s directory: %w", what, err)
 	}
 	if err := os.MkdirAll(absRoot, 0750); err != nil {
-		return fmt.Errorf("create storage directory: %w", err)
+		return "", fmt.Errorf("create %s directory: %w", what, err)
 	}
 	probe, err := os.CreateTemp(absRoot, ".clustta-write-check-*")
 	if err != nil {
-		return fmt.Errorf("storage directory is not writable: %w", err)
+		return "", fmt.Errorf("%s directory is not writable: %w", what, err)
 	}
 	probeName := probe.Name()
 	if err := probe.Close(); err != nil {
 		os.Remove(probeName)
-		return fmt.Errorf("close storage directory write check: %w", err)
+		return "", fmt.Errorf("close %s directory write check: %w", what, err)
 	}
 	if err := os.Remove(probeName); err != nil {
-		return fmt.Errorf("clean storage directory write check: %w", err)
+		return "", fmt.Errorf("clean %s directory write check: %w", what, err)
 	}
-	storageRoot = absRoot
-	return nil
+	return absRoot, nil
 }
 
 func StorageDirectoryAvailable() bool {
@@ -64,31 +79,30 @@ func StorageDirectoryAvailable() bool {
 }
 
 func SupportedStorageModes() []string {
-	return []string{StorageModeCompact, StorageModeDeflated}
+	storageBackendsMu.RLock()
+	defer storageBackendsMu.RUnlock()
+	return append([]string(nil), storageModeOrder...)
 }
 
 func AvailableStorageModes() []string {
-	modes := []string{StorageModeCompact}
-	if StorageDirectoryAvailable() {
-		modes = append(modes, StorageModeDeflated)
+	modes := []string{}
+	for _, mode := range SupportedStorageModes() {
+		if ValidateStorageMode(mode) == nil {
+			modes = append(modes, mode)
+		}
 	}
 	return modes
 }
 
 func ValidateStorageMode(mode string) error {
-	switch mode {
-	case StorageModeCompact:
-		return nil
-	case StorageModeDeflated:
-		if !StorageDirectoryAvailable() {
-			return errors.New("deflated storage is not configured")
-		}
-		return nil
-	case StorageModeObjectStorage:
-		return errors.New("object storage is not implemented")
-	default:
+	backend, ok := storageBackend(mode)
+	if !ok {
 		return fmt.Errorf("unsupported storage mode %q", mode)
 	}
+	if backend.Available != nil {
+		return backend.Available()
+	}
+	return nil
 }
 
 // GetProjectStorageMode returns Compact for legacy databases without the
@@ -102,12 +116,10 @@ func GetProjectStorageMode(tx *sqlx.Tx) (string, error) {
 		}
 		return "", err
 	}
-	switch mode {
-	case StorageModeCompact, StorageModeDeflated, StorageModeObjectStorage:
-		return mode, nil
-	default:
+	if _, ok := storageBackend(mode); !ok {
 		return "", fmt.Errorf("project has invalid storage mode %q", mode)
 	}
+	return mode, nil
 }
 
 func SetProjectStorageMode(tx *sqlx.Tx, mode string) error {
@@ -143,175 +155,456 @@ func validateChunkHash(hash string) error {
 	return nil
 }
 
-func deflatedChunkPath(tx *sqlx.Tx, hash string) (string, string, error) {
-	if err := validateChunkHash(hash); err != nil {
-		return "", "", err
+func deflatedStorageAvailable() error {
+	if !StorageDirectoryAvailable() {
+		return errors.New("deflated storage is not configured")
+	}
+	return nil
+}
+
+// StoreChunk stores compressed chunk bytes using the project's selected mode.
+func StoreChunk(tx *sqlx.Tx, hash string, data []byte, size int) error {
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return err
+	}
+	if err := store.Put(tx, hash, data, size); err != nil {
+		return err
+	}
+	return RecordChunkDictionary(tx, hash, data)
+}
+
+func ReadChunk(tx *sqlx.Tx, hash string) ([]byte, error) {
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(tx, hash)
+}
+
+// DeleteProjectStorage removes the project's chunk storage kept outside the
+// project database. Compact chunks live in the database and are left alone.
+func DeleteProjectStorage(tx *sqlx.Tx) error {
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return err
+	}
+	return removeChunkStore(tx, store)
+}
+
+func removeChunkStore(tx *sqlx.Tx, store ChunkStore) error {
+	if remover, ok := store.(ChunkStoreRemover); ok {
+		return remover.RemoveAll(tx)
+	}
+	return nil
+}
+
+// writeChunkFile atomically writes data to path unless the file already
+// exists. Chunk files are content addressed, so an existing file is final.
+func writeChunkFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := replaceChunkFile(path, data); err != nil {
+		// A concurrent writer of the same chunk may have won the rename.
+		if _, statErr := os.Stat(path); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// replaceChunkFile atomically writes data to path, replacing any existing
+// file.
+func replaceChunkFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
 	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".chunk-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err = os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// readChunkFile reads a stored chunk file, reporting a missing file as
+// sql.ErrNoRows like a missing index row.
+func readChunkFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, sql.ErrNoRows
+	}
+	return data, err
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// compactStore keeps chunk bytes in the project database's chunk table.
+type compactStore struct{}
+
+func (compactStore) Mode() string { return StorageModeCompact }
+
+func (compactStore) Put(tx *sqlx.Tx, hash string, data []byte, size int) error {
+	_, err := tx.Exec("INSERT OR IGNORE INTO chunk (hash, data, size, created_at) VALUES (?, ?, ?, unixepoch())", hash, data, size)
+	return err
+}
+
+func (compactStore) Get(tx *sqlx.Tx, hash string) ([]byte, error) {
+	var data []byte
+	err := tx.Get(&data, "SELECT data FROM chunk WHERE hash = ?", hash)
+	return data, err
+}
+
+func (compactStore) Has(tx *sqlx.Tx, hash string) (bool, error) {
+	var count int
+	err := tx.Get(&count, "SELECT COUNT(*) FROM chunk WHERE hash = ?", hash)
+	return count > 0, err
+}
+
+func (compactStore) Stat(tx *sqlx.Tx, hash string) (ChunkStat, error) {
+	var stat ChunkStat
+	err := tx.Get(&stat, "SELECT hash, size, created_at FROM chunk WHERE hash = ?", hash)
+	return stat, err
+}
+
+func (compactStore) Delete(tx *sqlx.Tx, hash string) error {
+	_, err := tx.Exec("DELETE FROM chunk WHERE hash = ?", hash)
+	return err
+}
+
+func (compactStore) Iterate(tx *sqlx.Tx, after string, limit int, fn func(ChunkStat) error) error {
+	return iterateChunkIndex(tx, "chunk", after, limit, fn)
+}
+
+func (compactStore) Replace(tx *sqlx.Tx, hash string, data []byte) error {
+	_, err := tx.Exec("UPDATE chunk SET data = ? WHERE hash = ?", data, hash)
+	return err
+}
+
+func (compactStore) ReclaimableSize(tx *sqlx.Tx, hash string) (int64, error) {
+	var size int64
+	err := tx.Get(&size, "SELECT COALESCE(SUM(length(data)), 0) FROM chunk WHERE hash = ?", hash)
+	return size, err
+}
+
+// chunkRefIndex is the chunk_ref table shared by the modes that keep chunk
+// bytes outside the project database.
+type chunkRefIndex struct{}
+
+func (chunkRefIndex) add(tx *sqlx.Tx, hash, key string, size int) error {
+	_, err := tx.Exec(`
+		INSERT OR IGNORE INTO chunk_ref (hash, storage_key, size, created_at)
+		VALUES (?, ?, ?, unixepoch())
+	`, hash, key, size)
+	return err
+}
+
+func (chunkRefIndex) indexed(tx *sqlx.Tx, hash string) (bool, error) {
+	var count int
+	err := tx.Get(&count, "SELECT COUNT(*) FROM chunk_ref WHERE hash = ?", hash)
+	return count > 0, err
+}
+
+func (chunkRefIndex) remove(tx *sqlx.Tx, hash string) error {
+	_, err := tx.Exec("DELETE FROM chunk_ref WHERE hash = ?", hash)
+	return err
+}
+
+func (chunkRefIndex) Stat(tx *sqlx.Tx, hash string) (ChunkStat, error) {
+	var stat ChunkStat
+	err := tx.Get(&stat, "SELECT hash, size, created_at FROM chunk_ref WHERE hash = ?", hash)
+	return stat, err
+}
+
+func (chunkRefIndex) Iterate(tx *sqlx.Tx, after string, limit int, fn func(ChunkStat) error) error {
+	return iterateChunkIndex(tx, "chunk_ref", after, limit, fn)
+}
+
+// deflatedStore appends chunks to pack files under
+// <storage_root>/<project_id>/packs. Chunks stored before packs were
+// introduced stay as loose files under <storage_root>/<project_id>/chunks,
+// fanned out by hash prefix, until RepackChunks moves them into a pack.
+type deflatedStore struct {
+	chunkRefIndex
+	root string
+	id   string
+}
+
+func openDeflatedStore(tx *sqlx.Tx) (ChunkStore, error) {
 	storageConfigMu.RLock()
 	root := storageRoot
 	storageConfigMu.RUnlock()
 	if root == "" {
-		return "", "", errors.New("deflated storage is not configured")
+		return nil, errors.New("deflated storage is not configured")
 	}
 	id, err := projectID(tx)
 	if err != nil {
+		return nil, err
+	}
+	return deflatedStore{root: root, id: id}, nil
+}
+
+func (deflatedStore) Mode() string { return StorageModeDeflated }
+
+func (s deflatedStore) chunkPath(hash string) (string, string, error) {
+	if err := validateChunkHash(hash); err != nil {
 		return "", "", err
 	}
-	key := filepath.Join(id, "chunks", hash[:2], hash[2:4], hash)
-	return filepath.Join(root, key), filepath.ToSlash(key), nil
+	key := filepath.Join(s.id, "chunks", hash[:2], hash[2:4], hash)
+	return filepath.Join(s.root, key), filepath.ToSlash(key), nil
 }
 
-// StoreChunk stores compressed chunk bytes using the project's selected mode.
-func StoreChunk(tx *sqlx.Tx, hash string, data []byte, size int) error {
-	mode, err := GetProjectStorageMode(tx)
-	if err != nil {
+func (s deflatedStore) Put(tx *sqlx.Tx, hash string, data []byte, size int) error {
+	if err := validateChunkHash(hash); err != nil {
 		return err
 	}
-	if mode == StorageModeCompact {
-		_, err = tx.Exec("INSERT OR IGNORE INTO chunk (hash, data, size) VALUES (?, ?, ?)", hash, data, size)
+	if ok, err := s.Has(tx, hash); err != nil || ok {
 		return err
 	}
-	if mode != StorageModeDeflated {
-		return fmt.Errorf("storage mode %q is not available", mode)
-	}
-
-	path, key, err := deflatedChunkPath(tx, hash)
+	locations, err := appendToPack(s.packsDir(), []packRecord{{hash: hash, data: data}})
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+	if err := s.add(tx, hash, s.packKey(locations[0].Pack), size); err != nil {
 		return err
 	}
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		tmp, err := os.CreateTemp(filepath.Dir(path), ".chunk-*")
-		if err != nil {
+	return s.setLocation(tx, hash, locations[0])
+}
+
+// PutBatch appends the chunks not stored yet to a pack at once.
+func (s deflatedStore) PutBatch(tx *sqlx.Tx, chunks []Chunk) error {
+	records := make([]packRecord, 0, len(chunks))
+	sizes := make([]int, 0, len(chunks))
+	queued := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		if err := validateChunkHash(chunk.Hash); err != nil {
 			return err
 		}
-		tmpName := tmp.Name()
-		removeTemp := true
-		defer func() {
-			if removeTemp {
-				os.Remove(tmpName)
-			}
-		}()
-		if _, err = tmp.Write(data); err != nil {
-			tmp.Close()
-			return err
+		if queued[chunk.Hash] {
+			continue
 		}
-		if err = tmp.Sync(); err != nil {
-			tmp.Close()
+		if ok, err := s.Has(tx, chunk.Hash); err != nil {
 			return err
+		} else if ok {
+			continue
 		}
-		if err = tmp.Close(); err != nil {
+		queued[chunk.Hash] = true
+		records = append(records, packRecord{hash: chunk.Hash, data: chunk.Data})
+		sizes = append(sizes, chunk.Size)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	locations, err := appendToPack(s.packsDir(), records)
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		if err := s.add(tx, record.hash, s.packKey(locations[i].Pack), sizes[i]); err != nil {
 			return err
 		}
-		if err = os.Rename(tmpName, path); err != nil {
-			if _, statErr := os.Stat(path); statErr != nil {
-				return err
-			}
-		} else {
-			removeTemp = false
+		if err := s.setLocation(tx, record.hash, locations[i]); err != nil {
+			return err
 		}
-	} else if err != nil {
-		return err
 	}
-
-	_, err = tx.Exec(`
-		INSERT OR IGNORE INTO chunk_ref (hash, storage_key, size, created_at)
-		VALUES (?, ?, ?, unixepoch())
-	`, hash, key, size)
-	return err
+	return nil
 }
 
-func ReadChunk(tx *sqlx.Tx, hash string) ([]byte, error) {
-	mode, err := GetProjectStorageMode(tx)
-	if err != nil {
+func (s deflatedStore) Get(tx *sqlx.Tx, hash string) ([]byte, error) {
+	if ok, err := s.indexed(tx, hash); err != nil {
 		return nil, err
+	} else if !ok {
+		return nil, sql.ErrNoRows
 	}
-	if mode == StorageModeCompact {
-		var data []byte
-		err = tx.Get(&data, "SELECT data FROM chunk WHERE hash = ?", hash)
-		return data, err
-	}
-	if mode != StorageModeDeflated {
-		return nil, fmt.Errorf("storage mode %q is not available", mode)
-	}
-	var count int
-	if err := tx.Get(&count, "SELECT COUNT(*) FROM chunk_ref WHERE hash = ?", hash); err != nil {
+	location, packed, err := s.locate(tx, hash)
+	if err != nil {
 		return nil, err
 	}
-	if count == 0 {
-		return nil, sql.ErrNoRows
+	if packed {
+		return readPackRecord(s.packsDir(), location)
 	}
-	path, _, err := deflatedChunkPath(tx, hash)
+	path, _, err := s.chunkPath(hash)
 	if err != nil {
 		return nil, err
 	}
-	return os.ReadFile(path)
+	return readChunkFile(path)
 }
 
-func chunkExistsInStore(tx *sqlx.Tx, hash string) (bool, error) {
-	mode, err := GetProjectStorageMode(tx)
-	if err != nil {
+func (s deflatedStore) Has(tx *sqlx.Tx, hash string) (bool, error) {
+	if ok, err := s.indexed(tx, hash); err != nil || !ok {
 		return false, err
 	}
-	table := "chunk"
-	if mode == StorageModeDeflated {
-		table = "chunk_ref"
-	} else if mode != StorageModeCompact {
-		return false, fmt.Errorf("storage mode %q is not available", mode)
-	}
-	var count int
-	if err := tx.Get(&count, "SELECT COUNT(*) FROM "+table+" WHERE hash = ?", hash); err != nil {
+	location, packed, err := s.locate(tx, hash)
+	if err != nil {
 		return false, err
 	}
-	if count == 0 || mode == StorageModeCompact {
-		return count > 0, nil
+	if packed {
+		info, err := os.Stat(filepath.Join(s.packsDir(), location.Pack))
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil && info.Size() >= location.Offset+int64(location.Length), err
 	}
-	path, _, err := deflatedChunkPath(tx, hash)
+	path, _, err := s.chunkPath(hash)
 	if err != nil {
 		return false, err
 	}
-	_, err = os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
-		return false, nil
-	}
-	return err == nil, err
+	return fileExists(path)
 }
 
-func chunkInfoFromStore(tx *sqlx.Tx, hash string) (ChunkInfo, error) {
-	mode, err := GetProjectStorageMode(tx)
+// Delete drops the chunk from the index. A packed chunk's bytes stay in its
+// pack until the next repack.
+func (s deflatedStore) Delete(tx *sqlx.Tx, hash string) error {
+	path, _, err := s.chunkPath(hash)
 	if err != nil {
-		return ChunkInfo{}, err
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
 	}
-	var info ChunkInfo
-	if mode == StorageModeCompact {
-		err = tx.Get(&info, "SELECT hash, size FROM chunk WHERE hash = ?", hash)
-	} else if mode == StorageModeDeflated {
-		err = tx.Get(&info, "SELECT hash, size FROM chunk_ref WHERE hash = ?", hash)
-	} else {
-		err = fmt.Errorf("storage mode %q is not available", mode)
+	if _, err := tx.Exec("DELETE FROM chunk_pack WHERE hash = ?", hash); err != nil {
+		return err
 	}
-	return info, err
+	return s.remove(tx, hash)
 }
 
-func DeleteProjectStorage(tx *sqlx.Tx) error {
-	mode, err := GetProjectStorageMode(tx)
-	if err != nil || mode == StorageModeCompact {
+func (s deflatedStore) Replace(tx *sqlx.Tx, hash string, data []byte) error {
+	_, packed, err := s.locate(tx, hash)
+	if err != nil {
 		return err
 	}
-	if mode != StorageModeDeflated {
-		return fmt.Errorf("storage mode %q is not available", mode)
+	if packed {
+		locations, err := appendToPack(s.packsDir(), []packRecord{{hash: hash, data: data}})
+		if err != nil {
+			return err
+		}
+		return s.setLocation(tx, hash, locations[0])
 	}
-	id, err := projectID(tx)
+	path, _, err := s.chunkPath(hash)
 	if err != nil {
 		return err
 	}
-	storageConfigMu.RLock()
-	root := storageRoot
-	storageConfigMu.RUnlock()
-	if root == "" {
-		return errors.New("deflated storage is not configured")
+	return replaceChunkFile(path, data)
+}
+
+func (s deflatedStore) ReclaimableSize(tx *sqlx.Tx, hash string) (int64, error) {
+	location, packed, err := s.locate(tx, hash)
+	if err != nil || packed {
+		return int64(location.Length), err
+	}
+	path, _, err := s.chunkPath(hash)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
 	}
-	return os.RemoveAll(filepath.Join(root, id))
+	return info.Size(), nil
+}
+
+// Quarantine moves the chunk's bytes to
+// <storage_root>/<project_id>/quarantine.
+func (s deflatedStore) Quarantine(tx *sqlx.Tx, hash string) (string, error) {
+	path, _, err := s.chunkPath(hash)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.Join(s.id, "quarantine", hash)
+	target := filepath.Join(s.root, key)
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return "", err
+	}
+	location, packed, err := s.locate(tx, hash)
+	if err != nil {
+		return "", err
+	}
+	if packed {
+		data, err := readPackRecord(s.packsDir(), location)
+		if errors.Is(err, sql.ErrNoRows) {
+			key = ""
+		} else if err != nil {
+			return "", err
+		} else if err := replaceChunkFile(target, data); err != nil {
+			return "", err
+		}
+		if _, err := tx.Exec("DELETE FROM chunk_pack WHERE hash = ?", hash); err != nil {
+			return "", err
+		}
+	} else if err := os.Rename(path, target); errors.Is(err, os.ErrNotExist) {
+		key = ""
+	} else if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(key), s.remove(tx, hash)
+}
+
+// SweepOrphans removes loose chunk files without an index row, including
+// temp files left by interrupted writes, and packs holding no indexed chunk.
+func (s deflatedStore) SweepOrphans(tx *sqlx.Tx, indexed map[string]bool, cutoff time.Time, dryRun bool) (int, int64, error) {
+	count, size, err := s.sweepOrphanPacks(tx, cutoff, dryRun)
+	if err != nil {
+		return count, size, err
+	}
+	chunksDir := filepath.Join(s.root, s.id, "chunks")
+	err = filepath.WalkDir(chunksDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() || (indexed[name] && !strings.HasPrefix(name, ".chunk-")) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+		count++
+		size += info.Size()
+		if dryRun {
+			return nil
+		}
+		return os.Remove(filePath)
+	})
+	return count, size, err
+}
+
+func (s deflatedStore) RemoveAll(tx *sqlx.Tx) error {
+	return os.RemoveAll(filepath.Join(s.root, s.id))
 }
@@ -0,0 +1,82 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestPullStreamChunksResumesAfterDroppedConnection(t *testing.T) {
+	projectPath := newCompactProject(t)
+	hashes := []string{}
+	chunks := map[string][]byte{}
+	for _, content := range []string{"first streamed", "second streamed", "third streamed"} {
+		hash, data := compressedChunk(t, content)
+		hashes = append(hashes, hash)
+		chunks[hash] = data
+	}
+
+	var cursors []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream-chunks" {
+			http.NotFound(w, r)
+			return
+		}
+		var request struct {
+			Chunks []string `json:"chunks"`
+			Cursor string   `json:"cursor"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+			return
+		}
+		cursors = append(cursors, request.Cursor)
+		remaining := request.Chunks[slices.Index(request.Chunks, request.Cursor)+1:]
+		var stream bytes.Buffer
+		for _, hash := range remaining {
+			record, err := chunk_service.EncodeChunk(chunk_service.Chunk{Hash: hash, Data: chunks[hash]})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			stream.Write(record)
+		}
+		if len(cursors) > 1 {
+			w.Write(stream.Bytes())
+			return
+		}
+		// Send the first chunk and part of the second, then drop the connection.
+		first, _ := chunk_service.EncodeChunk(chunk_service.Chunk{Hash: remaining[0], Data: chunks[remaining[0]]})
+		w.Write(stream.Bytes()[:len(first)+10])
+		w.(http.Flusher).Flush()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	err := chunk_service.PullStreamChunks(context.Background(), projectPath, server.URL, hashes, hashes, 100, func(int, int, string, string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) != 2 || cursors[0] != "" || cursors[1] != hashes[0] {
+		t.Fatalf("expected one resumed request after %s, got cursors %v", hashes[0], cursors)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for _, hash := range hashes {
+			if got, err := chunk_service.ReadChunk(tx, hash); err != nil || !bytes.Equal(got, chunks[hash]) {
+				t.Fatalf("reading pulled chunk %s: %v", hash, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,705 @@
+package chunk_service
+
+import (
+	"clustta/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Tiered storage moves chunks that only cold checkpoints use out of the
+// project's storage into a Studio-wide archive directory, at
+// <archive>/<project id>/chunks/aa/bb/<hash>. The chunk_archive table lists
+// the archived chunks, and reads fall back to the archive transparently.
+//
+// A checkpoint is cold when the nearest collection above its asset marked in
+// collection_tier is marked cold, or when no collection is marked and either
+// the project is closed or the checkpoint is older than the policy allows and
+// a newer checkpoint of its asset exists. A hot mark keeps a subtree on fast
+// storage whatever the project state. Template chunks are never archived.
+
+const (
+	CollectionTierCold = "cold"
+	CollectionTierHot  = "hot"
+
+	// DefaultTieringBatchSize is how many chunks are moved per transaction.
+	DefaultTieringBatchSize = 128
+)
+
+var (
+	ErrArchiveUnavailable = errors.New("chunk archive is not configured")
+	ErrTieringBusy        = errors.New("chunks are already being tiered for this project")
+	ErrTieringUnsupported = errors.New("pooled storage cannot archive chunks")
+)
+
+var (
+	archiveRoot string
+
+	activeTieringsMu sync.Mutex
+	activeTierings   = map[string]bool{}
+)
+
+// ConfigureChunkArchive validates and stores the Studio-wide archive root.
+// An empty root leaves archiving unavailable; chunks already archived then
+// fail to read until it is configured again.
+func ConfigureChunkArchive(root string) error {
+	storageConfigMu.Lock()
+	defer storageConfigMu.Unlock()
+
+	forgetChunkStores()
+	archiveRoot = ""
+	if strings.TrimSpace(root) == "" {
+		return nil
+	}
+	absRoot, err := prepareStorageDir(root, "archive")
+	if err != nil {
+		return err
+	}
+	archiveRoot = absRoot
+	return nil
+}
+
+func ChunkArchiveAvailable() bool {
+	storageConfigMu.RLock()
+	defer storageConfigMu.RUnlock()
+	return archiveRoot != ""
+}
+
+// TieringPolicy decides which checkpoints are cold besides collection marks
+// and the project's closed state.
+type TieringPolicy struct {
+	// ColdAfter is the age after which superseded checkpoints are cold. Zero
+	// disables the age rule.
+	ColdAfter time.Duration
+}
+
+// TieringReport summarises one ArchiveColdChunks or WarmChunks pass.
+type TieringReport struct {
+	ColdChunks     int   `json:"cold_chunks"`
+	ArchivedChunks int   `json:"archived_chunks"`
+	ArchivedBytes  int64 `json:"archived_bytes"`
+	WarmedChunks   int   `json:"warmed_chunks"`
+	WarmedBytes    int64 `json:"warmed_bytes"`
+}
+
+// CollectionTier is a collection explicitly marked hot or cold.
+type CollectionTier struct {
+	CollectionId string `db:"collection_id" json:"collection_id"`
+	Tier         string `db:"tier" json:"tier"`
+	UpdatedAt    int64  `db:"updated_at" json:"updated_at"`
+}
+
+// TieringStatus describes a project's archive tier.
+type TieringStatus struct {
+	ArchiveAvailable bool             `json:"archive_available"`
+	ProjectClosed    bool             `json:"project_closed"`
+	ArchivedChunks   int              `json:"archived_chunks"`
+	ArchivedBytes    int64            `json:"archived_bytes"`
+	Collections      []CollectionTier `json:"collections"`
+}
+
+// SetCollectionTier marks a collection and everything below it hot or cold.
+// An empty tier removes the mark so the collection follows its parents and
+// the project policy again.
+func SetCollectionTier(tx *sqlx.Tx, collectionId, tier string) error {
+	if collectionId == "" {
+		return errors.New("collection id is required")
+	}
+	if tier == "" {
+		_, err := tx.Exec("DELETE FROM collection_tier WHERE collection_id = ?", collectionId)
+		return err
+	}
+	if tier != CollectionTierCold && tier != CollectionTierHot {
+		return fmt.Errorf("invalid collection tier %q", tier)
+	}
+	var exists bool
+	if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM collection WHERE id = ?)", collectionId); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("collection %s does not exist", collectionId)
+	}
+	_, err := tx.Exec(`
+		INSERT INTO collection_tier (collection_id, tier, updated_at)
+		VALUES (?, ?, unixepoch())
+		ON CONFLICT(collection_id) DO UPDATE SET tier = excluded.tier, updated_at = excluded.updated_at
+	`, collectionId, tier)
+	return err
+}
+
+func GetTieringStatus(tx *sqlx.Tx) (TieringStatus, error) {
+	status := TieringStatus{ArchiveAvailable: ChunkArchiveAvailable(), Collections: []CollectionTier{}}
+	closed, err := utils.GetIsClosed(tx)
+	if err != nil {
+		return status, err
+	}
+	status.ProjectClosed = closed
+	err = tx.QueryRowx("SELECT COUNT(*), IFNULL(SUM(size), 0) FROM chunk_archive").
+		Scan(&status.ArchivedChunks, &status.ArchivedBytes)
+	if err != nil {
+		return status, err
+	}
+	err = tx.Select(&status.Collections, "SELECT collection_id, tier, updated_at FROM collection_tier ORDER BY collection_id")
+	return status, err
+}
+
+// tieredCollectionsQuery resolves every collection's tier from its nearest
+// marked ancestor. Unmarked subtrees are left out.
+const tieredCollectionsQuery = `
+	tiered(id, tier) AS (
+		SELECT collection_id, tier FROM collection_tier
+		UNION
+		SELECT c.id, tiered.tier
+		FROM collection c
+		JOIN tiered ON c.parent_id = tiered.id
+		WHERE c.id NOT IN (SELECT collection_id FROM collection_tier)
+	)`
+
+// coldChunkHashes returns the chunks that only cold checkpoints use.
+func coldChunkHashes(tx *sqlx.Tx, policy TieringPolicy) ([]string, error) {
+	closed, err := utils.GetIsClosed(tx)
+	if err != nil {
+		return nil, err
+	}
+	var coldBefore int64
+	if policy.ColdAfter > 0 {
+		coldBefore = time.Now().Add(-policy.ColdAfter).Unix()
+	}
+	var hashes []string
+	err = tx.Select(&hashes, `
+		WITH RECURSIVE `+tieredCollectionsQuery+`,
+		checkpoint_tier(chunks, tier) AS (
+			SELECT ac.chunks,
+				CASE
+					WHEN tiered.tier IS NOT NULL THEN tiered.tier
+					WHEN ? THEN 'cold'
+					WHEN ? > 0 AND CAST(ac.created_at AS INTEGER) BETWEEN 1 AND ?
+						AND ac.id != (
+							SELECT latest.id FROM asset_checkpoint latest
+							WHERE latest.asset_id = ac.asset_id
+							ORDER BY CAST(latest.created_at AS INTEGER) DESC
+							LIMIT 1
+						) THEN 'cold'
+					ELSE 'hot'
+				END
+			FROM asset_checkpoint ac
+			LEFT JOIN asset a ON a.id = ac.asset_id
+			LEFT JOIN tiered ON tiered.id = a.collection_id
+			WHERE ac.chunks != ''
+		)
+		SELECT TRIM(value) AS hash
+		FROM checkpoint_tier, json_each('["' || REPLACE(chunks, ',', '","') || '"]')
+		WHERE tier = 'cold'
+		EXCEPT
+		SELECT TRIM(value)
+		FROM checkpoint_tier, json_each('["' || REPLACE(chunks, ',', '","') || '"]')
+		WHERE tier = 'hot'
+		EXCEPT
+		SELECT TRIM(value)
+		FROM template, json_each('["' || REPLACE(chunks, ',', '","') || '"]')
+		WHERE chunks != ''
+		ORDER BY hash
+	`, closed, coldBefore, coldBefore)
+	return hashes, err
+}
+
+// collectionArchivedHashes returns the archived chunks used by checkpoints
+// of assets in collectionId or below it.
+func collectionArchivedHashes(tx *sqlx.Tx, collectionId string) ([]string, error) {
+	var hashes []string
+	err := tx.Select(&hashes, `
+		WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION
+			SELECT c.id FROM collection c JOIN subtree ON c.parent_id = subtree.id
+		)
+		SELECT DISTINCT TRIM(value) AS hash
+		FROM asset_checkpoint ac
+		JOIN asset a ON a.id = ac.asset_id
+		JOIN subtree ON subtree.id = a.collection_id,
+		json_each('["' || REPLACE(ac.chunks, ',', '","') || '"]')
+		WHERE ac.chunks != '' AND TRIM(value) IN (SELECT hash FROM chunk_archive)
+		ORDER BY hash
+	`, collectionId)
+	return hashes, err
+}
+
+func startTiering(projectPath string) (func(), error) {
+	activeTieringsMu.Lock()
+	defer activeTieringsMu.Unlock()
+	if activeTierings[projectPath] {
+		return nil, ErrTieringBusy
+	}
+	activeTierings[projectPath] = true
+	return func() {
+		activeTieringsMu.Lock()
+		delete(activeTierings, projectPath)
+		activeTieringsMu.Unlock()
+	}, nil
+}
+
+// withTieringTx runs fn on the project's unencrypted backend store and
+// archive, refusing while a storage migration runs. Files fn returns are
+// removed once the transaction commits.
+func withTieringTx(projectPath string, fn func(tx *sqlx.Tx, store ChunkStore, archive chunkArchive) ([]string, error)) error {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	migration, err := GetStorageMigration(tx)
+	if err == nil && migration.Status == StorageMigrationRunning {
+		return ErrStorageMigrationInProgress
+	} else if err != nil && !errors.Is(err, ErrNoStorageMigration) {
+		return err
+	}
+	mode, err := GetProjectStorageMode(tx)
+	if err != nil {
+		return err
+	}
+	store, err := openBackendStore(tx, mode)
+	if err != nil {
+		return err
+	}
+	archive, err := openChunkArchive(tx)
+	if err != nil {
+		return err
+	}
+	obsolete, err := fn(tx, store, archive)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, path := range obsolete {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveColdChunks moves the chunks only cold checkpoints use into the
+// archive, batch by batch. Chunks are copied and indexed in the archive
+// before the project's copy is deleted in a second transaction, so an
+// interrupted pass leaves both copies and the next pass finishes the move.
+// Payloads are moved as stored; sealed chunks stay sealed.
+func ArchiveColdChunks(ctx context.Context, projectPath string, policy TieringPolicy) (TieringReport, error) {
+	done, err := startTiering(projectPath)
+	if err != nil {
+		return TieringReport{}, err
+	}
+	defer done()
+
+	report := TieringReport{}
+	var cold []string
+	err = withTieringTx(projectPath, func(tx *sqlx.Tx, store ChunkStore, archive chunkArchive) ([]string, error) {
+		mode, err := GetProjectStorageMode(tx)
+		if err != nil {
+			return nil, err
+		}
+		if backend, _ := storageBackend(mode); backend.Shared {
+			return nil, ErrTieringUnsupported
+		}
+		cold, err = coldChunkHashes(tx, policy)
+		return nil, err
+	})
+	if err != nil {
+		return report, err
+	}
+	report.ColdChunks = len(cold)
+
+	for start := 0; start < len(cold); start += DefaultTieringBatchSize {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		batch := cold[start:min(start+DefaultTieringBatchSize, len(cold))]
+		var moved []string
+		err := withTieringTx(projectPath, func(tx *sqlx.Tx, store ChunkStore, archive chunkArchive) ([]string, error) {
+			for _, hash := range batch {
+				stat, err := store.Stat(tx, hash)
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				if ok, err := archive.has(tx, hash); err != nil {
+					return nil, err
+				} else if !ok {
+					data, err := store.Get(tx, hash)
+					if errors.Is(err, sql.ErrNoRows) {
+						continue
+					} else if err != nil {
+						return nil, err
+					}
+					if err := archive.put(tx, stat, data); err != nil {
+						return nil, err
+					}
+					report.ArchivedBytes += int64(len(data))
+				}
+				moved = append(moved, hash)
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return report, err
+		}
+		err = withTieringTx(projectPath, func(tx *sqlx.Tx, store ChunkStore, archive chunkArchive) ([]string, error) {
+			for _, hash := range moved {
+				if err := store.Delete(tx, hash); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return report, err
+		}
+		report.ArchivedChunks += len(moved)
+	}
+	return report, nil
+}
+
+// WarmChunks moves archived chunks back into the project's storage. With a
+// collectionId it marks that collection hot, so later archive passes leave
+// it alone, and warms only the chunks its checkpoints use; otherwise it
+// warms every archived chunk. Chunks of an encrypted project are sealed on
+// the way back if they were archived before encryption was enabled.
+func WarmChunks(ctx context.Context, projectPath, collectionId string) (TieringReport, error) {
+	done, err := startTiering(projectPath)
+	if err != nil {
+		return TieringReport{}, err
+	}
+	defer done()
+
+	report := TieringReport{}
+	var archived []string
+	err = withTieringTx(projectPath, func(tx *sqlx.Tx, store ChunkStore, archive chunkArchive) ([]string, error) {
+		if collectionId == "" {
+			return nil, tx.Select(&archived, "SELECT hash FROM chunk_archive ORDER BY hash")
+		}
+		if err := SetCollectionTier(tx, collectionId, CollectionTierHot); err != nil {
+			return nil, err
+		}
+		var err error
+		archived, err = collectionArchivedHashes(tx, collectionId)
+		return nil, err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for start := 0; start < len(archived); start += DefaultTieringBatchSize {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		batch := archived[start:min(start+DefaultTieringBatchSize, len(archived))]
+		err := withTieringTx(projectPath, func(tx *sqlx.Tx, store ChunkStore, archive chunkArchive) ([]string, error) {
+			key, err := projectDataKey(tx)
+			if err != nil {
+				return nil, err
+			}
+			var obsolete []string
+			for _, hash := range batch {
+				stat, data, err := archive.get(tx, hash)
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				if key != nil && !isEncryptedPayload(data) {
+					if data, err = sealPayload(key, data, "chunk:"+hash); err != nil {
+						return nil, err
+					}
+				}
+				if err := store.Put(tx, hash, data, stat.Size); err != nil {
+					return nil, err
+				}
+				path, err := archive.remove(tx, hash)
+				if err != nil {
+					return nil, err
+				}
+				obsolete = append(obsolete, path)
+				report.WarmedChunks++
+				report.WarmedBytes += int64(len(data))
+			}
+			return obsolete, nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// sweepArchivedChunks is the archive's part of SweepChunks. Unreferenced
+// archived chunks older than cutoff are deleted.
+func sweepArchivedChunks(tx *sqlx.Tx, used, indexed map[string]bool, cutoff int64, dryRun bool, report *ChunkGCReport) error {
+	archive, err := openChunkArchive(tx)
+	if errors.Is(err, ErrArchiveUnavailable) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var archived []ChunkStat
+	if err := tx.Select(&archived, "SELECT hash, size, created_at FROM chunk_archive ORDER BY hash"); err != nil {
+		return err
+	}
+	for _, chunk := range archived {
+		// A chunk still in the project's storage after an interrupted archive
+		// pass was already counted there.
+		if indexed[chunk.Hash] {
+			continue
+		}
+		report.TotalChunks++
+		if used[chunk.Hash] {
+			report.ReferencedChunks++
+			continue
+		}
+		if chunk.CreatedAt > cutoff {
+			report.RecentChunks++
+			continue
+		}
+		report.ReclaimableChunks++
+		path := archive.chunkPath(chunk.Hash)
+		if info, err := os.Stat(path); err == nil {
+			report.ReclaimableBytes += info.Size()
+		}
+		if dryRun {
+			continue
+		}
+		if _, err := archive.remove(tx, chunk.Hash); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := forgetChunkDictionary(tx, chunk.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkArchive is one project's directory in the archive.
+type chunkArchive struct {
+	dir string
+}
+
+func openChunkArchive(tx *sqlx.Tx) (chunkArchive, error) {
+	storageConfigMu.RLock()
+	root := archiveRoot
+	storageConfigMu.RUnlock()
+	if root == "" {
+		return chunkArchive{}, ErrArchiveUnavailable
+	}
+	id, err := projectID(tx)
+	if err != nil {
+		return chunkArchive{}, err
+	}
+	return chunkArchive{dir: filepath.Join(root, id)}, nil
+}
+
+// hasArchivedChunks reports whether any of the project's chunks are
+// archived. Databases from before tiering have none.
+func hasArchivedChunks(tx *sqlx.Tx) (bool, error) {
+	var archived bool
+	err := tx.Get(&archived, "SELECT EXISTS(SELECT 1 FROM chunk_archive)")
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return false, nil
+	}
+	return archived, err
+}
+
+func (a chunkArchive) chunkPath(hash string) string {
+	return filepath.Join(a.dir, "chunks", hash[:2], hash[2:4], hash)
+}
+
+func (a chunkArchive) has(tx *sqlx.Tx, hash string) (bool, error) {
+	if _, err := archivedChunkStat(tx, hash); errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return fileExists(a.chunkPath(hash))
+}
+
+// archivedChunkStat returns the chunk's archive index entry, or
+// sql.ErrNoRows if it is not archived.
+func archivedChunkStat(tx *sqlx.Tx, hash string) (ChunkStat, error) {
+	var stat ChunkStat
+	err := tx.Get(&stat, "SELECT hash, size, created_at FROM chunk_archive WHERE hash = ?", hash)
+	return stat, err
+}
+
+func (a chunkArchive) get(tx *sqlx.Tx, hash string) (ChunkStat, []byte, error) {
+	stat, err := archivedChunkStat(tx, hash)
+	if err != nil {
+		return ChunkStat{}, nil, err
+	}
+	data, err := readChunkFile(a.chunkPath(hash))
+	return stat, data, err
+}
+
+// put writes the chunk file, synced to disk, before indexing it.
+func (a chunkArchive) put(tx *sqlx.Tx, stat ChunkStat, data []byte) error {
+	if err := validateChunkHash(stat.Hash); err != nil {
+		return err
+	}
+	if err := replaceChunkFile(a.chunkPath(stat.Hash), data); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO chunk_archive (hash, size, created_at, archived_at)
+		VALUES (?, ?, ?, unixepoch())
+		ON CONFLICT(hash) DO NOTHING
+	`, stat.Hash, stat.Size, stat.CreatedAt)
+	return err
+}
+
+// remove drops the chunk's index row and returns the file to delete once
+// the transaction commits.
+func (a chunkArchive) remove(tx *sqlx.Tx, hash string) (string, error) {
+	if err := validateChunkHash(hash); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("DELETE FROM chunk_archive WHERE hash = ?", hash); err != nil {
+		return "", err
+	}
+	return a.chunkPath(hash), nil
+}
+
+// tieredStore reads chunks missing from the project's storage from the
+// archive. New chunks always go to the project's storage and Iterate only
+// visits it; archived chunks are swept by SweepChunks separately.
+type tieredStore struct {
+	inner ChunkStore
+	// archive is unset when the archive is not configured, in which case
+	// reading an archived chunk fails with ErrArchiveUnavailable.
+	archive *chunkArchive
+}
+
+func openTieredStore(tx *sqlx.Tx, inner ChunkStore) (ChunkStore, error) {
+	archived, err := hasArchivedChunks(tx)
+	if err != nil || !archived {
+		return inner, err
+	}
+	archive, err := openChunkArchive(tx)
+	if errors.Is(err, ErrArchiveUnavailable) {
+		return tieredStore{inner: inner}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return tieredStore{inner: inner, archive: &archive}, nil
+}
+
+func (s tieredStore) Mode() string { return s.inner.Mode() }
+
+func (s tieredStore) Put(tx *sqlx.Tx, hash string, data []byte, size int) error {
+	return s.inner.Put(tx, hash, data, size)
+}
+
+func (s tieredStore) PutBatch(tx *sqlx.Tx, chunks []Chunk) error {
+	return putChunks(s.inner, tx, chunks)
+}
+
+func (s tieredStore) Get(tx *sqlx.Tx, hash string) ([]byte, error) {
+	data, err := s.inner.Get(tx, hash)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return data, err
+	}
+	if s.archive == nil {
+		if _, statErr := archivedChunkStat(tx, hash); statErr == nil {
+			return nil, ErrArchiveUnavailable
+		}
+		return nil, err
+	}
+	_, data, err = s.archive.get(tx, hash)
+	return data, err
+}
+
+func (s tieredStore) Has(tx *sqlx.Tx, hash string) (bool, error) {
+	if ok, err := s.inner.Has(tx, hash); err != nil || ok {
+		return ok, err
+	}
+	if s.archive == nil {
+		return false, nil
+	}
+	return s.archive.has(tx, hash)
+}
+
+func (s tieredStore) Stat(tx *sqlx.Tx, hash string) (ChunkStat, error) {
+	stat, err := s.inner.Stat(tx, hash)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return stat, err
+	}
+	return archivedChunkStat(tx, hash)
+}
+
+// Delete removes the chunk from both tiers.
+func (s tieredStore) Delete(tx *sqlx.Tx, hash string) error {
+	if err := s.inner.Delete(tx, hash); err != nil {
+		return err
+	}
+	if s.archive == nil {
+		return nil
+	}
+	path, err := s.archive.remove(tx, hash)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s tieredStore) Iterate(tx *sqlx.Tx, after string, limit int, fn func(ChunkStat) error) error {
+	return s.inner.Iterate(tx, after, limit, fn)
+}
+
+func (s tieredStore) ReclaimableSize(tx *sqlx.Tx, hash string) (int64, error) {
+	if sizer, ok := s.inner.(ChunkSizer); ok {
+		return sizer.ReclaimableSize(tx, hash)
+	}
+	return 0, nil
+}
+
+func (s tieredStore) Quarantine(tx *sqlx.Tx, hash string) (string, error) {
+	if quarantiner, ok := s.inner.(ChunkQuarantiner); ok {
+		return quarantiner.Quarantine(tx, hash)
+	}
+	return "", s.inner.Delete(tx, hash)
+}
+
+func (s tieredStore) SweepOrphans(tx *sqlx.Tx, indexed map[string]bool, cutoff time.Time, dryRun bool) (int, int64, error) {
+	if sweeper, ok := s.inner.(ChunkOrphanSweeper); ok {
+		return sweeper.SweepOrphans(tx, indexed, cutoff, dryRun)
+	}
+	return 0, 0, nil
+}
+
+func (s tieredStore) RemoveAll(tx *sqlx.Tx) error {
+	if err := removeChunkStore(tx, s.inner); err != nil {
+		return err
+	}
+	if s.archive == nil {
+		return nil
+	}
+	return os.RemoveAll(s.archive.dir)
+}
@@ -0,0 +1,180 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestTieringArchivesColdChunksAndWarmsThemUp(t *testing.T) {
+	archiveDir := t.TempDir()
+	if err := chunk_service.ConfigureChunkArchive(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chunk_service.ConfigureChunkArchive("") })
+
+	projectPath := newCompactProject(t)
+	chunks := map[string][]byte{}
+	hashes := map[string]string{}
+	now := time.Now().Unix()
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for _, content := range []string{"old only", "shared", "latest only", "other asset"} {
+			hash, data := compressedChunk(t, content)
+			hashes[content], chunks[hash] = hash, data
+			if err := chunk_service.StoreChunk(tx, hash, data, len(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, statement := range []string{
+			`INSERT INTO collection (id, created_at, mtime, name, collection_type_id, parent_id) VALUES ('shot', '', 1, 'shot', 'type', '')`,
+			`INSERT INTO collection (id, created_at, mtime, name, collection_type_id, parent_id) VALUES ('layout', '', 1, 'layout', 'type', 'shot')`,
+			`INSERT INTO asset (id, created_at, mtime, name, extension, status_id, asset_type_id, collection_id) VALUES ('plate', '', 1, 'plate', '.exr', 'status', 'type', 'layout')`,
+			`INSERT INTO asset (id, created_at, mtime, name, extension, status_id, asset_type_id) VALUES ('prop', '', 1, 'prop', '.usd', 'status', 'type')`,
+		} {
+			if _, err := tx.Exec(statement); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, checkpoint := range []struct {
+			id, asset string
+			createdAt int64
+			chunks    string
+		}{
+			{"plate-v1", "plate", now - 100*24*3600, hashes["old only"] + "," + hashes["shared"]},
+			{"plate-v2", "plate", now, hashes["shared"] + "," + hashes["latest only"]},
+			{"prop-v1", "prop", now - 100*24*3600, hashes["other asset"]},
+		} {
+			_, err := tx.Exec(`
+				INSERT INTO asset_checkpoint (id, created_at, mtime, asset_id, xxhash_checksum, time_modified, file_size, chunks, author_id)
+				VALUES (?, ?, 1, ?, '', 0, 0, ?, 'user')
+			`, checkpoint.id, checkpoint.createdAt, checkpoint.asset, checkpoint.chunks)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	archivedPath := func(hash string) string {
+		return filepath.Join(archiveDir, "project-1", "chunks", hash[:2], hash[2:4], hash)
+	}
+	inHotStore := func(hash string) bool {
+		t.Helper()
+		var hot bool
+		withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+			if err := tx.Get(&hot, "SELECT EXISTS(SELECT 1 FROM chunk WHERE hash = ?)", hash); err != nil {
+				t.Fatal(err)
+			}
+		})
+		return hot
+	}
+	archive := func() chunk_service.TieringReport {
+		t.Helper()
+		report, err := chunk_service.ArchiveColdChunks(context.Background(), projectPath, chunk_service.TieringPolicy{ColdAfter: 30 * 24 * time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	// Only the superseded checkpoint is old enough; the latest checkpoint of
+	// an asset stays hot however old it is.
+	if report := archive(); report.ArchivedChunks != 1 {
+		t.Fatalf("age policy archived %+v, want only the old checkpoint's own chunk", report)
+	}
+	old := hashes["old only"]
+	if inHotStore(old) {
+		t.Fatal("archived chunk is still in the project's storage")
+	}
+	if _, err := os.Stat(archivedPath(old)); err != nil {
+		t.Fatalf("archived chunk file: %v", err)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		data, err := chunk_service.ReadChunk(tx, old)
+		if err != nil || !bytes.Equal(data, chunks[old]) {
+			t.Fatalf("reading archived chunk: %v", err)
+		}
+		store, err := chunk_service.OpenChunkStore(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := store.Has(tx, old); err != nil || !ok {
+			t.Fatalf("archived chunk not found: %v", err)
+		}
+		if err := chunk_service.SetCollectionTier(tx, "shot", chunk_service.CollectionTierCold); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Marking the shot cold archives everything below it but not the asset
+	// outside it.
+	if report := archive(); report.ArchivedChunks != 2 {
+		t.Fatalf("cold collection archived %+v, want the remaining shot chunks", report)
+	}
+	if !inHotStore(hashes["other asset"]) {
+		t.Fatal("chunk outside the cold collection was archived")
+	}
+
+	report, err := chunk_service.WarmChunks(context.Background(), projectPath, "layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.WarmedChunks != 3 {
+		t.Fatalf("warm-up moved %+v, want every chunk of the shot", report)
+	}
+	for _, content := range []string{"old only", "shared", "latest only"} {
+		hash := hashes[content]
+		if !inHotStore(hash) {
+			t.Fatalf("%q was not warmed up", content)
+		}
+		if _, err := os.Stat(archivedPath(hash)); !os.IsNotExist(err) {
+			t.Fatalf("%q is still in the archive: %v", content, err)
+		}
+	}
+	// The hot mark on the reopened collection beats both its cold parent and
+	// the age rule.
+	if report := archive(); report.ArchivedChunks != 0 {
+		t.Fatalf("warmed collection was archived again: %+v", report)
+	}
+
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.SetCollectionTier(tx, "layout", ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec("DELETE FROM asset_checkpoint WHERE asset_id = 'plate'"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if report := archive(); report.ArchivedChunks != 0 {
+		t.Fatalf("unreferenced chunks were archived: %+v", report)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if _, err := tx.Exec("INSERT INTO chunk_archive (hash, size, created_at, archived_at) VALUES (?, 1, 1, 1)", old); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec("DELETE FROM chunk WHERE hash = ?", old); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := os.MkdirAll(filepath.Dir(archivedPath(old)), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archivedPath(old), chunks[old], 0640); err != nil {
+		t.Fatal(err)
+	}
+	gc, err := chunk_service.CollectGarbage(projectPath, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gc.ReclaimableChunks != 3 {
+		t.Fatalf("garbage collection found %+v, want the two unreferenced hot chunks and the archived one", gc)
+	}
+	if _, err := os.Stat(archivedPath(old)); !os.IsNotExist(err) {
+		t.Fatalf("unreferenced archived chunk survived garbage collection: %v", err)
+	}
+}
@@ -0,0 +1,544 @@
+package chunk_service
+
+import (
+	"bytes"
+	"clustta/internal/constants"
+	"clustta/internal/utils"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	kzstd "github.com/klauspost/compress/zstd"
+)
+
+// An upload session lets a client push chunks as one logical chunk stream
+// sent over as many requests as it takes. The server records how many bytes
+// of the stream it has taken in, keeping an incomplete trailing frame aside,
+// so a client whose connection drops asks for the offset and continues from
+// there. The stream uses the framing the server picked when the session was
+// opened; for v2 the session is only complete once the trailer has checked
+// out.
+
+const (
+	// uploadSessionTTL is how long an untouched session is kept.
+	uploadSessionTTL = 7 * 24 * time.Hour
+	// uploadCommitSize is how many stream bytes are taken in per transaction.
+	uploadCommitSize = 16 << 20
+	// maxEmptyUploadReads is how many reads in a row may return no bytes
+	// before the body is failed with io.ErrNoProgress, as bufio does.
+	maxEmptyUploadReads = 100
+)
+
+var ErrUploadSessionNotFound = errors.New("upload session not found")
+
+// UploadOffsetError is returned when a range does not start where the
+// session's stream currently ends.
+type UploadOffsetError struct {
+	Expected int64
+}
+
+func (e UploadOffsetError) Error() string {
+	return fmt.Sprintf("upload range must start at offset %d", e.Expected)
+}
+
+// UploadSession is a resumable chunk upload. Chunks lists the hashes the
+// server still needed when the session was opened, in the order the client
+// must send them. Servers that predate framing v2 leave Framing unset.
+type UploadSession struct {
+	Id             string   `json:"id"`
+	UserId         string   `json:"-"`
+	Chunks         []string `json:"chunks"`
+	Framing        int      `json:"framing"`
+	ReceivedChunks int      `json:"received_chunks"`
+	Offset         int64    `json:"offset"`
+	FailedChunks   []string `json:"failed_chunks"`
+	Complete       bool     `json:"complete"`
+	CreatedAt      int64    `json:"created_at"`
+	UpdatedAt      int64    `json:"updated_at"`
+}
+
+type uploadSessionRow struct {
+	Id             string `db:"id"`
+	UserId         string `db:"user_id"`
+	Chunks         string `db:"chunks"`
+	ReceivedChunks int    `db:"received_chunks"`
+	Offset         int64  `db:"byte_offset"`
+	Pending        []byte `db:"pending"`
+	FailedChunks   string `db:"failed_chunks"`
+	Framing        int    `db:"framing"`
+	FrameState     []byte `db:"frame_state"`
+	CreatedAt      int64  `db:"created_at"`
+	UpdatedAt      int64  `db:"updated_at"`
+}
+
+func splitHashes(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+func (row uploadSessionRow) session() UploadSession {
+	session := UploadSession{
+		Id:             row.Id,
+		UserId:         row.UserId,
+		Chunks:         splitHashes(row.Chunks),
+		Framing:        row.Framing,
+		ReceivedChunks: row.ReceivedChunks,
+		Offset:         row.Offset,
+		FailedChunks:   splitHashes(row.FailedChunks),
+		CreatedAt:      row.CreatedAt,
+		UpdatedAt:      row.UpdatedAt,
+	}
+	frames, err := row.frameDecoder()
+	session.Complete = session.ReceivedChunks == len(session.Chunks) &&
+		(len(session.Chunks) == 0 || err == nil && frames.complete())
+	return session
+}
+
+// frameDecoder restores the session's progress through its stream.
+func (row uploadSessionRow) frameDecoder() (*chunkFrameDecoder, error) {
+	if row.FrameState == nil {
+		return newChunkFrameDecoder(row.Framing)
+	}
+	decoder := &chunkFrameDecoder{}
+	return decoder, decoder.UnmarshalBinary(row.FrameState)
+}
+
+func getUploadSessionRow(tx *sqlx.Tx, id string) (uploadSessionRow, error) {
+	var row uploadSessionRow
+	err := tx.Get(&row, "SELECT * FROM upload_session WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return row, ErrUploadSessionNotFound
+	}
+	return row, err
+}
+
+// CreateUploadSession opens an upload session for the given chunks in the
+// given framing, leaving out those the project already stores. Sessions
+// untouched for a week are dropped first.
+func CreateUploadSession(tx *sqlx.Tx, userId string, chunkHashes []string, framing int) (UploadSession, error) {
+	if err := validChunkFraming(framing); err != nil {
+		return UploadSession{}, err
+	}
+	now := time.Now().Unix()
+	_, err := tx.Exec("DELETE FROM upload_session WHERE updated_at < ?", now-int64(uploadSessionTTL.Seconds()))
+	if err != nil {
+		return UploadSession{}, err
+	}
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	needed := []string{}
+	seenChunks := make(map[string]bool)
+	for _, hash := range chunkHashes {
+		if err := validateChunkHash(hash); err != nil {
+			return UploadSession{}, err
+		}
+		if StoreHasChunk(store, tx, hash, seenChunks) {
+			continue
+		}
+		seenChunks[hash] = true
+		needed = append(needed, hash)
+	}
+
+	row := uploadSessionRow{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		Chunks:    strings.Join(needed, ","),
+		Framing:   framing,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	_, err = tx.NamedExec(`
+		INSERT INTO upload_session (id, user_id, chunks, framing, created_at, updated_at)
+		VALUES (:id, :user_id, :chunks, :framing, :created_at, :updated_at)
+	`, row)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	return row.session(), nil
+}
+
+func GetUploadSession(tx *sqlx.Tx, id string) (UploadSession, error) {
+	row, err := getUploadSessionRow(tx, id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	return row.session(), nil
+}
+
+func DeleteUploadSession(tx *sqlx.Tx, id string) error {
+	result, err := tx.Exec("DELETE FROM upload_session WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return ErrUploadSessionNotFound
+	}
+	return nil
+}
+
+// WriteUploadRange appends the bytes read from body to the session's stream,
+// which must currently end at offset. Complete chunks are verified and stored
+// as they arrive and progress is committed every few megabytes, so bytes
+// taken in before body fails are kept. The returned session reflects what was
+// committed.
+func WriteUploadRange(projectPath, id string, offset int64, body io.Reader) (UploadSession, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	defer dbConn.Close()
+	store, err := openProjectChunkStore(dbConn)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	decoder, err := openChunkDecoder(dbConn)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	defer decoder.Close()
+
+	buffer := make([]byte, 1<<20)
+	for {
+		tx, err := dbConn.Beginx()
+		if err != nil {
+			return UploadSession{}, err
+		}
+		row, err := getUploadSessionRow(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return UploadSession{}, err
+		}
+		if row.Offset != offset {
+			tx.Rollback()
+			return row.session(), UploadOffsetError{Expected: row.Offset}
+		}
+
+		var readErr error
+		taken, emptyReads := 0, 0
+		for taken < uploadCommitSize && readErr == nil {
+			var n int
+			n, readErr = body.Read(buffer)
+			if n == 0 {
+				if emptyReads++; emptyReads >= maxEmptyUploadReads && readErr == nil {
+					readErr = io.ErrNoProgress
+				}
+				continue
+			}
+			emptyReads = 0
+			taken += n
+			row.Offset += int64(n)
+			row.Pending = append(row.Pending, buffer[:n]...)
+			if err := takeUploadedChunks(tx, store, decoder, &row); err != nil {
+				tx.Rollback()
+				return row.session(), err
+			}
+		}
+
+		row.UpdatedAt = time.Now().Unix()
+		_, err = tx.NamedExec(`
+			UPDATE upload_session SET received_chunks = :received_chunks, byte_offset = :byte_offset,
+				pending = :pending, failed_chunks = :failed_chunks, frame_state = :frame_state,
+				updated_at = :updated_at
+			WHERE id = :id
+		`, row)
+		if err != nil {
+			tx.Rollback()
+			return row.session(), err
+		}
+		if err := tx.Commit(); err != nil {
+			return row.session(), err
+		}
+		offset = row.Offset
+		if errors.Is(readErr, io.EOF) {
+			return row.session(), nil
+		}
+		if readErr != nil {
+			return row.session(), readErr
+		}
+	}
+}
+
+// takeUploadedChunks stores every complete chunk frame at the start of the
+// session's pending bytes.
+func takeUploadedChunks(tx *sqlx.Tx, store ChunkStore, decoder *kzstd.Decoder, row *uploadSessionRow) error {
+	chunks := splitHashes(row.Chunks)
+	frames, err := row.frameDecoder()
+	if err != nil {
+		return err
+	}
+	for {
+		size, err := frames.frameSize(row.Pending)
+		if err != nil {
+			return err
+		}
+		if len(row.Pending) < size {
+			break
+		}
+		frame, err := frames.decode(row.Pending[:size])
+		if err != nil {
+			return err
+		}
+		if frame == nil && frames.finished && row.ReceivedChunks != len(chunks) {
+			return fmt.Errorf("%w: upload ended after %d of %d chunks", ErrChunkFraming, row.ReceivedChunks, len(chunks))
+		}
+		if frame != nil {
+			if row.ReceivedChunks >= len(chunks) {
+				return errors.New("upload contains more chunks than the session lists")
+			}
+			if frame.Hash != chunks[row.ReceivedChunks] {
+				return fmt.Errorf("expected chunk %s, got %s", chunks[row.ReceivedChunks], frame.Hash)
+			}
+			compressedValue, _, err := frame.verify(decoder)
+			if err != nil {
+				if row.FailedChunks != "" {
+					row.FailedChunks += ","
+				}
+				row.FailedChunks += frame.Hash
+			} else if err := store.Put(tx, frame.Hash, compressedValue, len(compressedValue)); err != nil {
+				return err
+			} else if err := RecordChunkDictionary(tx, frame.Hash, compressedValue); err != nil {
+				return err
+			}
+			row.ReceivedChunks++
+		}
+		row.Pending = append([]byte(nil), row.Pending[size:]...)
+	}
+	state, err := frames.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	row.FrameState = state
+	return nil
+}
+
+const (
+	// uploadRangeSize is how many stream bytes the client sends per request.
+	uploadRangeSize = 8 << 20
+	// maxUploadAttempts is how many times a range is retried after a failure.
+	maxUploadAttempts = 5
+)
+
+// PushChunksResumable pushes chunks through an upload session. A range that
+// fails is resumed from the offset the server last committed, so a dropped
+// connection only costs the bytes in flight. Remotes that are local project
+// files are written directly.
+func PushChunksResumable(ctx context.Context, tx *sqlx.Tx, remoteUrl string, userId string, chunkInfos []ChunkInfo, callback func(int, int, string, string)) error {
+	if !utils.IsValidURL(remoteUrl) {
+		return PushChunksBatch(ctx, tx, remoteUrl, userId, chunkInfos, callback)
+	}
+	sessionsUrl := remoteUrl + "/upload-sessions"
+	client := &http.Client{Timeout: 5 * time.Minute}
+
+	store, err := OpenChunkStore(tx)
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, len(chunkInfos))
+	chunkSizes := make(map[string]int, len(chunkInfos))
+	totalChunksSize := 0
+	for i, chunkInfo := range chunkInfos {
+		hashes[i] = chunkInfo.Hash
+		chunkSizes[chunkInfo.Hash] = chunkInfo.Size
+		totalChunksSize += chunkInfo.Size
+	}
+
+	meter := newTransferMeter()
+	session := UploadSession{}
+	err = uploadSessionRequest(ctx, client, nil, "POST", sessionsUrl, map[string][]string{"chunks": hashes}, &session)
+	var statusErr uploadStatusError
+	if errors.As(err, &statusErr) && (statusErr.code == http.StatusNotFound || statusErr.code == http.StatusMethodNotAllowed) {
+		// Servers without upload sessions only take whole batches.
+		return PushChunksBatch(ctx, tx, remoteUrl, userId, chunkInfos, callback)
+	}
+	if err != nil {
+		return err
+	}
+	sessionUrl := sessionsUrl + "/" + session.Id
+	framing := session.Framing
+	if framing == 0 {
+		framing = ChunkFramingV1
+	}
+
+	// Chunks the server already had count as pushed.
+	processedChunks := totalChunksSize
+	for _, hash := range session.Chunks {
+		processedChunks -= chunkSizes[hash]
+	}
+	reportProgress := func(received int) {
+		done := processedChunks
+		for _, hash := range session.Chunks[:received] {
+			done += chunkSizes[hash]
+		}
+		message := fmt.Sprintf("Pushing Data %s/%s (%s)", utils.BytesToHumanReadable(done), utils.BytesToHumanReadable(totalChunksSize), meter)
+		callback(done, totalChunksSize, message, "")
+	}
+	reportProgress(0)
+
+	// recordEnds[i] is the stream offset just past chunk i's record. The
+	// first record carries the stream header and the last the trailer, whose
+	// digest is taken as each frame is first encoded.
+	recordEnds := []int64{}
+	digest := sha256.New()
+	var trailer []byte
+	encodeRecord := func(i int) ([]byte, error) {
+		data, err := store.Get(tx, session.Chunks[i])
+		if err != nil {
+			return nil, err
+		}
+		frame, err := encodeChunkFrame(framing, Chunk{Hash: session.Chunks[i], Data: data})
+		if err != nil {
+			return nil, err
+		}
+		record := frame
+		if i == 0 {
+			record = append(chunkStreamHeader(framing), frame...)
+		}
+		if i == len(recordEnds) {
+			digest.Write(frame)
+		}
+		if i == len(session.Chunks)-1 && framing == ChunkFramingV2 {
+			if trailer == nil {
+				trailer = chunkStreamTrailer(uint32(len(session.Chunks)), digest)
+			}
+			record = append(record, trailer...)
+		}
+		if i == len(recordEnds) {
+			start := int64(0)
+			if i > 0 {
+				start = recordEnds[i-1]
+			}
+			recordEnds = append(recordEnds, start+int64(len(record)))
+		}
+		return record, nil
+	}
+
+	offset := int64(0)
+	failures := 0
+	for !session.Complete {
+		// Find the chunk the stream offset falls in.
+		index := 0
+		for index < len(recordEnds) && recordEnds[index] <= offset {
+			index++
+		}
+		var body bytes.Buffer
+		for index < len(session.Chunks) && body.Len() < uploadRangeSize {
+			record, err := encodeRecord(index)
+			if err != nil {
+				return err
+			}
+			start := recordEnds[index] - int64(len(record))
+			skip := max(offset+int64(body.Len())-start, 0)
+			body.Write(record[skip:])
+			index++
+		}
+
+		rangeUrl := fmt.Sprintf("%s?offset=%d", sessionUrl, offset)
+		err := uploadSessionRequest(ctx, client, meter, "PUT", rangeUrl, body.Bytes(), &session)
+		var offsetErr UploadOffsetError
+		if errors.As(err, &offsetErr) {
+			offset = offsetErr.Expected
+			continue
+		}
+		var quotaErr *QuotaExceededError
+		if errors.As(err, &quotaErr) {
+			// Retrying cannot help until an admin frees space.
+			return err
+		}
+		if err != nil {
+			failures++
+			if failures >= maxUploadAttempts {
+				return err
+			}
+			time.Sleep(time.Duration(failures) * time.Second)
+			if err := uploadSessionRequest(ctx, client, nil, "GET", sessionUrl, nil, &session); err != nil {
+				return err
+			}
+			offset = session.Offset
+			continue
+		}
+		failures = 0
+		offset = session.Offset
+		reportProgress(session.ReceivedChunks)
+	}
+
+	uploadSessionRequest(ctx, client, nil, "DELETE", sessionUrl, nil, nil)
+	if len(session.FailedChunks) > 0 {
+		return fmt.Errorf("server rejected %d corrupt chunks", len(session.FailedChunks))
+	}
+	return nil
+}
+
+type uploadStatusError struct {
+	code    int
+	message string
+}
+
+func (e uploadStatusError) Error() string {
+	return e.message
+}
+
+// uploadSessionRequest calls an upload session endpoint. A []byte body is
+// sent as is and anything else as JSON; a 409 is returned as an
+// UploadOffsetError. A []byte body is limited by ctx's bandwidth limit and
+// counted by meter.
+func uploadSessionRequest(ctx context.Context, client *http.Client, meter *transferMeter, method, url string, body any, result *UploadSession) error {
+	var reader io.Reader
+	contentLength := 0
+	if data, ok := body.([]byte); ok {
+		reader = meter.reader(ctx, bytes.NewReader(data))
+		contentLength = len(data)
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		contentLength = len(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(contentLength)
+	req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+	AcceptChunkFraming(req)
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusInsufficientStorage:
+		return QuotaErrorFromResponse(response.StatusCode, responseBody)
+	case response.StatusCode == http.StatusConflict:
+		session := UploadSession{}
+		if err := json.Unmarshal(responseBody, &session); err != nil {
+			return err
+		}
+		return UploadOffsetError{Expected: session.Offset}
+	case response.StatusCode >= 300:
+		return uploadStatusError{code: response.StatusCode, message: string(responseBody)}
+	case result != nil:
+		return json.Unmarshal(responseBody, result)
+	}
+	return nil
+}
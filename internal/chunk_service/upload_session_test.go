@@ -0,0 +1,129 @@
+package chunk_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// brokenReader returns its bytes and then fails, like a dropped connection.
+type brokenReader struct {
+	data []byte
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadSessionResumesAfterInterruption(t *testing.T) {
+	projectPath := newCompactProject(t)
+	storedHash, storedData := compressedChunk(t, "already on the server")
+	firstHash, firstData := compressedChunk(t, "first upload")
+	secondHash, secondData := compressedChunk(t, "second upload")
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		if err := chunk_service.StoreChunk(tx, storedHash, storedData, len(storedData)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var session chunk_service.UploadSession
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		var err error
+		session, err = chunk_service.CreateUploadSession(tx, "user-1", []string{storedHash, firstHash, secondHash}, chunk_service.ChunkFramingV1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(session.Chunks) != 2 || session.Chunks[0] != firstHash || session.Chunks[1] != secondHash {
+		t.Fatalf("expected the session to skip stored chunks, got %v", session.Chunks)
+	}
+
+	stream, err := chunk_service.EncodeChunks([]chunk_service.Chunk{
+		{Hash: firstHash, Data: firstData},
+		{Hash: secondHash, Data: secondData},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drop the connection partway through the second chunk.
+	cut := len(stream) - 10
+	session, err = chunk_service.WriteUploadRange(projectPath, session.Id, 0, &brokenReader{data: stream[:cut]})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected the read error to be returned, got %v", err)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		session, err = chunk_service.GetUploadSession(tx, session.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if session.Offset != int64(cut) || session.ReceivedChunks != 1 || session.Complete {
+		t.Fatalf("unexpected progress after interruption %#v", session)
+	}
+
+	var offsetErr chunk_service.UploadOffsetError
+	_, err = chunk_service.WriteUploadRange(projectPath, session.Id, 0, bytes.NewReader(stream))
+	if !errors.As(err, &offsetErr) || offsetErr.Expected != int64(cut) {
+		t.Fatalf("expected an offset mismatch at %d, got %v", cut, err)
+	}
+	session, err = chunk_service.WriteUploadRange(projectPath, session.Id, int64(cut), bytes.NewReader(stream[cut:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.Complete || len(session.FailedChunks) != 0 {
+		t.Fatalf("expected a complete upload, got %#v", session)
+	}
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for hash, want := range map[string][]byte{firstHash: firstData, secondHash: secondData} {
+			if got, err := chunk_service.ReadChunk(tx, hash); err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("reading uploaded chunk %s: %v", hash, err)
+			}
+		}
+	})
+}
+
+// stalledReader returns its bytes and then neither data nor an error.
+type stalledReader struct {
+	data []byte
+}
+
+func (r *stalledReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadSessionFailsStalledBody(t *testing.T) {
+	projectPath := newCompactProject(t)
+	hash, data := compressedChunk(t, "stalled upload")
+	var session chunk_service.UploadSession
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		var err error
+		session, err = chunk_service.CreateUploadSession(tx, "user-1", []string{hash}, chunk_service.ChunkFramingV1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	stream, err := chunk_service.EncodeChunks([]chunk_service.Chunk{{Hash: hash, Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cut := len(stream) / 2
+	session, err = chunk_service.WriteUploadRange(projectPath, session.Id, 0, &stalledReader{data: stream[:cut]})
+	if !errors.Is(err, io.ErrNoProgress) {
+		t.Fatalf("expected io.ErrNoProgress, got %v", err)
+	}
+	if session.Offset != int64(cut) {
+		t.Fatalf("expected the bytes before the stall to be kept, got offset %d", session.Offset)
+	}
+}
@@ -43,22 +43,10 @@ func DecodeKey(b64 string) ([]byte, error) {
 // Encrypt seals plaintext using AES-256-GCM and returns the base64-encoded
 // (nonce || ciphertext || authTag) blob suitable for storage in a TEXT column.
 func Encrypt(key, plaintext []byte) (string, error) {
-	if len(key) != KeySize {
-		return "", ErrInvalidKey
-	}
-	block, err := aes.NewCipher(key)
+	sealed, err := Seal(key, plaintext, nil)
 	if err != nil {
 		return "", err
 	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
-	}
-	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
 	return base64.StdEncoding.EncodeToString(sealed), nil
 }
 
@@ -72,11 +60,27 @@ func Decrypt(key []byte, b64 string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	block, err := aes.NewCipher(key)
+	return Open(key, sealed, nil)
+}
+
+// Seal is Encrypt for binary storage: it returns the raw
+// (nonce || ciphertext || authTag) bytes. additionalData is authenticated
+// but not stored, and the same value must be passed to Open.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open reverses Seal.
+func Open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
@@ -84,5 +88,16 @@ func Decrypt(key []byte, b64 string) ([]byte, error) {
 		return nil, ErrCiphertextTooShort
 	}
 	nonce, body := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
-	return gcm.Open(nil, nonce, body, nil)
+	return gcm.Open(nil, nonce, body, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
@@ -100,3 +100,18 @@ func TestEncryptInvalidKey(t *testing.T) {
 		t.Fatal("expected error with invalid key length")
 	}
 }
+
+func TestSealBindsAdditionalData(t *testing.T) {
+	key := newKey(t)
+	sealed, err := Seal(key, []byte("chunk payload"), []byte("chunk:aa"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	got, err := Open(key, sealed, []byte("chunk:aa"))
+	if err != nil || string(got) != "chunk payload" {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := Open(key, sealed, []byte("chunk:bb")); err == nil {
+		t.Fatal("expected error opening with different additional data, got nil")
+	}
+}
@@ -0,0 +1,90 @@
+package event_service
+
+import (
+	"slices"
+	"sync"
+)
+
+// SyncTokenEvent is sent to a project's subscribers whenever its sync token
+// is written. ChangedTables names the tables the write touched, when known.
+type SyncTokenEvent struct {
+	Project       string   `json:"project"`
+	SyncToken     string   `json:"sync_token"`
+	ChangedTables []string `json:"changed_tables"`
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind by
+// before older ones are folded into newer ones.
+const subscriberBuffer = 16
+
+var (
+	mu          sync.Mutex
+	subscribers = map[string]map[chan SyncTokenEvent]struct{}{}
+)
+
+// Subscribe returns a channel receiving the project's sync token events and a
+// function that ends the subscription and closes the channel.
+func Subscribe(project string) (<-chan SyncTokenEvent, func()) {
+	events := make(chan SyncTokenEvent, subscriberBuffer)
+	mu.Lock()
+	if subscribers[project] == nil {
+		subscribers[project] = map[chan SyncTokenEvent]struct{}{}
+	}
+	subscribers[project][events] = struct{}{}
+	mu.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			mu.Lock()
+			delete(subscribers[project], events)
+			if len(subscribers[project]) == 0 {
+				delete(subscribers, project)
+			}
+			mu.Unlock()
+			close(events)
+		})
+	}
+}
+
+// PublishSyncToken tells the project's subscribers about a new sync token.
+// It never blocks: a subscriber that is behind has its oldest pending event
+// merged into this one, since only the latest token matters.
+func PublishSyncToken(project, syncToken string, changedTables []string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for events := range subscribers[project] {
+		event := SyncTokenEvent{Project: project, SyncToken: syncToken, ChangedTables: slices.Clone(changedTables)}
+		select {
+		case events <- event:
+			continue
+		default:
+		}
+		select {
+		case dropped := <-events:
+			event.ChangedTables = mergeTables(dropped.ChangedTables, event.ChangedTables)
+		default:
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Subscribers returns how many clients follow the project.
+func Subscribers(project string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(subscribers[project])
+}
+
+func mergeTables(a, b []string) []string {
+	merged := slices.Clone(a)
+	for _, table := range b {
+		if !slices.Contains(merged, table) {
+			merged = append(merged, table)
+		}
+	}
+	return merged
+}
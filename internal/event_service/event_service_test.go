@@ -0,0 +1,54 @@
+package event_service_test
+
+import (
+	"clustta/internal/event_service"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestSyncTokenEvents(t *testing.T) {
+	events, unsubscribe := event_service.Subscribe("shots")
+	other, unsubscribeOther := event_service.Subscribe("props")
+	defer unsubscribeOther()
+
+	event_service.PublishSyncToken("shots", "token-1", []string{"asset"})
+	event := <-events
+	if event.Project != "shots" || event.SyncToken != "token-1" || !slices.Equal(event.ChangedTables, []string{"asset"}) {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	select {
+	case event := <-other:
+		t.Fatalf("another project got %+v", event)
+	default:
+	}
+
+	// A subscriber that falls behind never blocks publishers and still ends
+	// on the latest token, with the dropped event's tables folded in.
+	for i := range 40 {
+		event_service.PublishSyncToken("shots", "token-"+strconv.Itoa(i+2), []string{"table-" + strconv.Itoa(i)})
+	}
+	tables := []string{}
+	for len(events) > 0 {
+		event = <-events
+		tables = append(tables, event.ChangedTables...)
+	}
+	if event.SyncToken != "token-41" {
+		t.Fatalf("last token %q", event.SyncToken)
+	}
+	for i := range 40 {
+		if !slices.Contains(tables, "table-"+strconv.Itoa(i)) {
+			t.Fatalf("table-%d was lost: %v", i, tables)
+		}
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, open := <-events; open {
+		t.Fatal("channel still open after unsubscribe")
+	}
+	if n := event_service.Subscribers("shots"); n != 0 {
+		t.Fatalf("%d subscribers left", n)
+	}
+	event_service.PublishSyncToken("shots", "token-42", nil)
+}
@@ -19,6 +19,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"clustta/internal/event_service"
 	"clustta/internal/integrations"
 	"clustta/internal/repository"
 	"clustta/internal/server/studio_integration_service"
@@ -527,10 +528,12 @@ func (l *StudioListener) applyToProject(projectPath string, a integrations.Exter
 		return "", err
 	}
 
-	// Bump sync_token so connected clients pull the new state on next poll.
+	// Bump sync_token so connected clients pull the new state.
 	assetChanged := assigneeChanged || statusChanged
+	syncToken := ""
 	if assetChanged {
-		if err := utils.SetProjectSyncToken(tx, uuid.New().String()); err != nil {
+		syncToken = uuid.New().String()
+		if err := utils.SetProjectSyncToken(tx, syncToken); err != nil {
 			return "", err
 		}
 	}
@@ -538,6 +541,10 @@ func (l *StudioListener) applyToProject(projectPath string, a integrations.Exter
 	if err := tx.Commit(); err != nil {
 		return "", err
 	}
+	if assetChanged {
+		project := strings.TrimSuffix(filepath.Base(projectPath), ".clst")
+		event_service.PublishSyncToken(project, syncToken, []string{"asset", "integration_asset_mapping"})
+	}
 	if !assetChanged {
 		if !statusSame && !statusMapped {
 			return "status-no-mapping:" + a.Status, nil
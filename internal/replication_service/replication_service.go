@@ -0,0 +1,337 @@
+package replication_service
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/chunk_service"
+	"clustta/internal/repository"
+	"clustta/internal/repository/migrations"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/sync_service"
+	"clustta/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Replica is the replication setting of a project mirrored read-only from
+// a project on another studio server, and how its last pulls went.
+type Replica struct {
+	// PrimaryUrl is the project's URL on the primary studio.
+	PrimaryUrl string `db:"primary_url" json:"primary_url"`
+	// UserId is the primary studio user the mirror pulls as. It must be an
+	// admin of the project there to receive all of its data. Its API token
+	// stays with the mirroring server and is never written to the project.
+	UserId string `db:"user_id" json:"user_id"`
+	// IntervalSeconds is how often the mirror pulls. 0 pulls whenever the
+	// primary reports a new sync token.
+	IntervalSeconds int64 `db:"interval_seconds" json:"interval_seconds"`
+	CreatedAt       int64 `db:"created_at" json:"created_at"`
+
+	// SyncToken is the primary sync token the mirror last caught up with,
+	// at the epoch time SyncedAsOf.
+	SyncToken           string `db:"sync_token" json:"sync_token"`
+	SyncedAsOf          int64  `db:"synced_as_of" json:"synced_as_of"`
+	LastAttemptAt       int64  `db:"last_attempt_at" json:"last_attempt_at"`
+	LastDurationMs      int64  `db:"last_duration_ms" json:"last_duration_ms"`
+	LastChunks          int64  `db:"last_chunks" json:"last_chunks"`
+	LastBytes           int64  `db:"last_bytes" json:"last_bytes"`
+	ConsecutiveFailures int64  `db:"consecutive_failures" json:"consecutive_failures"`
+	LastError           string `db:"last_error" json:"last_error"`
+}
+
+// Interval is how often the mirror pulls, 0 meaning continuously.
+func (r Replica) Interval() time.Duration {
+	return time.Duration(r.IntervalSeconds) * time.Second
+}
+
+// Lag is how long ago the primary was last known to match the mirror. It is
+// zero until the first pull succeeds.
+func (r Replica) Lag(now time.Time) time.Duration {
+	if r.SyncedAsOf == 0 {
+		return 0
+	}
+	return max(now.Sub(time.Unix(r.SyncedAsOf, 0)), 0)
+}
+
+func (r Replica) Validate() error {
+	if !utils.IsValidURL(r.PrimaryUrl) {
+		return errors.New("primary_url must be a project URL")
+	}
+	if r.UserId == "" {
+		return errors.New("user_id is required")
+	}
+	if r.IntervalSeconds < 0 {
+		return errors.New("interval_seconds cannot be negative")
+	}
+	return nil
+}
+
+// GetReplica returns the project's replication setting, if it is a mirror.
+func GetReplica(tx *sqlx.Tx) (Replica, bool, error) {
+	replica := Replica{}
+	err := tx.Get(&replica, `
+		SELECT primary_url, user_id, interval_seconds, created_at, sync_token, synced_as_of,
+			last_attempt_at, last_duration_ms, last_chunks, last_bytes, consecutive_failures, last_error
+		FROM replica WHERE id = 1
+	`)
+	if errors.Is(err, sql.ErrNoRows) {
+		return replica, false, nil
+	}
+	if err != nil {
+		return replica, false, err
+	}
+	return replica, true, nil
+}
+
+// SetReplica makes the project a mirror of replica.PrimaryUrl, or changes
+// how an existing mirror pulls. Metrics of an existing mirror are kept.
+func SetReplica(tx *sqlx.Tx, replica Replica) error {
+	if err := replica.Validate(); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO replica (id, primary_url, user_id, interval_seconds, created_at)
+		VALUES (1, ?, ?, ?, unixepoch())
+		ON CONFLICT(id) DO UPDATE SET
+			primary_url = excluded.primary_url,
+			user_id = excluded.user_id,
+			interval_seconds = excluded.interval_seconds
+	`, replica.PrimaryUrl, replica.UserId, replica.IntervalSeconds)
+	return err
+}
+
+// ClearReplica promotes a mirror to a writable project of its own. It stops
+// following the primary and keeps the data pulled so far.
+func ClearReplica(tx *sqlx.Tx) error {
+	_, err := tx.Exec("DELETE FROM replica WHERE id = 1")
+	return err
+}
+
+// PrimaryClient returns the HTTP client a mirror of primaryUrl pulls with,
+// signed in with the primary studio API token.
+func PrimaryClient(primaryUrl, token string) *http.Client {
+	host := ""
+	if parsed, err := url.Parse(primaryUrl); err == nil {
+		host = parsed.Host
+	}
+	return &http.Client{Transport: utils.BearerTransport{Token: token, Host: host, Base: chunk_service.StreamTransport()}}
+}
+
+// IsMirror reports whether the project at projectPath is a mirror. Projects
+// from before replication have no replica table and are not.
+func IsMirror(projectPath string) (bool, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return false, err
+	}
+	defer dbConn.Close()
+	mirrors := 0
+	err = dbConn.Get(&mirrors, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'replica'")
+	if err != nil || mirrors == 0 {
+		return false, err
+	}
+	err = dbConn.Get(&mirrors, "SELECT count(*) FROM replica")
+	return mirrors > 0, err
+}
+
+// ReadReplica returns the replication setting of the project at projectPath.
+func ReadReplica(projectPath string) (Replica, bool, error) {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return Replica{}, false, err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return Replica{}, false, err
+	}
+	defer tx.Rollback()
+	return GetReplica(tx)
+}
+
+// CreateMirror creates the project at projectPath as an empty mirror of
+// replica.PrimaryUrl, stored in storageMode, reaching the primary with token.
+// The first Replicate fills it.
+func CreateMirror(projectPath string, replica Replica, token, storageMode string) error {
+	if err := replica.Validate(); err != nil {
+		return err
+	}
+	if err := chunk_service.ValidateStorageMode(storageMode); err != nil {
+		return err
+	}
+	ctx := utils.WithHTTPClient(context.Background(), PrimaryClient(replica.PrimaryUrl, token))
+	projectInfo, err := repository.GetProjectInfoContext(ctx, replica.PrimaryUrl, auth_service.User{Id: replica.UserId})
+	if err != nil {
+		return fmt.Errorf("reach primary project: %w", err)
+	}
+
+	db, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = utils.CreateSchema(db, repository.ProjectSchema)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO config (name, value, mtime) VALUES ('project_id', ?, ?)", projectInfo.Id, utils.GetEpochTime())
+	if err != nil {
+		return err
+	}
+	err = utils.SetIsClosed(tx, projectInfo.IsClosed)
+	if err != nil {
+		return err
+	}
+	err = utils.SetProjectVersion(tx, migrations.LatestVersion)
+	if err != nil {
+		return err
+	}
+	// Like other studio projects, a mirror has no working directory.
+	err = utils.SetProjectWorkingDir(tx, "")
+	if err != nil {
+		return err
+	}
+	err = chunk_service.SetProjectStorageMode(tx, storageMode)
+	if err != nil {
+		return err
+	}
+	err = SetReplica(tx, replica)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Replicate pulls the primary's data and every chunk its checkpoints and
+// templates use into the mirror at projectPath, signed in with token, and
+// records how it went.
+// Unlike a client pull, chunks of all checkpoints are kept, not only the
+// latest ones, so the mirror can be promoted without losing history.
+func Replicate(ctx context.Context, projectPath, token string, callback func(int, int, string, string)) (Replica, error) {
+	replica, ok, err := ReadReplica(projectPath)
+	if err != nil {
+		return replica, err
+	}
+	if !ok {
+		return replica, errors.New("project is not a mirror")
+	}
+
+	start := time.Now()
+	ctx = utils.WithHTTPClient(ctx, PrimaryClient(replica.PrimaryUrl, token))
+	chunks, bytes, err := replicate(ctx, projectPath, replica, callback)
+	replica.LastAttemptAt = start.Unix()
+	replica.LastDurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		replica.ConsecutiveFailures++
+		replica.LastError = err.Error()
+	} else {
+		replica.ConsecutiveFailures = 0
+		replica.LastError = ""
+		replica.LastChunks = int64(chunks)
+		replica.LastBytes = int64(bytes)
+		replica.SyncedAsOf = start.Unix()
+	}
+
+	recordErr := recordAttempt(projectPath, &replica, err == nil)
+	if err != nil {
+		return replica, err
+	}
+	return replica, recordErr
+}
+
+func replicate(ctx context.Context, projectPath string, replica Replica, callback func(int, int, string, string)) (int, int, error) {
+	user := auth_service.User{Id: replica.UserId}
+	err := sync_service.PullDataAs(ctx, projectPath, replica.PrimaryUrl, user, false, sync_service.SyncOptions{}, callback)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	chunked := []models.Checkpoint{}
+	err = tx.Select(&chunked, `
+		SELECT chunks, file_size FROM asset_checkpoint
+		UNION ALL
+		SELECT chunks, file_size FROM template
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+	missingChunks, allChunks, totalSize, err := sync_service.CalculateCheckpointsMissingChunks(tx, chunked)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = tx.Rollback()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(missingChunks) > 0 {
+		err = chunk_service.PullStreamChunks(ctx, projectPath, replica.PrimaryUrl, missingChunks, allChunks, totalSize, callback)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(missingChunks), totalSize, nil
+}
+
+// recordAttempt saves the metrics of a pull and, after a successful one, the
+// sync token it caught up with.
+func recordAttempt(projectPath string, replica *Replica, succeeded bool) error {
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if succeeded {
+		replica.SyncToken, err = utils.GetProjectSyncToken(tx)
+		if err != nil {
+			return err
+		}
+	}
+	// A mirror promoted while pulling has no row left to update.
+	_, err = tx.NamedExec(`
+		UPDATE replica SET
+			sync_token = :sync_token,
+			synced_as_of = :synced_as_of,
+			last_attempt_at = :last_attempt_at,
+			last_duration_ms = :last_duration_ms,
+			last_chunks = :last_chunks,
+			last_bytes = :last_bytes,
+			consecutive_failures = :consecutive_failures,
+			last_error = :last_error
+		WHERE id = 1
+	`, replica)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
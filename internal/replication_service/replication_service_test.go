@@ -0,0 +1,172 @@
+package replication_service_test
+
+import (
+	"bytes"
+	"clustta/internal/chunk_service"
+	"clustta/internal/replication_service"
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/sync_service"
+	"clustta/internal/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func withProjectTx(t *testing.T, projectPath string, fn func(tx *sqlx.Tx)) {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	fn(tx)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servePrimary answers project info and /data the way the studio server
+// does, recording the credentials requests came with.
+func servePrimary(t *testing.T, projectPath string, failData *bool, credentials *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*credentials = append(*credentials, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/project":
+			json.NewEncoder(w).Encode(repository.ProjectInfo{Id: "project-1", SyncToken: "token-1"})
+		case "/project/data":
+			if *failData {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			db, err := sqlx.Open("sqlite3", projectPath)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			defer db.Close()
+			tx, err := db.Beginx()
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			defer tx.Rollback()
+			data, err := sync_service.LoadUserDataPb(tx, "user-1")
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			compressed, err := zstd.Compress(nil, data)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.Write(compressed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestReplicate(t *testing.T) {
+	primaryPath := filepath.Join(t.TempDir(), "primary.clst")
+	db, err := sqlx.Open("sqlite3", primaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(repository.ProjectSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO config(name,value,mtime) VALUES('working_dir',?,1)", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	withProjectTx(t, primaryPath, func(tx *sqlx.Tx) {
+		role, err := repository.CreateRole(tx, "role-1", "admin", models.RoleAttributes{ViewAsset: true, PullChunk: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.AddKnownUser(tx, "user-1", "user@example.com", "user", "First", "Last", role.Id, nil, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.CreateStatus(tx, "status-1", "todo", "todo", "#ffffff"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	failData := false
+	credentials := []string{}
+	primary := servePrimary(t, primaryPath, &failData, &credentials)
+	defer primary.Close()
+
+	mirrorPath := filepath.Join(t.TempDir(), "mirror.clst")
+	replica := replication_service.Replica{PrimaryUrl: primary.URL + "/project", UserId: "user-1"}
+	if err := replication_service.CreateMirror(mirrorPath, replica, "secret", chunk_service.StorageModeCompact); err != nil {
+		t.Fatal(err)
+	}
+
+	replica, err = replication_service.Replicate(context.Background(), mirrorPath, "secret", func(int, int, string, string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replica.SyncToken != "token-1" || replica.SyncedAsOf == 0 || replica.ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected replica after pull %+v", replica)
+	}
+	withProjectTx(t, mirrorPath, func(tx *sqlx.Tx) {
+		status, err := repository.GetStatus(tx, "status-1")
+		if err != nil || status.Name != "todo" {
+			t.Fatalf("status not replicated: %+v %v", status, err)
+		}
+	})
+	for _, credential := range credentials {
+		if credential != "Bearer secret" {
+			t.Fatalf("primary was sent credentials %q", credentials)
+		}
+	}
+	if mirror, err := replication_service.IsMirror(mirrorPath); err != nil || !mirror {
+		t.Fatalf("project is not known as a mirror: %v", err)
+	}
+	if project, err := os.ReadFile(mirrorPath); err != nil || bytes.Contains(project, []byte("secret")) {
+		t.Fatalf("the primary's token was written to the mirror: %v", err)
+	}
+
+	failData = true
+	syncedAsOf := replica.SyncedAsOf
+	withProjectTx(t, mirrorPath, func(tx *sqlx.Tx) {
+		if err := utils.SetProjectSyncToken(tx, "stale"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err = replication_service.Replicate(context.Background(), mirrorPath, "secret", func(int, int, string, string) {}); err == nil {
+		t.Fatal("pull from a failing primary succeeded")
+	}
+	replica, ok, err := replication_service.ReadReplica(mirrorPath)
+	if err != nil || !ok {
+		t.Fatalf("mirror lost its replica: %v", err)
+	}
+	if replica.ConsecutiveFailures != 1 || replica.LastError == "" || replica.SyncedAsOf != syncedAsOf {
+		t.Fatalf("unexpected replica after failed pull %+v", replica)
+	}
+
+	withProjectTx(t, mirrorPath, func(tx *sqlx.Tx) {
+		if err := replication_service.ClearReplica(tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if mirror, _ := replication_service.IsMirror(mirrorPath); mirror {
+		t.Fatal("promoted project is still a mirror")
+	}
+}
@@ -60,7 +60,7 @@ func CreateAssetFast(
 		return errors.New("template file does not exist")
 	}
 
-	chunkSequence, err := StoreFileChunks(tx, template_file_path, callback)
+	chunkSequence, err := StoreFileChunks(tx, template_file_path, assetTypeId, callback)
 	if err != nil {
 		return err
 	}
@@ -208,7 +208,7 @@ func CreateAsset(
 				}
 			}
 
-			Sequence, err := StoreFileChunks(tx, template_file_path, callback)
+			Sequence, err := StoreFileChunks(tx, template_file_path, assetTypeId, callback)
 			chunkSequence = Sequence
 			if err != nil {
 				return models.Asset{}, err
@@ -58,7 +58,11 @@ func CreateNewAssetCheckpoint(
 			checksum = xXHashChecksum
 		}
 
-		Sequence, err := StoreFileChunks(tx, filePath, callback)
+		assetTypeId, err := assetTypeIdOf(tx, assetId)
+		if err != nil {
+			return err
+		}
+		Sequence, err := StoreFileChunks(tx, filePath, assetTypeId, callback)
 		if err != nil {
 			return err
 		}
@@ -148,7 +152,11 @@ func CreateCheckpoint(
 			}
 		}
 
-		Sequence, err := StoreFileChunks(tx, filePath, callback)
+		assetTypeId, err := assetTypeIdOf(tx, assetId)
+		if err != nil {
+			return models.Checkpoint{}, err
+		}
+		Sequence, err := StoreFileChunks(tx, filePath, assetTypeId, callback)
 		if err != nil {
 			return models.Checkpoint{}, err
 		}
@@ -0,0 +1,257 @@
+package repository
+
+import (
+	"bytes"
+	"clustta/internal/auth_service"
+	"clustta/internal/constants"
+	"clustta/internal/settings"
+	"clustta/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jotfs/fastcdc-go"
+)
+
+// Chunking profiles set the fastcdc sizes StoreFileChunks splits files with.
+// They live in the project config table under chunking_profiles and travel
+// to clients with the project info, so a file is chunked the same way on
+// every machine. A file uses the profile mapped to its asset type, then the
+// one mapped to its extension, then the default profile.
+
+const (
+	ChunkingProfileSmallFile = "small-file"
+	ChunkingProfileDefault   = "default"
+	ChunkingProfileHugeMedia = "huge-media"
+)
+
+// maxChunkingSize keeps compressed chunks under the 16MB chunk record limit.
+const maxChunkingSize = 12 * 1024 * 1024
+
+type ChunkingProfile struct {
+	MinSize     int `json:"min_size"`
+	AverageSize int `json:"average_size"`
+	MaxSize     int `json:"max_size"`
+}
+
+type ChunkingProfiles struct {
+	Profiles map[string]ChunkingProfile `json:"profiles"`
+	Default  string                     `json:"default"`
+	// Extensions maps file extensions, such as ".json", to profile names.
+	Extensions map[string]string `json:"extensions"`
+	// AssetTypes maps asset type ids to profile names.
+	AssetTypes map[string]string `json:"asset_types"`
+}
+
+// DefaultChunkingProfiles returns the profiles of a project that has not
+// configured any. Every file uses the default profile.
+func DefaultChunkingProfiles() ChunkingProfiles {
+	kiB := 1024
+	miB := 1024 * kiB
+	return ChunkingProfiles{
+		Profiles: map[string]ChunkingProfile{
+			ChunkingProfileSmallFile: {MinSize: 2 * kiB, AverageSize: 8 * kiB, MaxSize: 64 * kiB},
+			ChunkingProfileDefault:   {MinSize: 512 * kiB, AverageSize: 1 * miB, MaxSize: 8 * miB},
+			ChunkingProfileHugeMedia: {MinSize: 2 * miB, AverageSize: 4 * miB, MaxSize: 12 * miB},
+		},
+		Default:    ChunkingProfileDefault,
+		Extensions: map[string]string{},
+		AssetTypes: map[string]string{},
+	}
+}
+
+func normalizeChunkingExtension(extension string) string {
+	extension = strings.ToLower(strings.TrimSpace(extension))
+	if extension != "" && !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+	return extension
+}
+
+// Validate checks that every profile is usable by fastcdc and that the
+// default and every mapping name an existing profile. Extensions are
+// normalised to lower case with a leading dot.
+func (p *ChunkingProfiles) Validate() error {
+	if len(p.Profiles) == 0 {
+		return errors.New("at least one chunking profile is required")
+	}
+	for name, profile := range p.Profiles {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("chunking profile name cannot be empty")
+		}
+		if profile.MinSize < 64 || profile.MaxSize <= profile.MinSize ||
+			profile.AverageSize < profile.MinSize || profile.AverageSize > profile.MaxSize {
+			return fmt.Errorf("chunking profile %q needs 64 <= min_size <= average_size <= max_size and min_size < max_size", name)
+		}
+		if profile.MaxSize > maxChunkingSize {
+			return fmt.Errorf("chunking profile %q max_size exceeds %d bytes", name, maxChunkingSize)
+		}
+	}
+	if _, ok := p.Profiles[p.Default]; !ok {
+		return fmt.Errorf("default chunking profile %q does not exist", p.Default)
+	}
+	extensions := make(map[string]string, len(p.Extensions))
+	for extension, name := range p.Extensions {
+		if _, ok := p.Profiles[name]; !ok {
+			return fmt.Errorf("extension %q uses unknown chunking profile %q", extension, name)
+		}
+		extensions[normalizeChunkingExtension(extension)] = name
+	}
+	p.Extensions = extensions
+	if p.AssetTypes == nil {
+		p.AssetTypes = map[string]string{}
+	}
+	for assetTypeId, name := range p.AssetTypes {
+		if _, ok := p.Profiles[name]; !ok {
+			return fmt.Errorf("asset type %q uses unknown chunking profile %q", assetTypeId, name)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the profile for a file with the given extension belonging
+// to an asset of the given type. assetTypeId may be empty.
+func (p ChunkingProfiles) Resolve(extension, assetTypeId string) ChunkingProfile {
+	if name, ok := p.AssetTypes[assetTypeId]; ok && assetTypeId != "" {
+		return p.Profiles[name]
+	}
+	if name, ok := p.Extensions[normalizeChunkingExtension(extension)]; ok {
+		return p.Profiles[name]
+	}
+	return p.Profiles[p.Default]
+}
+
+func (profile ChunkingProfile) fastcdcOptions() fastcdc.Options {
+	return fastcdc.Options{
+		MinSize:     profile.MinSize,
+		AverageSize: profile.AverageSize,
+		MaxSize:     profile.MaxSize,
+	}
+}
+
+func GetChunkingProfiles(tx *sqlx.Tx) (ChunkingProfiles, error) {
+	var profilesJson string
+	err := tx.Get(&profilesJson, `
+		SELECT value
+		FROM config
+		WHERE name = 'chunking_profiles'
+	`)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return DefaultChunkingProfiles(), nil
+		}
+		return ChunkingProfiles{}, err
+	}
+	var profiles ChunkingProfiles
+	err = json.Unmarshal([]byte(profilesJson), &profiles)
+	if err != nil {
+		return ChunkingProfiles{}, err
+	}
+	if err := profiles.Validate(); err != nil {
+		return ChunkingProfiles{}, err
+	}
+	return profiles, nil
+}
+
+// SaveChunkingProfiles validates and stores the project's chunking profiles.
+func SaveChunkingProfiles(tx *sqlx.Tx, profiles ChunkingProfiles) error {
+	if err := profiles.Validate(); err != nil {
+		return err
+	}
+	profilesJson, err := json.Marshal(profiles)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO config (name, value, mtime, synced)
+		VALUES ('chunking_profiles', $1, $2, 0)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, mtime = EXCLUDED.mtime, synced = 0
+	`, string(profilesJson), utils.GetEpochTime())
+	return err
+}
+
+// assetTypeIdOf returns the asset type of assetId, or "" if the asset does
+// not exist yet.
+func assetTypeIdOf(tx *sqlx.Tx, assetId string) (string, error) {
+	var assetTypeId string
+	err := tx.Get(&assetTypeId, "SELECT asset_type_id FROM asset WHERE id = ?", assetId)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return assetTypeId, err
+}
+
+func SetChunkingProfiles(projectUri, studioName string, profiles ChunkingProfiles, user auth_service.User) error {
+	if err := profiles.Validate(); err != nil {
+		return err
+	}
+	if utils.IsValidURL(projectUri) {
+		profilesJson, err := json.Marshal(profiles)
+		if err != nil {
+			return err
+		}
+		url := projectUri + "/chunking-profiles"
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(profilesJson))
+		if err != nil {
+			return err
+		}
+
+		userJson, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("UserData", string(userJson))
+		req.Header.Set("UserId", user.Id)
+		req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+		req.Header.Set("Content-Type", "application/json")
+
+		client := &http.Client{}
+		response, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 {
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				return err
+			}
+			return errors.New(string(body))
+		}
+
+		sharedProjectsDir, err := settings.GetSharedProjectDirectory()
+		if err != nil {
+			return err
+		}
+		studioProjectsDir := filepath.Join(sharedProjectsDir, studioName)
+
+		paths := strings.Split(projectUri, "/")
+
+		projectName := paths[len(paths)-1]
+		projectUri = filepath.Join(studioProjectsDir, projectName+".clst")
+	}
+
+	dbConn, err := utils.OpenDb(projectUri)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	tx, err := dbConn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = SaveChunkingProfiles(tx, profiles)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
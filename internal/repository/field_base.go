@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// FieldBase is the value a field held when the client last synced, captured
+// by the *_field_base triggers the first time a synced row is edited locally.
+type FieldBase struct {
+	TableName string `db:"table_name" json:"table_name"`
+	RowId     string `db:"row_id" json:"row_id"`
+	Field     string `db:"field" json:"field"`
+	Value     string `db:"value" json:"value"`
+}
+
+func GetFieldBases(tx *sqlx.Tx) ([]FieldBase, error) {
+	bases := []FieldBase{}
+	err := tx.Select(&bases, "SELECT table_name, row_id, field, value FROM field_base")
+	return bases, err
+}
+
+func SetFieldBase(tx *sqlx.Tx, tableName, rowId, field, value string) error {
+	_, err := tx.Exec(`
+		INSERT INTO field_base (table_name, row_id, field, value) VALUES (?, ?, ?, ?)
+		ON CONFLICT (table_name, row_id, field) DO UPDATE SET value = EXCLUDED.value
+	`, tableName, rowId, field, value)
+	return err
+}
+
+func DeleteFieldBases(tx *sqlx.Tx, tableName string, rowIds []string) error {
+	if len(rowIds) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In("DELETE FROM field_base WHERE table_name = ? AND row_id IN (?)", tableName, rowIds)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(query, args...)
+	return err
+}
+
+func ClearFieldBases(tx *sqlx.Tx) error {
+	_, err := tx.Exec("DELETE FROM field_base")
+	return err
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// IdempotentResponse is the response the studio server gave a request
+// carrying an idempotency key, kept so a retry of it can be answered the
+// same way without applying it again.
+type IdempotentResponse struct {
+	UserId      string `db:"user_id"`
+	Key         string `db:"key"`
+	Endpoint    string `db:"endpoint"`
+	RequestHash string `db:"request_hash"`
+	Status      int    `db:"status"`
+	Response    []byte `db:"response"`
+	SyncToken   string `db:"sync_token"`
+	CreatedAt   int64  `db:"created_at"`
+}
+
+// GetIdempotentResponse returns the response stored for userId's key, if any.
+func GetIdempotentResponse(tx *sqlx.Tx, userId, key string) (IdempotentResponse, bool, error) {
+	response := IdempotentResponse{}
+	err := tx.Get(&response, "SELECT * FROM idempotency_key WHERE user_id = ? AND key = ?", userId, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return response, false, nil
+	}
+	if err != nil {
+		return response, false, err
+	}
+	return response, true, nil
+}
+
+func SaveIdempotentResponse(tx *sqlx.Tx, response IdempotentResponse) error {
+	_, err := tx.NamedExec(`
+		INSERT INTO idempotency_key (user_id, key, endpoint, request_hash, status, response, sync_token, created_at)
+		VALUES (:user_id, :key, :endpoint, :request_hash, :status, :response, :sync_token, :created_at)
+	`, response)
+	return err
+}
+
+// PruneIdempotentResponses forgets responses stored before the epoch time
+// before.
+func PruneIdempotentResponses(tx *sqlx.Tx, before int64) error {
+	_, err := tx.Exec("DELETE FROM idempotency_key WHERE created_at < ?", before)
+	return err
+}
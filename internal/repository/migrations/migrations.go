@@ -7,7 +7,7 @@ import (
 )
 
 // LatestVersion is the current schema version after all migrations.
-const LatestVersion = 1.9
+const LatestVersion = 3.5
 
 // Migration defines a single schema migration step.
 type Migration struct {
@@ -27,6 +27,22 @@ func All() []Migration {
 		{Version: 1.7, Description: "Add integration tables", Up: MigrateV1_7},
 		{Version: 1.8, Description: "Rename task/entity to asset/collection", Up: MigrateV1_8},
 		{Version: 1.9, Description: "Add manage_share_links permission", Up: MigrateV1_9},
+		{Version: 2.0, Description: "Add chunk timestamps for garbage collection", Up: MigrateV2_0},
+		{Version: 2.1, Description: "Allow pooled project storage", Up: MigrateV2_1},
+		{Version: 2.2, Description: "Add project encryption keys", Up: MigrateV2_2},
+		{Version: 2.3, Description: "Add chunk pack index", Up: MigrateV2_3},
+		{Version: 2.4, Description: "Add resumable upload sessions", Up: MigrateV2_4},
+		{Version: 2.5, Description: "Add chunk compression dictionaries", Up: MigrateV2_5},
+		{Version: 2.6, Description: "Add chunk archive tier", Up: MigrateV2_6},
+		{Version: 2.7, Description: "Add project storage quotas", Up: MigrateV2_7},
+		{Version: 2.8, Description: "Add chunk framing to upload sessions", Up: MigrateV2_8},
+		{Version: 2.9, Description: "Add change log for delta data sync", Up: MigrateV2_9},
+		{Version: 3.0, Description: "Add field bases for three-way merge", Up: MigrateV3_0},
+		{Version: 3.1, Description: "Add sync audit log", Up: MigrateV3_1},
+		{Version: 3.2, Description: "Add idempotency keys", Up: MigrateV3_2},
+		{Version: 3.3, Description: "Add studio replication", Up: MigrateV3_3},
+		{Version: 3.4, Description: "Add storage mode migrations", Up: MigrateV3_4},
+		{Version: 3.5, Description: "Add chunk scrub and quarantine", Up: MigrateV3_5},
 	}
 }
 
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS project_encryption (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    key_id TEXT NOT NULL,
+    wrapped_key TEXT NOT NULL,
+    backfill_pending INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    rotated_at INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS chunk_pack (
+    hash TEXT PRIMARY KEY NOT NULL,
+    pack TEXT NOT NULL,
+    offset INTEGER NOT NULL,
+    length INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chunk_pack_pack ON chunk_pack(pack);
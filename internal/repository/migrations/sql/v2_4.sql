@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS upload_session (
+    id TEXT PRIMARY KEY NOT NULL,
+    user_id TEXT NOT NULL,
+    chunks TEXT NOT NULL,
+    received_chunks INTEGER NOT NULL DEFAULT 0,
+    byte_offset INTEGER NOT NULL DEFAULT 0,
+    pending BLOB,
+    failed_chunks TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
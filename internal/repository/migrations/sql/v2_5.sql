@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS chunk_dictionary (
+    id INTEGER PRIMARY KEY NOT NULL,
+    extension TEXT NOT NULL DEFAULT '',
+    data BLOB NOT NULL,
+    sample_chunks INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chunk_dictionary_ref (
+    hash TEXT PRIMARY KEY NOT NULL,
+    dictionary_id INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chunk_dictionary_ref_dictionary ON chunk_dictionary_ref(dictionary_id);
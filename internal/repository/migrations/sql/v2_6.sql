@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS chunk_archive (
+    hash TEXT PRIMARY KEY NOT NULL,
+    size INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    archived_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS collection_tier (
+    collection_id TEXT PRIMARY KEY NOT NULL,
+    tier TEXT NOT NULL CHECK (tier IN ('cold', 'hot')),
+    updated_at INTEGER NOT NULL
+);
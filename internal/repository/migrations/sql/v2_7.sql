@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS storage_quota (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    limit_bytes INTEGER NOT NULL,
+    soft_limit_bytes INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL
+);
@@ -0,0 +1,479 @@
+CREATE TABLE IF NOT EXISTS change_tracking (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    started_seq INTEGER NOT NULL,
+    pruned_seq INTEGER NOT NULL DEFAULT 0,
+    started_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS change_log (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    table_name TEXT NOT NULL,
+    row_id TEXT NOT NULL,
+    deleted BOOLEAN DEFAULT 0 NOT NULL,
+    changed_at INTEGER NOT NULL,
+    UNIQUE (table_name, row_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_change_log_deleted ON change_log(deleted, changed_at);
+
+CREATE TRIGGER IF NOT EXISTS role_change_insert AFTER INSERT ON role
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('role', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS role_change_update AFTER UPDATE ON role
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('role', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS role_change_delete AFTER DELETE ON role
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('role', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS user_change_insert AFTER INSERT ON user
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('user', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS user_change_update AFTER UPDATE ON user
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('user', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS user_change_delete AFTER DELETE ON user
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('user', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS status_change_insert AFTER INSERT ON status
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('status', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS status_change_update AFTER UPDATE ON status
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('status', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS status_change_delete AFTER DELETE ON status
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('status', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS tag_change_insert AFTER INSERT ON tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS tag_change_update AFTER UPDATE ON tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS tag_change_delete AFTER DELETE ON tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('tag', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_type_change_insert AFTER INSERT ON asset_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_type_change_update AFTER UPDATE ON asset_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_type_change_delete AFTER DELETE ON asset_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_type', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_change_insert AFTER INSERT ON asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_change_update AFTER UPDATE ON asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_change_delete AFTER DELETE ON asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS dependency_type_change_insert AFTER INSERT ON dependency_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('dependency_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS dependency_type_change_update AFTER UPDATE ON dependency_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('dependency_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS dependency_type_change_delete AFTER DELETE ON dependency_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('dependency_type', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_dependency_change_insert AFTER INSERT ON asset_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_dependency_change_update AFTER UPDATE ON asset_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_dependency_change_delete AFTER DELETE ON asset_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_dependency', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_dependency_change_insert AFTER INSERT ON collection_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_dependency_change_update AFTER UPDATE ON collection_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_dependency_change_delete AFTER DELETE ON collection_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_dependency', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_type_change_insert AFTER INSERT ON collection_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_type_change_update AFTER UPDATE ON collection_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_type_change_delete AFTER DELETE ON collection_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_type', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_change_insert AFTER INSERT ON collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_change_update AFTER UPDATE ON collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_change_delete AFTER DELETE ON collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_assignee_change_insert AFTER INSERT ON collection_assignee
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_assignee', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_assignee_change_update AFTER UPDATE ON collection_assignee
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_assignee', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_assignee_change_delete AFTER DELETE ON collection_assignee
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_assignee', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS template_change_insert AFTER INSERT ON template
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('template', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS template_change_update AFTER UPDATE ON template
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('template', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS template_change_delete AFTER DELETE ON template
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('template', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_change_insert AFTER INSERT ON workflow
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_change_update AFTER UPDATE ON workflow
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_change_delete AFTER DELETE ON workflow
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_link_change_insert AFTER INSERT ON workflow_link
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_link', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_link_change_update AFTER UPDATE ON workflow_link
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_link', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_link_change_delete AFTER DELETE ON workflow_link
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_link', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_collection_change_insert AFTER INSERT ON workflow_collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_collection_change_update AFTER UPDATE ON workflow_collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_collection_change_delete AFTER DELETE ON workflow_collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_collection', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_asset_change_insert AFTER INSERT ON workflow_asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_asset_change_update AFTER UPDATE ON workflow_asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_asset_change_delete AFTER DELETE ON workflow_asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_asset', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_tag_change_insert AFTER INSERT ON asset_tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_tag_change_update AFTER UPDATE ON asset_tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_tag_change_delete AFTER DELETE ON asset_tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_tag', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_checkpoint_change_insert AFTER INSERT ON asset_checkpoint
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_checkpoint', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_checkpoint_change_update AFTER UPDATE ON asset_checkpoint
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_checkpoint', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_checkpoint_change_delete AFTER DELETE ON asset_checkpoint
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_checkpoint', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_project_change_insert AFTER INSERT ON integration_project
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_project', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_project_change_update AFTER UPDATE ON integration_project
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_project', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_project_change_delete AFTER DELETE ON integration_project
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_project', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_collection_mapping_change_insert AFTER INSERT ON integration_collection_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_collection_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_collection_mapping_change_update AFTER UPDATE ON integration_collection_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_collection_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_collection_mapping_change_delete AFTER DELETE ON integration_collection_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_collection_mapping', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_asset_mapping_change_insert AFTER INSERT ON integration_asset_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_asset_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_asset_mapping_change_update AFTER UPDATE ON integration_asset_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_asset_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_asset_mapping_change_delete AFTER DELETE ON integration_asset_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_asset_mapping', OLD.id, 1, unixepoch());
+END;
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS field_base (
+    table_name TEXT NOT NULL,
+    row_id TEXT NOT NULL,
+    field TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (table_name, row_id, field)
+);
+
+CREATE TRIGGER IF NOT EXISTS asset_field_base AFTER UPDATE ON asset
+FOR EACH ROW
+WHEN OLD.mtime != NEW.mtime AND OLD.synced = 1 AND NOT EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR IGNORE INTO field_base (table_name, row_id, field, value) VALUES
+        ('asset', OLD.id, 'name', OLD.name),
+        ('asset', OLD.id, 'collection_id', OLD.collection_id),
+        ('asset', OLD.id, 'asset_type_id', OLD.asset_type_id),
+        ('asset', OLD.id, 'assignee_id', OLD.assignee_id),
+        ('asset', OLD.id, 'assigner_id', OLD.assigner_id),
+        ('asset', OLD.id, 'status_id', OLD.status_id),
+        ('asset', OLD.id, 'preview_id', OLD.preview_id),
+        ('asset', OLD.id, 'is_resource', OLD.is_resource),
+        ('asset', OLD.id, 'is_link', OLD.is_link),
+        ('asset', OLD.id, 'pointer', OLD.pointer);
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_field_base AFTER UPDATE ON collection
+FOR EACH ROW
+WHEN OLD.mtime != NEW.mtime AND OLD.synced = 1 AND NOT EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR IGNORE INTO field_base (table_name, row_id, field, value) VALUES
+        ('collection', OLD.id, 'name', OLD.name),
+        ('collection', OLD.id, 'parent_id', OLD.parent_id),
+        ('collection', OLD.id, 'collection_type_id', OLD.collection_type_id),
+        ('collection', OLD.id, 'preview_id', OLD.preview_id),
+        ('collection', OLD.id, 'is_shared', OLD.is_shared);
+END;
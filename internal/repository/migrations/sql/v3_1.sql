@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sync_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time INTEGER NOT NULL,
+    user_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    client_agent TEXT DEFAULT '' NOT NULL,
+    tables TEXT DEFAULT '{}' NOT NULL,
+    tombs TEXT DEFAULT '[]' NOT NULL,
+    bytes INTEGER DEFAULT 0 NOT NULL,
+    sync_token TEXT DEFAULT '' NOT NULL,
+    detail TEXT DEFAULT '' NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_audit_time ON sync_audit(time);
+CREATE INDEX IF NOT EXISTS idx_sync_audit_user ON sync_audit(user_id, time);
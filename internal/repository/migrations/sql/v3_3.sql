@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS replica (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    primary_url TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    interval_seconds INTEGER DEFAULT 0 NOT NULL,
+    created_at INTEGER NOT NULL,
+    sync_token TEXT DEFAULT '' NOT NULL,
+    synced_as_of INTEGER DEFAULT 0 NOT NULL,
+    last_attempt_at INTEGER DEFAULT 0 NOT NULL,
+    last_duration_ms INTEGER DEFAULT 0 NOT NULL,
+    last_chunks INTEGER DEFAULT 0 NOT NULL,
+    last_bytes INTEGER DEFAULT 0 NOT NULL,
+    consecutive_failures INTEGER DEFAULT 0 NOT NULL,
+    last_error TEXT DEFAULT '' NOT NULL
+);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS storage_migration (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    source_mode TEXT NOT NULL,
+    target_mode TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('running', 'failed', 'completed')),
+    cursor TEXT NOT NULL DEFAULT '',
+    total_chunks INTEGER NOT NULL DEFAULT 0,
+    migrated_chunks INTEGER NOT NULL DEFAULT 0,
+    migrated_bytes INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    started_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS storage_migration_failure (
+    hash TEXT PRIMARY KEY NOT NULL,
+    reason TEXT NOT NULL
+);
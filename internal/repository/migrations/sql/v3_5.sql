@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS chunk_quarantine (
+    hash TEXT PRIMARY KEY NOT NULL,
+    reason TEXT NOT NULL,
+    storage_mode TEXT NOT NULL,
+    storage_key TEXT NOT NULL DEFAULT '',
+    data BLOB,
+    quarantined_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chunk_scrub (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    cursor TEXT NOT NULL DEFAULT '',
+    scanned_chunks INTEGER NOT NULL DEFAULT 0,
+    corrupt_chunks INTEGER NOT NULL DEFAULT 0,
+    started_at INTEGER NOT NULL DEFAULT 0,
+    finished_at INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,14 @@
+package migrations
+
+import (
+	"clustta/internal/utils"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrateV2_0 records when compact chunks were stored so garbage collection
+// can spare chunks uploaded ahead of the checkpoints that reference them.
+// Existing chunks get 0, which places them outside any grace period.
+func MigrateV2_0(db *sqlx.DB, schema string) error {
+	return utils.AddColumnIfNotExist(db, "chunk", "created_at", "INTEGER", "0", false)
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrateV2_1 rebuilds project_storage so its mode CHECK accepts the pooled
+// storage mode, preserving the project's current mode.
+func MigrateV2_1(db *sqlx.DB, schema string) error {
+	mode := "compact"
+	err := db.Get(&mode, "SELECT mode FROM project_storage WHERE id = 1")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !strings.Contains(err.Error(), "no such table") {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DROP TABLE IF EXISTS project_storage",
+		`CREATE TABLE project_storage (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			mode TEXT NOT NULL CHECK (mode IN ('compact', 'deflated', 'object_storage', 'pooled')),
+			updated_at INTEGER NOT NULL
+		)`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO project_storage (id, mode, updated_at) VALUES (1, ?, unixepoch())", mode); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v2_2.sql
+var v2_2SQL string
+
+// MigrateV2_2 adds the table holding the project's data key, wrapped by the
+// studio master key, for encryption at rest.
+func MigrateV2_2(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v2_2SQL)
+	return err
+}
@@ -0,0 +1,16 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v2_3.sql
+var v2_3SQL string
+
+// MigrateV2_3 adds the index of where deflated chunks sit in their packs.
+func MigrateV2_3(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v2_3SQL)
+	return err
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v2_4.sql
+var v2_4SQL string
+
+// MigrateV2_4 adds the upload sessions that let an interrupted chunk push
+// resume where the server stopped receiving.
+func MigrateV2_4(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v2_4SQL)
+	return err
+}
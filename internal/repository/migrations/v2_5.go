@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v2_5.sql
+var v2_5SQL string
+
+// MigrateV2_5 adds the trained zstd dictionaries and which one each chunk
+// was compressed with.
+func MigrateV2_5(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v2_5SQL)
+	return err
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v2_6.sql
+var v2_6SQL string
+
+// MigrateV2_6 adds the index of chunks moved to the archive tier and the
+// collections marked hot or cold.
+func MigrateV2_6(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v2_6SQL)
+	return err
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v2_7.sql
+var v2_7SQL string
+
+// MigrateV2_7 adds the project's own storage quota, overriding the studio
+// default.
+func MigrateV2_7(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v2_7SQL)
+	return err
+}
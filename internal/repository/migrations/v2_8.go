@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"clustta/internal/utils"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrateV2_8 records the chunk framing of upload sessions and their progress
+// through a v2 stream. Open sessions keep framing v1.
+func MigrateV2_8(db *sqlx.DB, schema string) error {
+	err := utils.AddColumnIfNotExist(db, "upload_session", "framing", "INTEGER", "1", false)
+	if err != nil {
+		return err
+	}
+	return utils.AddColumnIfNotExist(db, "upload_session", "frame_state", "BLOB", "", true)
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v2_9.sql
+var v2_9SQL string
+
+// MigrateV2_9 adds the change log behind delta data sync, with the triggers
+// that record every row change once tracking is on. Tracking stays off
+// until a studio server enables it.
+func MigrateV2_9(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v2_9SQL)
+	return err
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v3_0.sql
+var v3_0SQL string
+
+// MigrateV3_0 adds the field values clients last synced, kept by triggers on
+// asset and collection edits, that let the studio server three-way merge
+// concurrent metadata edits.
+func MigrateV3_0(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v3_0SQL)
+	return err
+}
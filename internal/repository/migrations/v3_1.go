@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v3_1.sql
+var v3_1SQL string
+
+// MigrateV3_1 adds the log of pushes the studio server received, with its
+// lookups by time and by user.
+func MigrateV3_1(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v3_1SQL)
+	return err
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v3_2.sql
+var v3_2SQL string
+
+// MigrateV3_2 adds the stored responses that answer retried pushes and
+// patches sent with the same Idempotency-Key.
+func MigrateV3_2(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v3_2SQL)
+	return err
+}
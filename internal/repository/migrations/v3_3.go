@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v3_3.sql
+var v3_3SQL string
+
+// MigrateV3_3 adds the replica row that marks a project as a read-only
+// mirror of a project on another studio and records how its pulls went.
+func MigrateV3_3(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v3_3SQL)
+	return err
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v3_4.sql
+var v3_4SQL string
+
+// MigrateV3_4 adds the progress of a project's storage mode migration and the
+// chunks it could not copy, so an interrupted migration resumes.
+func MigrateV3_4(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v3_4SQL)
+	return err
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/v3_5.sql
+var v3_5SQL string
+
+// MigrateV3_5 adds the quarantine for chunks the scrubber found corrupt and
+// the scrubber's progress through the project.
+func MigrateV3_5(db *sqlx.DB, _ string) error {
+	_, err := db.Exec(v3_5SQL)
+	return err
+}
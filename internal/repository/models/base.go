@@ -321,7 +321,7 @@ func (cp *Checkpoint) HasMissingChunks(tx *sqlx.Tx) (bool, error) {
 	chunkHashes := strings.Split(cp.Chunks, ",")
 	table := "chunk"
 	var storageMode string
-	if err := tx.Get(&storageMode, "SELECT mode FROM project_storage WHERE id = 1"); err == nil && storageMode == "deflated" {
+	if err := tx.Get(&storageMode, "SELECT mode FROM project_storage WHERE id = 1"); err == nil && storageMode != "compact" {
 		table = "chunk_ref"
 	}
 	for _, chunkHash := range chunkHashes {
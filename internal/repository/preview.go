@@ -3,11 +3,13 @@ package repository
 import (
 	"bytes"
 	"clustta/internal/base_service"
+	"clustta/internal/chunk_service"
 	"clustta/internal/constants"
 	"clustta/internal/error_service"
 	"clustta/internal/repository/models"
 	"clustta/internal/repository/repositorypb"
 	"clustta/internal/utils"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -48,6 +50,10 @@ func CreatePreview(tx *sqlx.Tx, previewPath string) (models.Preview, error) {
 	if err != nil {
 		return preview, err
 	}
+	fileData, err = chunk_service.EncryptPreview(tx, hash, fileData)
+	if err != nil {
+		return preview, err
+	}
 	_, err = tx.Exec("INSERT INTO preview (hash, preview, extension) VALUES (?, ?, ?)",
 		hash,
 		fileData,
@@ -68,6 +74,10 @@ func AddPreview(tx *sqlx.Tx, hash string, preview []byte, extension string) erro
 	if err == nil {
 		return nil
 	}
+	preview, err = chunk_service.EncryptPreview(tx, hash, preview)
+	if err != nil {
+		return err
+	}
 	_, err = tx.Exec("INSERT INTO preview (hash, preview, extension) VALUES (?, ?, ?)",
 		hash,
 		preview,
@@ -88,9 +98,13 @@ func AddPreviews(tx *sqlx.Tx, previews []models.Preview) error {
 		if err != error_service.ErrPreviewNotFound {
 			return err
 		}
+		data, err := chunk_service.EncryptPreview(tx, preview.Hash, preview.Preview)
+		if err != nil {
+			return err
+		}
 		_, err = tx.Exec("INSERT INTO preview (hash, preview, extension) VALUES (?, ?, ?)",
 			preview.Hash,
-			preview.Preview,
+			data,
 			preview.Extension,
 		)
 		if err != nil {
@@ -110,7 +124,8 @@ func GetPreview(tx *sqlx.Tx, hash string) (models.Preview, error) {
 		}
 		return preview, err
 	}
-	return preview, nil
+	preview.Preview, err = chunk_service.DecryptPreview(tx, hash, preview.Preview)
+	return preview, err
 }
 
 func AddCollectionPreview(tx *sqlx.Tx, collectionId, entityModel, previewPath string) (models.Preview, error) {
@@ -165,9 +180,9 @@ func GetNonExistingPreviews(tx *sqlx.Tx, previewIds []string) ([]string, error)
 	return nonExistentPreviews, nil
 }
 
-func PullPreviews(tx *sqlx.Tx, remoteUrl string, previewHashes []string, callback func(int, int, string, string)) error {
+func PullPreviews(ctx context.Context, tx *sqlx.Tx, remoteUrl string, previewHashes []string, callback func(int, int, string, string)) error {
 	dataUrl := remoteUrl + "/previews"
-	client := &http.Client{}
+	client := utils.HTTPClient(ctx, &http.Client{})
 	totalPreviews := len(previewHashes)
 	processedPreviews := 0
 	if utils.IsValidURL(remoteUrl) {
@@ -180,7 +195,7 @@ func PullPreviews(tx *sqlx.Tx, remoteUrl string, previewHashes []string, callbac
 				return err
 			}
 
-			req, err := http.NewRequest("GET", dataUrl, bytes.NewBuffer(jsonData))
+			req, err := http.NewRequestWithContext(ctx, "GET", dataUrl, bytes.NewBuffer(jsonData))
 			if err != nil {
 				return err
 			}
@@ -239,14 +254,17 @@ func PullPreviews(tx *sqlx.Tx, remoteUrl string, previewHashes []string, callbac
 		}
 		defer remoteTx.Rollback()
 		for _, previewHash := range previewHashes {
-			var preview models.Preview
-			err = remoteTx.Get(&preview, "SELECT * FROM preview WHERE hash = ?", previewHash)
+			preview, err := GetPreview(remoteTx, previewHash)
+			if err != nil {
+				return err
+			}
+			data, err := chunk_service.EncryptPreview(tx, preview.Hash, preview.Preview)
 			if err != nil {
 				return err
 			}
 			_, err = tx.Exec("INSERT INTO preview (hash, preview, extension) VALUES (?, ?, ?)",
 				preview.Hash,
-				preview.Preview,
+				data,
 				preview.Extension,
 			)
 			if err != nil {
@@ -269,8 +287,7 @@ func PushPreviews(tx *sqlx.Tx, remoteUrl string, userId string, previewHashes []
 	if utils.IsValidURL(remoteUrl) {
 		for _, previewHash := range previewHashes {
 			previews := []models.Preview{}
-			var preview models.Preview
-			err := tx.Get(&preview, "SELECT * FROM preview WHERE hash = ?", previewHash)
+			preview, err := GetPreview(tx, previewHash)
 			if err != nil {
 				return err
 			}
@@ -311,6 +328,12 @@ func PushPreviews(tx *sqlx.Tx, remoteUrl string, userId string, previewHashes []
 					return err
 				}
 				return errors.New(string(body))
+			} else if responseCode == http.StatusInsufficientStorage {
+				body, err := io.ReadAll(response.Body)
+				if err != nil {
+					return err
+				}
+				return chunk_service.QuotaErrorFromResponse(responseCode, body)
 			} else {
 				return errors.New("unknown error while pushing previews")
 			}
@@ -327,14 +350,17 @@ func PushPreviews(tx *sqlx.Tx, remoteUrl string, userId string, previewHashes []
 		}
 
 		for _, previewHash := range previewHashes {
-			var preview models.Preview
-			err = tx.Get(&preview, "SELECT * FROM preview WHERE hash = ?", previewHash)
+			preview, err := GetPreview(tx, previewHash)
+			if err != nil {
+				return err
+			}
+			data, err := chunk_service.EncryptPreview(remoteTx, preview.Hash, preview.Preview)
 			if err != nil {
 				return err
 			}
 			_, err = remoteTx.Exec("INSERT INTO preview (hash, preview, extension) VALUES (?, ?, ?)",
 				preview.Hash,
-				preview.Preview,
+				data,
 				preview.Extension,
 			)
 			if err != nil {
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
@@ -19,6 +20,7 @@ import (
 	"clustta/internal/chunk_service"
 	"clustta/internal/constants"
 	"clustta/internal/error_service"
+	"clustta/internal/event_service"
 	"clustta/internal/repository/migrations"
 	"clustta/internal/repository/models"
 	"clustta/internal/settings"
@@ -54,6 +56,9 @@ type ProjectInfo struct {
 	IsOutdated       bool     `json:"is_outdated"`
 	IgnoreList       []string `json:"ignore_list"`
 	StorageMode      string   `json:"storage_mode"`
+	// ChunkingProfiles is nil when the project info comes from a server
+	// that predates chunking profiles.
+	ChunkingProfiles *ChunkingProfiles `json:"chunking_profiles,omitempty"`
 }
 
 type ProjectConfig struct {
@@ -608,23 +613,9 @@ func Purge(projectPath string) error {
 		return err
 	}
 
-	clearUnusedChunks := `
-	WITH used_chunks AS (
-		-- Chunks used in templates
-		SELECT DISTINCT TRIM(value) as hash
-		FROM template, json_each('["' || REPLACE(chunks, ',', '","') || '"]')
-		WHERE chunks != ''
-		UNION
-		-- Chunks used in asset_checkpoints
-		SELECT DISTINCT TRIM(value) as hash
-		FROM asset_checkpoint, json_each('["' || REPLACE(chunks, ',', '","') || '"]')
-		WHERE chunks != ''
-	)
-	DELETE FROM chunk 
-	WHERE hash NOT IN (SELECT hash FROM used_chunks);
-	`
-
-	_, err = tx.Exec(clearUnusedChunks)
+	// Purging is explicit, so unreferenced chunks are swept without waiting
+	// out the garbage collection grace period.
+	_, err = chunk_service.SweepChunks(tx, 0, false)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -1006,6 +997,12 @@ func CreateProject(projectUri, studioName, workingDir, templateName, projectId s
 }
 
 func GetProjectInfo(projectUri string, user auth_service.User) (ProjectInfo, error) {
+	return GetProjectInfoContext(context.Background(), projectUri, user)
+}
+
+// GetProjectInfoContext is GetProjectInfo sending its request through the
+// HTTP client ctx carries, if any.
+func GetProjectInfoContext(ctx context.Context, projectUri string, user auth_service.User) (ProjectInfo, error) {
 	// userDataDir, err := settings.GetUserDataFolder()
 	// if err != nil {
 	// 	return ProjectInfo{}, err
@@ -1021,7 +1018,7 @@ func GetProjectInfo(projectUri string, user auth_service.User) (ProjectInfo, err
 	// }
 	if utils.IsValidURL(projectUri) {
 		projectUrl := projectUri
-		req, err := http.NewRequest("GET", projectUrl, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", projectUrl, nil)
 		if err != nil {
 			return ProjectInfo{}, err
 		}
@@ -1033,7 +1030,7 @@ func GetProjectInfo(projectUri string, user auth_service.User) (ProjectInfo, err
 		req.Header.Set("UserId", user.Id)
 		req.Header.Set("Clustta-Agent", constants.USER_AGENT)
 
-		client := &http.Client{}
+		client := utils.HTTPClient(ctx, &http.Client{})
 		response, err := client.Do(req)
 		if err != nil {
 			return ProjectInfo{}, err
@@ -1109,6 +1106,10 @@ func GetProjectInfo(projectUri string, user auth_service.User) (ProjectInfo, err
 		if err != nil {
 			return ProjectInfo{}, err
 		}
+		chunkingProfiles, err := GetChunkingProfiles(tx)
+		if err != nil {
+			return ProjectInfo{}, err
+		}
 		syncToken, err := utils.GetProjectSyncToken(tx)
 		if err != nil {
 			return ProjectInfo{}, err
@@ -1132,6 +1133,7 @@ func GetProjectInfo(projectUri string, user auth_service.User) (ProjectInfo, err
 			IsClosed:         isClosed,
 			IgnoreList:       ignoreList,
 			StorageMode:      storageMode,
+			ChunkingProfiles: &chunkingProfiles,
 		}, nil
 	} else {
 		return ProjectInfo{}, fmt.Errorf("invalid url:%s", projectUri)
@@ -1203,6 +1205,66 @@ func GetSyncToken(projectUri string, user auth_service.User) (string, error) {
 	}
 }
 
+// WatchSyncToken follows a remote project's /events stream and calls onEvent
+// for every sync token it reports, the current one first. It returns when ctx
+// is cancelled or the stream ends; callers reconnect or fall back to polling
+// GetSyncToken.
+func WatchSyncToken(ctx context.Context, projectUri string, user auth_service.User, onEvent func(event_service.SyncTokenEvent)) error {
+	if !utils.IsValidURL(projectUri) {
+		return errors.New("sync token events are only served by remote projects")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", projectUri+"/events", nil)
+	if err != nil {
+		return err
+	}
+	userJson, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("UserData", string(userJson))
+	req.Header.Set("UserId", user.Id)
+	req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+	req.Header.Set("Accept", "text/event-stream")
+
+	response, err := utils.HTTPClient(ctx, http.DefaultClient).Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(body))
+	}
+
+	eventName, data := "", ""
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if eventName == "sync-token" && data != "" {
+				event := event_service.SyncTokenEvent{}
+				if err := json.Unmarshal([]byte(data), &event); err != nil {
+					return err
+				}
+				onEvent(event)
+			}
+			eventName, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			eventName = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
 func UserInProject(projectPath string, userId string) (bool, error) {
 	db, err := utils.OpenDb(projectPath)
 	if err != nil {
@@ -1694,6 +1756,10 @@ func LoadProjectTemplateData(projectPath, templatePath string) error {
 	if err != nil {
 		return err
 	}
+	templateChunkingProfiles, err := GetChunkingProfiles(templateTx)
+	if err != nil {
+		return err
+	}
 
 	templateAssetTemplates, err := GetTemplates(templateTx, false)
 	if err != nil {
@@ -1716,14 +1782,23 @@ func LoadProjectTemplateData(projectPath, templatePath string) error {
 		return err
 	}
 
+	assetTypeChunking := templateChunkingProfiles.AssetTypes
+	templateChunkingProfiles.AssetTypes = map[string]string{}
 	for _, templateAssetType := range templateAssetTypes {
-		_, err = GetOrCreateAssetType(projectTx, templateAssetType.Name, templateAssetType.Icon)
+		assetType, err := GetOrCreateAssetType(projectTx, templateAssetType.Name, templateAssetType.Icon)
 		if err != nil {
 			if err.Error() == "UNIQUE constraint failed: asset_type.icon" {
 				continue
 			}
 			return err
 		}
+		if profile, ok := assetTypeChunking[templateAssetType.Id]; ok {
+			templateChunkingProfiles.AssetTypes[assetType.Id] = profile
+		}
+	}
+	err = SaveChunkingProfiles(projectTx, templateChunkingProfiles)
+	if err != nil {
+		return err
 	}
 	for _, templateCollectionType := range templateCollectionTypes {
 		_, err = GetOrCreateCollectionType(projectTx, templateCollectionType.Name, templateCollectionType.Icon)
@@ -409,6 +409,19 @@ func ToPbTombs(tombs []Tomb) []*repositorypb.Tomb {
 	return pb
 }
 
+func ToPbFieldBases(bases []FieldBase) []*repositorypb.FieldBase {
+	pb := make([]*repositorypb.FieldBase, len(bases))
+	for i, b := range bases {
+		pb[i] = &repositorypb.FieldBase{
+			TableName: b.TableName,
+			RowId:     b.RowId,
+			Field:     b.Field,
+			Value:     b.Value,
+		}
+	}
+	return pb
+}
+
 func ToPbIntegrationProjects(integrations []models.IntegrationProject) []*repositorypb.IntegrationProject {
 	pb := make([]*repositorypb.IntegrationProject, len(integrations))
 	for i, ip := range integrations {
@@ -1078,6 +1091,19 @@ func FromPbTombs(pbs []*repositorypb.Tomb) []Tomb {
 	}
 	return tombs
 }
+func FromPbFieldBases(pbs []*repositorypb.FieldBase) []FieldBase {
+	bases := make([]FieldBase, len(pbs))
+	for i, pb := range pbs {
+		bases[i] = FieldBase{
+			TableName: pb.TableName,
+			RowId:     pb.RowId,
+			Field:     pb.Field,
+			Value:     pb.Value,
+		}
+	}
+	return bases
+}
+
 func FromPbIntegrationProject(pb *repositorypb.IntegrationProject) models.IntegrationProject {
 	return models.IntegrationProject{
 		Id:                  pb.Id,
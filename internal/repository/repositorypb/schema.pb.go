@@ -2707,6 +2707,9 @@ type ProjectData struct {
 	IntegrationProjects           []*IntegrationProject           `protobuf:"bytes,22,rep,name=integration_projects,json=integrationProjects,proto3" json:"integration_projects,omitempty"`
 	IntegrationCollectionMappings []*IntegrationCollectionMapping `protobuf:"bytes,23,rep,name=integration_collection_mappings,json=integrationCollectionMappings,proto3" json:"integration_collection_mappings,omitempty"`
 	IntegrationAssetMappings      []*IntegrationAssetMapping      `protobuf:"bytes,24,rep,name=integration_asset_mappings,json=integrationAssetMappings,proto3" json:"integration_asset_mappings,omitempty"`
+	FieldBases                    []*FieldBase                    `protobuf:"bytes,25,rep,name=field_bases,json=fieldBases,proto3" json:"field_bases,omitempty"`
+	ConflictPolicy                string                          `protobuf:"bytes,26,opt,name=conflict_policy,json=conflictPolicy,proto3" json:"conflict_policy,omitempty"`
+	ConflictResolutions           []*ConflictResolution           `protobuf:"bytes,27,rep,name=conflict_resolutions,json=conflictResolutions,proto3" json:"conflict_resolutions,omitempty"`
 	unknownFields                 protoimpl.UnknownFields
 	sizeCache                     protoimpl.SizeCache
 }
@@ -2909,6 +2912,147 @@ func (x *ProjectData) GetIntegrationAssetMappings() []*IntegrationAssetMapping {
 	return nil
 }
 
+func (x *ProjectData) GetFieldBases() []*FieldBase {
+	if x != nil {
+		return x.FieldBases
+	}
+	return nil
+}
+
+func (x *ProjectData) GetConflictPolicy() string {
+	if x != nil {
+		return x.ConflictPolicy
+	}
+	return ""
+}
+
+func (x *ProjectData) GetConflictResolutions() []*ConflictResolution {
+	if x != nil {
+		return x.ConflictResolutions
+	}
+	return nil
+}
+
+type FieldBase struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TableName     string                 `protobuf:"bytes,1,opt,name=table_name,json=tableName,proto3" json:"table_name,omitempty"`
+	RowId         string                 `protobuf:"bytes,2,opt,name=row_id,json=rowId,proto3" json:"row_id,omitempty"`
+	Field         string                 `protobuf:"bytes,3,opt,name=field,proto3" json:"field,omitempty"`
+	Value         string                 `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FieldBase) Reset() {
+	*x = FieldBase{}
+	mi := &file_internal_repository_schema_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FieldBase) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FieldBase) ProtoMessage() {}
+
+func (x *FieldBase) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_repository_schema_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FieldBase.ProtoReflect.Descriptor instead.
+func (*FieldBase) Descriptor() ([]byte, []int) {
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *FieldBase) GetTableName() string {
+	if x != nil {
+		return x.TableName
+	}
+	return ""
+}
+
+func (x *FieldBase) GetRowId() string {
+	if x != nil {
+		return x.RowId
+	}
+	return ""
+}
+
+func (x *FieldBase) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *FieldBase) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type ConflictResolution struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	LocalId       string                 `protobuf:"bytes,1,opt,name=local_id,json=localId,proto3" json:"local_id,omitempty"`
+	Policy        string                 `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConflictResolution) Reset() {
+	*x = ConflictResolution{}
+	mi := &file_internal_repository_schema_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConflictResolution) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConflictResolution) ProtoMessage() {}
+
+func (x *ConflictResolution) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_repository_schema_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConflictResolution.ProtoReflect.Descriptor instead.
+func (*ConflictResolution) Descriptor() ([]byte, []int) {
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *ConflictResolution) GetLocalId() string {
+	if x != nil {
+		return x.LocalId
+	}
+	return ""
+}
+
+func (x *ConflictResolution) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
 type FullAsset struct {
 	state                     protoimpl.MessageState `protogen:"open.v1"`
 	Id                        string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
@@ -2959,7 +3103,7 @@ type FullAsset struct {
 
 func (x *FullAsset) Reset() {
 	*x = FullAsset{}
-	mi := &file_internal_repository_schema_proto_msgTypes[26]
+	mi := &file_internal_repository_schema_proto_msgTypes[28]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2971,7 +3115,7 @@ func (x *FullAsset) String() string {
 func (*FullAsset) ProtoMessage() {}
 
 func (x *FullAsset) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_repository_schema_proto_msgTypes[26]
+	mi := &file_internal_repository_schema_proto_msgTypes[28]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2984,7 +3128,7 @@ func (x *FullAsset) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FullAsset.ProtoReflect.Descriptor instead.
 func (*FullAsset) Descriptor() ([]byte, []int) {
-	return file_internal_repository_schema_proto_rawDescGZIP(), []int{26}
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{28}
 }
 
 func (x *FullAsset) GetId() string {
@@ -3291,7 +3435,7 @@ type ChunkInfo struct {
 
 func (x *ChunkInfo) Reset() {
 	*x = ChunkInfo{}
-	mi := &file_internal_repository_schema_proto_msgTypes[27]
+	mi := &file_internal_repository_schema_proto_msgTypes[29]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3303,7 +3447,7 @@ func (x *ChunkInfo) String() string {
 func (*ChunkInfo) ProtoMessage() {}
 
 func (x *ChunkInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_repository_schema_proto_msgTypes[27]
+	mi := &file_internal_repository_schema_proto_msgTypes[29]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3316,7 +3460,7 @@ func (x *ChunkInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ChunkInfo.ProtoReflect.Descriptor instead.
 func (*ChunkInfo) Descriptor() ([]byte, []int) {
-	return file_internal_repository_schema_proto_rawDescGZIP(), []int{27}
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{29}
 }
 
 func (x *ChunkInfo) GetHash() string {
@@ -3342,7 +3486,7 @@ type FullAssetList struct {
 
 func (x *FullAssetList) Reset() {
 	*x = FullAssetList{}
-	mi := &file_internal_repository_schema_proto_msgTypes[28]
+	mi := &file_internal_repository_schema_proto_msgTypes[30]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3354,7 +3498,7 @@ func (x *FullAssetList) String() string {
 func (*FullAssetList) ProtoMessage() {}
 
 func (x *FullAssetList) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_repository_schema_proto_msgTypes[28]
+	mi := &file_internal_repository_schema_proto_msgTypes[30]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3367,7 +3511,7 @@ func (x *FullAssetList) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FullAssetList.ProtoReflect.Descriptor instead.
 func (*FullAssetList) Descriptor() ([]byte, []int) {
-	return file_internal_repository_schema_proto_rawDescGZIP(), []int{28}
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{30}
 }
 
 func (x *FullAssetList) GetFullAssets() []*FullAsset {
@@ -3386,7 +3530,7 @@ type Previews struct {
 
 func (x *Previews) Reset() {
 	*x = Previews{}
-	mi := &file_internal_repository_schema_proto_msgTypes[29]
+	mi := &file_internal_repository_schema_proto_msgTypes[31]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3398,7 +3542,7 @@ func (x *Previews) String() string {
 func (*Previews) ProtoMessage() {}
 
 func (x *Previews) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_repository_schema_proto_msgTypes[29]
+	mi := &file_internal_repository_schema_proto_msgTypes[31]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3411,7 +3555,7 @@ func (x *Previews) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Previews.ProtoReflect.Descriptor instead.
 func (*Previews) Descriptor() ([]byte, []int) {
-	return file_internal_repository_schema_proto_rawDescGZIP(), []int{29}
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{31}
 }
 
 func (x *Previews) GetPreviews() []*Preview {
@@ -3430,7 +3574,7 @@ type ChunkHashes struct {
 
 func (x *ChunkHashes) Reset() {
 	*x = ChunkHashes{}
-	mi := &file_internal_repository_schema_proto_msgTypes[30]
+	mi := &file_internal_repository_schema_proto_msgTypes[32]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3442,7 +3586,7 @@ func (x *ChunkHashes) String() string {
 func (*ChunkHashes) ProtoMessage() {}
 
 func (x *ChunkHashes) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_repository_schema_proto_msgTypes[30]
+	mi := &file_internal_repository_schema_proto_msgTypes[32]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3455,7 +3599,7 @@ func (x *ChunkHashes) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ChunkHashes.ProtoReflect.Descriptor instead.
 func (*ChunkHashes) Descriptor() ([]byte, []int) {
-	return file_internal_repository_schema_proto_rawDescGZIP(), []int{30}
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{32}
 }
 
 func (x *ChunkHashes) GetChunkHashes() []string {
@@ -3474,7 +3618,7 @@ type ChunkInfos struct {
 
 func (x *ChunkInfos) Reset() {
 	*x = ChunkInfos{}
-	mi := &file_internal_repository_schema_proto_msgTypes[31]
+	mi := &file_internal_repository_schema_proto_msgTypes[33]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3486,7 +3630,7 @@ func (x *ChunkInfos) String() string {
 func (*ChunkInfos) ProtoMessage() {}
 
 func (x *ChunkInfos) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_repository_schema_proto_msgTypes[31]
+	mi := &file_internal_repository_schema_proto_msgTypes[33]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3499,7 +3643,7 @@ func (x *ChunkInfos) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ChunkInfos.ProtoReflect.Descriptor instead.
 func (*ChunkInfos) Descriptor() ([]byte, []int) {
-	return file_internal_repository_schema_proto_rawDescGZIP(), []int{31}
+	return file_internal_repository_schema_proto_rawDescGZIP(), []int{33}
 }
 
 func (x *ChunkInfos) GetChunkInfos() []*ChunkInfo {
@@ -3796,7 +3940,7 @@ const file_internal_repository_schema_proto_rawDesc = "" +
 	"\basset_id\x18\v \x01(\tR\aassetId\x129\n" +
 	"\x19last_pushed_checkpoint_id\x18\f \x01(\tR\x16lastPushedCheckpointId\x12\x1b\n" +
 	"\tsynced_at\x18\r \x01(\tR\bsyncedAt\x12\x16\n" +
-	"\x06synced\x18\x0e \x01(\bR\x06synced\"\x8c\f\n" +
+	"\x06synced\x18\x0e \x01(\bR\x06synced\"\xc0\r\n" +
 	"\vProjectData\x12'\n" +
 	"\x0fproject_preview\x18\x01 \x01(\tR\x0eprojectPreview\x12)\n" +
 	"\x06assets\x18\x02 \x03(\v2\x11.repository.AssetR\x06assets\x126\n" +
@@ -3824,7 +3968,20 @@ const file_internal_repository_schema_proto_rawDesc = "" +
 	"\x04tomb\x18\x15 \x03(\v2\x10.repository.TombR\x04tomb\x12Q\n" +
 	"\x14integration_projects\x18\x16 \x03(\v2\x1e.repository.IntegrationProjectR\x13integrationProjects\x12p\n" +
 	"\x1fintegration_collection_mappings\x18\x17 \x03(\v2(.repository.IntegrationCollectionMappingR\x1dintegrationCollectionMappings\x12a\n" +
-	"\x1aintegration_asset_mappings\x18\x18 \x03(\v2#.repository.IntegrationAssetMappingR\x18integrationAssetMappings\"\xc7\v\n" +
+	"\x1aintegration_asset_mappings\x18\x18 \x03(\v2#.repository.IntegrationAssetMappingR\x18integrationAssetMappings\x126\n" +
+	"\vfield_bases\x18\x19 \x03(\v2\x15.repository.FieldBaseR\n" +
+	"fieldBases\x12'\n" +
+	"\x0fconflict_policy\x18\x1a \x01(\tR\x0econflictPolicy\x12Q\n" +
+	"\x14conflict_resolutions\x18\x1b \x03(\v2\x1e.repository.ConflictResolutionR\x13conflictResolutions\"m\n" +
+	"\tFieldBase\x12\x1d\n" +
+	"\n" +
+	"table_name\x18\x01 \x01(\tR\ttableName\x12\x15\n" +
+	"\x06row_id\x18\x02 \x01(\tR\x05rowId\x12\x14\n" +
+	"\x05field\x18\x03 \x01(\tR\x05field\x12\x14\n" +
+	"\x05value\x18\x04 \x01(\tR\x05value\"G\n" +
+	"\x12ConflictResolution\x12\x19\n" +
+	"\blocal_id\x18\x01 \x01(\tR\alocalId\x12\x16\n" +
+	"\x06policy\x18\x02 \x01(\tR\x06policy\"\xc7\v\n" +
 	"\tFullAsset\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
 	"\x05mtime\x18\x02 \x01(\x03R\x05mtime\x12\x1e\n" +
@@ -3903,7 +4060,7 @@ func file_internal_repository_schema_proto_rawDescGZIP() []byte {
 	return file_internal_repository_schema_proto_rawDescData
 }
 
-var file_internal_repository_schema_proto_msgTypes = make([]protoimpl.MessageInfo, 32)
+var file_internal_repository_schema_proto_msgTypes = make([]protoimpl.MessageInfo, 34)
 var file_internal_repository_schema_proto_goTypes = []any{
 	(*User)(nil),                         // 0: repository.User
 	(*CollectionType)(nil),               // 1: repository.CollectionType
@@ -3931,12 +4088,14 @@ var file_internal_repository_schema_proto_goTypes = []any{
 	(*IntegrationCollectionMapping)(nil), // 23: repository.IntegrationCollectionMapping
 	(*IntegrationAssetMapping)(nil),      // 24: repository.IntegrationAssetMapping
 	(*ProjectData)(nil),                  // 25: repository.ProjectData
-	(*FullAsset)(nil),                    // 26: repository.FullAsset
-	(*ChunkInfo)(nil),                    // 27: repository.ChunkInfo
-	(*FullAssetList)(nil),                // 28: repository.FullAssetList
-	(*Previews)(nil),                     // 29: repository.Previews
-	(*ChunkHashes)(nil),                  // 30: repository.ChunkHashes
-	(*ChunkInfos)(nil),                   // 31: repository.ChunkInfos
+	(*FieldBase)(nil),                    // 26: repository.FieldBase
+	(*ConflictResolution)(nil),           // 27: repository.ConflictResolution
+	(*FullAsset)(nil),                    // 28: repository.FullAsset
+	(*ChunkInfo)(nil),                    // 29: repository.ChunkInfo
+	(*FullAssetList)(nil),                // 30: repository.FullAssetList
+	(*Previews)(nil),                     // 31: repository.Previews
+	(*ChunkHashes)(nil),                  // 32: repository.ChunkHashes
+	(*ChunkInfos)(nil),                   // 33: repository.ChunkInfos
 }
 var file_internal_repository_schema_proto_depIdxs = []int32{
 	3,  // 0: repository.ProjectData.assets:type_name -> repository.Asset
@@ -3962,16 +4121,18 @@ var file_internal_repository_schema_proto_depIdxs = []int32{
 	22, // 20: repository.ProjectData.integration_projects:type_name -> repository.IntegrationProject
 	23, // 21: repository.ProjectData.integration_collection_mappings:type_name -> repository.IntegrationCollectionMapping
 	24, // 22: repository.ProjectData.integration_asset_mappings:type_name -> repository.IntegrationAssetMapping
-	13, // 23: repository.FullAsset.status:type_name -> repository.Status
-	16, // 24: repository.FullAsset.checkpoints:type_name -> repository.Checkpoint
-	26, // 25: repository.FullAssetList.full_assets:type_name -> repository.FullAsset
-	20, // 26: repository.Previews.previews:type_name -> repository.Preview
-	27, // 27: repository.ChunkInfos.chunk_infos:type_name -> repository.ChunkInfo
-	28, // [28:28] is the sub-list for method output_type
-	28, // [28:28] is the sub-list for method input_type
-	28, // [28:28] is the sub-list for extension type_name
-	28, // [28:28] is the sub-list for extension extendee
-	0,  // [0:28] is the sub-list for field type_name
+	26, // 23: repository.ProjectData.field_bases:type_name -> repository.FieldBase
+	27, // 24: repository.ProjectData.conflict_resolutions:type_name -> repository.ConflictResolution
+	13, // 25: repository.FullAsset.status:type_name -> repository.Status
+	16, // 26: repository.FullAsset.checkpoints:type_name -> repository.Checkpoint
+	28, // 27: repository.FullAssetList.full_assets:type_name -> repository.FullAsset
+	20, // 28: repository.Previews.previews:type_name -> repository.Preview
+	29, // 29: repository.ChunkInfos.chunk_infos:type_name -> repository.ChunkInfo
+	30, // [30:30] is the sub-list for method output_type
+	30, // [30:30] is the sub-list for method input_type
+	30, // [30:30] is the sub-list for extension type_name
+	30, // [30:30] is the sub-list for extension extendee
+	0,  // [0:30] is the sub-list for field type_name
 }
 
 func init() { file_internal_repository_schema_proto_init() }
@@ -3985,7 +4146,7 @@ func file_internal_repository_schema_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_repository_schema_proto_rawDesc), len(file_internal_repository_schema_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   32,
+			NumMessages:   34,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -546,12 +546,13 @@ END;
 CREATE TABLE IF NOT EXISTS chunk (
     hash TEXT PRIMARY KEY NOT NULL,
     data BLOB NOT NULL,
-    size INTEGER NOT NULL
+    size INTEGER NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS project_storage (
     id INTEGER PRIMARY KEY CHECK (id = 1),
-    mode TEXT NOT NULL CHECK (mode IN ('compact', 'deflated', 'object_storage')),
+    mode TEXT NOT NULL CHECK (mode IN ('compact', 'deflated', 'object_storage', 'pooled')),
     updated_at INTEGER NOT NULL
 );
 
@@ -952,3 +953,671 @@ CREATE INDEX IF NOT EXISTS idx_asset_tag_tag ON asset_tag(tag_id);
 CREATE INDEX IF NOT EXISTS idx_asset_dependency_asset ON asset_dependency(asset_id);
 CREATE INDEX IF NOT EXISTS idx_collection_dependency_asset ON collection_dependency(asset_id);
 CREATE INDEX IF NOT EXISTS idx_collection_parent ON collection(parent_id);
+
+CREATE TABLE IF NOT EXISTS storage_migration (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    source_mode TEXT NOT NULL,
+    target_mode TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('running', 'failed', 'completed')),
+    cursor TEXT NOT NULL DEFAULT '',
+    total_chunks INTEGER NOT NULL DEFAULT 0,
+    migrated_chunks INTEGER NOT NULL DEFAULT 0,
+    migrated_bytes INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    started_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS storage_migration_failure (
+    hash TEXT PRIMARY KEY NOT NULL,
+    reason TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chunk_quarantine (
+    hash TEXT PRIMARY KEY NOT NULL,
+    reason TEXT NOT NULL,
+    storage_mode TEXT NOT NULL,
+    storage_key TEXT NOT NULL DEFAULT '',
+    data BLOB,
+    quarantined_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chunk_scrub (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    cursor TEXT NOT NULL DEFAULT '',
+    scanned_chunks INTEGER NOT NULL DEFAULT 0,
+    corrupt_chunks INTEGER NOT NULL DEFAULT 0,
+    started_at INTEGER NOT NULL DEFAULT 0,
+    finished_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS project_encryption (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    key_id TEXT NOT NULL,
+    wrapped_key TEXT NOT NULL,
+    backfill_pending INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    rotated_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS chunk_pack (
+    hash TEXT PRIMARY KEY NOT NULL,
+    pack TEXT NOT NULL,
+    offset INTEGER NOT NULL,
+    length INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chunk_pack_pack ON chunk_pack(pack);
+
+CREATE TABLE IF NOT EXISTS upload_session (
+    id TEXT PRIMARY KEY NOT NULL,
+    user_id TEXT NOT NULL,
+    chunks TEXT NOT NULL,
+    received_chunks INTEGER NOT NULL DEFAULT 0,
+    byte_offset INTEGER NOT NULL DEFAULT 0,
+    pending BLOB,
+    failed_chunks TEXT NOT NULL DEFAULT '',
+    framing INTEGER NOT NULL DEFAULT 1,
+    frame_state BLOB,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chunk_dictionary (
+    id INTEGER PRIMARY KEY NOT NULL,
+    extension TEXT NOT NULL DEFAULT '',
+    data BLOB NOT NULL,
+    sample_chunks INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chunk_dictionary_ref (
+    hash TEXT PRIMARY KEY NOT NULL,
+    dictionary_id INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chunk_dictionary_ref_dictionary ON chunk_dictionary_ref(dictionary_id);
+
+CREATE TABLE IF NOT EXISTS chunk_archive (
+    hash TEXT PRIMARY KEY NOT NULL,
+    size INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    archived_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS collection_tier (
+    collection_id TEXT PRIMARY KEY NOT NULL,
+    tier TEXT NOT NULL CHECK (tier IN ('cold', 'hot')),
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS storage_quota (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    limit_bytes INTEGER NOT NULL,
+    soft_limit_bytes INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS change_tracking (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    started_seq INTEGER NOT NULL,
+    pruned_seq INTEGER NOT NULL DEFAULT 0,
+    started_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS change_log (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    table_name TEXT NOT NULL,
+    row_id TEXT NOT NULL,
+    deleted BOOLEAN DEFAULT 0 NOT NULL,
+    changed_at INTEGER NOT NULL,
+    UNIQUE (table_name, row_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_change_log_deleted ON change_log(deleted, changed_at);
+
+CREATE TRIGGER IF NOT EXISTS role_change_insert AFTER INSERT ON role
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('role', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS role_change_update AFTER UPDATE ON role
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('role', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS role_change_delete AFTER DELETE ON role
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('role', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS user_change_insert AFTER INSERT ON user
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('user', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS user_change_update AFTER UPDATE ON user
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('user', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS user_change_delete AFTER DELETE ON user
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('user', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS status_change_insert AFTER INSERT ON status
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('status', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS status_change_update AFTER UPDATE ON status
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('status', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS status_change_delete AFTER DELETE ON status
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('status', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS tag_change_insert AFTER INSERT ON tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS tag_change_update AFTER UPDATE ON tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS tag_change_delete AFTER DELETE ON tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('tag', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_type_change_insert AFTER INSERT ON asset_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_type_change_update AFTER UPDATE ON asset_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_type_change_delete AFTER DELETE ON asset_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_type', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_change_insert AFTER INSERT ON asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_change_update AFTER UPDATE ON asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_change_delete AFTER DELETE ON asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS dependency_type_change_insert AFTER INSERT ON dependency_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('dependency_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS dependency_type_change_update AFTER UPDATE ON dependency_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('dependency_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS dependency_type_change_delete AFTER DELETE ON dependency_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('dependency_type', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_dependency_change_insert AFTER INSERT ON asset_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_dependency_change_update AFTER UPDATE ON asset_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_dependency_change_delete AFTER DELETE ON asset_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_dependency', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_dependency_change_insert AFTER INSERT ON collection_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_dependency_change_update AFTER UPDATE ON collection_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_dependency', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_dependency_change_delete AFTER DELETE ON collection_dependency
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_dependency', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_type_change_insert AFTER INSERT ON collection_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_type_change_update AFTER UPDATE ON collection_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_type', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_type_change_delete AFTER DELETE ON collection_type
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_type', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_change_insert AFTER INSERT ON collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_change_update AFTER UPDATE ON collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_change_delete AFTER DELETE ON collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_assignee_change_insert AFTER INSERT ON collection_assignee
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_assignee', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_assignee_change_update AFTER UPDATE ON collection_assignee
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_assignee', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_assignee_change_delete AFTER DELETE ON collection_assignee
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('collection_assignee', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS template_change_insert AFTER INSERT ON template
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('template', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS template_change_update AFTER UPDATE ON template
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('template', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS template_change_delete AFTER DELETE ON template
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('template', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_change_insert AFTER INSERT ON workflow
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_change_update AFTER UPDATE ON workflow
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_change_delete AFTER DELETE ON workflow
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_link_change_insert AFTER INSERT ON workflow_link
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_link', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_link_change_update AFTER UPDATE ON workflow_link
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_link', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_link_change_delete AFTER DELETE ON workflow_link
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_link', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_collection_change_insert AFTER INSERT ON workflow_collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_collection_change_update AFTER UPDATE ON workflow_collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_collection', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_collection_change_delete AFTER DELETE ON workflow_collection
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_collection', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_asset_change_insert AFTER INSERT ON workflow_asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_asset_change_update AFTER UPDATE ON workflow_asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_asset', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS workflow_asset_change_delete AFTER DELETE ON workflow_asset
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('workflow_asset', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_tag_change_insert AFTER INSERT ON asset_tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_tag_change_update AFTER UPDATE ON asset_tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_tag', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_tag_change_delete AFTER DELETE ON asset_tag
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_tag', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_checkpoint_change_insert AFTER INSERT ON asset_checkpoint
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_checkpoint', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_checkpoint_change_update AFTER UPDATE ON asset_checkpoint
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_checkpoint', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS asset_checkpoint_change_delete AFTER DELETE ON asset_checkpoint
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('asset_checkpoint', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_project_change_insert AFTER INSERT ON integration_project
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_project', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_project_change_update AFTER UPDATE ON integration_project
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_project', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_project_change_delete AFTER DELETE ON integration_project
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_project', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_collection_mapping_change_insert AFTER INSERT ON integration_collection_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_collection_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_collection_mapping_change_update AFTER UPDATE ON integration_collection_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_collection_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_collection_mapping_change_delete AFTER DELETE ON integration_collection_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_collection_mapping', OLD.id, 1, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_asset_mapping_change_insert AFTER INSERT ON integration_asset_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_asset_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_asset_mapping_change_update AFTER UPDATE ON integration_asset_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_asset_mapping', NEW.id, 0, unixepoch());
+END;
+
+CREATE TRIGGER IF NOT EXISTS integration_asset_mapping_change_delete AFTER DELETE ON integration_asset_mapping
+FOR EACH ROW
+WHEN EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR REPLACE INTO change_log (table_name, row_id, deleted, changed_at) VALUES ('integration_asset_mapping', OLD.id, 1, unixepoch());
+END;
+
+CREATE TABLE IF NOT EXISTS field_base (
+    table_name TEXT NOT NULL,
+    row_id TEXT NOT NULL,
+    field TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (table_name, row_id, field)
+);
+
+CREATE TRIGGER IF NOT EXISTS asset_field_base AFTER UPDATE ON asset
+FOR EACH ROW
+WHEN OLD.mtime != NEW.mtime AND OLD.synced = 1 AND NOT EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR IGNORE INTO field_base (table_name, row_id, field, value) VALUES
+        ('asset', OLD.id, 'name', OLD.name),
+        ('asset', OLD.id, 'collection_id', OLD.collection_id),
+        ('asset', OLD.id, 'asset_type_id', OLD.asset_type_id),
+        ('asset', OLD.id, 'assignee_id', OLD.assignee_id),
+        ('asset', OLD.id, 'assigner_id', OLD.assigner_id),
+        ('asset', OLD.id, 'status_id', OLD.status_id),
+        ('asset', OLD.id, 'preview_id', OLD.preview_id),
+        ('asset', OLD.id, 'is_resource', OLD.is_resource),
+        ('asset', OLD.id, 'is_link', OLD.is_link),
+        ('asset', OLD.id, 'pointer', OLD.pointer);
+END;
+
+CREATE TRIGGER IF NOT EXISTS collection_field_base AFTER UPDATE ON collection
+FOR EACH ROW
+WHEN OLD.mtime != NEW.mtime AND OLD.synced = 1 AND NOT EXISTS (SELECT 1 FROM change_tracking)
+BEGIN
+    INSERT OR IGNORE INTO field_base (table_name, row_id, field, value) VALUES
+        ('collection', OLD.id, 'name', OLD.name),
+        ('collection', OLD.id, 'parent_id', OLD.parent_id),
+        ('collection', OLD.id, 'collection_type_id', OLD.collection_type_id),
+        ('collection', OLD.id, 'preview_id', OLD.preview_id),
+        ('collection', OLD.id, 'is_shared', OLD.is_shared);
+END;
+
+CREATE TABLE IF NOT EXISTS sync_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time INTEGER NOT NULL,
+    user_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    client_agent TEXT DEFAULT '' NOT NULL,
+    tables TEXT DEFAULT '{}' NOT NULL,
+    tombs TEXT DEFAULT '[]' NOT NULL,
+    bytes INTEGER DEFAULT 0 NOT NULL,
+    sync_token TEXT DEFAULT '' NOT NULL,
+    detail TEXT DEFAULT '' NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_audit_time ON sync_audit(time);
+CREATE INDEX IF NOT EXISTS idx_sync_audit_user ON sync_audit(user_id, time);
+
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    user_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    response BLOB NOT NULL,
+    sync_token TEXT DEFAULT '' NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_created ON idempotency_key(created_at);
+
+CREATE TABLE IF NOT EXISTS replica (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    primary_url TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    interval_seconds INTEGER DEFAULT 0 NOT NULL,
+    created_at INTEGER NOT NULL,
+    sync_token TEXT DEFAULT '' NOT NULL,
+    synced_as_of INTEGER DEFAULT 0 NOT NULL,
+    last_attempt_at INTEGER DEFAULT 0 NOT NULL,
+    last_duration_ms INTEGER DEFAULT 0 NOT NULL,
+    last_chunks INTEGER DEFAULT 0 NOT NULL,
+    last_bytes INTEGER DEFAULT 0 NOT NULL,
+    consecutive_failures INTEGER DEFAULT 0 NOT NULL,
+    last_error TEXT DEFAULT '' NOT NULL
+);
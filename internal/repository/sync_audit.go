@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"clustta/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Actions and outcomes recorded in the sync audit log.
+const (
+	SyncAuditData   = "data"
+	SyncAuditChunks = "chunks"
+
+	SyncAuditApplied  = "applied"
+	SyncAuditDenied   = "denied"
+	SyncAuditConflict = "conflict"
+	SyncAuditFailed   = "failed"
+)
+
+// namedTables are the tables whose rows have a name worth keeping in the
+// audit log once they are deleted.
+var namedTables = map[string]bool{
+	"asset": true, "collection": true, "template": true, "role": true,
+	"status": true, "tag": true, "asset_type": true, "collection_type": true,
+	"dependency_type": true, "workflow": true,
+}
+
+// SyncAuditTomb is a row deleted by a push, with the name it had.
+type SyncAuditTomb struct {
+	TableName string `json:"table_name"`
+	Id        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+}
+
+// SyncAudit is one push received by the studio server. Tables holds the rows
+// sent per table; Bytes the size of the request body.
+type SyncAudit struct {
+	Id          int64           `json:"id"`
+	Time        int64           `json:"time"`
+	UserId      string          `json:"user_id"`
+	Action      string          `json:"action"`
+	Outcome     string          `json:"outcome"`
+	ClientAgent string          `json:"client_agent"`
+	Tables      map[string]int  `json:"tables"`
+	Tombs       []SyncAuditTomb `json:"tombs"`
+	Bytes       int64           `json:"bytes"`
+	SyncToken   string          `json:"sync_token"`
+	Detail      string          `json:"detail"`
+}
+
+// SyncAuditFilter narrows GetSyncAudits. Empty fields match everything; Since
+// and Until are epoch seconds, inclusive. EntityId and Name match the rows a
+// push deleted.
+type SyncAuditFilter struct {
+	UserId   string
+	Action   string
+	Outcome  string
+	Table    string
+	EntityId string
+	Name     string
+	Since    int64
+	Until    int64
+	Limit    int
+	Offset   int
+}
+
+// AuditTombs pairs each tomb with the name of the row it deletes. Call it
+// before the rows are deleted.
+func AuditTombs(tx *sqlx.Tx, tombs []Tomb) ([]SyncAuditTomb, error) {
+	auditTombs := make([]SyncAuditTomb, 0, len(tombs))
+	for _, tomb := range tombs {
+		auditTomb := SyncAuditTomb{TableName: tomb.TableName, Id: tomb.Id}
+		if namedTables[tomb.TableName] {
+			err := tx.Get(&auditTomb.Name, "SELECT name FROM "+tomb.TableName+" WHERE id = ?", tomb.Id)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+		}
+		auditTombs = append(auditTombs, auditTomb)
+	}
+	return auditTombs, nil
+}
+
+func AddSyncAudit(tx *sqlx.Tx, audit SyncAudit) error {
+	if audit.Time == 0 {
+		audit.Time = utils.GetEpochTime()
+	}
+	if audit.Tables == nil {
+		audit.Tables = map[string]int{}
+	}
+	if audit.Tombs == nil {
+		audit.Tombs = []SyncAuditTomb{}
+	}
+	tables, err := json.Marshal(audit.Tables)
+	if err != nil {
+		return err
+	}
+	tombs, err := json.Marshal(audit.Tombs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO sync_audit (time, user_id, action, outcome, client_agent, tables, tombs, bytes, sync_token, detail)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, audit.Time, audit.UserId, audit.Action, audit.Outcome, audit.ClientAgent,
+		string(tables), string(tombs), audit.Bytes, audit.SyncToken, audit.Detail)
+	return err
+}
+
+// GetSyncAudits returns the audit entries matching filter, newest first.
+func GetSyncAudits(tx *sqlx.Tx, filter SyncAuditFilter) ([]SyncAudit, error) {
+	conditions := []string{}
+	args := []any{}
+	where := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if filter.UserId != "" {
+		where("user_id = ?", filter.UserId)
+	}
+	if filter.Action != "" {
+		where("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		where("outcome = ?", filter.Outcome)
+	}
+	if filter.Table != "" {
+		conditions = append(conditions, `(
+			EXISTS (SELECT 1 FROM json_each(sync_audit.tables) WHERE key = ?)
+			OR EXISTS (SELECT 1 FROM json_each(sync_audit.tombs) WHERE json_extract(value, '$.table_name') = ?))`)
+		args = append(args, filter.Table, filter.Table)
+	}
+	if filter.EntityId != "" {
+		where("EXISTS (SELECT 1 FROM json_each(sync_audit.tombs) WHERE json_extract(value, '$.id') = ?)", filter.EntityId)
+	}
+	if filter.Name != "" {
+		where("EXISTS (SELECT 1 FROM json_each(sync_audit.tombs) WHERE json_extract(value, '$.name') LIKE ?)", "%"+filter.Name+"%")
+	}
+	if filter.Since != 0 {
+		where("time >= ?", filter.Since)
+	}
+	if filter.Until != 0 {
+		where("time <= ?", filter.Until)
+	}
+
+	query := "SELECT id, time, user_id, action, outcome, client_agent, tables, tombs, bytes, sync_token, detail FROM sync_audit"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows := []struct {
+		Id          int64  `db:"id"`
+		Time        int64  `db:"time"`
+		UserId      string `db:"user_id"`
+		Action      string `db:"action"`
+		Outcome     string `db:"outcome"`
+		ClientAgent string `db:"client_agent"`
+		Tables      string `db:"tables"`
+		Tombs       string `db:"tombs"`
+		Bytes       int64  `db:"bytes"`
+		SyncToken   string `db:"sync_token"`
+		Detail      string `db:"detail"`
+	}{}
+	err := tx.Select(&rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	audits := make([]SyncAudit, 0, len(rows))
+	for _, row := range rows {
+		audit := SyncAudit{
+			Id:          row.Id,
+			Time:        row.Time,
+			UserId:      row.UserId,
+			Action:      row.Action,
+			Outcome:     row.Outcome,
+			ClientAgent: row.ClientAgent,
+			Bytes:       row.Bytes,
+			SyncToken:   row.SyncToken,
+			Detail:      row.Detail,
+		}
+		if err := json.Unmarshal([]byte(row.Tables), &audit.Tables); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(row.Tombs), &audit.Tombs); err != nil {
+			return nil, err
+		}
+		audits = append(audits, audit)
+	}
+	return audits, nil
+}
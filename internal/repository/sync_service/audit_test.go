@@ -0,0 +1,77 @@
+package sync_service_test
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/sync_service"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSyncAudit(t *testing.T) {
+	serverPath := newMergeProject(t, "server")
+	data := conflictingPush(sync_service.ConflictPolicies{})
+	data.Tombs = []repository.Tomb{{Id: "asset-1", TableName: "asset"}}
+
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		tombs, err := repository.AuditTombs(tx, data.Tombs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tombs) != 1 || tombs[0].Name != "hero" {
+			t.Fatalf("unexpected tombs %+v", tombs)
+		}
+		for _, audit := range []repository.SyncAudit{
+			{Time: 100, UserId: "user-1", Action: repository.SyncAuditData, Outcome: repository.SyncAuditApplied,
+				Tables: data.RowCounts(), Tombs: tombs, SyncToken: "token-1"},
+			{Time: 200, UserId: "user-2", Action: repository.SyncAuditChunks, Outcome: repository.SyncAuditApplied, Bytes: 10},
+			{Time: 300, UserId: "user-2", Action: repository.SyncAuditData, Outcome: repository.SyncAuditDenied,
+				Tables: map[string]int{"status": 1}, Detail: "permission denied"},
+		} {
+			if err := repository.AddSyncAudit(tx, audit); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		for _, test := range []struct {
+			filter repository.SyncAuditFilter
+			times  []int64
+		}{
+			{repository.SyncAuditFilter{}, []int64{300, 200, 100}},
+			{repository.SyncAuditFilter{Name: "her"}, []int64{100}},
+			{repository.SyncAuditFilter{EntityId: "asset-1", Until: 150}, []int64{100}},
+			{repository.SyncAuditFilter{EntityId: "asset-1", Since: 150}, nil},
+			{repository.SyncAuditFilter{Table: "asset"}, []int64{100}},
+			{repository.SyncAuditFilter{Table: "status"}, []int64{300}},
+			{repository.SyncAuditFilter{UserId: "user-2", Action: repository.SyncAuditData}, []int64{300}},
+			{repository.SyncAuditFilter{Outcome: repository.SyncAuditApplied, Limit: 1, Offset: 1}, []int64{100}},
+		} {
+			audits, err := repository.GetSyncAudits(tx, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			times := []int64{}
+			for _, audit := range audits {
+				times = append(times, audit.Time)
+			}
+			if len(times) != len(test.times) {
+				t.Fatalf("%+v matched %v, want %v", test.filter, times, test.times)
+			}
+			for i := range times {
+				if times[i] != test.times[i] {
+					t.Fatalf("%+v matched %v, want %v", test.filter, times, test.times)
+				}
+			}
+		}
+
+		audits, err := repository.GetSyncAudits(tx, repository.SyncAuditFilter{UserId: "user-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if audit := audits[0]; audit.Tables["collection"] != 2 || audit.Tables["asset_checkpoint"] != 1 || audit.SyncToken != "token-1" {
+			t.Fatalf("unexpected audit %+v", audit)
+		}
+	})
+}
@@ -11,9 +11,9 @@ import (
 // PermissionError is returned when a sync push attempts an operation the
 // caller's project role does not allow. Handlers should map this to HTTP 403.
 type PermissionError struct {
-	Entity string
-	Op     string
-	Id     string
+	Entity string `json:"entity"`
+	Op     string `json:"op"`
+	Id     string `json:"id"`
 }
 
 func (e *PermissionError) Error() string {
@@ -24,6 +24,18 @@ func deny(entity, op, id string) error {
 	return &PermissionError{Entity: entity, Op: op, Id: id}
 }
 
+// denials collects the violations found by authorizeProjectDataWrite. deny
+// reports whether checking should stop there.
+type denials struct {
+	all    bool
+	denied []*PermissionError
+}
+
+func (d *denials) deny(entity, op, id string) bool {
+	d.denied = append(d.denied, &PermissionError{Entity: entity, Op: op, Id: id})
+	return !d.all
+}
+
 // AuthorizeProjectDataWrite verifies the caller is allowed to perform every
 // mutation implied by the supplied ProjectData. Permissions are read from the
 // project's own .clst (server-side ground truth); the payload's Roles/Users
@@ -32,17 +44,39 @@ func deny(entity, op, id string) error {
 // If bypass is true (project owner or studio admin) all checks are skipped.
 // On the first violation the function returns a *PermissionError; otherwise nil.
 func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, data ProjectData) error {
+	d := &denials{}
+	err := authorizeProjectDataWrite(tx, callerUserId, bypass, data, d)
+	if err != nil {
+		return err
+	}
+	if len(d.denied) > 0 {
+		return d.denied[0]
+	}
+	return nil
+}
+
+// ProjectDataWriteDenials is AuthorizeProjectDataWrite reporting every
+// violation instead of stopping at the first.
+func ProjectDataWriteDenials(tx *sqlx.Tx, callerUserId string, bypass bool, data ProjectData) ([]*PermissionError, error) {
+	d := &denials{all: true}
+	err := authorizeProjectDataWrite(tx, callerUserId, bypass, data, d)
+	return d.denied, err
+}
+
+func authorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, data ProjectData, d *denials) error {
 	if bypass {
 		return nil
 	}
 
 	caller, err := repository.GetUser(tx, callerUserId)
 	if err != nil {
-		return deny("project", "access", callerUserId)
+		d.deny("project", "access", callerUserId)
+		return nil
 	}
 	role, err := repository.GetRole(tx, caller.RoleId)
 	if err != nil {
-		return deny("project", "access", callerUserId)
+		d.deny("project", "access", callerUserId)
+		return nil
 	}
 	isAdmin := role.Name == "admin"
 
@@ -88,12 +122,16 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 
 	// Project preview (project-wide config) → admin only
 	if data.ProjectPreview != "" && !isAdmin {
-		return deny("project_preview", "update", "")
+		if d.deny("project_preview", "update", "") {
+			return nil
+		}
 	}
 
 	// Roles → admin only (creating/updating role permission rows)
 	if len(data.Roles) > 0 && !isAdmin {
-		return deny("role", "modify", "")
+		if d.deny("role", "modify", "") {
+			return nil
+		}
 	}
 
 	// Users: new rows = AddUser; role_id diff = ChangeRole; never allow self-elevation
@@ -101,7 +139,9 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 		local, err := repository.GetUser(tx, u.Id)
 		if err != nil {
 			if !role.AddUser {
-				return deny("user", "add", u.Id)
+				if d.deny("user", "add", u.Id) {
+					return nil
+				}
 			}
 			continue
 		}
@@ -109,10 +149,15 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 			continue
 		}
 		if u.Id == callerUserId {
-			return deny("user", "self_elevate", u.Id)
+			if d.deny("user", "self_elevate", u.Id) {
+				return nil
+			}
+			continue
 		}
 		if !role.ChangeRole {
-			return deny("user", "change_role", u.Id)
+			if d.deny("user", "change_role", u.Id) {
+				return nil
+			}
 		}
 	}
 
@@ -121,12 +166,16 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 		local, exists := collectionsById[c.Id]
 		if !exists {
 			if !role.CreateCollection {
-				return deny("collection", "create", c.Id)
+				if d.deny("collection", "create", c.Id) {
+					return nil
+				}
 			}
 			continue
 		}
 		if local.MTime < c.MTime && !role.UpdateCollection {
-			return deny("collection", "update", c.Id)
+			if d.deny("collection", "update", c.Id) {
+				return nil
+			}
 		}
 	}
 
@@ -134,7 +183,9 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 	for _, ca := range data.CollectionAssignees {
 		if _, err := repository.GetAssignee(tx, ca.Id); err != nil {
 			if !role.AssignAsset {
-				return deny("collection_assignee", "create", ca.Id)
+				if d.deny("collection_assignee", "create", ca.Id) {
+					return nil
+				}
 			}
 		}
 	}
@@ -144,7 +195,9 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 		local, exists := assetsById[a.Id]
 		if !exists {
 			if !role.CreateAsset {
-				return deny("asset", "create", a.Id)
+				if d.deny("asset", "create", a.Id) {
+					return nil
+				}
 			}
 			continue
 		}
@@ -152,30 +205,42 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 			continue
 		}
 		if !role.UpdateAsset {
-			return deny("asset", "update", a.Id)
+			if d.deny("asset", "update", a.Id) {
+				return nil
+			}
 		}
 		if local.StatusId != a.StatusId {
 			if !role.ChangeStatus {
-				return deny("asset", "change_status", a.Id)
+				if d.deny("asset", "change_status", a.Id) {
+					return nil
+				}
 			}
 			switch statusName(a.StatusId) {
 			case "done":
 				if !role.SetDoneAsset {
-					return deny("asset", "set_done", a.Id)
+					if d.deny("asset", "set_done", a.Id) {
+						return nil
+					}
 				}
 			case "retake":
 				if !role.SetRetakeAsset {
-					return deny("asset", "set_retake", a.Id)
+					if d.deny("asset", "set_retake", a.Id) {
+						return nil
+					}
 				}
 			}
 		}
 		if local.AssigneeId != a.AssigneeId {
 			if a.AssigneeId == "" {
 				if !role.UnassignAsset {
-					return deny("asset", "unassign", a.Id)
+					if d.deny("asset", "unassign", a.Id) {
+						return nil
+					}
 				}
 			} else if !role.AssignAsset {
-				return deny("asset", "assign", a.Id)
+				if d.deny("asset", "assign", a.Id) {
+					return nil
+				}
 			}
 		}
 	}
@@ -186,70 +251,78 @@ func AuthorizeProjectDataWrite(tx *sqlx.Tx, callerUserId string, bypass bool, da
 			continue
 		}
 		if !role.CreateCheckpoint {
-			return deny("checkpoint", "create", cp.Id)
+			if d.deny("checkpoint", "create", cp.Id) {
+				return nil
+			}
 		}
 	}
 
 	// Asset / collection dependencies → ManageDependencies
-	for _, d := range data.AssetDependencies {
-		if _, err := repository.GetDependency(tx, d.Id); err != nil && !role.ManageDependencies {
-			return deny("asset_dependency", "create", d.Id)
+	for _, dependency := range data.AssetDependencies {
+		if _, err := repository.GetDependency(tx, dependency.Id); err != nil && !role.ManageDependencies {
+			if d.deny("asset_dependency", "create", dependency.Id) {
+				return nil
+			}
 		}
 	}
-	for _, d := range data.CollectionDependencies {
-		if _, err := repository.GetCollectionDependency(tx, d.Id); err != nil && !role.ManageDependencies {
-			return deny("collection_dependency", "create", d.Id)
+	for _, dependency := range data.CollectionDependencies {
+		if _, err := repository.GetCollectionDependency(tx, dependency.Id); err != nil && !role.ManageDependencies {
+			if d.deny("collection_dependency", "create", dependency.Id) {
+				return nil
+			}
 		}
 	}
 
 	// Templates → create/update/delete
 	for _, t := range data.Templates {
 		if _, err := repository.GetTemplate(tx, t.Id); err != nil && !role.CreateTemplate {
-			return deny("template", "create", t.Id)
+			if d.deny("template", "create", t.Id) {
+				return nil
+			}
 		}
 	}
 
 	// Asset tags → treated as asset edit
 	for _, at := range data.AssetsTags {
 		if _, err := repository.GetAssetTag(tx, at.Id); err != nil && !role.UpdateAsset {
-			return deny("asset_tag", "create", at.Id)
+			if d.deny("asset_tag", "create", at.Id) {
+				return nil
+			}
 		}
 	}
 
 	// Project-wide config (types, statuses, tags, workflows, integrations) → admin only
 	if !isAdmin {
-		switch {
-		case len(data.CollectionTypes) > 0:
-			return deny("collection_type", "modify", "")
-		case len(data.AssetTypes) > 0:
-			return deny("asset_type", "modify", "")
-		case len(data.DependencyTypes) > 0:
-			return deny("dependency_type", "modify", "")
-		case len(data.Statuses) > 0:
-			return deny("status", "modify", "")
-		case len(data.Tags) > 0:
-			return deny("tag", "modify", "")
-		case len(data.Workflows) > 0:
-			return deny("workflow", "modify", "")
-		case len(data.WorkflowLinks) > 0:
-			return deny("workflow_link", "modify", "")
-		case len(data.WorkflowCollections) > 0:
-			return deny("workflow_collection", "modify", "")
-		case len(data.WorkflowAssets) > 0:
-			return deny("workflow_asset", "modify", "")
-		case len(data.IntegrationProjects) > 0:
-			return deny("integration_project", "modify", "")
-		case len(data.IntegrationCollectionMappings) > 0:
-			return deny("integration_collection_mapping", "modify", "")
-		case len(data.IntegrationAssetMappings) > 0:
-			return deny("integration_asset_mapping", "modify", "")
+		for _, config := range []struct {
+			entity string
+			rows   int
+		}{
+			{"collection_type", len(data.CollectionTypes)},
+			{"asset_type", len(data.AssetTypes)},
+			{"dependency_type", len(data.DependencyTypes)},
+			{"status", len(data.Statuses)},
+			{"tag", len(data.Tags)},
+			{"workflow", len(data.Workflows)},
+			{"workflow_link", len(data.WorkflowLinks)},
+			{"workflow_collection", len(data.WorkflowCollections)},
+			{"workflow_asset", len(data.WorkflowAssets)},
+			{"integration_project", len(data.IntegrationProjects)},
+			{"integration_collection_mapping", len(data.IntegrationCollectionMappings)},
+			{"integration_asset_mapping", len(data.IntegrationAssetMappings)},
+		} {
+			if config.rows > 0 && d.deny(config.entity, "modify", "") {
+				return nil
+			}
 		}
 	}
 
 	// Tombs: classify by table_name, gate on the matching delete permission.
 	for _, t := range data.Tombs {
 		if err := authorizeTomb(role, isAdmin, t); err != nil {
-			return err
+			denied := err.(*PermissionError)
+			if d.deny(denied.Entity, denied.Op, denied.Id) {
+				return nil
+			}
 		}
 	}
 
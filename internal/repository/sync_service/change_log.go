@@ -0,0 +1,513 @@
+package sync_service
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/repositorypb"
+	"clustta/internal/utils"
+	"fmt"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/protobuf/proto"
+)
+
+// A studio server records every change to the project tables in change_log,
+// one entry per row carrying the sequence of its latest change. A client that
+// sends back the sequence of its last pull receives only the rows changed
+// since, with deleted rows as tombs.
+
+const (
+	// SyncSequenceHeader carries the change sequence a /data response is
+	// current to. Clients send it back as the since query parameter.
+	SyncSequenceHeader = "Clustta-Sync-Sequence"
+	// SyncModeHeader tells whether a /data response is a full snapshot or a
+	// delta to apply over the previous pull.
+	SyncModeHeader = "Clustta-Sync-Mode"
+	SyncModeFull   = "full"
+	SyncModeDelta  = "delta"
+)
+
+// changeBatchSize keeps id lists below SQLite's bound parameter limit.
+const changeBatchSize = 500
+
+// DataSync describes a /data response. Sequence is empty when the studio does
+// not track changes.
+type DataSync struct {
+	Sequence string
+	Delta    bool
+}
+
+// ChangeTrackingEnabled reports whether row changes are being recorded.
+func ChangeTrackingEnabled(tx *sqlx.Tx) (bool, error) {
+	var count int
+	err := tx.Get(&count, "SELECT COUNT(*) FROM change_tracking")
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// EnableChangeTracking starts recording row changes. Cursors from before the
+// current sequence are answered with a full snapshot. It does nothing when
+// tracking is already on.
+func EnableChangeTracking(tx *sqlx.Tx) error {
+	enabled, err := ChangeTrackingEnabled(tx)
+	if err != nil || enabled {
+		return err
+	}
+	sequence, err := CurrentChangeSequence(tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO change_tracking (id, started_seq, pruned_seq, started_at) VALUES (1, ?, 0, ?)",
+		sequence, utils.GetEpochTime())
+	return err
+}
+
+// CurrentChangeSequence returns the sequence of the latest recorded change,
+// including pruned ones.
+func CurrentChangeSequence(tx *sqlx.Tx) (int64, error) {
+	var sequence int64
+	err := tx.Get(&sequence, "SELECT IFNULL((SELECT seq FROM sqlite_sequence WHERE name = 'change_log'), 0)")
+	return sequence, err
+}
+
+// PruneChangeLog forgets deletions recorded before the given unix time.
+// Cursors older than the newest forgotten deletion get a full snapshot.
+func PruneChangeLog(tx *sqlx.Tx, before int64) (int, error) {
+	var newest int64
+	err := tx.Get(&newest, "SELECT IFNULL(MAX(seq), 0) FROM change_log WHERE deleted = 1 AND changed_at < ?", before)
+	if err != nil || newest == 0 {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM change_log WHERE deleted = 1 AND seq <= ?", newest)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE change_tracking SET pruned_seq = MAX(pruned_seq, ?)", newest)
+	if err != nil {
+		return 0, err
+	}
+	pruned, err := result.RowsAffected()
+	return int(pruned), err
+}
+
+// changesSince maps each table to the ids changed after since, true for the
+// deleted ones. ok is false when the log cannot answer for since.
+func changesSince(tx *sqlx.Tx, since int64) (changes map[string]map[string]bool, ok bool, err error) {
+	tracking := struct {
+		StartedSeq int64 `db:"started_seq"`
+		PrunedSeq  int64 `db:"pruned_seq"`
+	}{}
+	err = tx.Get(&tracking, "SELECT started_seq, pruned_seq FROM change_tracking WHERE id = 1")
+	if err != nil {
+		if enabled, checkErr := ChangeTrackingEnabled(tx); checkErr == nil && !enabled {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	current, err := CurrentChangeSequence(tx)
+	if err != nil {
+		return nil, false, err
+	}
+	if since < max(tracking.StartedSeq, tracking.PrunedSeq) || since > current {
+		return nil, false, nil
+	}
+
+	entries := []struct {
+		TableName string `db:"table_name"`
+		RowId     string `db:"row_id"`
+		Deleted   bool   `db:"deleted"`
+	}{}
+	err = tx.Select(&entries, "SELECT table_name, row_id, deleted FROM change_log WHERE seq > ?", since)
+	if err != nil {
+		return nil, false, err
+	}
+	changes = map[string]map[string]bool{}
+	for _, entry := range entries {
+		if changes[entry.TableName] == nil {
+			changes[entry.TableName] = map[string]bool{}
+		}
+		changes[entry.TableName][entry.RowId] = entry.Deleted
+	}
+	return changes, true, nil
+}
+
+// LoadUserDataSincePb returns the user's data changed after since, or a full
+// snapshot when that cannot be done: the change log does not reach back to
+// since, the user only sees part of the project, the user's role changed, or
+// an integration row was deleted.
+// delta tells which one was returned.
+func LoadUserDataSincePb(tx *sqlx.Tx, userId string, since int64) (data []byte, delta bool, err error) {
+	changes, ok, err := changesSince(tx, since)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		user, err := repository.GetUser(tx, userId)
+		if err != nil {
+			return nil, false, err
+		}
+		userRole, err := repository.GetRole(tx, user.RoleId)
+		if err != nil {
+			return nil, false, err
+		}
+		// What a restricted user sees follows assignments rather than the
+		// rows themselves, so it cannot be diffed row by row.
+		_, userChanged := changes["user"][user.Id]
+		_, roleChanged := changes["role"][userRole.Id]
+		if userRole.ViewAsset && !userChanged && !roleChanged && !integrationRowDeleted(changes) {
+			data, err = loadUserDeltaPb(tx, userRole, changes)
+			return data, true, err
+		}
+	}
+	data, err = LoadUserDataPb(tx, userId)
+	return data, false, err
+}
+
+// integrationRowDeleted reports whether an integration row was deleted.
+// Clients store integration rows under ids of their own, so a tomb could not
+// tell them which row to remove.
+func integrationRowDeleted(changes map[string]map[string]bool) bool {
+	for _, table := range []string{"integration_project", "integration_collection_mapping", "integration_asset_mapping"} {
+		for _, deleted := range changes[table] {
+			if deleted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadUserDeltaPb loads the changed rows visible to a user who can view every
+// asset. Changed rows the user no longer gets, such as trashed checkpoints,
+// are sent as tombs along with the deleted ones.
+func loadUserDeltaPb(tx *sqlx.Tx, userRole models.Role, changes map[string]map[string]bool) ([]byte, error) {
+	delta := &repositorypb.ProjectData{}
+
+	roles, err := selectChangedRows[models.Role](tx, "role", "", changes["role"])
+	if err != nil {
+		return nil, err
+	}
+	delta.Roles = repository.ToPbRoles(roles)
+	users, err := selectChangedRows[models.User](tx, "user", "", changes["user"])
+	if err != nil {
+		return nil, err
+	}
+	delta.Users = repository.ToPbUsers(users)
+	statuses, err := selectChangedRows[models.Status](tx, "status", "", changes["status"])
+	if err != nil {
+		return nil, err
+	}
+	delta.Statuses = repository.ToPbStatuses(statuses)
+	tags, err := selectChangedRows[models.Tag](tx, "tag", "", changes["tag"])
+	if err != nil {
+		return nil, err
+	}
+	delta.Tags = repository.ToPbTags(tags)
+	assetTypes, err := selectChangedRows[models.AssetType](tx, "asset_type", "", changes["asset_type"])
+	if err != nil {
+		return nil, err
+	}
+	delta.AssetTypes = repository.ToPbAssetTypes(assetTypes)
+	collectionTypes, err := selectChangedRows[models.CollectionType](tx, "collection_type", "", changes["collection_type"])
+	if err != nil {
+		return nil, err
+	}
+	delta.CollectionTypes = repository.ToPbCollectionTypes(collectionTypes)
+	dependencyTypes, err := selectChangedRows[models.DependencyType](tx, "dependency_type", "", changes["dependency_type"])
+	if err != nil {
+		return nil, err
+	}
+	delta.DependencyTypes = repository.ToPbDependencyTypes(dependencyTypes)
+
+	collections, err := selectChangedRows[models.Collection](tx, "collection", "", changes["collection"])
+	if err != nil {
+		return nil, err
+	}
+	delta.Collections = repository.ToPbCollections(collections)
+	collectionAssignees, err := selectChangedRows[models.CollectionAssignee](tx, "collection_assignee", "", changes["collection_assignee"])
+	if err != nil {
+		return nil, err
+	}
+	delta.CollectionAssignees = repository.ToPbCollectionAssignees(collectionAssignees)
+	assets, err := selectChangedRows[models.Asset](tx, "asset", "", changes["asset"])
+	if err != nil {
+		return nil, err
+	}
+	delta.Assets = repository.ToPbAssets(assets)
+	checkpoints, err := selectChangedRows[models.Checkpoint](tx, "asset_checkpoint", "trashed = 0", changes["asset_checkpoint"])
+	if err != nil {
+		return nil, err
+	}
+	delta.AssetsCheckpoints = repository.ToPbCheckpoints(checkpoints)
+	assetDependencies, err := selectChangedRows[models.AssetDependency](tx, "asset_dependency", "", changes["asset_dependency"])
+	if err != nil {
+		return nil, err
+	}
+	delta.AssetDependencies = repository.ToPbAssetDependencies(assetDependencies)
+	collectionDependencies, err := selectChangedRows[models.CollectionDependency](tx, "collection_dependency", "", changes["collection_dependency"])
+	if err != nil {
+		return nil, err
+	}
+	delta.CollectionDependencies = repository.ToPbCollectionDependencies(collectionDependencies)
+	assetsTags, err := selectChangedRows[models.AssetTag](tx, "asset_tag", "", changes["asset_tag"])
+	if err != nil {
+		return nil, err
+	}
+	delta.AssetsTags = repository.ToPbAssetTags(assetsTags)
+
+	if userRole.CreateAsset {
+		templates, err := selectChangedRows[models.Template](tx, "template", "trashed = 0", changes["template"])
+		if err != nil {
+			return nil, err
+		}
+		delta.Templates = repository.ToPbTemplates(templates)
+		workflows, err := selectChangedRows[models.Workflow](tx, "workflow", "", changes["workflow"])
+		if err != nil {
+			return nil, err
+		}
+		delta.Workflows = repository.ToPbWorkflows(workflows)
+		workflowLinks, err := selectChangedRows[models.WorkflowLink](tx, "workflow_link", "", changes["workflow_link"])
+		if err != nil {
+			return nil, err
+		}
+		delta.WorkflowLinks = repository.ToPbWorkflowLinks(workflowLinks)
+		workflowCollections, err := selectChangedRows[models.WorkflowCollection](tx, "workflow_collection", "", changes["workflow_collection"])
+		if err != nil {
+			return nil, err
+		}
+		delta.WorkflowCollections = repository.ToPbWorkflowCollections(workflowCollections)
+		workflowAssets, err := selectChangedRows[models.WorkflowAsset](tx, "workflow_asset", "", changes["workflow_asset"])
+		if err != nil {
+			return nil, err
+		}
+		delta.WorkflowAssets = repository.ToPbWorkflowAssets(workflowAssets)
+	}
+
+	integrationProjects, err := selectChangedRows[models.IntegrationProject](tx, "integration_project", "", changes["integration_project"])
+	if err != nil {
+		return nil, err
+	}
+	delta.IntegrationProjects = repository.ToPbIntegrationProjects(integrationProjects)
+	integrationCollectionMappings, err := selectChangedRows[models.IntegrationCollectionMapping](tx, "integration_collection_mapping", "", changes["integration_collection_mapping"])
+	if err != nil {
+		return nil, err
+	}
+	delta.IntegrationCollectionMappings = repository.ToPbIntegrationCollectionMappings(integrationCollectionMappings)
+	integrationAssetMappings, err := selectChangedRows[models.IntegrationAssetMapping](tx, "integration_asset_mapping", "", changes["integration_asset_mapping"])
+	if err != nil {
+		return nil, err
+	}
+	delta.IntegrationAssetMappings = repository.ToPbIntegrationAssetMappings(integrationAssetMappings)
+
+	projectPreview, err := repository.GetProjectPreview(tx)
+	if err != nil {
+		if err.Error() != "no preview" {
+			return nil, err
+		}
+	}
+	delta.ProjectPreview = projectPreview.Hash
+
+	sent := map[string]map[string]bool{
+		"role":                           rowIds(delta.Roles),
+		"user":                           rowIds(delta.Users),
+		"status":                         rowIds(delta.Statuses),
+		"tag":                            rowIds(delta.Tags),
+		"asset_type":                     rowIds(delta.AssetTypes),
+		"collection_type":                rowIds(delta.CollectionTypes),
+		"dependency_type":                rowIds(delta.DependencyTypes),
+		"collection":                     rowIds(delta.Collections),
+		"collection_assignee":            rowIds(delta.CollectionAssignees),
+		"asset":                          rowIds(delta.Assets),
+		"asset_checkpoint":               rowIds(delta.AssetsCheckpoints),
+		"asset_dependency":               rowIds(delta.AssetDependencies),
+		"collection_dependency":          rowIds(delta.CollectionDependencies),
+		"asset_tag":                      rowIds(delta.AssetsTags),
+		"template":                       rowIds(delta.Templates),
+		"workflow":                       rowIds(delta.Workflows),
+		"workflow_link":                  rowIds(delta.WorkflowLinks),
+		"workflow_collection":            rowIds(delta.WorkflowCollections),
+		"workflow_asset":                 rowIds(delta.WorkflowAssets),
+		"integration_project":            rowIds(delta.IntegrationProjects),
+		"integration_collection_mapping": rowIds(delta.IntegrationCollectionMappings),
+		"integration_asset_mapping":      rowIds(delta.IntegrationAssetMappings),
+	}
+	now := utils.GetEpochTime()
+	for table, ids := range changes {
+		for id := range ids {
+			if !sent[table][id] {
+				delta.Tomb = append(delta.Tomb, &repositorypb.Tomb{Id: id, Mtime: int64(now), TableName: table})
+			}
+		}
+	}
+
+	return proto.Marshal(delta)
+}
+
+// selectChangedRows loads the rows of table among the changed ids that were
+// not deleted and match condition.
+func selectChangedRows[T any](tx *sqlx.Tx, table, condition string, changed map[string]bool) ([]T, error) {
+	rows := []T{}
+	ids := []string{}
+	for id, deleted := range changed {
+		if !deleted {
+			ids = append(ids, id)
+		}
+	}
+	if condition != "" {
+		condition = " AND " + condition
+	}
+	for batch := range slices.Chunk(ids, changeBatchSize) {
+		query, args, err := sqlx.In(fmt.Sprintf("SELECT * FROM %s WHERE id IN (?)%s", table, condition), batch)
+		if err != nil {
+			return rows, err
+		}
+		batchRows := []T{}
+		err = tx.Select(&batchRows, tx.Rebind(query), args...)
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, batchRows...)
+	}
+	return rows, nil
+}
+
+func rowIds[T interface{ GetId() string }](rows []T) map[string]bool {
+	ids := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		ids[row.GetId()] = true
+	}
+	return ids
+}
+
+// deltaRow locates a local row replaced or removed by a delta.
+type deltaRow struct {
+	table     string
+	condition string
+	args      []any
+}
+
+// ApplyProjectDelta writes a delta pulled from the studio over the local data.
+// Changed rows are replaced and tombed ones deleted, without leaving local
+// tombs that the next push would send back. Integration rows get new ids when
+// written locally, so they are matched on their external ids instead.
+func ApplyProjectDelta(tx *sqlx.Tx, data ProjectData) error {
+	rows := []deltaRow{}
+	byId := func(table, id string) {
+		rows = append(rows, deltaRow{table, "id = ?", []any{id}})
+	}
+	for _, role := range data.Roles {
+		byId("role", role.Id)
+	}
+	for _, user := range data.Users {
+		byId("user", user.Id)
+	}
+	for _, status := range data.Statuses {
+		byId("status", status.Id)
+	}
+	for _, tag := range data.Tags {
+		byId("tag", tag.Id)
+	}
+	for _, assetType := range data.AssetTypes {
+		byId("asset_type", assetType.Id)
+	}
+	for _, collectionType := range data.CollectionTypes {
+		byId("collection_type", collectionType.Id)
+	}
+	for _, dependencyType := range data.DependencyTypes {
+		byId("dependency_type", dependencyType.Id)
+	}
+	for _, collection := range data.Collections {
+		byId("collection", collection.Id)
+	}
+	for _, collectionAssignee := range data.CollectionAssignees {
+		byId("collection_assignee", collectionAssignee.Id)
+	}
+	for _, asset := range data.Assets {
+		byId("asset", asset.Id)
+	}
+	for _, checkpoint := range data.AssetsCheckpoints {
+		byId("asset_checkpoint", checkpoint.Id)
+	}
+	for _, dependency := range data.AssetDependencies {
+		byId("asset_dependency", dependency.Id)
+	}
+	for _, dependency := range data.CollectionDependencies {
+		byId("collection_dependency", dependency.Id)
+	}
+	for _, assetTag := range data.AssetsTags {
+		byId("asset_tag", assetTag.Id)
+	}
+	for _, template := range data.Templates {
+		byId("template", template.Id)
+	}
+	for _, workflow := range data.Workflows {
+		byId("workflow", workflow.Id)
+	}
+	for _, workflowLink := range data.WorkflowLinks {
+		byId("workflow_link", workflowLink.Id)
+	}
+	for _, workflowCollection := range data.WorkflowCollections {
+		byId("workflow_collection", workflowCollection.Id)
+	}
+	for _, workflowAsset := range data.WorkflowAssets {
+		byId("workflow_asset", workflowAsset.Id)
+	}
+	for _, project := range data.IntegrationProjects {
+		rows = append(rows, deltaRow{"integration_project", "integration_id = ? AND external_project_id = ?",
+			[]any{project.IntegrationId, project.ExternalProjectId}})
+	}
+	for _, mapping := range data.IntegrationCollectionMappings {
+		rows = append(rows, deltaRow{"integration_collection_mapping", "integration_id = ? AND external_id = ?",
+			[]any{mapping.IntegrationId, mapping.ExternalId}})
+	}
+	for _, mapping := range data.IntegrationAssetMappings {
+		rows = append(rows, deltaRow{"integration_asset_mapping", "integration_id = ? AND external_id = ?",
+			[]any{mapping.IntegrationId, mapping.ExternalId}})
+	}
+	for _, tomb := range data.Tombs {
+		if tomb.TableName == "tomb" || !slices.Contains(ProjectTables, tomb.TableName) {
+			return fmt.Errorf("unknown table in delta: %s", tomb.TableName)
+		}
+		byId(tomb.TableName, tomb.Id)
+	}
+
+	for _, row := range rows {
+		ids := []string{}
+		err := tx.Select(&ids, "SELECT id FROM "+row.table+" WHERE "+row.condition, row.args...)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			_, err = tx.Exec("DELETE FROM "+row.table+" WHERE id = ?", id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("DELETE FROM tomb WHERE id = ? AND table_name = ? AND synced = 0", id, row.table)
+			if err != nil {
+				return err
+			}
+			err = repository.DeleteFieldBases(tx, row.table, []string{id})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data.Tombs = nil
+	err := OverWriteProjectData(tx, data)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		_, err = tx.Exec("UPDATE "+row.table+" SET synced = 1 WHERE "+row.condition, row.args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
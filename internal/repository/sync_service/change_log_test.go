@@ -0,0 +1,224 @@
+package sync_service_test
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/sync_service"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newProject(t *testing.T, name string) string {
+	t.Helper()
+	projectPath := filepath.Join(t.TempDir(), name+".clst")
+	db, err := sqlx.Open("sqlite3", projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(repository.ProjectSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO config(name,value,mtime) VALUES('working_dir',?,1)", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	return projectPath
+}
+
+func withProjectTx(t *testing.T, projectPath string, fn func(tx *sqlx.Tx)) {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	fn(tx)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustExec(t *testing.T, tx *sqlx.Tx, query string, args ...any) {
+	t.Helper()
+	if _, err := tx.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// serveData answers /data the way the studio server does.
+func serveData(t *testing.T, projectPath string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db, err := sqlx.Open("sqlite3", projectPath)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer db.Close()
+		tx, err := db.Beginx()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer tx.Rollback()
+
+		var data []byte
+		delta := false
+		err = sync_service.EnableChangeTracking(tx)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		sequence, err := sync_service.CurrentChangeSequence(tx)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if value := r.URL.Query().Get("since"); value != "" {
+			since, _ := strconv.ParseInt(value, 10, 64)
+			data, delta, err = sync_service.LoadUserDataSincePb(tx, "user-1", since)
+		} else {
+			data, err = sync_service.LoadUserDataPb(tx, "user-1")
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		compressed, err := zstd.Compress(nil, data)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set(sync_service.SyncSequenceHeader, strconv.FormatInt(sequence, 10))
+		if delta {
+			w.Header().Set(sync_service.SyncModeHeader, sync_service.SyncModeDelta)
+		}
+		w.Write(compressed)
+	}))
+}
+
+func TestDeltaDataSync(t *testing.T) {
+	serverPath := newProject(t, "server")
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		role, err := repository.CreateRole(tx, "role-1", "admin", models.RoleAttributes{ViewAsset: true, CreateAsset: true, PullChunk: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.AddKnownUser(tx, "user-1", "user@example.com", "user", "First", "Last", role.Id, nil, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.CreateStatus(tx, "status-1", "todo", "todo", "#ffffff"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.CreateAssetType(tx, "type-1", "model", "model"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.CreateCollectionType(tx, "ctype-1", "shot", "shot"); err != nil {
+			t.Fatal(err)
+		}
+		if err = repository.AddCollection(tx, "collection-1", "shots", "", "ctype-1", "", "", false); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"asset-1", "asset-2"} {
+			mustExec(t, tx, `INSERT INTO asset (id, mtime, created_at, name, extension, asset_type_id, collection_id, status_id)
+				VALUES (?, 1, 1, ?, '.blend', 'type-1', 'collection-1', 'status-1')`, id, id)
+		}
+		mustExec(t, tx, `INSERT INTO asset_checkpoint (id, mtime, created_at, asset_id, xxhash_checksum, time_modified, file_size, chunks, author_id)
+			VALUES ('checkpoint-1', 1, 1, 'asset-1', 'sum', 1, 1, 'chunk', 'user-1')`)
+	})
+
+	server := serveData(t, serverPath)
+	defer server.Close()
+
+	clientPath := newProject(t, "client")
+	sequence := ""
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		data, dataSync, err := sync_service.FetchDataSince(server.URL, "user-1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dataSync.Delta || len(data.Assets) != 2 {
+			t.Fatalf("expected a full snapshot, got delta=%v with %d assets", dataSync.Delta, len(data.Assets))
+		}
+		if err = sync_service.OverWriteProjectData(tx, data); err != nil {
+			t.Fatal(err)
+		}
+		sequence = dataSync.Sequence
+	})
+
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET name = 'renamed', mtime = 2 WHERE id = 'asset-1'")
+		mustExec(t, tx, "DELETE FROM asset WHERE id = 'asset-2'")
+		mustExec(t, tx, "UPDATE asset_checkpoint SET trashed = 1, mtime = 2 WHERE id = 'checkpoint-1'")
+		mustExec(t, tx, `INSERT INTO asset (id, mtime, created_at, name, extension, asset_type_id, collection_id, status_id)
+			VALUES ('asset-3', 2, 2, 'asset-3', '.blend', 'type-1', 'collection-1', 'status-1')`)
+	})
+
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		data, dataSync, err := sync_service.FetchDataSince(server.URL, "user-1", sequence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !dataSync.Delta {
+			t.Fatal("expected a delta")
+		}
+		if len(data.Assets) != 2 || len(data.Users) != 0 || len(data.Collections) != 0 || len(data.Tombs) != 2 {
+			t.Fatalf("unexpected delta: %d assets, %d users, %d collections, %d tombs",
+				len(data.Assets), len(data.Users), len(data.Collections), len(data.Tombs))
+		}
+		if err = sync_service.ApplyProjectDelta(tx, data); err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		if err = tx.Select(&names, "SELECT name FROM asset WHERE synced = 1 ORDER BY name"); err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 2 || names[0] != "asset-3" || names[1] != "renamed" {
+			t.Fatalf("client assets after delta: %v", names)
+		}
+		var checkpoints, tombs int
+		tx.Get(&checkpoints, "SELECT COUNT(*) FROM asset_checkpoint")
+		tx.Get(&tombs, "SELECT COUNT(*) FROM tomb WHERE synced = 0")
+		if checkpoints != 0 || tombs != 0 {
+			t.Fatalf("%d checkpoints and %d local tombs left after delta", checkpoints, tombs)
+		}
+	})
+
+	// Once the deletion is pruned, or the user's role changes, the old
+	// cursor gets a full snapshot again.
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		if _, err := sync_service.PruneChangeLog(tx, 1<<40); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, dataSync, err := sync_service.FetchDataSince(server.URL, "user-1", sequence); err != nil || dataSync.Delta {
+		t.Fatalf("pruned cursor: delta=%v err=%v", dataSync.Delta, err)
+	}
+	var current string
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		value, err := sync_service.CurrentChangeSequence(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current = strconv.FormatInt(value, 10)
+		mustExec(t, tx, "UPDATE role SET view_asset = 0, mtime = 3 WHERE id = 'role-1'")
+	})
+	if _, dataSync, err := sync_service.FetchDataSince(server.URL, "user-1", current); err != nil || dataSync.Delta {
+		t.Fatalf("cursor across a role change: delta=%v err=%v", dataSync.Delta, err)
+	}
+}
@@ -0,0 +1,359 @@
+package sync_service
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/repositorypb"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Policies a push can ask the server to settle a name conflict with.
+const (
+	// ConflictPolicyRename gives the pushed entity the first free name with
+	// a numeric suffix.
+	ConflictPolicyRename = "rename"
+	// ConflictPolicyMerge folds the pushed entity into the existing one:
+	// children, checkpoints and other references move over to its id.
+	ConflictPolicyMerge = "merge"
+	// ConflictPolicySkip leaves the pushed entity, and everything under it,
+	// out of the push.
+	ConflictPolicySkip = "skip"
+)
+
+var ErrUnknownConflictPolicy = errors.New("unknown conflict policy")
+
+// ConflictPolicies picks how name conflicts are settled on push. ById maps a
+// pushed collection or asset id to its policy; Default covers the rest. An
+// empty policy leaves the conflict to be reported.
+type ConflictPolicies struct {
+	Default string            `json:"default"`
+	ById    map[string]string `json:"by_id"`
+}
+
+func (p ConflictPolicies) policyFor(id string) string {
+	if policy, ok := p.ById[id]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+func (p ConflictPolicies) validate() error {
+	policies := []string{p.Default}
+	for _, policy := range p.ById {
+		policies = append(policies, policy)
+	}
+	for _, policy := range policies {
+		switch policy {
+		case "", ConflictPolicyRename, ConflictPolicyMerge, ConflictPolicySkip:
+		default:
+			return fmt.Errorf("%w %q", ErrUnknownConflictPolicy, policy)
+		}
+	}
+	return nil
+}
+
+func ConflictPoliciesFromPb(policy string, resolutions []*repositorypb.ConflictResolution) ConflictPolicies {
+	policies := ConflictPolicies{Default: policy, ById: make(map[string]string, len(resolutions))}
+	for _, resolution := range resolutions {
+		policies.ById[resolution.LocalId] = resolution.Policy
+	}
+	return policies
+}
+
+func (p ConflictPolicies) ToPb() []*repositorypb.ConflictResolution {
+	pb := make([]*repositorypb.ConflictResolution, 0, len(p.ById))
+	for localId, policy := range p.ById {
+		pb = append(pb, &repositorypb.ConflictResolution{LocalId: localId, Policy: policy})
+	}
+	return pb
+}
+
+// NameConflictError is returned by PushDataResolving when the server refused
+// a push over name conflicts no policy settled.
+type NameConflictError struct {
+	Conflicts []ConflictInfo
+}
+
+// Error keeps the JSON WriteResult that callers of PushData have always been
+// handed for a refused push.
+func (e *NameConflictError) Error() string {
+	body, _ := json.Marshal(WriteResult{Success: false, Conflicts: e.Conflicts})
+	return string(body)
+}
+
+// ConflictResolution records how the server settled one name conflict.
+type ConflictResolution struct {
+	ConflictInfo
+	Policy     string `json:"policy"`
+	ResolvedId string `json:"resolved_id"` // id on the server, empty when skipped
+	Resolved   string `json:"resolved"`    // name on the server, empty when skipped
+}
+
+// ResolveNameConflicts finds pushed collections and assets whose names clash
+// with ones already on the server and settles each by data.ConflictPolicies,
+// rewriting data so WriteProjectData applies the outcome. Conflicts without
+// a policy are returned unresolved with Success false. IdMap holds the
+// pushed ids that were merged into existing ones.
+func ResolveNameConflicts(tx *sqlx.Tx, data *ProjectData) (*WriteResult, error) {
+	result := &WriteResult{Success: true, Conflicts: []ConflictInfo{}}
+	policies := data.ConflictPolicies
+	if err := policies.validate(); err != nil {
+		return nil, err
+	}
+
+	tombItems := make(map[string]bool)
+	tombedItems, err := repository.GetTombedItems(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, tombItem := range tombedItems {
+		tombItems[tombItem] = true
+	}
+
+	localCollections, err := repository.GetSimpleCollections(tx)
+	if err != nil {
+		return nil, err
+	}
+	localCollectionsIndex := make(map[string]bool)
+	entityByNameParent := make(map[string]string)
+	for _, collection := range localCollections {
+		localCollectionsIndex[collection.Id] = true
+		entityByNameParent[strings.ToLower(collection.Name)+"|"+collection.ParentId] = collection.Id
+	}
+
+	// Parents come before children so a child sees its parent's outcome.
+	sortedCollections, err := repository.TopologicalSort(data.Collections)
+	if err != nil {
+		return nil, err
+	}
+
+	idMap := make(map[string]string)
+	skipped := make(map[string]bool)
+	resolve := func(conflict ConflictInfo, name string, taken map[string]string, key func(string) string) (string, bool) {
+		policy := policies.policyFor(conflict.LocalId)
+		resolution := ConflictResolution{ConflictInfo: conflict, Policy: policy}
+		switch policy {
+		case ConflictPolicyRename:
+			for n := 1; ; n++ {
+				resolution.Resolved = fmt.Sprintf("%s_%d", name, n)
+				if _, used := taken[key(resolution.Resolved)]; !used {
+					break
+				}
+			}
+			resolution.ResolvedId = conflict.LocalId
+		case ConflictPolicyMerge:
+			idMap[conflict.LocalId] = conflict.ExistingId
+			resolution.ResolvedId = conflict.ExistingId
+			resolution.Resolved = name
+		case ConflictPolicySkip:
+			skipped[conflict.LocalId] = true
+		default:
+			// Unresolved: children are checked against the existing
+			// entity, as a merge would place them.
+			idMap[conflict.LocalId] = conflict.ExistingId
+			result.Conflicts = append(result.Conflicts, conflict)
+			return "", false
+		}
+		result.Resolutions = append(result.Resolutions, resolution)
+		return resolution.Resolved, policy == ConflictPolicyRename
+	}
+
+	collections := []models.Collection{}
+	for _, collection := range sortedCollections {
+		if tombItems[collection.Id] || localCollectionsIndex[collection.Id] {
+			// A move under a skipped collection is dropped, one under a
+			// merged collection follows it.
+			if skipped[collection.ParentId] {
+				continue
+			}
+			if mappedParentId, exists := idMap[collection.ParentId]; exists {
+				collection.ParentId = mappedParentId
+			}
+			collections = append(collections, collection)
+			continue
+		}
+		if skipped[collection.ParentId] {
+			skipped[collection.Id] = true
+			continue
+		}
+		originalParentId := collection.ParentId
+		if mappedParentId, exists := idMap[collection.ParentId]; exists {
+			collection.ParentId = mappedParentId
+		}
+
+		parentKey := func(name string) string { return strings.ToLower(name) + "|" + collection.ParentId }
+		if existingId, hasConflict := entityByNameParent[parentKey(collection.Name)]; hasConflict {
+			newName, renamed := resolve(ConflictInfo{
+				Type:       "collection",
+				LocalId:    collection.Id,
+				ExistingId: existingId,
+				Name:       collection.Name,
+				ParentId:   originalParentId,
+			}, collection.Name, entityByNameParent, parentKey)
+			if !renamed {
+				continue
+			}
+			collection.Name = newName
+		}
+		entityByNameParent[parentKey(collection.Name)] = collection.Id
+		collections = append(collections, collection)
+	}
+
+	localAssets, err := repository.GetSimpleAssets(tx)
+	if err != nil {
+		return nil, err
+	}
+	localAssetsIndex := make(map[string]bool)
+	assetByKey := make(map[string]string)
+	for _, asset := range localAssets {
+		localAssetsIndex[asset.Id] = true
+		assetByKey[strings.ToLower(asset.Name)+"|"+asset.CollectionId+"|"+asset.Extension] = asset.Id
+	}
+
+	assets := []models.Asset{}
+	for _, asset := range data.Assets {
+		if tombItems[asset.Id] || localAssetsIndex[asset.Id] {
+			if skipped[asset.CollectionId] {
+				continue
+			}
+			if mappedCollectionId, exists := idMap[asset.CollectionId]; exists {
+				asset.CollectionId = mappedCollectionId
+			}
+			assets = append(assets, asset)
+			continue
+		}
+		if skipped[asset.CollectionId] {
+			skipped[asset.Id] = true
+			continue
+		}
+		originalCollectionId := asset.CollectionId
+		if mappedCollectionId, exists := idMap[asset.CollectionId]; exists {
+			asset.CollectionId = mappedCollectionId
+		}
+
+		assetKey := func(name string) string {
+			return strings.ToLower(name) + "|" + asset.CollectionId + "|" + asset.Extension
+		}
+		if existingId, hasConflict := assetByKey[assetKey(asset.Name)]; hasConflict {
+			newName, renamed := resolve(ConflictInfo{
+				Type:       "asset",
+				LocalId:    asset.Id,
+				ExistingId: existingId,
+				Name:       asset.Name,
+				ParentId:   originalCollectionId,
+				Extension:  asset.Extension,
+			}, asset.Name, assetByKey, assetKey)
+			if !renamed {
+				continue
+			}
+			asset.Name = newName
+		}
+		assetByKey[assetKey(asset.Name)] = asset.Id
+		assets = append(assets, asset)
+	}
+
+	if len(result.Conflicts) > 0 {
+		result.Success = false
+		return result, nil
+	}
+	data.Collections = collections
+	data.Assets = assets
+	remapProjectData(data, idMap, skipped)
+	for localId, existingId := range idMap {
+		if result.IdMap == nil {
+			result.IdMap = make(map[string]string)
+		}
+		result.IdMap[localId] = existingId
+	}
+	return result, nil
+}
+
+// remapProjectData points the rows referencing merged collections and assets
+// at the entities they were merged into, and drops the ones referencing
+// skipped entities.
+func remapProjectData(data *ProjectData, idMap map[string]string, skipped map[string]bool) {
+	remap := func(id string) string {
+		if mapped, ok := idMap[id]; ok {
+			return mapped
+		}
+		return id
+	}
+
+	checkpoints := []models.Checkpoint{}
+	for _, checkpoint := range data.AssetsCheckpoints {
+		if skipped[checkpoint.AssetId] {
+			skipped[checkpoint.Id] = true
+			continue
+		}
+		checkpoint.AssetId = remap(checkpoint.AssetId)
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	data.AssetsCheckpoints = checkpoints
+
+	assetDependencies := []models.AssetDependency{}
+	for _, dependency := range data.AssetDependencies {
+		if skipped[dependency.AssetId] || skipped[dependency.DependencyId] {
+			continue
+		}
+		dependency.AssetId = remap(dependency.AssetId)
+		dependency.DependencyId = remap(dependency.DependencyId)
+		assetDependencies = append(assetDependencies, dependency)
+	}
+	data.AssetDependencies = assetDependencies
+
+	collectionDependencies := []models.CollectionDependency{}
+	for _, dependency := range data.CollectionDependencies {
+		if skipped[dependency.AssetId] || skipped[dependency.DependencyId] {
+			continue
+		}
+		dependency.AssetId = remap(dependency.AssetId)
+		dependency.DependencyId = remap(dependency.DependencyId)
+		collectionDependencies = append(collectionDependencies, dependency)
+	}
+	data.CollectionDependencies = collectionDependencies
+
+	collectionAssignees := []models.CollectionAssignee{}
+	for _, assignee := range data.CollectionAssignees {
+		if skipped[assignee.CollectionId] {
+			continue
+		}
+		assignee.CollectionId = remap(assignee.CollectionId)
+		collectionAssignees = append(collectionAssignees, assignee)
+	}
+	data.CollectionAssignees = collectionAssignees
+
+	assetTags := []models.AssetTag{}
+	for _, assetTag := range data.AssetsTags {
+		if skipped[assetTag.AssetId] {
+			continue
+		}
+		assetTag.AssetId = remap(assetTag.AssetId)
+		assetTags = append(assetTags, assetTag)
+	}
+	data.AssetsTags = assetTags
+
+	collectionMappings := []models.IntegrationCollectionMapping{}
+	for _, mapping := range data.IntegrationCollectionMappings {
+		if skipped[mapping.CollectionId] {
+			continue
+		}
+		mapping.CollectionId = remap(mapping.CollectionId)
+		collectionMappings = append(collectionMappings, mapping)
+	}
+	data.IntegrationCollectionMappings = collectionMappings
+
+	assetMappings := []models.IntegrationAssetMapping{}
+	for _, mapping := range data.IntegrationAssetMappings {
+		if skipped[mapping.AssetId] {
+			continue
+		}
+		mapping.AssetId = remap(mapping.AssetId)
+		assetMappings = append(assetMappings, mapping)
+	}
+	data.IntegrationAssetMappings = assetMappings
+}
@@ -0,0 +1,113 @@
+package sync_service_test
+
+import (
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/sync_service"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// conflictingPush is a client's "shots" collection, holding a "sq01"
+// collection and a "hero" asset with one checkpoint, made offline while the
+// server got its own "shots" with a "hero".
+func conflictingPush(policies sync_service.ConflictPolicies) sync_service.ProjectData {
+	return sync_service.ProjectData{
+		Collections: []models.Collection{
+			{Id: "local-sq01", MTime: 5, CreatedAt: "5", Name: "sq01", CollectionTypeId: "ctype-1", ParentId: "local-shots"},
+			{Id: "local-shots", MTime: 5, CreatedAt: "5", Name: "Shots", CollectionTypeId: "ctype-1"},
+		},
+		Assets: []models.Asset{
+			{Id: "local-hero", MTime: 5, CreatedAt: "5", Name: "hero", Extension: ".blend",
+				AssetTypeId: "type-1", CollectionId: "local-shots", StatusId: "status-1"},
+		},
+		AssetsCheckpoints: []models.Checkpoint{
+			{Id: "local-checkpoint", MTime: 5, CreatedAt: "2024-01-01T00:00:00Z", AssetId: "local-hero",
+				XXHashChecksum: "sum", Chunks: "chunk", AuthorUID: "user-1"},
+		},
+		ConflictPolicies: policies,
+	}
+}
+
+func resolveAndWrite(t *testing.T, serverPath string, data sync_service.ProjectData) *sync_service.WriteResult {
+	t.Helper()
+	var result *sync_service.WriteResult
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		var err error
+		if result, err = sync_service.ResolveNameConflicts(tx, &data); err != nil {
+			t.Fatal(err)
+		}
+		if result.Success {
+			if err = sync_service.WriteProjectData(tx, data, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	return result
+}
+
+func TestNameConflictPolicies(t *testing.T) {
+	// Without a policy both clashes are reported and nothing is written.
+	serverPath := newMergeProject(t, "server")
+	result := resolveAndWrite(t, serverPath, conflictingPush(sync_service.ConflictPolicies{}))
+	if result.Success || len(result.Conflicts) != 2 {
+		t.Fatalf("expected two conflicts, got %+v", result)
+	}
+
+	// Merging moves the children and the checkpoint onto the server's ids.
+	result = resolveAndWrite(t, serverPath, conflictingPush(sync_service.ConflictPolicies{Default: sync_service.ConflictPolicyMerge}))
+	if !result.Success || result.IdMap["local-shots"] != "collection-1" || result.IdMap["local-hero"] != "asset-1" {
+		t.Fatalf("unexpected merge result %+v", result)
+	}
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		var parentId, assetId string
+		var collections int
+		tx.Get(&parentId, "SELECT parent_id FROM collection WHERE id = 'local-sq01'")
+		tx.Get(&assetId, "SELECT asset_id FROM asset_checkpoint WHERE id = 'local-checkpoint'")
+		tx.Get(&collections, "SELECT COUNT(*) FROM collection")
+		if parentId != "collection-1" || assetId != "asset-1" || collections != 2 {
+			t.Fatalf("merge left parent %q, checkpoint asset %q and %d collections", parentId, assetId, collections)
+		}
+	})
+
+	// Renaming the collection leaves nothing under it clashing, so the
+	// asset's own policy never applies.
+	serverPath = newMergeProject(t, "server-2")
+	result = resolveAndWrite(t, serverPath, conflictingPush(sync_service.ConflictPolicies{
+		Default: sync_service.ConflictPolicyRename,
+		ById:    map[string]string{"local-hero": sync_service.ConflictPolicySkip},
+	}))
+	if !result.Success || len(result.Resolutions) != 1 || result.Resolutions[0].Resolved != "Shots_1" {
+		t.Fatalf("unexpected rename result %+v", result)
+	}
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		var name string
+		var checkpoints int
+		tx.Get(&name, "SELECT name FROM collection WHERE id = 'local-shots'")
+		tx.Get(&checkpoints, "SELECT COUNT(*) FROM asset_checkpoint")
+		if name != "Shots_1" || checkpoints != 1 {
+			t.Fatalf("rename left %q with %d checkpoints", name, checkpoints)
+		}
+	})
+
+	serverPath = newMergeProject(t, "server-3")
+	result = resolveAndWrite(t, serverPath, conflictingPush(sync_service.ConflictPolicies{Default: sync_service.ConflictPolicySkip}))
+	if !result.Success || len(result.Resolutions) != 1 {
+		t.Fatalf("unexpected skip result %+v", result)
+	}
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		var rows int
+		tx.Get(&rows, "SELECT (SELECT COUNT(*) FROM collection) + (SELECT COUNT(*) FROM asset) + (SELECT COUNT(*) FROM asset_checkpoint)")
+		if rows != 2 {
+			t.Fatalf("skipping the collection still wrote %d rows of its subtree", rows-2)
+		}
+	})
+
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		data := conflictingPush(sync_service.ConflictPolicies{Default: "overwrite"})
+		if _, err := sync_service.ResolveNameConflicts(tx, &data); !errors.Is(err, sync_service.ErrUnknownConflictPolicy) {
+			t.Fatalf("unknown policy: %v", err)
+		}
+	})
+}
@@ -1007,6 +1007,12 @@ func LoadChangedData(tx *sqlx.Tx) (ProjectData, error) {
 	}
 	userData.IntegrationAssetMappings = integrationAssetMappings
 
+	fieldBases, err := repository.GetFieldBases(tx)
+	if err != nil {
+		return userData, err
+	}
+	userData.FieldBases = fieldBases
+
 	return userData, nil
 }
 
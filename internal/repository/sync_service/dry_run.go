@@ -0,0 +1,202 @@
+package sync_service
+
+import (
+	"clustta/internal/chunk_service"
+	"clustta/internal/repository"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TableChanges counts the rows a push would insert, update and delete in
+// one table.
+type TableChanges struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Deleted  int `json:"deleted"`
+}
+
+// DryRunReport describes what a push would do without doing it.
+type DryRunReport struct {
+	Conflicts       []ConflictInfo          `json:"conflicts"`
+	Resolutions     []ConflictResolution    `json:"resolutions"`
+	FieldConflicts  []FieldConflict         `json:"field_conflicts"`
+	Denials         []*PermissionError      `json:"denials"`
+	Tables          map[string]TableChanges `json:"tables"`
+	TombsApplied    int                     `json:"tombs_applied"`
+	MissingChunks   []string                `json:"missing_chunks"`
+	MissingPreviews []string                `json:"missing_previews"`
+	// WriteError is why the write itself would fail, if it would.
+	WriteError string `json:"write_error,omitempty"`
+}
+
+// DryRunProjectData runs a push of data by callerUserId through conflict
+// resolution, authorization and the write, and reports the outcome. All of it
+// happens inside a savepoint that is always rolled back, so tx is left as it
+// was. Chunks and previews the push has not uploaded yet are reported rather
+// than failing the write.
+func DryRunProjectData(tx *sqlx.Tx, callerUserId string, bypass bool, data ProjectData) (*DryRunReport, error) {
+	report := &DryRunReport{
+		Conflicts:       []ConflictInfo{},
+		Resolutions:     []ConflictResolution{},
+		FieldConflicts:  []FieldConflict{},
+		Denials:         []*PermissionError{},
+		Tables:          map[string]TableChanges{},
+		MissingChunks:   []string{},
+		MissingPreviews: []string{},
+	}
+
+	_, err := tx.Exec("SAVEPOINT dry_run")
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Exec("RELEASE dry_run")
+	defer tx.Exec("ROLLBACK TO dry_run")
+
+	result, err := ResolveNameConflicts(tx, &data)
+	if err != nil {
+		return nil, err
+	}
+	report.Conflicts = result.Conflicts
+	report.Resolutions = append(report.Resolutions, result.Resolutions...)
+
+	fieldConflicts, err := MergeFieldEdits(tx, &data)
+	if err != nil {
+		return nil, err
+	}
+	report.FieldConflicts = fieldConflicts
+
+	denials, err := ProjectDataWriteDenials(tx, callerUserId, bypass, data)
+	if err != nil {
+		return nil, err
+	}
+	report.Denials = append(report.Denials, denials...)
+
+	chunks := []string{}
+	for _, checkpoint := range data.AssetsCheckpoints {
+		chunks = append(chunks, strings.Split(checkpoint.Chunks, ",")...)
+	}
+	for _, template := range data.Templates {
+		chunks = append(chunks, strings.Split(template.Chunks, ",")...)
+	}
+	slices.Sort(chunks)
+	chunks = slices.Compact(chunks)
+	chunks = slices.DeleteFunc(chunks, func(chunk string) bool { return chunk == "" })
+	missingChunks, err := chunk_service.GetNonExistingChunks(tx, chunks)
+	if err != nil {
+		return nil, err
+	}
+	report.MissingChunks = append(report.MissingChunks, missingChunks...)
+
+	previewIds := []string{}
+	if data.ProjectPreview != "" {
+		previewIds = append(previewIds, data.ProjectPreview)
+	}
+	for _, asset := range data.Assets {
+		previewIds = append(previewIds, asset.PreviewId)
+	}
+	for _, collection := range data.Collections {
+		previewIds = append(previewIds, collection.PreviewId)
+	}
+	for _, checkpoint := range data.AssetsCheckpoints {
+		previewIds = append(previewIds, checkpoint.PreviewId)
+	}
+	slices.Sort(previewIds)
+	previewIds = slices.Compact(previewIds)
+	previewIds = slices.DeleteFunc(previewIds, func(id string) bool { return id == "" })
+	missingPreviews, err := repository.GetNonExistingPreviews(tx, previewIds)
+	if err != nil {
+		return nil, err
+	}
+	report.MissingPreviews = append(report.MissingPreviews, missingPreviews...)
+	// Stand-ins let the write go ahead as it will once the previews are up.
+	for _, hash := range missingPreviews {
+		_, err = tx.Exec("INSERT INTO preview (hash) VALUES (?)", hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = trackDryRunChanges(tx)
+	if err != nil {
+		return nil, err
+	}
+	err = WriteProjectData(tx, data, false)
+	if err == nil {
+		err = repository.AddItemsToTomb(tx, data.Tombs)
+	}
+	if err != nil {
+		report.WriteError = err.Error()
+	}
+
+	changes := []struct {
+		TableName string `db:"table_name"`
+		RowId     string `db:"row_id"`
+		Op        string `db:"op"`
+	}{}
+	// A row inserted and then touched by a trigger still counts as inserted.
+	err = tx.Select(&changes, `
+		SELECT table_name, row_id,
+			CASE WHEN MAX(op = 'insert') THEN 'insert' WHEN MAX(op = 'delete') THEN 'delete' ELSE 'update' END AS op
+		FROM temp.dry_run_change
+		GROUP BY table_name, row_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	tombs := map[string]bool{}
+	for _, tomb := range data.Tombs {
+		tombs[tomb.TableName+"|"+tomb.Id] = true
+	}
+	for _, change := range changes {
+		tableChanges := report.Tables[change.TableName]
+		switch change.Op {
+		case "insert":
+			tableChanges.Inserted++
+		case "update":
+			tableChanges.Updated++
+		case "delete":
+			tableChanges.Deleted++
+			if tombs[change.TableName+"|"+change.RowId] {
+				report.TombsApplied++
+			}
+		}
+		report.Tables[change.TableName] = tableChanges
+	}
+	return report, nil
+}
+
+// trackDryRunChanges records every row written to a project table in
+// temp.dry_run_change, through temporary triggers that go away with the
+// dry run's savepoint.
+func trackDryRunChanges(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TEMP TABLE dry_run_change (
+			table_name TEXT NOT NULL,
+			row_id TEXT NOT NULL,
+			op TEXT NOT NULL,
+			UNIQUE (table_name, row_id, op)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	for _, table := range ProjectTables {
+		if table == "tomb" {
+			continue
+		}
+		for op, row := range map[string]string{"insert": "NEW", "update": "NEW", "delete": "OLD"} {
+			_, err = tx.Exec(`
+				CREATE TEMP TRIGGER dry_run_` + table + `_` + op + ` AFTER ` + strings.ToUpper(op) + ` ON main.` + table + `
+				BEGIN
+					INSERT OR IGNORE INTO dry_run_change (table_name, row_id, op) VALUES ('` + table + `', ` + row + `.id, '` + op + `');
+				END
+			`)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
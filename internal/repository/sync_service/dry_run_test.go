@@ -0,0 +1,68 @@
+package sync_service_test
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/sync_service"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestDryRunProjectData(t *testing.T) {
+	serverPath := newMergeProject(t, "server")
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		if _, err := repository.CreateRole(tx, "role-viewer", "viewer", models.RoleAttributes{ViewAsset: true}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repository.AddKnownUser(tx, "user-1", "viewer@example.com", "viewer", "View", "Er", "role-viewer", nil, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	data := conflictingPush(sync_service.ConflictPolicies{Default: sync_service.ConflictPolicyRename})
+	data.Tombs = []repository.Tomb{{Id: "asset-1", TableName: "asset"}}
+
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		// Twice in one transaction: the first run must leave nothing behind.
+		for range 2 {
+			report, err := sync_service.DryRunProjectData(tx, "user-1", false, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.WriteError != "" {
+				t.Fatalf("write would fail: %s", report.WriteError)
+			}
+			if len(report.Resolutions) != 1 || report.Resolutions[0].Resolved != "Shots_1" {
+				t.Fatalf("unexpected resolutions %+v", report.Resolutions)
+			}
+			// Both collections, the asset, its checkpoint and the tomb.
+			if len(report.Denials) != 5 {
+				t.Fatalf("expected five denials, got %d", len(report.Denials))
+			}
+			if denial := report.Denials[4]; denial.Entity != "asset" || denial.Op != "delete" || denial.Id != "asset-1" {
+				t.Fatalf("unexpected tomb denial %+v", denial)
+			}
+			if changes := report.Tables["collection"]; changes.Inserted != 2 || changes.Deleted != 0 {
+				t.Fatalf("collection changes %+v", changes)
+			}
+			if changes := report.Tables["asset"]; changes.Inserted != 1 || changes.Deleted != 1 {
+				t.Fatalf("asset changes %+v", changes)
+			}
+			if report.Tables["asset_checkpoint"].Inserted != 1 || report.TombsApplied != 1 {
+				t.Fatalf("checkpoint changes %+v, %d tombs applied", report.Tables["asset_checkpoint"], report.TombsApplied)
+			}
+			if len(report.MissingChunks) != 1 || report.MissingChunks[0] != "chunk" {
+				t.Fatalf("missing chunks %v", report.MissingChunks)
+			}
+		}
+	})
+
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		var rows int
+		tx.Get(&rows, "SELECT (SELECT COUNT(*) FROM collection) + (SELECT COUNT(*) FROM asset) + (SELECT COUNT(*) FROM asset_checkpoint)")
+		if rows != 2 {
+			t.Fatalf("dry run left %d rows behind", rows-2)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package sync_service
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// FieldConflict is a field that the pushing client and the server both
+// changed, to different values, since the client last synced.
+type FieldConflict struct {
+	Table  string `json:"table"`
+	Id     string `json:"id"`
+	Field  string `json:"field"`
+	Base   string `json:"base"`
+	Local  string `json:"local"`  // the pushing client's value
+	Remote string `json:"remote"` // the server's value
+}
+
+// FieldConflictError is returned by PushData when the server kept its own
+// values for fields the client also changed. The rest of the push was
+// applied; each conflict is settled with ResolveFieldConflict before pushing
+// again.
+type FieldConflictError struct {
+	Conflicts []FieldConflict
+}
+
+func (e *FieldConflictError) Error() string {
+	fields := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		fields[i] = conflict.Table + "." + conflict.Field
+	}
+	return fmt.Sprintf("%d field conflicts: %s", len(e.Conflicts), strings.Join(fields, ", "))
+}
+
+type mergeField[T any] struct {
+	name string
+	get  func(*T) string
+	set  func(*T, string)
+}
+
+// The fields WriteProjectData carries over on update, and so the ones the
+// *_field_base triggers record.
+var assetMergeFields = []mergeField[models.Asset]{
+	{"name", func(a *models.Asset) string { return a.Name }, func(a *models.Asset, v string) { a.Name = v }},
+	{"collection_id", func(a *models.Asset) string { return a.CollectionId }, func(a *models.Asset, v string) { a.CollectionId = v }},
+	{"asset_type_id", func(a *models.Asset) string { return a.AssetTypeId }, func(a *models.Asset, v string) { a.AssetTypeId = v }},
+	{"assignee_id", func(a *models.Asset) string { return a.AssigneeId }, func(a *models.Asset, v string) { a.AssigneeId = v }},
+	{"assigner_id", func(a *models.Asset) string { return a.AssignerId }, func(a *models.Asset, v string) { a.AssignerId = v }},
+	{"status_id", func(a *models.Asset) string { return a.StatusId }, func(a *models.Asset, v string) { a.StatusId = v }},
+	{"preview_id", func(a *models.Asset) string { return a.PreviewId }, func(a *models.Asset, v string) { a.PreviewId = v }},
+	{"is_resource", func(a *models.Asset) string { return boolField(a.IsResource) }, func(a *models.Asset, v string) { a.IsResource = v == "1" }},
+	{"is_link", func(a *models.Asset) string { return boolField(a.IsLink) }, func(a *models.Asset, v string) { a.IsLink = v == "1" }},
+	{"pointer", func(a *models.Asset) string { return a.Pointer }, func(a *models.Asset, v string) { a.Pointer = v }},
+}
+
+var collectionMergeFields = []mergeField[models.Collection]{
+	{"name", func(c *models.Collection) string { return c.Name }, func(c *models.Collection, v string) { c.Name = v }},
+	{"parent_id", func(c *models.Collection) string { return c.ParentId }, func(c *models.Collection, v string) { c.ParentId = v }},
+	{"collection_type_id", func(c *models.Collection) string { return c.CollectionTypeId }, func(c *models.Collection, v string) { c.CollectionTypeId = v }},
+	{"preview_id", func(c *models.Collection) string { return c.PreviewId }, func(c *models.Collection, v string) { c.PreviewId = v }},
+	{"is_shared", func(c *models.Collection) string { return boolField(c.IsShared) }, func(c *models.Collection, v string) { c.IsShared = v == "1" }},
+}
+
+func boolField(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func mergeFieldNames[T any](fields []mergeField[T]) []string {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.name
+	}
+	return names
+}
+
+// mergeRow three-way merges local into remote field by field against base.
+// Fields only one side changed take that side's value; fields both changed
+// differently are left at the remote value and reported. It returns whether
+// the merged row differs from remote.
+func mergeRow[T any](table, id string, fields []mergeField[T], base map[string]string, local *T, remote *T) (bool, []FieldConflict) {
+	changed := false
+	conflicts := []FieldConflict{}
+	for _, field := range fields {
+		baseValue, tracked := base[field.name]
+		localValue, remoteValue := field.get(local), field.get(remote)
+		switch {
+		case localValue == remoteValue:
+		case !tracked || localValue == baseValue:
+			field.set(local, remoteValue)
+		case remoteValue == baseValue:
+			changed = true
+		default:
+			conflicts = append(conflicts, FieldConflict{
+				Table: table, Id: id, Field: field.name,
+				Base: baseValue, Local: localValue, Remote: remoteValue,
+			})
+			field.set(local, remoteValue)
+		}
+	}
+	return changed, conflicts
+}
+
+// MergeFieldEdits three-way merges the pushed assets and collections that
+// carry field bases with the server's rows, rewriting data in place so
+// WriteProjectData stores the merged values. Rows without bases keep the
+// last-writer-wins behaviour. Conflicting fields keep the server's values and
+// are returned so the client can settle them.
+func MergeFieldEdits(tx *sqlx.Tx, data *ProjectData) ([]FieldConflict, error) {
+	conflicts := []FieldConflict{}
+	if len(data.FieldBases) == 0 {
+		return conflicts, nil
+	}
+	bases := map[string]map[string]string{}
+	for _, base := range data.FieldBases {
+		key := base.TableName + "|" + base.RowId
+		if bases[key] == nil {
+			bases[key] = map[string]string{}
+		}
+		bases[key][base.Field] = base.Value
+	}
+
+	assetQuery := "SELECT id, mtime, " + strings.Join(mergeFieldNames(assetMergeFields), ", ") + " FROM asset WHERE id = ?"
+	for i := range data.Assets {
+		local := &data.Assets[i]
+		base, ok := bases["asset|"+local.Id]
+		if !ok {
+			continue
+		}
+		remote := models.Asset{}
+		err := tx.Get(&remote, assetQuery, local.Id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return conflicts, err
+		}
+		changed, rowConflicts := mergeRow("asset", local.Id, assetMergeFields, base, local, &remote)
+		conflicts = append(conflicts, rowConflicts...)
+		local.MTime = mergedMtime(changed, local.MTime, remote.MTime)
+	}
+
+	collectionQuery := "SELECT id, mtime, " + strings.Join(mergeFieldNames(collectionMergeFields), ", ") + " FROM collection WHERE id = ?"
+	for i := range data.Collections {
+		local := &data.Collections[i]
+		base, ok := bases["collection|"+local.Id]
+		if !ok {
+			continue
+		}
+		remote := models.Collection{}
+		err := tx.Get(&remote, collectionQuery, local.Id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return conflicts, err
+		}
+		changed, rowConflicts := mergeRow("collection", local.Id, collectionMergeFields, base, local, &remote)
+		conflicts = append(conflicts, rowConflicts...)
+		local.MTime = mergedMtime(changed, local.MTime, remote.MTime)
+	}
+	return conflicts, nil
+}
+
+// mergedMtime makes WriteProjectData store a merged row that differs from
+// the server's however old the client's edit was, and skip one that does not.
+func mergedMtime(changed bool, local, remote int) int {
+	if !changed {
+		return remote
+	}
+	return max(local, remote) + 1
+}
+
+// keepFieldConflicts leaves the rows whose fields the server kept unsynced,
+// with the conflicting fields' bases, so they are pushed again once settled
+// and conflict again until then.
+func keepFieldConflicts(tx *sqlx.Tx, conflicts []FieldConflict) error {
+	for _, conflict := range conflicts {
+		if conflict.Table != "asset" && conflict.Table != "collection" {
+			return fmt.Errorf("unknown table %s", conflict.Table)
+		}
+		err := repository.SetFieldBase(tx, conflict.Table, conflict.Id, conflict.Field, conflict.Base)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE "+conflict.Table+" SET synced = 0 WHERE id = ?", conflict.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveFieldConflict settles a conflict the server kept its value for.
+// Keeping the local value rebases the field onto the server's so the next
+// push wins; otherwise the server's value is written locally.
+func ResolveFieldConflict(tx *sqlx.Tx, conflict FieldConflict, keepLocal bool) error {
+	var fields []string
+	switch conflict.Table {
+	case "asset":
+		fields = mergeFieldNames(assetMergeFields)
+	case "collection":
+		fields = mergeFieldNames(collectionMergeFields)
+	}
+	if !utils.Contains(fields, conflict.Field) {
+		return fmt.Errorf("unknown field %s.%s", conflict.Table, conflict.Field)
+	}
+	err := repository.SetFieldBase(tx, conflict.Table, conflict.Id, conflict.Field, conflict.Remote)
+	if err != nil {
+		return err
+	}
+	if keepLocal {
+		return nil
+	}
+	_, err = tx.Exec("UPDATE "+conflict.Table+" SET "+conflict.Field+" = ?, mtime = ? WHERE id = ?",
+		conflict.Remote, utils.GetEpochTime(), conflict.Id)
+	return err
+}
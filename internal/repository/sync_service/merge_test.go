@@ -0,0 +1,132 @@
+package sync_service_test
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/sync_service"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func newMergeProject(t *testing.T, name string) string {
+	t.Helper()
+	projectPath := newProject(t, name)
+	withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+		for _, id := range []string{"status-1", "status-2"} {
+			if _, err := repository.CreateStatus(tx, id, id, id, "#ffffff"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := repository.CreateAssetType(tx, "type-1", "model", "model"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repository.CreateCollectionType(tx, "ctype-1", "shot", "shot"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repository.AddCollection(tx, "collection-1", "shots", "", "ctype-1", "", "", false); err != nil {
+			t.Fatal(err)
+		}
+		mustExec(t, tx, `INSERT INTO asset (id, mtime, created_at, name, extension, asset_type_id, collection_id, status_id)
+			VALUES ('asset-1', 1, 1, 'hero', '.blend', 'type-1', 'collection-1', 'status-1')`)
+		mustExec(t, tx, "UPDATE asset SET synced = 1")
+	})
+	return projectPath
+}
+
+// push merges the client's changes into the server the way PostDataHandler
+// does, keeping the server's values for conflicting fields.
+func push(t *testing.T, clientPath, serverPath string) []sync_service.FieldConflict {
+	t.Helper()
+	var data sync_service.ProjectData
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		var err error
+		if data, err = sync_service.LoadChangedData(tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var conflicts []sync_service.FieldConflict
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		var err error
+		if conflicts, err = sync_service.MergeFieldEdits(tx, &data); err != nil {
+			t.Fatal(err)
+		}
+		if err = sync_service.WriteProjectData(tx, data, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return conflicts
+}
+
+func serverAsset(t *testing.T, serverPath string) models.Asset {
+	t.Helper()
+	asset := models.Asset{}
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		if err := tx.Get(&asset, "SELECT name, status_id, assignee_id FROM asset WHERE id = 'asset-1'"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return asset
+}
+
+func TestFieldMerge(t *testing.T) {
+	serverPath := newMergeProject(t, "server")
+	clientPath := newMergeProject(t, "client")
+
+	// The client changes the status offline while the server, later, gets a
+	// new assignee: both edits survive.
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET status_id = 'status-2', mtime = 2 WHERE id = 'asset-1'")
+		mustExec(t, tx, "UPDATE asset SET name = 'hero', mtime = 3 WHERE id = 'asset-1'")
+		bases, err := repository.GetFieldBases(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, base := range bases {
+			if base.Field == "status_id" && base.Value != "status-1" {
+				t.Fatalf("status base %q, want the synced value", base.Value)
+			}
+		}
+	})
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET assignee_id = 'user-2', mtime = 100 WHERE id = 'asset-1'")
+	})
+	if conflicts := push(t, clientPath, serverPath); len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts %+v", conflicts)
+	}
+	if asset := serverAsset(t, serverPath); asset.StatusId != "status-2" || asset.AssigneeId != "user-2" {
+		t.Fatalf("merged asset has status %q and assignee %q", asset.StatusId, asset.AssigneeId)
+	}
+
+	// Both sides rename it: the server keeps its name and reports both
+	// values, and the client's name goes through once the client keeps it.
+	clientPath = newMergeProject(t, "client-2")
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET name = 'hero_local', mtime = 2 WHERE id = 'asset-1'")
+	})
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET name = 'hero_remote', mtime = 200 WHERE id = 'asset-1'")
+	})
+	conflicts := push(t, clientPath, serverPath)
+	if len(conflicts) != 1 {
+		t.Fatalf("expected one conflict, got %+v", conflicts)
+	}
+	conflict := conflicts[0]
+	if conflict.Field != "name" || conflict.Base != "hero" || conflict.Local != "hero_local" || conflict.Remote != "hero_remote" {
+		t.Fatalf("unexpected conflict %+v", conflict)
+	}
+	if asset := serverAsset(t, serverPath); asset.Name != "hero_remote" {
+		t.Fatalf("conflicting push renamed the asset to %q", asset.Name)
+	}
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		if err := sync_service.ResolveFieldConflict(tx, conflict, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if conflicts := push(t, clientPath, serverPath); len(conflicts) != 0 {
+		t.Fatalf("conflicts after resolving: %+v", conflicts)
+	}
+	if asset := serverAsset(t, serverPath); asset.Name != "hero_local" || asset.StatusId != "status-2" {
+		t.Fatalf("resolved asset is %q with status %q", asset.Name, asset.StatusId)
+	}
+}
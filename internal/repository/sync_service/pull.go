@@ -21,8 +21,23 @@ import (
 )
 
 func PullData(ctx context.Context, projectPath, remoteUrl string, userId string, pullChunk bool, syncOptions SyncOptions, callback func(int, int, string, string)) error {
+	user, err := auth_service.GetActiveUser()
+	if err != nil {
+		return err
+	}
+	return pullData(ctx, projectPath, remoteUrl, user, userId, pullChunk, syncOptions, callback)
+}
+
+// PullDataAs pulls like PullData on behalf of user rather than the user
+// signed in to this machine, for servers pulling from one another.
+func PullDataAs(ctx context.Context, projectPath, remoteUrl string, user auth_service.User, pullChunk bool, syncOptions SyncOptions, callback func(int, int, string, string)) error {
+	return pullData(ctx, projectPath, remoteUrl, user, user.Id, pullChunk, syncOptions, callback)
+}
+
+func pullData(ctx context.Context, projectPath, remoteUrl string, user auth_service.User, userId string, pullChunk bool, syncOptions SyncOptions, callback func(int, int, string, string)) error {
 	fmt.Printf("start pull for %s\n", projectPath)
 	trueStart := time.Now()
+	ctx = chunk_service.WithBandwidthLimit(ctx, syncOptions.MaxBytesPerSecond)
 	dbConn, err := utils.OpenDb(projectPath)
 	if err != nil {
 		return err
@@ -40,12 +55,23 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 		return err
 	}
 
-	user, err := auth_service.GetActiveUser()
+	selection, err := GetCollectionSelection(tx)
 	if err != nil {
 		return err
 	}
+	if requested, ok := syncOptions.Selection(); ok && !requested.Equal(selection) {
+		// Collections entering or leaving the selection are not in any
+		// delta, so the new selection is pulled in full.
+		selection = requested
+		syncOptions.Force = true
+		err = SetCollectionSelection(tx, selection)
+		if err != nil {
+			return err
+		}
+	}
+	syncOptions.IncludePaths, syncOptions.ExcludePaths, syncOptions.FullClone = selection.IncludePaths, selection.ExcludePaths, false
 
-	projectInfo, err := repository.GetProjectInfo(remoteUrl, user)
+	projectInfo, err := repository.GetProjectInfoContext(ctx, remoteUrl, user)
 	if err != nil {
 		return err
 	}
@@ -62,6 +88,12 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 	if err != nil {
 		return err
 	}
+	if projectInfo.ChunkingProfiles != nil {
+		err = repository.SaveChunkingProfiles(tx, *projectInfo.ChunkingProfiles)
+		if err != nil {
+			return err
+		}
+	}
 
 	isUpToDate := false
 
@@ -72,13 +104,23 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 
 	start := time.Now()
 	data := ProjectData{}
+	dataSync := DataSync{}
 	if isUpToDate {
 		data, err = LoadUserData(tx, userId)
 		if err != nil {
 			return err
 		}
 	} else {
-		data, err = FetchData(remoteUrl, userId)
+		since := ""
+		// What enters a sparse clone's selection cannot be told from a
+		// delta, so it always pulls its selection in full.
+		if !syncOptions.Force && selection.IsEmpty() {
+			since, err = utils.GetProjectSyncSequence(tx)
+			if err != nil {
+				return err
+			}
+		}
+		data, dataSync, err = FetchSelectedData(ctx, remoteUrl, userId, since, selection)
 		if err != nil {
 			return err
 		}
@@ -90,21 +132,6 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 		return ctx.Err()
 	}
 
-	userRole := models.Role{}
-	userRoleId := ""
-	for _, user := range data.Users {
-		if user.Id == userId {
-			userRoleId = user.RoleId
-			break
-		}
-	}
-	for _, role := range data.Roles {
-		if role.Id == userRoleId {
-			userRole = role
-			break
-		}
-	}
-
 	start = time.Now()
 	missingPreviews, err := CalculateMissingPreviews(tx, data)
 	if err != nil {
@@ -115,7 +142,7 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 
 	start = time.Now()
 	if len(missingPreviews) > 0 {
-		err = repository.PullPreviews(tx, remoteUrl, missingPreviews, callback)
+		err = repository.PullPreviews(ctx, tx, remoteUrl, missingPreviews, callback)
 		if err != nil {
 			return err
 		}
@@ -127,7 +154,35 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 		return ctx.Err()
 	}
 
-	if !isUpToDate {
+	if !isUpToDate && dataSync.Delta {
+		start = time.Now()
+		err = ApplyProjectDelta(tx, data)
+		if err != nil {
+			return err
+		}
+		elapsed = time.Since(start)
+		fmt.Printf("applying data delta took %s\n", elapsed)
+
+		err = utils.SetProjectSyncToken(tx, projectInfo.SyncToken)
+		if err != nil {
+			return err
+		}
+		err = utils.SetProjectSyncSequence(tx, dataSync.Sequence)
+		if err != nil {
+			return err
+		}
+		err = utils.SetLastSyncTime(tx, utils.GetEpochTime())
+		if err != nil {
+			return err
+		}
+
+		// The delta only holds what changed; chunks are worked out from
+		// the whole of the user's data.
+		data, err = LoadUserData(tx, userId)
+		if err != nil {
+			return err
+		}
+	} else if !isUpToDate {
 		start = time.Now()
 		err = ClearLocalDataDrop(tx)
 		if err != nil {
@@ -140,6 +195,10 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 		if err != nil {
 			return err
 		}
+		err = utils.SetProjectSyncSequence(tx, dataSync.Sequence)
+		if err != nil {
+			return err
+		}
 
 		start = time.Now()
 		err = OverWriteProjectData(tx, data)
@@ -160,6 +219,21 @@ func PullData(ctx context.Context, projectPath, remoteUrl string, userId string,
 		}
 	}
 
+	userRole := models.Role{}
+	userRoleId := ""
+	for _, user := range data.Users {
+		if user.Id == userId {
+			userRoleId = user.RoleId
+			break
+		}
+	}
+	for _, role := range data.Roles {
+		if role.Id == userRoleId {
+			userRole = role
+			break
+		}
+	}
+
 	start = time.Now()
 	missingChunks := []string{}
 	allChunks := []string{}
@@ -217,11 +291,17 @@ func PullLatestCheckpoints(ctx context.Context, projectPath, remoteUrl string, u
 		return ctx.Err()
 	}
 
+	selection, err := GetCollectionSelection(tx)
+	if err != nil {
+		return err
+	}
 	syncOptions := SyncOptions{
 		OnlyLatestCheckpoints: true,
 		Assets:                true,
 		AssetDependencies:     true,
 		Resources:             true,
+		IncludePaths:          selection.IncludePaths,
+		ExcludePaths:          selection.ExcludePaths,
 	}
 
 	if ctx.Err() != nil {
@@ -490,7 +570,7 @@ func GetStudioProjects(user auth_service.User, url string, studioName string) ([
 							return studioProjects, err
 						}
 						if len(missingPreviews) > 0 {
-							err = repository.PullPreviews(tx, projectUrl, missingPreviews, func(i1, i2 int, s1, s2 string) {})
+							err = repository.PullPreviews(context.Background(), tx, projectUrl, missingPreviews, func(i1, i2 int, s1, s2 string) {})
 							if err != nil {
 								return studioProjects, err
 							}
@@ -7,6 +7,8 @@ import (
 	"clustta/internal/repository"
 	"clustta/internal/repository/repositorypb"
 	"clustta/internal/utils"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,35 +17,75 @@ import (
 	"time"
 
 	"github.com/DataDog/zstd"
+	"github.com/jmoiron/sqlx"
 	"google.golang.org/protobuf/proto"
 )
 
+const (
+	// maxPushAttempts is how many times a data push is sent before its
+	// error is returned.
+	maxPushAttempts = 3
+	// pushRetryDelay is the wait before the first retry; later ones wait
+	// longer.
+	pushRetryDelay = 2 * time.Second
+)
+
+// retryPushStatus reports whether a push answered with status is worth
+// sending again.
+func retryPushStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
 func PushData(projectPath, remoteUrl string, userId string, callback func(int, int, string, string)) error {
-	dbConn, err := utils.OpenDb(projectPath)
+	result, err := PushDataResolving(projectPath, remoteUrl, userId, ConflictPolicies{}, callback)
 	if err != nil {
 		return err
 	}
+	if len(result.FieldConflicts) > 0 {
+		return &FieldConflictError{Conflicts: result.FieldConflicts}
+	}
+	return nil
+}
+
+// PushDataResolving pushes like PushData, asking the server to settle name
+// conflicts by policies, and returns how it settled them and the fields it
+// kept its own values for.
+func PushDataResolving(projectPath, remoteUrl string, userId string, policies ConflictPolicies, callback func(int, int, string, string)) (*WriteResult, error) {
+	return PushDataWithOptions(projectPath, remoteUrl, userId, policies, SyncOptions{}, callback)
+}
+
+// PushDataWithOptions pushes like PushDataResolving, uploading chunks no
+// faster than syncOptions.MaxBytesPerSecond. Its other options apply to
+// pulls only.
+func PushDataWithOptions(projectPath, remoteUrl string, userId string, policies ConflictPolicies, syncOptions SyncOptions, callback func(int, int, string, string)) (*WriteResult, error) {
+	ctx := chunk_service.WithBandwidthLimit(context.Background(), syncOptions.MaxBytesPerSecond)
+	result := &WriteResult{Success: true}
+	dbConn, err := utils.OpenDb(projectPath)
+	if err != nil {
+		return nil, err
+	}
 	defer dbConn.Close()
 
 	tx, err := dbConn.Beginx()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
 	// data, err := LoadCheckpointData(tx)
 	err = repository.ClearTrash(tx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	data, err := LoadChangedData(tx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if data.IsEmpty() {
-		return nil
+		return result, nil
 	}
+	data.ConflictPolicies = policies
 	pdData := repositorypb.ProjectData{
 		ProjectPreview:  data.ProjectPreview,
 		CollectionTypes:     repository.ToPbCollectionTypes(data.CollectionTypes),
@@ -77,16 +119,21 @@ func PushData(projectPath, remoteUrl string, userId string, callback func(int, i
 		IntegrationProjects:           repository.ToPbIntegrationProjects(data.IntegrationProjects),
 		IntegrationCollectionMappings: repository.ToPbIntegrationCollectionMappings(data.IntegrationCollectionMappings),
 		IntegrationAssetMappings:      repository.ToPbIntegrationAssetMappings(data.IntegrationAssetMappings),
+
+		FieldBases: repository.ToPbFieldBases(data.FieldBases),
+
+		ConflictPolicy:      policies.Default,
+		ConflictResolutions: policies.ToPb(),
 	}
 
 	dataByte, err := proto.Marshal(&pdData)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	compressedData, err := zstd.CompressLevel(nil, dataByte, 3)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	chunks := []string{}
@@ -113,16 +160,16 @@ func PushData(projectPath, remoteUrl string, userId string, callback func(int, i
 
 	remoteMissingChunks, err := FetchMissingChunks(remoteUrl, userId, chunks)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(remoteMissingChunks) > 0 {
 		remoteMissingChunksInfo, err := chunk_service.GetChunksInfo(tx, remoteMissingChunks)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = chunk_service.PushChunksBatch(tx, remoteUrl, userId, remoteMissingChunksInfo, callback)
+		err = chunk_service.PushChunksResumable(ctx, tx, remoteUrl, userId, remoteMissingChunksInfo, callback)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -148,13 +195,13 @@ func PushData(projectPath, remoteUrl string, userId string, callback func(int, i
 
 	remoteMissingPreviews, err := FetchMissingPreviews(remoteUrl, userId, previewIds)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(remoteMissingPreviews) > 0 {
 		err = repository.PushPreviews(tx, remoteUrl, userId, remoteMissingPreviews, callback)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -166,70 +213,136 @@ func PushData(projectPath, remoteUrl string, userId string, callback func(int, i
 		// 	return err
 		// }
 
-		req, err := http.NewRequest("POST", dataUrl, bytes.NewBuffer(compressedData))
-		if err != nil {
-			return err
-		}
-		req.Header.Set("Clustta-Agent", constants.USER_AGENT)
-
 		client := &http.Client{
 			Timeout: 10 * time.Minute, // total time including connection, redirects, reading body
 		}
-		response, err := client.Do(req)
-		if err != nil {
-			return err
+		// Every attempt carries the same key, so a push the server applied
+		// before the connection dropped is answered, not applied, again.
+		idempotencyKey := utils.GenerateToken()
+		var response *http.Response
+		for attempt := 1; ; attempt++ {
+			req, err := http.NewRequest("POST", dataUrl, bytes.NewReader(compressedData))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Clustta-Agent", constants.USER_AGENT)
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+			response, err = client.Do(req)
+			if err == nil && !retryPushStatus(response.StatusCode) {
+				break
+			}
+			if attempt == maxPushAttempts {
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+			if err == nil {
+				response.Body.Close()
+			}
+			time.Sleep(time.Duration(attempt) * pushRetryDelay)
 		}
 		defer response.Body.Close()
 
 		responseCode := response.StatusCode
 		if responseCode == 200 {
-			err = utils.SetTablesToSynced(tx, ProjectTables)
+			body, err := io.ReadAll(response.Body)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			err = tx.Commit()
+			// Servers before conflict policies answer with an empty body.
+			if len(body) > 0 {
+				err = json.Unmarshal(body, result)
+				if err != nil {
+					return nil, err
+				}
+			}
+			err = finishPush(tx, result)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			return nil
+			return result, nil
 		} else {
 			body, err := io.ReadAll(response.Body)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			if responseCode == http.StatusConflict {
+				conflictResult := WriteResult{}
+				if json.Unmarshal(body, &conflictResult) == nil {
+					if len(conflictResult.Conflicts) > 0 {
+						return nil, &NameConflictError{Conflicts: conflictResult.Conflicts}
+					}
+				}
 			}
-			return errors.New(string(body))
+			return nil, errors.New(string(body))
 		}
 	} else if utils.FileExists(remoteUrl) {
 		db, err := utils.OpenDb(remoteUrl)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer db.Close()
 		remoteTx, err := db.Beginx()
 		if err != nil {
-			return err
+			return nil, err
+		}
+		defer remoteTx.Rollback()
+		result, err = ResolveNameConflicts(remoteTx, &data)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Success {
+			return nil, &NameConflictError{Conflicts: result.Conflicts}
+		}
+		fieldConflicts, err := MergeFieldEdits(remoteTx, &data)
+		if err != nil {
+			return nil, err
+		}
+		if len(fieldConflicts) > 0 {
+			result.FieldConflicts = fieldConflicts
 		}
 		err = WriteProjectData(remoteTx, data, true)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		err = remoteTx.Commit()
 		if err != nil {
-			remoteTx.Rollback()
-			return err
+			return nil, err
 		}
 
-		err = utils.SetTablesToSynced(tx, ProjectTables)
+		err = finishPush(tx, result)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = tx.Commit()
+		return result, nil
+	} else {
+		return nil, fmt.Errorf("invalid url:%s", remoteUrl)
+	}
+}
+
+// finishPush marks the pushed rows synced, except those with fields the
+// server kept its own values for. When the server renamed, merged or skipped
+// any of them, the stored sync sequence is dropped so the next pull is a full
+// one and replaces the local copies with the server's.
+func finishPush(tx *sqlx.Tx, result *WriteResult) error {
+	err := utils.SetTablesToSynced(tx, ProjectTables)
+	if err != nil {
+		return err
+	}
+	err = repository.ClearFieldBases(tx)
+	if err != nil {
+		return err
+	}
+	err = keepFieldConflicts(tx, result.FieldConflicts)
+	if err != nil {
+		return err
+	}
+	if len(result.Resolutions) > 0 {
+		err = utils.SetProjectSyncSequence(tx, "")
 		if err != nil {
 			return err
 		}
-
-		return nil
-	} else {
-		return fmt.Errorf("invalid url:%s", remoteUrl)
 	}
+	return tx.Commit()
 }
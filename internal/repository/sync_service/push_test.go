@@ -0,0 +1,88 @@
+package sync_service_test
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/sync_service"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestPushRetriesWithSameIdempotencyKey(t *testing.T) {
+	clientPath := newMergeProject(t, "client")
+	keys := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chunks-missing", "/previews-exist":
+			w.Write([]byte("[]"))
+		case "/data":
+			keys = append(keys, r.Header.Get("Idempotency-Key"))
+			if len(keys) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"success":true}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	if err := sync_service.PushData(clientPath, server.URL, "user-1", func(int, int, string, string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("push sent idempotency keys %q", keys)
+	}
+}
+
+func TestPushKeepsFieldConflictsUnsynced(t *testing.T) {
+	clientPath := newMergeProject(t, "client")
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		mustExec(t, tx, "UPDATE asset SET name = 'hero_local', status_id = 'status-2', mtime = 2 WHERE id = 'asset-1'")
+	})
+	conflict := sync_service.FieldConflict{
+		Table: "asset", Id: "asset-1", Field: "name",
+		Base: "hero", Local: "hero_local", Remote: "hero_remote",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chunks-missing", "/previews-exist":
+			w.Write([]byte("[]"))
+		case "/data":
+			json.NewEncoder(w).Encode(sync_service.WriteResult{
+				Success:        true,
+				FieldConflicts: []sync_service.FieldConflict{conflict},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	err := sync_service.PushData(clientPath, server.URL, "user-1", func(int, int, string, string) {})
+	var conflictErr *sync_service.FieldConflictError
+	if !errors.As(err, &conflictErr) || len(conflictErr.Conflicts) != 1 {
+		t.Fatalf("expected the field conflict to be reported, got %v", err)
+	}
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		var synced bool
+		if err := tx.Get(&synced, "SELECT synced FROM asset WHERE id = 'asset-1'"); err != nil {
+			t.Fatal(err)
+		}
+		if synced {
+			t.Fatal("the conflicting asset was marked synced")
+		}
+		bases, err := repository.GetFieldBases(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bases) != 1 || bases[0].Field != "name" || bases[0].Value != "hero" {
+			t.Fatalf("expected only the conflicting field's base to be kept, got %+v", bases)
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package sync_service
+
+import (
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/repositorypb"
+	"clustta/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/protobuf/proto"
+)
+
+// CollectionSelection limits a sparse clone to collection subtrees. A
+// collection is selected when its path is under one of IncludePaths, or
+// IncludePaths is empty, and under none of ExcludePaths. The zero value
+// selects the whole project.
+//
+// Paths are collection paths as stored in collection_path, "/sequences/sq010/"
+// for collection sq010 of the root collection sequences.
+type CollectionSelection struct {
+	IncludePaths []string `json:"include_paths"`
+	ExcludePaths []string `json:"exclude_paths"`
+}
+
+// NewCollectionSelection returns the selection of include minus exclude,
+// with paths normalised so "sequences/sq010" and "/sequences/sq010/" are the
+// same subtree.
+func NewCollectionSelection(include, exclude []string) CollectionSelection {
+	return CollectionSelection{
+		IncludePaths: normalizeCollectionPaths(include),
+		ExcludePaths: normalizeCollectionPaths(exclude),
+	}
+}
+
+func normalizeCollectionPaths(paths []string) []string {
+	normalized := []string{}
+	for _, path := range paths {
+		path = strings.Trim(strings.TrimSpace(path), "/")
+		if path == "" {
+			path = "/"
+		} else {
+			path = "/" + path + "/"
+		}
+		if !slices.Contains(normalized, path) {
+			normalized = append(normalized, path)
+		}
+	}
+	slices.Sort(normalized)
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// Selection returns the collection selection the options ask for, and
+// whether they ask for one at all rather than leaving the saved one.
+func (o SyncOptions) Selection() (CollectionSelection, bool) {
+	if o.FullClone {
+		return CollectionSelection{}, true
+	}
+	selection := NewCollectionSelection(o.IncludePaths, o.ExcludePaths)
+	return selection, !selection.IsEmpty()
+}
+
+func (s CollectionSelection) IsEmpty() bool {
+	return len(s.IncludePaths) == 0 && len(s.ExcludePaths) == 0
+}
+
+func (s CollectionSelection) Equal(other CollectionSelection) bool {
+	return slices.Equal(s.IncludePaths, other.IncludePaths) && slices.Equal(s.ExcludePaths, other.ExcludePaths)
+}
+
+// Contains reports whether the collection at collectionPath is selected.
+// Assets outside any collection are at "/".
+func (s CollectionSelection) Contains(collectionPath string) bool {
+	if collectionPath == "" {
+		collectionPath = "/"
+	}
+	included := len(s.IncludePaths) == 0
+	for _, path := range s.IncludePaths {
+		if strings.HasPrefix(collectionPath, path) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, path := range s.ExcludePaths {
+		if strings.HasPrefix(collectionPath, path) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectedAssets returns the ids of the assets in selected collections.
+func (s CollectionSelection) selectedAssets(data ProjectData) map[string]bool {
+	paths := map[string]string{}
+	for _, collection := range data.Collections {
+		paths[collection.Id] = collection.CollectionPath
+	}
+	selected := map[string]bool{}
+	for _, asset := range data.Assets {
+		path := "/"
+		if asset.CollectionId != "" {
+			path = paths[asset.CollectionId]
+		}
+		if path != "" && s.Contains(path) {
+			selected[asset.Id] = true
+		}
+	}
+	return selected
+}
+
+// Filter returns the part of data a sparse clone of the selection holds:
+// the selected collections and their assets, the assets those depend on,
+// and the ancestors of every collection kept, so paths still resolve.
+// Rows not tied to a collection, such as statuses and templates, are kept.
+func (s CollectionSelection) Filter(data ProjectData) ProjectData {
+	if s.IsEmpty() {
+		return data
+	}
+
+	assets := s.selectedAssets(data)
+	for _, dependency := range data.AssetDependencies {
+		if assets[dependency.AssetId] {
+			assets[dependency.DependencyId] = true
+		}
+	}
+
+	parents := map[string]string{}
+	for _, collection := range data.Collections {
+		parents[collection.Id] = collection.ParentId
+	}
+	collections := map[string]bool{}
+	keepCollection := func(id string) {
+		for id != "" && !collections[id] {
+			collections[id] = true
+			id = parents[id]
+		}
+	}
+	for _, collection := range data.Collections {
+		if s.Contains(collection.CollectionPath) {
+			keepCollection(collection.Id)
+		}
+	}
+	for _, asset := range data.Assets {
+		if assets[asset.Id] {
+			keepCollection(asset.CollectionId)
+		}
+	}
+	for _, dependency := range data.CollectionDependencies {
+		if assets[dependency.AssetId] {
+			keepCollection(dependency.DependencyId)
+		}
+	}
+
+	filtered := data
+	filtered.Collections = keep(data.Collections, func(c models.Collection) bool { return collections[c.Id] })
+	filtered.CollectionAssignees = keep(data.CollectionAssignees, func(a models.CollectionAssignee) bool { return collections[a.CollectionId] })
+	filtered.Assets = keep(data.Assets, func(a models.Asset) bool { return assets[a.Id] })
+	filtered.AssetsCheckpoints = keep(data.AssetsCheckpoints, func(c models.Checkpoint) bool { return assets[c.AssetId] })
+	filtered.AssetDependencies = keep(data.AssetDependencies, func(d models.AssetDependency) bool { return assets[d.AssetId] })
+	filtered.CollectionDependencies = keep(data.CollectionDependencies, func(d models.CollectionDependency) bool { return assets[d.AssetId] })
+	filtered.AssetsTags = keep(data.AssetsTags, func(t models.AssetTag) bool { return assets[t.AssetId] })
+	filtered.IntegrationCollectionMappings = keep(data.IntegrationCollectionMappings, func(m models.IntegrationCollectionMapping) bool { return collections[m.CollectionId] })
+	filtered.IntegrationAssetMappings = keep(data.IntegrationAssetMappings, func(m models.IntegrationAssetMapping) bool { return assets[m.AssetId] })
+	return filtered
+}
+
+func keep[T any](rows []T, fn func(T) bool) []T {
+	kept := []T{}
+	for _, row := range rows {
+		if fn(row) {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+// SetQuery adds the selection to the query of a /data request.
+func (s CollectionSelection) SetQuery(query url.Values) {
+	for _, path := range s.IncludePaths {
+		query.Add("include", path)
+	}
+	for _, path := range s.ExcludePaths {
+		query.Add("exclude", path)
+	}
+}
+
+// CollectionSelectionFromQuery reads the selection of a /data request.
+func CollectionSelectionFromQuery(query url.Values) CollectionSelection {
+	return NewCollectionSelection(query["include"], query["exclude"])
+}
+
+// GetCollectionSelection returns the selection the project was cloned with.
+func GetCollectionSelection(tx *sqlx.Tx) (CollectionSelection, error) {
+	selection := CollectionSelection{}
+	value := ""
+	err := tx.Get(&value, "SELECT value FROM config WHERE name = 'collection_selection'")
+	if errors.Is(err, sql.ErrNoRows) {
+		return selection, nil
+	}
+	if err != nil {
+		return selection, err
+	}
+	err = json.Unmarshal([]byte(value), &selection)
+	return selection, err
+}
+
+// SetCollectionSelection saves the selection later pulls of the project keep
+// to.
+func SetCollectionSelection(tx *sqlx.Tx, selection CollectionSelection) error {
+	if selection.IsEmpty() {
+		_, err := tx.Exec("DELETE FROM config WHERE name = 'collection_selection'")
+		return err
+	}
+	value, err := json.Marshal(selection)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO config (name, value, mtime)
+		VALUES ('collection_selection', $1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, mtime = EXCLUDED.mtime
+	`, string(value), utils.GetEpochTime())
+	return err
+}
+
+// LoadSelectedUserDataPb returns the part of the user's data in selection.
+func LoadSelectedUserDataPb(tx *sqlx.Tx, userId string, selection CollectionSelection) ([]byte, error) {
+	data, err := LoadUserData(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+	data = selection.Filter(data)
+	return proto.Marshal(&repositorypb.ProjectData{
+		ProjectPreview:      data.ProjectPreview,
+		CollectionTypes:     repository.ToPbCollectionTypes(data.CollectionTypes),
+		Collections:         repository.ToPbCollections(data.Collections),
+		CollectionAssignees: repository.ToPbCollectionAssignees(data.CollectionAssignees),
+
+		AssetTypes:             repository.ToPbAssetTypes(data.AssetTypes),
+		Assets:                 repository.ToPbAssets(data.Assets),
+		AssetsCheckpoints:      repository.ToPbCheckpoints(data.AssetsCheckpoints),
+		AssetDependencies:      repository.ToPbAssetDependencies(data.AssetDependencies),
+		CollectionDependencies: repository.ToPbCollectionDependencies(data.CollectionDependencies),
+
+		Statuses:        repository.ToPbStatuses(data.Statuses),
+		DependencyTypes: repository.ToPbDependencyTypes(data.DependencyTypes),
+
+		Users: repository.ToPbUsers(data.Users),
+		Roles: repository.ToPbRoles(data.Roles),
+
+		Templates: repository.ToPbTemplates(data.Templates),
+
+		Workflows:           repository.ToPbWorkflows(data.Workflows),
+		WorkflowLinks:       repository.ToPbWorkflowLinks(data.WorkflowLinks),
+		WorkflowCollections: repository.ToPbWorkflowCollections(data.WorkflowCollections),
+		WorkflowAssets:      repository.ToPbWorkflowAssets(data.WorkflowAssets),
+
+		Tags:       repository.ToPbTags(data.Tags),
+		AssetsTags: repository.ToPbAssetTags(data.AssetsTags),
+
+		IntegrationProjects:           repository.ToPbIntegrationProjects(data.IntegrationProjects),
+		IntegrationCollectionMappings: repository.ToPbIntegrationCollectionMappings(data.IntegrationCollectionMappings),
+		IntegrationAssetMappings:      repository.ToPbIntegrationAssetMappings(data.IntegrationAssetMappings),
+	})
+}
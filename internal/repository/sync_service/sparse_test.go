@@ -0,0 +1,176 @@
+package sync_service_test
+
+import (
+	"clustta/internal/auth_service"
+	"clustta/internal/repository"
+	"clustta/internal/repository/models"
+	"clustta/internal/repository/sync_service"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/jmoiron/sqlx"
+)
+
+// serveSparse answers project info and /data the way the studio server does
+// for sparse clones.
+func serveSparse(t *testing.T, projectPath string, syncToken *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			json.NewEncoder(w).Encode(repository.ProjectInfo{Id: "project-1", SyncToken: *syncToken})
+		case "/data":
+			var data []byte
+			withProjectTx(t, projectPath, func(tx *sqlx.Tx) {
+				var err error
+				selection := sync_service.CollectionSelectionFromQuery(r.URL.Query())
+				if selection.IsEmpty() {
+					data, err = sync_service.LoadUserDataPb(tx, "user-1")
+				} else {
+					data, err = sync_service.LoadSelectedUserDataPb(tx, "user-1", selection)
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			})
+			compressed, err := zstd.Compress(nil, data)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.Write(compressed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func addAsset(t *testing.T, tx *sqlx.Tx, id, collectionId string) {
+	t.Helper()
+	mustExec(t, tx, `INSERT INTO asset (id, mtime, created_at, name, extension, asset_type_id, collection_id, status_id)
+		VALUES (?, 1, 1, ?, '.blend', 'type-1', ?, 'status-1')`, id, id, collectionId)
+	mustExec(t, tx, `INSERT INTO asset_checkpoint (id, mtime, created_at, asset_id, xxhash_checksum, time_modified, file_size, chunks, author_id)
+		VALUES (?, 1, 1, ?, 'sum', 1, 1, ?, 'user-1')`, "checkpoint-"+id, id, "chunk-"+id)
+}
+
+func clientAssets(t *testing.T, clientPath string) []string {
+	t.Helper()
+	ids := []string{}
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		if err := tx.Select(&ids, "SELECT id FROM asset ORDER BY id"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return ids
+}
+
+func TestSparseClone(t *testing.T) {
+	serverPath := newProject(t, "server")
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		role, err := repository.CreateRole(tx, "role-1", "admin", models.RoleAttributes{ViewAsset: true, CreateAsset: true, PullChunk: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.AddKnownUser(tx, "user-1", "user@example.com", "user", "First", "Last", role.Id, nil, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.CreateStatus(tx, "status-1", "todo", "todo", "#ffffff"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.CreateAssetType(tx, "type-1", "model", "model"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repository.CreateCollectionType(tx, "ctype-1", "shot", "shot"); err != nil {
+			t.Fatal(err)
+		}
+		for _, collection := range [][2]string{{"sequences", ""}, {"sq010", "sequences"}, {"sq020", "sequences"}, {"library", ""}} {
+			if err = repository.AddCollection(tx, collection[0], collection[0], "", "ctype-1", collection[1], "", false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		addAsset(t, tx, "comp-010", "sq010")
+		addAsset(t, tx, "comp-020", "sq020")
+		addAsset(t, tx, "prop", "library")
+		addAsset(t, tx, "loose", "")
+		mustExec(t, tx, "INSERT INTO dependency_type (id, mtime, name) VALUES ('linked', 1, 'linked')")
+		mustExec(t, tx, `INSERT INTO asset_dependency (id, mtime, asset_id, dependency_id, dependency_type_id)
+			VALUES ('dependency-1', 1, 'comp-010', 'prop', 'linked')`)
+	})
+
+	syncToken := "token-1"
+	server := serveSparse(t, serverPath, &syncToken)
+	defer server.Close()
+
+	clientPath := newProject(t, "client")
+	user := auth_service.User{Id: "user-1"}
+	pull := func(options sync_service.SyncOptions) {
+		t.Helper()
+		if err := sync_service.PullDataAs(context.Background(), clientPath, server.URL, user, false, options, func(int, int, string, string) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The selected subtree comes with the asset it depends on, and the
+	// collections holding them.
+	pull(sync_service.SyncOptions{IncludePaths: []string{"sequences/sq010"}})
+	if assets := clientAssets(t, clientPath); !slices.Equal(assets, []string{"comp-010", "prop"}) {
+		t.Fatalf("sparse clone holds assets %v", assets)
+	}
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		collections := []string{}
+		if err := tx.Select(&collections, "SELECT collection_path FROM collection ORDER BY collection_path"); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(collections, []string{"/library/", "/sequences/", "/sequences/sq010/"}) {
+			t.Fatalf("sparse clone holds collections %v", collections)
+		}
+
+		data, err := sync_service.LoadUserData(tx, "user-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		missing, _, _, err := sync_service.CalculateMissingChunks(tx, data, "user-1", sync_service.SyncOptions{
+			OnlyLatestCheckpoints: true,
+			Assets:                true,
+			IncludePaths:          []string{"/sequences/sq010/"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(missing, []string{"chunk-comp-010"}) {
+			t.Fatalf("sparse clone downloads chunks %v", missing)
+		}
+	})
+
+	// Later pulls keep to the saved selection.
+	withProjectTx(t, serverPath, func(tx *sqlx.Tx) {
+		addAsset(t, tx, "comp-011", "sq010")
+		addAsset(t, tx, "comp-021", "sq020")
+	})
+	syncToken = "token-2"
+	pull(sync_service.SyncOptions{})
+	if assets := clientAssets(t, clientPath); !slices.Equal(assets, []string{"comp-010", "comp-011", "prop"}) {
+		t.Fatalf("later pull holds assets %v", assets)
+	}
+
+	// Excluding a subtree keeps everything else.
+	pull(sync_service.SyncOptions{ExcludePaths: []string{"/sequences/sq020/"}})
+	if assets := clientAssets(t, clientPath); !slices.Equal(assets, []string{"comp-010", "comp-011", "loose", "prop"}) {
+		t.Fatalf("clone excluding sq020 holds assets %v", assets)
+	}
+
+	pull(sync_service.SyncOptions{FullClone: true})
+	if assets := clientAssets(t, clientPath); len(assets) != 6 {
+		t.Fatalf("full clone holds assets %v", assets)
+	}
+	withProjectTx(t, clientPath, func(tx *sqlx.Tx) {
+		selection, err := sync_service.GetCollectionSelection(tx)
+		if err != nil || !selection.IsEmpty() {
+			t.Fatalf("full clone kept selection %+v: %v", selection, err)
+		}
+	})
+}
@@ -16,6 +16,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -34,8 +36,12 @@ type ConflictInfo struct {
 }
 
 type WriteResult struct {
-	Success   bool           `json:"success"`
-	Conflicts []ConflictInfo `json:"conflicts,omitempty"`
+	Success        bool            `json:"success"`
+	Conflicts      []ConflictInfo  `json:"conflicts,omitempty"`
+	FieldConflicts []FieldConflict `json:"field_conflicts,omitempty"`
+
+	Resolutions []ConflictResolution `json:"resolutions,omitempty"`
+	IdMap       map[string]string    `json:"id_map,omitempty"`
 }
 
 type ProjectData struct {
@@ -70,6 +76,10 @@ type ProjectData struct {
 	IntegrationProjects           []models.IntegrationProject           `json:"integration_projects"`
 	IntegrationCollectionMappings []models.IntegrationCollectionMapping `json:"integration_collection_mappings"`
 	IntegrationAssetMappings      []models.IntegrationAssetMapping      `json:"integration_asset_mappings"`
+
+	FieldBases []repository.FieldBase `json:"field_bases"`
+
+	ConflictPolicies ConflictPolicies `json:"conflict_policies"`
 }
 
 func (d *ProjectData) IsEmpty() bool {
@@ -99,111 +109,72 @@ func (d *ProjectData) IsEmpty() bool {
 		d.ProjectPreview == ""
 }
 
-// CheckForConflicts checks for collection and asset name conflicts before writing data.
-// Returns a WriteResult with any conflicts found. If conflicts exist, data should NOT be written.
-func CheckForConflicts(tx *sqlx.Tx, data ProjectData) (*WriteResult, error) {
-	result := &WriteResult{Success: true, Conflicts: []ConflictInfo{}}
-
-	tombItems := make(map[string]bool)
-	tombedItems, err := repository.GetTombedItems(tx)
-	if err != nil {
-		return nil, err
-	}
-	for _, tombItem := range tombedItems {
-		tombItems[tombItem] = true
-	}
-
-	localCollections, err := repository.GetSimpleCollections(tx)
-	if err != nil {
-		return nil, err
-	}
-
-	localCollectionsIndex := make(map[string]bool)
-	for _, collection := range localCollections {
-		localCollectionsIndex[collection.Id] = true
-	}
+type tableRows struct {
+	table string
+	rows  int
+}
 
-	entityByNameParent := make(map[string]string)
-	for _, collection := range localCollections {
-		key := strings.ToLower(collection.Name) + "|" + collection.ParentId
-		entityByNameParent[key] = collection.Id
+func (d *ProjectData) tableRows() []tableRows {
+	return []tableRows{
+		{"collection_type", len(d.CollectionTypes)},
+		{"collection", len(d.Collections)},
+		{"collection_assignee", len(d.CollectionAssignees)},
+		{"asset_type", len(d.AssetTypes)},
+		{"asset", len(d.Assets)},
+		{"asset_checkpoint", len(d.AssetsCheckpoints)},
+		{"asset_dependency", len(d.AssetDependencies)},
+		{"collection_dependency", len(d.CollectionDependencies)},
+		{"status", len(d.Statuses)},
+		{"dependency_type", len(d.DependencyTypes)},
+		{"user", len(d.Users)},
+		{"role", len(d.Roles)},
+		{"template", len(d.Templates)},
+		{"tag", len(d.Tags)},
+		{"asset_tag", len(d.AssetsTags)},
+		{"workflow", len(d.Workflows)},
+		{"workflow_link", len(d.WorkflowLinks)},
+		{"workflow_collection", len(d.WorkflowCollections)},
+		{"workflow_asset", len(d.WorkflowAssets)},
+		{"integration_project", len(d.IntegrationProjects)},
+		{"integration_collection_mapping", len(d.IntegrationCollectionMappings)},
+		{"integration_asset_mapping", len(d.IntegrationAssetMappings)},
 	}
+}
 
-	conflictIdMap := make(map[string]string)
-
-	for _, collection := range data.Collections {
-		if tombItems[collection.Id] {
-			continue
-		}
-		if localCollectionsIndex[collection.Id] {
-			continue
-		}
-
-		resolvedParentId := collection.ParentId
-		if mappedParentId, exists := conflictIdMap[collection.ParentId]; exists {
-			resolvedParentId = mappedParentId
-		}
-
-		key := strings.ToLower(collection.Name) + "|" + resolvedParentId
-		if existingId, hasConflict := entityByNameParent[key]; hasConflict {
-			result.Conflicts = append(result.Conflicts, ConflictInfo{
-				Type:       "collection",
-				LocalId:    collection.Id,
-				ExistingId: existingId,
-				Name:       collection.Name,
-				ParentId:   collection.ParentId,
-			})
-			conflictIdMap[collection.Id] = existingId
+// Tables returns the names of the tables d writes, including the tables its
+// tombs delete from.
+func (d *ProjectData) Tables() []string {
+	tables := []string{}
+	for _, entry := range d.tableRows() {
+		if entry.rows > 0 {
+			tables = append(tables, entry.table)
 		}
 	}
-
-	localAssets, err := repository.GetSimpleAssets(tx)
-	if err != nil {
-		return nil, err
-	}
-
-	localAssetsIndex := make(map[string]bool)
-	for _, asset := range localAssets {
-		localAssetsIndex[asset.Id] = true
-	}
-
-	assetByKey := make(map[string]string)
-	for _, asset := range localAssets {
-		key := strings.ToLower(asset.Name) + "|" + asset.CollectionId + "|" + asset.Extension
-		assetByKey[key] = asset.Id
-	}
-
-	for _, asset := range data.Assets {
-		if tombItems[asset.Id] {
-			continue
-		}
-		if localAssetsIndex[asset.Id] {
-			continue
-		}
-
-		resolvedCollectionId := asset.CollectionId
-		if mappedCollectionId, exists := conflictIdMap[asset.CollectionId]; exists {
-			resolvedCollectionId = mappedCollectionId
-		}
-
-		key := strings.ToLower(asset.Name) + "|" + resolvedCollectionId + "|" + asset.Extension
-		if existingId, hasConflict := assetByKey[key]; hasConflict {
-			result.Conflicts = append(result.Conflicts, ConflictInfo{
-				Type:       "asset",
-				LocalId:    asset.Id,
-				ExistingId: existingId,
-				Name:       asset.Name,
-				ParentId:   asset.CollectionId,
-				Extension:  asset.Extension,
-			})
+	for _, tomb := range d.Tombs {
+		if !slices.Contains(tables, tomb.TableName) {
+			tables = append(tables, tomb.TableName)
 		}
 	}
+	return tables
+}
 
-	if len(result.Conflicts) > 0 {
-		result.Success = false
+// RowCounts returns how many rows d writes to each table it touches, tombs
+// excluded.
+func (d *ProjectData) RowCounts() map[string]int {
+	counts := map[string]int{}
+	for _, entry := range d.tableRows() {
+		if entry.rows > 0 {
+			counts[entry.table] = entry.rows
+		}
 	}
+	return counts
+}
 
-	return result, nil
+// CheckForConflicts checks for collection and asset name conflicts before writing data.
+// Returns a WriteResult with any conflicts found. If conflicts exist, data should NOT be written.
+func CheckForConflicts(tx *sqlx.Tx, data ProjectData) (*WriteResult, error) {
+	data.ConflictPolicies = ConflictPolicies{}
+	return ResolveNameConflicts(tx, &data)
 }
 
 func WriteProjectData(tx *sqlx.Tx, data ProjectData, strict bool) error {
@@ -1265,32 +1236,55 @@ func OverWriteProjectData(tx *sqlx.Tx, data ProjectData) error {
 }
 
 func FetchData(remoteUrl string, userId string) (ProjectData, error) {
+	userData, _, err := FetchDataSince(remoteUrl, userId, "")
+	return userData, err
+}
+
+// FetchDataSince fetches the user's data, asking a studio for only the
+// changes after since when it is set. The returned DataSync says whether a
+// delta came back and the sequence to pass as since on the next pull.
+func FetchDataSince(remoteUrl string, userId string, since string) (ProjectData, DataSync, error) {
+	return FetchSelectedData(context.Background(), remoteUrl, userId, since, CollectionSelection{})
+}
+
+// FetchSelectedData fetches like FetchDataSince the part of the user's data
+// in selection.
+func FetchSelectedData(ctx context.Context, remoteUrl string, userId string, since string, selection CollectionSelection) (ProjectData, DataSync, error) {
 	userData := ProjectData{}
+	dataSync := DataSync{}
 	userDataPb := repositorypb.ProjectData{}
 	if utils.IsValidURL(remoteUrl) {
 		type userTokenStruct struct {
 			UserId string `json:"user_id"`
 		}
 		dataUrl := remoteUrl + "/data"
+		query := url.Values{}
+		if since != "" {
+			query.Set("since", since)
+		}
+		selection.SetQuery(query)
+		if len(query) > 0 {
+			dataUrl += "?" + query.Encode()
+		}
 
 		userToken := userTokenStruct{
 			UserId: userId,
 		}
 		jsonData, err := json.Marshal(userToken)
 		if err != nil {
-			return userData, err
+			return userData, dataSync, err
 		}
 
-		req, err := http.NewRequest("GET", dataUrl, bytes.NewBuffer(jsonData))
+		req, err := http.NewRequestWithContext(ctx, "GET", dataUrl, bytes.NewBuffer(jsonData))
 		if err != nil {
-			return userData, err
+			return userData, dataSync, err
 		}
 		req.Header.Set("Clustta-Agent", constants.USER_AGENT)
 
-		client := &http.Client{}
+		client := utils.HTTPClient(ctx, &http.Client{})
 		response, err := client.Do(req)
 		if err != nil {
-			return userData, err
+			return userData, dataSync, err
 		}
 		defer response.Body.Close()
 
@@ -1298,17 +1292,17 @@ func FetchData(remoteUrl string, userId string) (ProjectData, error) {
 		if responseCode == 200 {
 			body, err := io.ReadAll(response.Body)
 			if err != nil {
-				return userData, fmt.Errorf("error reading response body: %s", err.Error())
+				return userData, dataSync, fmt.Errorf("error reading response body: %s", err.Error())
 			}
 
 			decompressedData, err := zstd.Decompress(nil, body)
 			if err != nil {
-				return userData, err
+				return userData, dataSync, err
 			}
 
 			err = proto.Unmarshal(decompressedData, &userDataPb)
 			if err != nil {
-				return userData, err
+				return userData, dataSync, err
 			}
 
 			userData = ProjectData{
@@ -1342,40 +1336,49 @@ func FetchData(remoteUrl string, userId string) (ProjectData, error) {
 				IntegrationProjects:           repository.FromPbIntegrationProjects(userDataPb.IntegrationProjects),
 				IntegrationCollectionMappings: repository.FromPbIntegrationCollectionMappings(userDataPb.IntegrationCollectionMappings),
 				IntegrationAssetMappings:      repository.FromPbIntegrationAssetMappings(userDataPb.IntegrationAssetMappings),
+
+				Tombs: repository.FromPbTombs(userDataPb.Tomb),
+			}
+			dataSync.Sequence = response.Header.Get(SyncSequenceHeader)
+			dataSync.Delta = response.Header.Get(SyncModeHeader) == SyncModeDelta
+			if !dataSync.Delta {
+				// Studios predating sparse clones send everything.
+				userData = selection.Filter(userData)
 			}
 
-			return userData, nil
+			return userData, dataSync, nil
 		} else if responseCode == 400 {
 			body, err := io.ReadAll(response.Body)
 			if err != nil {
-				return userData, err
+				return userData, dataSync, err
 			}
-			return userData, errors.New(string(body))
+			return userData, dataSync, errors.New(string(body))
 		}
 		body, err := io.ReadAll(response.Body)
 		if err != nil {
-			return userData, err
+			return userData, dataSync, err
 		}
-		return userData, fmt.Errorf("unknown error while fetching data. url: %s, status code: %d, message: %s", dataUrl, responseCode, string(body))
+		return userData, dataSync, fmt.Errorf("unknown error while fetching data. url: %s, status code: %d, message: %s", dataUrl, responseCode, string(body))
 	} else if utils.FileExists(remoteUrl) {
 		db, err := utils.OpenDb(remoteUrl)
 		if err != nil {
-			return userData, err
+			return userData, dataSync, err
 		}
 		defer db.Close()
 		remoteTx, err := db.Beginx()
 		if err != nil {
-			return userData, err
+			return userData, dataSync, err
 		}
 		defer remoteTx.Rollback()
 		userData, err = LoadUserData(remoteTx, userId)
 		if err != nil {
-			return userData, err
+			return userData, dataSync, err
 		}
+		userData = selection.Filter(userData)
 	} else {
-		return userData, fmt.Errorf("invalid url:%s", remoteUrl)
+		return userData, dataSync, fmt.Errorf("invalid url:%s", remoteUrl)
 	}
-	return userData, nil
+	return userData, dataSync, nil
 
 }
 
@@ -1408,7 +1411,14 @@ func CalculateMissingPreviews(tx *sqlx.Tx, data ProjectData) ([]string, error) {
 func CalculateMissingChunks(tx *sqlx.Tx, data ProjectData, userId string, syncOptions SyncOptions) ([]string, []string, int, error) {
 	assetsIds := []string{}
 
+	// A sparse clone only downloads the assets of its selection, and the
+	// dependencies it asks for.
+	selection, _ := syncOptions.Selection()
+	selectedAssets := selection.selectedAssets(data)
 	for _, asset := range data.Assets {
+		if !selection.IsEmpty() && !selectedAssets[asset.Id] && !(syncOptions.AssetDependencies && asset.IsDependency) {
+			continue
+		}
 		if asset.AssigneeId == userId {
 			assetsIds = append(assetsIds, asset.Id)
 		} else if syncOptions.AssetDependencies && asset.IsDependency {
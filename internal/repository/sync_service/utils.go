@@ -15,6 +15,18 @@ type SyncOptions struct {
 	Resources             bool `json:"resources"`
 	Templates             bool `json:"templates"`
 	Force                 bool `json:"force"`
+
+	// IncludePaths and ExcludePaths limit a clone to collection subtrees,
+	// such as "/sequences/sq010". Given, they replace the selection saved
+	// with the project; otherwise the saved one applies.
+	IncludePaths []string `json:"include_paths"`
+	ExcludePaths []string `json:"exclude_paths"`
+	// FullClone forgets the saved selection and pulls the whole project.
+	FullClone bool `json:"full_clone"`
+
+	// MaxBytesPerSecond caps how fast chunks are downloaded or uploaded, so
+	// a sync leaves room on a shared link. 0 means unlimited.
+	MaxBytesPerSecond int64 `json:"max_bytes_per_second"`
 }
 
 var ProjectTables = []string{
@@ -69,5 +81,5 @@ func ClearLocalDataDrop(tx *sqlx.Tx) error {
 		return err
 	}
 
-	return nil
+	return repository.ClearFieldBases(tx)
 }
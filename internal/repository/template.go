@@ -29,7 +29,7 @@ func CreateTemplate(tx *sqlx.Tx, name string, templateFile string) (models.Templ
 	}
 
 	// chunks := "test,test,test"
-	chunks, err := StoreFileChunks(tx, templateFile, func(current int, total int, message string, extraMessage string) {})
+	chunks, err := StoreFileChunks(tx, templateFile, "", func(current int, total int, message string, extraMessage string) {})
 	if err != nil {
 		return template, err
 	}
@@ -183,7 +183,7 @@ func UpdateTemplateFile(tx *sqlx.Tx, id string, templateFile string) (models.Tem
 		return template, err
 	}
 
-	chunks, err := StoreFileChunks(tx, templateFile, func(current int, total int, message string, extraMessage string) {})
+	chunks, err := StoreFileChunks(tx, templateFile, "", func(current int, total int, message string, extraMessage string) {})
 	if err != nil {
 		return template, err
 	}
@@ -9,19 +9,22 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	kzstd "github.com/klauspost/compress/zstd"
 
-	"github.com/DataDog/zstd"
 	"github.com/jmoiron/sqlx"
 	"github.com/jotfs/fastcdc-go"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func StoreFileChunks(tx *sqlx.Tx, filePath string, callback func(int, int, string, string)) (string, error) {
+// StoreFileChunks chunks the file with the project's chunking profile for
+// its extension and assetTypeId, which may be empty, and stores the chunks
+// it does not have yet. It returns the comma separated chunk sequence.
+func StoreFileChunks(tx *sqlx.Tx, filePath, assetTypeId string, callback func(int, int, string, string)) (string, error) {
 
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -36,20 +39,29 @@ func StoreFileChunks(tx *sqlx.Tx, filePath string, callback func(int, int, strin
 	fileSize := int(fileInfo.Size())
 	processed_size := 0
 
+	store, err := chunk_service.OpenChunkStore(tx)
+	if err != nil {
+		return "", err
+	}
+	compressor, err := chunk_service.NewChunkCompressor(tx, filepath.Ext(filePath))
+	if err != nil {
+		return "", err
+	}
+	defer compressor.Close()
 	seenChunks := make(map[string]bool)
 
-	kiB := 1024
-	miB := 1024 * kiB
-
-	opts := fastcdc.Options{
-		MinSize:     512 * kiB,
-		AverageSize: 1 * miB,
-		MaxSize:     8 * miB,
+	profiles, err := GetChunkingProfiles(tx)
+	if err != nil {
+		return "", err
 	}
+	opts := profiles.Resolve(filepath.Ext(filePath), assetTypeId).fastcdcOptions()
 
 	chunkSequence := make([]string, 0)
 
-	chunker, _ := fastcdc.NewChunker(file, opts)
+	chunker, err := fastcdc.NewChunker(file, opts)
+	if err != nil {
+		return "", err
+	}
 	for {
 		chunk, err := chunker.Next()
 		if err == io.EOF {
@@ -63,26 +75,23 @@ func StoreFileChunks(tx *sqlx.Tx, filePath string, callback func(int, int, strin
 		sha256Hash.Write(data)
 		hash := hex.EncodeToString(sha256Hash.Sum(nil))
 
-		if chunk_service.ChunkExists(hash, tx, seenChunks) {
+		if chunk_service.StoreHasChunk(store, tx, hash, seenChunks) {
 			chunkSequence = append(chunkSequence, hash)
 			processed_size += len(data)
 			callback(processed_size, fileSize, "", "")
 			continue
 		}
 
-		compressedData, err := zstd.CompressLevel(nil, data, 3)
+		compressedData, err := compressor.Compress(data)
 		if err != nil {
 			return "", err
 		}
 
-		// encoder, err := kzstd.NewWriter(nil, kzstd.WithEncoderLevel(3))
-		// if err != nil {
-		// 	return "", err
-		// }
-		// defer encoder.Close()
-		// compressedData := encoder.EncodeAll(data, nil)
-
-		err = chunk_service.StoreChunk(tx, hash, compressedData, len(compressedData))
+		err = store.Put(tx, hash, compressedData, len(compressedData))
+		if err != nil {
+			return "", err
+		}
+		err = chunk_service.RecordChunkDictionary(tx, hash, compressedData)
 		if err != nil {
 			return "", err
 		}
@@ -102,10 +111,14 @@ func CheckMissingChunks(tx *sqlx.Tx, chunkHashes []string) ([]string, error) {
 		return nil, nil // No hashes to check, return an empty result
 	}
 
+	store, err := chunk_service.OpenChunkStore(tx)
+	if err != nil {
+		return nil, err
+	}
 	var missingHashes []string
 	seen := make(map[string]bool)
 	for _, hash := range chunkHashes {
-		if !chunk_service.ChunkExists(hash, tx, seen) {
+		if !chunk_service.StoreHasChunk(store, tx, hash, seen) {
 			missingHashes = append(missingHashes, hash)
 		}
 	}
@@ -123,6 +136,14 @@ func RebuildFile(tx *sqlx.Tx, chunks string, filePath string, timeModified int64
 		return errors.New("missing some chunks. please sync")
 	}
 
+	store, err := chunk_service.OpenChunkStore(tx)
+	if err != nil {
+		return err
+	}
+	decoderOptions, err := chunk_service.ChunkDecoderOptions(tx)
+	if err != nil {
+		return err
+	}
 	buffer := bytes.Buffer{}
 	bufferLimit := 100 * 1024 * 1024
 
@@ -137,7 +158,7 @@ func RebuildFile(tx *sqlx.Tx, chunks string, filePath string, timeModified int64
 	}
 	processedChunks := 0
 	for _, chunkHash := range chunkHashes {
-		data, readErr := chunk_service.ReadChunk(tx, chunkHash)
+		data, readErr := store.Get(tx, chunkHash)
 		err = readErr
 		if err != nil {
 			return err
@@ -150,7 +171,7 @@ func RebuildFile(tx *sqlx.Tx, chunks string, filePath string, timeModified int64
 			// decompressor := zstd.NewReader(&buffer)
 			// io.Copy(file, decompressor)
 			// buffer.Reset()
-			decompressor, err := kzstd.NewReader(&buffer)
+			decompressor, err := kzstd.NewReader(&buffer, decoderOptions...)
 			if err != nil {
 				return err
 			}
@@ -164,7 +185,7 @@ func RebuildFile(tx *sqlx.Tx, chunks string, filePath string, timeModified int64
 		// decompressor := zstd.NewReader(&buffer)
 		// io.Copy(file, decompressor)
 		// buffer.Reset()
-		decompressor, err := kzstd.NewReader(&buffer)
+		decompressor, err := kzstd.NewReader(&buffer, decoderOptions...)
 		if err != nil {
 			return err
 		}
@@ -198,6 +198,29 @@ func GetProjectSyncToken(tx *sqlx.Tx) (string, error) {
 	return projectIcon, nil
 }
 
+// SetProjectSyncSequence stores the studio change sequence the local data is
+// current to. An empty value makes the next pull fetch a full snapshot.
+func SetProjectSyncSequence(tx *sqlx.Tx, sequence string) error {
+	_, err := tx.Exec(`
+		INSERT INTO config (name, value, mtime)
+		VALUES ('sync_sequence', $1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, mtime = EXCLUDED.mtime
+	`, sequence, GetEpochTime())
+	return err
+}
+
+func GetProjectSyncSequence(tx *sqlx.Tx) (string, error) {
+	var sequence string
+	err := tx.Get(&sequence, "SELECT value FROM config WHERE name='sync_sequence'")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return sequence, err
+	}
+	return sequence, nil
+}
+
 func SetProjectIcon(tx *sqlx.Tx, projectIcon string) error {
 	_, err := tx.Exec(`
 		INSERT INTO config (name, value, mtime)
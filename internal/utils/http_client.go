@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+)
+
+type httpClientKey struct{}
+
+// WithHTTPClient returns a context under which project pulls send their
+// requests through client instead of their own, such as a client that signs
+// in to another studio.
+func WithHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, httpClientKey{}, client)
+}
+
+// HTTPClient returns the client ctx carries, or fallback when it has none.
+func HTTPClient(ctx context.Context, fallback *http.Client) *http.Client {
+	if client, ok := ctx.Value(httpClientKey{}).(*http.Client); ok && client != nil {
+		return client
+	}
+	return fallback
+}
+
+// BearerTransport sends requests to Host with Token as their bearer
+// credential. Requests to other hosts, such as redirects, go without it.
+type BearerTransport struct {
+	Token string
+	Host  string
+	Base  http.RoundTripper
+}
+
+func (t BearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Token == "" || req.URL.Host != t.Host {
+		return base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.Token)
+	return base.RoundTrip(req)
+}